@@ -0,0 +1,99 @@
+// Package drain takes an HTTP server out of its load balancer before shutting
+// it down: on SIGTERM, /ready starts failing, the server keeps serving for a
+// drain delay so that the load balancer stops routing to it, and only then
+// are its connections shut down.
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StopTimeout is the stopTimeout of the app containers in the ECS task
+// definitions: how long ECS waits after SIGTERM before killing the container
+const StopTimeout = 30 * time.Second
+
+// exitMargin is left at the end of StopTimeout for the process to exit
+const exitMargin = 2 * time.Second
+
+// minShutdownTimeout is the least time the server gets to shut down its
+// connections after draining
+const minShutdownTimeout = 5 * time.Second
+
+// MaxDelay is the longest drain delay that still leaves the shutdown of the
+// connections minShutdownTimeout before StopTimeout is up
+const MaxDelay = StopTimeout - exitMargin - minShutdownTimeout
+
+// DelayFromEnv reads the drain delay from DRAIN_SECONDS; unset means none
+func DelayFromEnv() (time.Duration, error) {
+	v := os.Getenv("DRAIN_SECONDS")
+	if v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 || time.Duration(secs)*time.Second > MaxDelay {
+		return 0, fmt.Errorf("invalid DRAIN_SECONDS %q: must be an integer from 0 to %d", v, int(MaxDelay/time.Second))
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// Drainer serves the /ready endpoint of a server and drains the server
+// before shutting it down. The zero value is not ready and has no delay.
+type Drainer struct {
+	ServerID string        // Reported by /ready
+	Delay    time.Duration // How long to keep serving after /ready fails
+
+	ready atomic.Bool
+	sleep func(time.Duration) // Replaced in tests; nil means time.Sleep
+}
+
+// SetReady makes /ready succeed; call it once the server is about to serve
+func (d *Drainer) SetReady() {
+	d.ready.Store(true)
+}
+
+// ServeHTTP returns 200 while the server accepts traffic and 503 once
+// shutdown has started, so the load balancer stops routing before draining
+func (d *Drainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, code := "ready", http.StatusOK
+	if !d.ready.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    status,
+		"server_id": d.ServerID,
+	})
+}
+
+// ShutdownOnSignal waits for a signal, then fails /ready, keeps serving for
+// the drain delay and shuts server down. The shutdown waits for in-flight
+// requests until shortly before StopTimeout, counted from the signal.
+func (d *Drainer) ShutdownOnSignal(server *http.Server, signals <-chan os.Signal) {
+	sig := <-signals
+	log.Printf("Received signal %v, initiating graceful shutdown...", sig)
+
+	d.ready.Store(false)
+	if d.Delay > 0 {
+		log.Printf("Draining for %v before shutdown...", d.Delay)
+		sleep := d.sleep
+		if sleep == nil {
+			sleep = time.Sleep
+		}
+		sleep(d.Delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StopTimeout-exitMargin-d.Delay)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+}
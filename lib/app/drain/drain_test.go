@@ -0,0 +1,83 @@
+package drain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDelayFromEnv(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": 0, "0": 0, "15": 15 * time.Second, "23": MaxDelay} {
+		t.Setenv("DRAIN_SECONDS", v)
+		if got, err := DelayFromEnv(); err != nil || got != want {
+			t.Errorf("DRAIN_SECONDS=%q: DelayFromEnv() = %v, %v, want %v", v, got, err, want)
+		}
+	}
+	// The drain and the shutdown must both fit in StopTimeout
+	for _, v := range []string{"-1", "abc", "24", "30"} {
+		t.Setenv("DRAIN_SECONDS", v)
+		if _, err := DelayFromEnv(); err == nil {
+			t.Errorf("DRAIN_SECONDS=%q: DelayFromEnv() succeeded, want an error", v)
+		}
+	}
+}
+
+func get(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestShutdownOnSignalDrainsBeforeShutdown(t *testing.T) {
+	d := &Drainer{ServerID: "server-1", Delay: 10 * time.Second}
+	mux := http.NewServeMux()
+	mux.Handle("/ready", d)
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d.SetReady()
+	if code := get(t, srv.URL+"/ready"); code != http.StatusOK {
+		t.Fatalf("/ready before the signal = %d, want %d", code, http.StatusOK)
+	}
+
+	// While draining, /ready fails but requests are still served
+	var slept time.Duration
+	var readyWhileDraining, apiWhileDraining int
+	d.sleep = func(delay time.Duration) {
+		slept = delay
+		readyWhileDraining = get(t, srv.URL+"/ready")
+		apiWhileDraining = get(t, srv.URL+"/api")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	done := make(chan struct{})
+	go func() {
+		d.ShutdownOnSignal(srv.Config, signals)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ShutdownOnSignal did not return")
+	}
+
+	if slept != d.Delay {
+		t.Errorf("drained for %v, want %v", slept, d.Delay)
+	}
+	if readyWhileDraining != http.StatusServiceUnavailable || apiWhileDraining != http.StatusOK {
+		t.Errorf("while draining /ready = %d and /api = %d, want %d and %d",
+			readyWhileDraining, apiWhileDraining, http.StatusServiceUnavailable, http.StatusOK)
+	}
+	if _, err := http.Get(srv.URL + "/api"); err == nil {
+		t.Error("server still serving after shutdown")
+	}
+}
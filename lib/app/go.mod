@@ -0,0 +1,5 @@
+module github.com/example/hello-fargate-app
+
+go 1.23
+
+require github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
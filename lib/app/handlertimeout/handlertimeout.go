@@ -0,0 +1,41 @@
+// Package handlertimeout bounds the processing time of HTTP requests, so that
+// a hung handler replies 503 instead of tying up its connection until the
+// server's WriteTimeout.
+package handlertimeout
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FromEnv reads the handler timeout from HANDLER_TIMEOUT_SECONDS; unset or 0
+// means none. The timeout must be shorter than the server's writeTimeout, as
+// the connection is closed by then and the 503 would never reach the client.
+func FromEnv(writeTimeout time.Duration) (time.Duration, error) {
+	v := os.Getenv("HANDLER_TIMEOUT_SECONDS")
+	if v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid HANDLER_TIMEOUT_SECONDS %q: must be a non-negative integer", v)
+	}
+	d := time.Duration(secs) * time.Second
+	if d >= writeTimeout {
+		return 0, fmt.Errorf("invalid HANDLER_TIMEOUT_SECONDS %q: must be below the server's %v write timeout", v, writeTimeout)
+	}
+	return d, nil
+}
+
+// Wrap bounds the processing time of each request to d, replying 503 once it
+// runs out. The request context is canceled at the same time so that handlers
+// can stop their work. A zero d disables the limit.
+func Wrap(h http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return h
+	}
+	return http.TimeoutHandler(h, d, "handler timed out\n")
+}
@@ -0,0 +1,66 @@
+package handlertimeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromEnv(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": 0, "0": 0, "5": 5 * time.Second, "9": 9 * time.Second} {
+		t.Setenv("HANDLER_TIMEOUT_SECONDS", v)
+		if got, err := FromEnv(10 * time.Second); err != nil || got != want {
+			t.Errorf("HANDLER_TIMEOUT_SECONDS=%q: FromEnv() = %v, %v, want %v", v, got, err, want)
+		}
+	}
+	// The 503 must be written before the server's write timeout
+	for _, v := range []string{"-1", "abc", "1.5", "10", "60"} {
+		t.Setenv("HANDLER_TIMEOUT_SECONDS", v)
+		if _, err := FromEnv(10 * time.Second); err == nil {
+			t.Errorf("HANDLER_TIMEOUT_SECONDS=%q: FromEnv() succeeded, want an error", v)
+		}
+	}
+}
+
+func TestWrapRepliesUnavailableToSlowHandler(t *testing.T) {
+	canceled := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("too late"))
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	Wrap(slow, 50*time.Millisecond).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want it cut off after 50ms", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "handler timed out") {
+		t.Errorf("response = %d %q, want 503 handler timed out", rec.Code, rec.Body)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("the handler's request context was not canceled")
+	}
+}
+
+func TestWrapPassesFastHandler(t *testing.T) {
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for _, d := range []time.Duration{0, time.Second} {
+		rec := httptest.NewRecorder()
+		Wrap(fast, d).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("timeout %v: response = %d %q, want 200 ok", d, rec.Code, rec.Body)
+		}
+	}
+}
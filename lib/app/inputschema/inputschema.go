@@ -0,0 +1,39 @@
+// Package inputschema validates the JSON input of the one-off task and the
+// batch worker against a JSON Schema, so that input not matching the contract
+// is rejected before any work is done.
+package inputschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Validate checks the JSON input against the JSON Schema file at schemaPath.
+// A violation is reported with every failing keyword and its location.
+func Validate(schemaPath, input string) error {
+	schema, err := jsonschema.Compile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("failed to load schema: %w", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if err := schema.Validate(v); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			// The detailed form lists every failing keyword with its location
+			return fmt.Errorf("%#v", verr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package inputschema
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const schemaPath = "testdata/input.schema.json"
+
+func TestValidateAcceptsMatchingInput(t *testing.T) {
+	for _, input := range []string{
+		`{"message":"hello"}`,
+		`{"message":"hello","items":["a","b"],"data":{"n":1}}`,
+		`{"message":"hello","extra":true}`,
+	} {
+		if err := Validate(schemaPath, input); err != nil {
+			t.Errorf("Validate(%s) = %v, want no error", input, err)
+		}
+	}
+}
+
+func TestValidateRejectsViolations(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  []string // Substrings of the error: the failing locations
+	}{
+		{`{}`, []string{"missing properties", "message"}},
+		{`{"message":42}`, []string{"/message", "expected string"}},
+		{`{"message":"hello","items":["a",2]}`, []string{"/items/1", "expected string"}},
+		{`[]`, []string{"expected object"}},
+		// Every violation is listed, not just the first
+		{`{"message":1,"data":"x"}`, []string{"/message", "/data"}},
+	} {
+		err := Validate(schemaPath, tt.input)
+		if err == nil {
+			t.Errorf("Validate(%s) succeeded, want a violation", tt.input)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Validate(%s) = %v, want it to mention %q", tt.input, err, want)
+			}
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	if err := Validate(schemaPath, `{"message":`); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Errorf("Validate() of truncated JSON = %v, want an invalid JSON error", err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.schema.json")
+	if err := Validate(missing, `{}`); err == nil || !strings.Contains(err.Error(), "failed to load schema") {
+		t.Errorf("Validate() with a missing schema = %v, want a schema load error", err)
+	}
+}
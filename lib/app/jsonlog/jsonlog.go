@@ -0,0 +1,59 @@
+// Package jsonlog writes structured log entries as single-line JSON objects,
+// so that CloudWatch Logs filter patterns and the test harnesses can match
+// them reliably.
+package jsonlog
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+)
+
+// Fields holds the extra fields of a log entry
+type Fields map[string]interface{}
+
+// reservedKeys are set by the Logger itself. An extra field with one of
+// these names is written with a "field_" prefix instead of replacing them.
+var reservedKeys = map[string]bool{"level": true, "msg": true, "ts": true, "job_id": true}
+
+// Logger writes one JSON object per line. It is safe for concurrent use.
+type Logger struct {
+	out *log.Logger
+	now func() time.Time
+}
+
+// New returns a Logger writing to w
+func New(w io.Writer) *Logger {
+	return &Logger{out: log.New(w, "", 0), now: time.Now}
+}
+
+// SetOutput redirects the log entries to w
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out.SetOutput(w)
+}
+
+// Log writes an entry with the level, message, job ID (omitted when empty),
+// timestamp and any extra fields
+func (l *Logger) Log(level, jobID, msg string, fields Fields) {
+	entry := make(Fields, len(fields)+4)
+	for k, v := range fields {
+		if reservedKeys[k] {
+			k = "field_" + k
+		}
+		entry[k] = v
+	}
+	entry["level"] = level
+	entry["msg"] = msg
+	entry["ts"] = l.now().UTC().Format(time.RFC3339Nano)
+	if jobID != "" {
+		entry["job_id"] = jobID
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.out.Printf(`{"level":"error","msg":"failed to marshal log entry","error":%q}`, err.Error())
+		return
+	}
+	l.out.Println(string(line))
+}
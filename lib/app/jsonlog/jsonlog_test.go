@@ -0,0 +1,90 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger() (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	l.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &buf
+}
+
+// lines returns the logged lines, each parsed as a JSON object
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not a JSON object: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogWritesSingleLineJSON(t *testing.T) {
+	l, buf := newTestLogger()
+
+	// Nested values and embedded newlines must not span lines
+	l.Log("info", "job-1", "Job result", Fields{
+		"status": "success",
+		"output": map[string]interface{}{"items": []int{1, 2}},
+		"note":   "first\nsecond",
+	})
+	l.Log("warn", "", "No job", nil)
+
+	entries := lines(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("logged %d lines, want 2: %q", len(entries), buf.String())
+	}
+	first := entries[0]
+	for key, want := range map[string]interface{}{
+		"level":  "info",
+		"msg":    "Job result",
+		"job_id": "job-1",
+		"ts":     "2024-01-02T03:04:05Z",
+		"status": "success",
+		"note":   "first\nsecond",
+	} {
+		if first[key] != want {
+			t.Errorf("%s = %v, want %v", key, first[key], want)
+		}
+	}
+	if _, ok := entries[1]["job_id"]; ok {
+		t.Errorf("entry without a job ID has job_id %v", entries[1]["job_id"])
+	}
+}
+
+func TestLogKeepsReservedKeys(t *testing.T) {
+	l, buf := newTestLogger()
+
+	l.Log("info", "job-1", "Job result", Fields{"level": "debug", "msg": "from handler", "ts": "yesterday", "job_id": "other"})
+
+	entry := lines(t, buf)[0]
+	if entry["level"] != "info" || entry["msg"] != "Job result" || entry["ts"] != "2024-01-02T03:04:05Z" || entry["job_id"] != "job-1" {
+		t.Errorf("reserved keys overwritten by fields: %v", entry)
+	}
+	for _, key := range []string{"field_level", "field_msg", "field_ts", "field_job_id"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("colliding field missing as %s: %v", key, entry)
+		}
+	}
+}
+
+func TestLogUnmarshalableField(t *testing.T) {
+	l, buf := newTestLogger()
+
+	l.Log("info", "", "Bad", Fields{"ch": make(chan int)})
+
+	entry := lines(t, buf)[0]
+	if entry["level"] != "error" || entry["msg"] != "failed to marshal log entry" {
+		t.Errorf("entry = %v, want a marshal error entry", entry)
+	}
+}
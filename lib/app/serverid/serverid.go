@@ -0,0 +1,57 @@
+// Package serverid resolves the server ID that the services report in their
+// responses, so that callers can tell the tasks behind a load balancer apart.
+package serverid
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Resolve returns the container hostname. If it cannot be read, it falls back
+// to the ECS task ID from the task metadata endpoint and then to a random ID,
+// so that the server ID is never empty.
+func Resolve() string {
+	return resolve(os.Hostname, func() string {
+		return TaskID(&http.Client{Timeout: 2 * time.Second}, os.Getenv("ECS_CONTAINER_METADATA_URI_V4"))
+	})
+}
+
+func resolve(hostname func() (string, error), taskID func() string) string {
+	if name, err := hostname(); err == nil && name != "" {
+		return name
+	}
+	if id := taskID(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("server-%08x", rand.Uint32())
+}
+
+// TaskID returns the ID of the ECS task described by the task metadata
+// endpoint at metadataURI, or "" when it is unset or unavailable
+func TaskID(client *http.Client, metadataURI string) string {
+	if metadataURI == "" {
+		return ""
+	}
+
+	resp, err := client.Get(metadataURI + "/task")
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	var task struct {
+		TaskARN string `json:"TaskARN"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil || task.TaskARN == "" {
+		return ""
+	}
+	return task.TaskARN[strings.LastIndex(task.TaskARN, "/")+1:]
+}
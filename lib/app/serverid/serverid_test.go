@@ -0,0 +1,71 @@
+package serverid
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func failingHostname() (string, error) { return "", errors.New("hostname unavailable") }
+
+func TestResolvePrefersHostname(t *testing.T) {
+	got := resolve(func() (string, error) { return "ip-10-0-1-23", nil }, func() string {
+		t.Error("task metadata queried although the hostname is available")
+		return ""
+	})
+	if got != "ip-10-0-1-23" {
+		t.Errorf("resolve() = %q, want the hostname", got)
+	}
+}
+
+func TestResolveHostnameFailure(t *testing.T) {
+	if got := resolve(failingHostname, func() string { return "abc123" }); got != "abc123" {
+		t.Errorf("resolve() = %q, want the task ID", got)
+	}
+
+	// Without task metadata, a random ID keeps distinct servers apart
+	first := resolve(failingHostname, func() string { return "" })
+	second := resolve(failingHostname, func() string { return "" })
+	if !strings.HasPrefix(first, "server-") || len(first) != len("server-")+8 {
+		t.Errorf("resolve() = %q, want a server-xxxxxxxx ID", first)
+	}
+	if first == second {
+		t.Errorf("resolve() returned %q twice, want random IDs", first)
+	}
+}
+
+func TestTaskID(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"ok", http.StatusOK, `{"TaskARN":"arn:aws:ecs:us-east-1:123456789012:task/cluster/abc123"}`, "abc123"},
+		{"error status", http.StatusInternalServerError, `{"TaskARN":"arn:aws:ecs:us-east-1:123456789012:task/cluster/abc123"}`, ""},
+		{"no ARN", http.StatusOK, `{}`, ""},
+		{"invalid JSON", http.StatusOK, `not json`, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/task" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			if got := TaskID(srv.Client(), srv.URL); got != tt.want {
+				t.Errorf("TaskID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := TaskID(http.DefaultClient, ""); got != "" {
+		t.Errorf("TaskID() without a metadata URI = %q, want empty", got)
+	}
+}
@@ -0,0 +1,22 @@
+// Package tlsversion parses the TLS_MIN_VERSION setting of the apps that
+// terminate TLS themselves. TLS 1.2 is the floor: older versions are rejected
+// rather than accepted, so a typo cannot weaken the server below it.
+package tlsversion
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// Parse converts a TLS_MIN_VERSION value such as "1.2" into the
+// corresponding crypto/tls constant. An empty value defaults to TLS 1.2.
+func Parse(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q (expected 1.2 or 1.3)", v)
+	}
+}
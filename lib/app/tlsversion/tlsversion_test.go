@@ -0,0 +1,51 @@
+package tlsversion
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for v, want := range map[string]uint16{"": tls.VersionTLS12, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		if got, err := Parse(v); err != nil || got != want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", v, got, err, want)
+		}
+	}
+	// Nothing below the TLS 1.2 floor is accepted
+	for _, v := range []string{"1.0", "1.1", "1.4", "tls1.2"} {
+		if _, err := Parse(v); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestMinVersionHandshake(t *testing.T) {
+	minVersion, err := Parse("1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MinVersion: minVersion}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(clientVersion uint16) error {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.MinVersion = clientVersion
+		transport.TLSClientConfig.MaxVersion = clientVersion
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(tls.VersionTLS11); err == nil {
+		t.Error("TLS 1.1 handshake succeeded, want it rejected")
+	}
+	if err := get(tls.VersionTLS12); err != nil {
+		t.Errorf("TLS 1.2 handshake failed: %v", err)
+	}
+}
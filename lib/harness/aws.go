@@ -0,0 +1,45 @@
+// Package harness holds the helpers shared by the end-to-end test harnesses
+// under usecases/*/tests.
+package harness
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// LoadAWSConfig loads the default AWS config with a standard retryer that
+// retries each API call up to maxRetries times with exponential backoff, and
+// bounds each API call, retries included, by opTimeout (0 disables the bound)
+func LoadAWSConfig(ctx context.Context, maxRetries int, opTimeout time.Duration) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRetryer(func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = maxRetries + 1
+			o.MaxBackoff = 20 * time.Second
+		})
+	}))
+	if err != nil {
+		return cfg, err
+	}
+	if opTimeout > 0 {
+		cfg.APIOptions = append(cfg.APIOptions, WithOpTimeout(opTimeout))
+	}
+	return cfg, nil
+}
+
+// WithOpTimeout returns an API option that runs each operation under its own
+// context.WithTimeout, so a single hung call cannot eat the whole test budget
+func WithOpTimeout(d time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OpTimeout",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}
+}
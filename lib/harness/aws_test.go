@@ -0,0 +1,95 @@
+package harness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestLoadAWSConfigRetryer(t *testing.T) {
+	// Keep the default config chain away from the developer's AWS setup
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	for _, maxRetries := range []int{0, 2, 5} {
+		cfg, err := LoadAWSConfig(context.Background(), maxRetries, 0)
+		if err != nil {
+			t.Fatalf("LoadAWSConfig(%d) error = %v", maxRetries, err)
+		}
+		if cfg.Retryer == nil {
+			t.Fatalf("LoadAWSConfig(%d) set no retryer", maxRetries)
+		}
+		if got := cfg.Retryer().MaxAttempts(); got != maxRetries+1 {
+			t.Errorf("LoadAWSConfig(%d) retryer max attempts = %d, want %d", maxRetries, got, maxRetries+1)
+		}
+	}
+}
+
+// deadlineSeen runs an operation through a stack configured by opts and
+// returns the deadline of the context the operation handler received
+func deadlineSeen(t *testing.T, ctx context.Context, opts ...func(*middleware.Stack) error) (time.Time, bool) {
+	t.Helper()
+	stack := middleware.NewStack("TestOperation", func() interface{} { return nil })
+	for _, opt := range opts {
+		if err := opt(stack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deadline time.Time
+	var ok bool
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(ctx context.Context, in interface{}) (interface{}, middleware.Metadata, error) {
+		deadline, ok = ctx.Deadline()
+		return nil, middleware.Metadata{}, nil
+	}), stack)
+	if _, _, err := handler.Handle(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	return deadline, ok
+}
+
+func TestWithOpTimeoutSetsDeadline(t *testing.T) {
+	start := time.Now()
+	deadline, ok := deadlineSeen(t, context.Background(), WithOpTimeout(time.Minute))
+	if !ok {
+		t.Fatal("operation context has no deadline")
+	}
+	if deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("deadline is %v after the call started, want 1m", deadline.Sub(start))
+	}
+
+	// An earlier overall deadline still wins
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	outer, _ := ctx.Deadline()
+	if deadline, _ := deadlineSeen(t, ctx, WithOpTimeout(time.Minute)); !deadline.Equal(outer) {
+		t.Errorf("deadline = %v, want the overall deadline %v", deadline, outer)
+	}
+}
+
+func TestLoadAWSConfigOpTimeout(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	cfg, err := LoadAWSConfig(context.Background(), 2, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	deadline, ok := deadlineSeen(t, context.Background(), cfg.APIOptions...)
+	if !ok || deadline.Before(start.Add(30*time.Second)) || deadline.After(time.Now().Add(30*time.Second)) {
+		t.Errorf("deadline = %v (set %t), want 30s after the call started", deadline.Sub(start), ok)
+	}
+
+	cfg, err = LoadAWSConfig(context.Background(), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := deadlineSeen(t, context.Background(), cfg.APIOptions...); ok {
+		t.Error("operation context has a deadline with -op-timeout 0")
+	}
+}
@@ -0,0 +1,112 @@
+package harness
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WaitForHealth polls healthURL every 5s until it answers 200 OK, returning
+// as soon as ctx is done rather than after the next poll
+func WaitForHealth(ctx context.Context, client *http.Client, healthURL string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			return nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		log.Printf("Waiting for health check... (error: %v)", err)
+
+		// Sleep between attempts, but return as soon as the context is done
+		timer := time.NewTimer(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// HeaderFlags collects repeatable -header k=v flags
+type HeaderFlags http.Header
+
+func (h HeaderFlags) String() string {
+	var pairs []string
+	for k, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h HeaderFlags) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("header must be in the form key=value: %q", value)
+	}
+	http.Header(h).Add(k, v)
+	return nil
+}
+
+// HeaderTransport sets the User-Agent and any custom headers on every
+// outgoing request, so that harness traffic can be identified server-side.
+// A nil Base sends requests through http.DefaultTransport.
+type HeaderTransport struct {
+	Base      http.RoundTripper
+	UserAgent string
+	Headers   http.Header
+}
+
+// RegisterHeaderFlags registers -user-agent and -header on fs and returns a
+// HeaderTransport that carries their values once fs has been parsed
+func RegisterHeaderFlags(fs *flag.FlagSet, version string) *HeaderTransport {
+	t := &HeaderTransport{Headers: http.Header{}}
+	fs.StringVar(&t.UserAgent, "user-agent", "hello-fargate-e2e/"+version, "User-Agent header sent with every request")
+	fs.Var(HeaderFlags(t.Headers), "header", "Custom header (key=value) sent with every request; can be repeated")
+	return t
+}
+
+// Wrap returns a copy of t that sends requests through base
+func (t *HeaderTransport) Wrap(base http.RoundTripper) *HeaderTransport {
+	wrapped := *t
+	wrapped.Base = base
+	return &wrapped
+}
+
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.UserAgent != "" {
+		req.Header.Set("User-Agent", t.UserAgent)
+	}
+	for k, values := range t.Headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
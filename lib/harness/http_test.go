@@ -0,0 +1,75 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitForHealthCancelsPromptly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := WaitForHealth(ctx, srv.Client(), srv.URL+"/health")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitForHealth() error = %v, want context.Canceled", err)
+	}
+	// The retry sleep is 5s; cancellation must not wait for it
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitForHealth() returned after %v, want well under the 5s retry interval", elapsed)
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	fs := flag.NewFlagSet("harness", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	transport := RegisterHeaderFlags(fs, "test")
+	if err := fs.Parse([]string{"-header", "no-equals-sign"}); err == nil {
+		t.Error("-header no-equals-sign parsed, want an error")
+	}
+	if err := fs.Parse([]string{"-header", "X-Run-Id=42", "-header", "X-Team=e2e", "-header", "X-Team=infra"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: transport.Wrap(srv.Client().Transport)}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if ua := got.Get("User-Agent"); ua != "hello-fargate-e2e/test" {
+		t.Errorf("User-Agent = %q, want the hello-fargate-e2e/test default", ua)
+	}
+	if v := got.Get("X-Run-Id"); v != "42" {
+		t.Errorf("X-Run-Id = %q, want 42", v)
+	}
+	if v := got.Values("X-Team"); len(v) != 2 || v[0] != "e2e" || v[1] != "infra" {
+		t.Errorf("X-Team = %q, want [e2e infra]", v)
+	}
+	if len(req.Header) != 0 {
+		t.Errorf("caller's request was modified: %v", req.Header)
+	}
+	if transport.Base != nil {
+		t.Error("Wrap modified the registered transport")
+	}
+
+}
@@ -0,0 +1,25 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PrintLogEvent prints a log message, pretty-printing it when pretty is set
+// and the message is a JSON object
+func PrintLogEvent(msg string, pretty bool) {
+	fmt.Println(FormatLogEvent(msg, pretty))
+}
+
+// FormatLogEvent returns msg indented when pretty is set and msg is a JSON
+// object, and msg as-is otherwise
+func FormatLogEvent(msg string, pretty bool) string {
+	if pretty {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(msg), &obj); err == nil {
+			formattedJSON, _ := json.MarshalIndent(obj, "", "  ")
+			return string(formattedJSON)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,23 @@
+package harness
+
+import "testing"
+
+func TestFormatLogEvent(t *testing.T) {
+	const jsonMsg = `{"level":"info","msg":"done"}`
+	tests := []struct {
+		msg    string
+		pretty bool
+		want   string
+	}{
+		{jsonMsg, true, "{\n  \"level\": \"info\",\n  \"msg\": \"done\"\n}"},
+		{jsonMsg, false, jsonMsg},
+		{"plain text line", true, "plain text line"},
+		{"plain text line", false, "plain text line"},
+		{`["not", "an", "object"]`, true, `["not", "an", "object"]`},
+	}
+	for _, tt := range tests {
+		if got := FormatLogEvent(tt.msg, tt.pretty); got != tt.want {
+			t.Errorf("FormatLogEvent(%q, %t) = %q, want %q", tt.msg, tt.pretty, got, tt.want)
+		}
+	}
+}
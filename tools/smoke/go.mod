@@ -0,0 +1,3 @@
+module github.com/example/hello-fargate-smoke
+
+go 1.23
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// probeResult is the outcome of probing one service
+type probeResult struct {
+	URL        string
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// OK reports whether the service answered with a 2xx status
+func (r probeResult) OK() bool {
+	return r.Err == nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+func main() {
+	path := flag.String("path", "/health", "Path probed on each base URL")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for each probe")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification (for self-signed ALB certificates)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] BASE_URL...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	baseURLs := flag.Args()
+	if len(baseURLs) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	results := probeAll(context.Background(), newClient(*timeout, *insecure), baseURLs, *path)
+	if !printResults(os.Stdout, results) {
+		os.Exit(1)
+	}
+}
+
+// newClient returns the client used for the probes, optionally accepting
+// self-signed certificates
+func newClient(timeout time.Duration, insecure bool) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: insecure,
+			},
+		},
+	}
+}
+
+// probeAll probes path on all base URLs concurrently and returns the results
+// in the order of baseURLs
+func probeAll(ctx context.Context, client *http.Client, baseURLs []string, path string) []probeResult {
+	results := make([]probeResult, len(baseURLs))
+	var wg sync.WaitGroup
+	for i, baseURL := range baseURLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = probe(ctx, client, url)
+		}(i, strings.TrimRight(baseURL, "/")+path)
+	}
+	wg.Wait()
+	return results
+}
+
+// probe sends a GET request to url and measures how long the response took
+func probe(ctx context.Context, client *http.Client, url string) probeResult {
+	result := probeResult{URL: url}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create request: %w", err)
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Latency = time.Since(start)
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	result.Latency = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	return result
+}
+
+// printResults writes the results as a table and reports whether all passed
+func printResults(w io.Writer, results []probeResult) bool {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tURL\tSTATUS\tLATENCY\tERROR")
+
+	passed := 0
+	for _, r := range results {
+		outcome := "FAIL"
+		if r.OK() {
+			outcome = "PASS"
+			passed++
+		}
+		status := "-"
+		if r.StatusCode != 0 {
+			status = fmt.Sprintf("%d", r.StatusCode)
+		}
+		errMsg := "-"
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", outcome, r.URL, status, r.Latency.Round(time.Millisecond), errMsg)
+	}
+	if err := tw.Flush(); err != nil {
+		log.Printf("Failed to write results: %v", err)
+	}
+
+	fmt.Fprintf(w, "\n%d/%d services healthy\n", passed, len(results))
+	return passed == len(results)
+}
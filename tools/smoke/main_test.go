@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthServer(status int, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+}
+
+func TestProbeAllConsolidatesResults(t *testing.T) {
+	backend := healthServer(http.StatusOK, 0)
+	defer backend.Close()
+	webapi := healthServer(http.StatusServiceUnavailable, 0)
+	defer webapi.Close()
+	webapp := healthServer(http.StatusOK, 50*time.Millisecond)
+	defer webapp.Close()
+	down := healthServer(http.StatusOK, 0)
+	down.Close()
+
+	baseURLs := []string{backend.URL, webapi.URL + "/", webapp.URL, down.URL}
+	results := probeAll(context.Background(), newClient(5*time.Second, false), baseURLs, "/health")
+
+	// Results keep the order of the base URLs
+	for i, want := range []struct {
+		url    string
+		status int
+		ok     bool
+	}{
+		{backend.URL + "/health", http.StatusOK, true},
+		{webapi.URL + "/health", http.StatusServiceUnavailable, false},
+		{webapp.URL + "/health", http.StatusOK, true},
+		{down.URL + "/health", 0, false},
+	} {
+		r := results[i]
+		if r.URL != want.url || r.StatusCode != want.status || r.OK() != want.ok {
+			t.Errorf("result %d = %s %d ok=%v, want %s %d ok=%v", i, r.URL, r.StatusCode, r.OK(), want.url, want.status, want.ok)
+		}
+	}
+	if results[3].Err == nil {
+		t.Error("probing a closed server reported no error")
+	}
+	if results[2].Latency < 50*time.Millisecond {
+		t.Errorf("latency of the slow service = %v, want at least 50ms", results[2].Latency)
+	}
+
+	var out bytes.Buffer
+	if printResults(&out, results) {
+		t.Error("printResults() reported success with failing services")
+	}
+	if got := out.String(); strings.Count(got, "PASS") != 2 || strings.Count(got, "FAIL") != 2 || !strings.Contains(got, "2/4 services healthy") {
+		t.Errorf("printResults() wrote\n%s\nwant 2 PASS, 2 FAIL and 2/4 services healthy", got)
+	}
+}
+
+func TestProbeAllAllHealthy(t *testing.T) {
+	a := healthServer(http.StatusOK, 0)
+	defer a.Close()
+	b := healthServer(http.StatusNoContent, 0)
+	defer b.Close()
+
+	results := probeAll(context.Background(), newClient(5*time.Second, false), []string{a.URL, b.URL}, "/health")
+	var out bytes.Buffer
+	if !printResults(&out, results) || !strings.Contains(out.String(), "2/2 services healthy") {
+		t.Errorf("printResults() failed for healthy services:\n%s", out.String())
+	}
+}
+
+func TestProbeSelfSignedCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if r := probe(context.Background(), newClient(5*time.Second, false), srv.URL+"/health"); r.OK() || r.Err == nil {
+		t.Errorf("probe() without -insecure = %d, %v, want a certificate error", r.StatusCode, r.Err)
+	}
+	if r := probe(context.Background(), newClient(5*time.Second, true), srv.URL+"/health"); !r.OK() {
+		t.Errorf("probe() with -insecure = %d, %v, want success", r.StatusCode, r.Err)
+	}
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
-	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/example/hello-fargate-app/drain"
+	"github.com/example/hello-fargate-app/handlertimeout"
+	"github.com/example/hello-fargate-app/serverid"
+	"github.com/example/hello-fargate-app/tlsversion"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // HealthResponse represents the health check response
@@ -27,13 +39,51 @@ type EchoResponse struct {
 
 var serverID string
 
+// writeTimeout is the server's WriteTimeout, which HANDLER_TIMEOUT_SECONDS
+// must stay below
+const writeTimeout = 10 * time.Second
+
+// Build metadata, set at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// serverIDHeader carries the server ID on every health and echo response, so
+// callers can identify the backend even when the body is an error page
+const serverIDHeader = "X-Server-Id"
+
+// Prometheus metrics exposed on /metrics
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_http_requests_total",
+		Help: "Total HTTP requests by handler and status code.",
+	}, []string{"handler", "code"})
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "backend_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+	echoDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "backend_echo_duration_seconds",
+		Help:    "Latency of /api/echo requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// failures decides whether /api/echo should fail; nil disables injection
+var failures *failureInjector
+
+// echoLatency is an artificial delay added to every /api/echo request
+var echoLatency time.Duration
+
+// drainer serves /ready and drains the server on shutdown
+var drainer drain.Drainer
+
 func init() {
 	// Use container hostname as unique server ID
-	var err error
-	serverID, err = os.Hostname()
-	if err != nil {
-		serverID = "unknown"
-	}
+	serverID = serverid.Resolve()
 }
 
 func main() {
@@ -42,37 +92,74 @@ func main() {
 		port = "8080"
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/api/echo", echoHandler)
+	// Optional failure injection for chaos testing
+	var err error
+	failures, err = newFailureInjectorFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid failure injection config: %v", err)
+	}
+	if failures != nil {
+		log.Printf("Failure injection enabled (rate: %.2f, status: %d)", failures.rate, failures.status)
+	}
+
+	echoLatency, err = echoLatencyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if echoLatency > 0 {
+		log.Printf("Latency injection enabled (%v per echo request)", echoLatency)
+	}
+
+	drainDelay, err := drain.DelayFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	drainer.ServerID = serverID
+	drainer.Delay = drainDelay
+
+	// Bound the processing time of every request
+	handlerTimeout, err := handlertimeout.FromEnv(writeTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := newMux()
 
 	server := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      handlertimeout.Wrap(mux, handlerTimeout),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Graceful shutdown handling
+	// Terminate TLS directly when a certificate is provided
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	tlsEnabled := tlsCertFile != "" && tlsKeyFile != ""
+	if tlsEnabled {
+		minVersion, err := tlsversion.Parse(os.Getenv("TLS_MIN_VERSION"))
+		if err != nil {
+			log.Fatalf("Invalid TLS_MIN_VERSION: %v", err)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: minVersion}
+	}
+
+	// Graceful shutdown: fail /ready, drain, then shut down
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal %v, initiating graceful shutdown...", sig)
-
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
-		}
-	}()
+	go drainer.ShutdownOnSignal(server, sigChan)
 
 	log.Printf("Backend server starting on port %s (Server ID: %s)", port, serverID)
+	drainer.SetReady()
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if tlsEnabled {
+		log.Printf("TLS enabled (min version: %s)", tls.VersionName(server.TLSConfig.MinVersion))
+		err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 
@@ -86,10 +173,43 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(serverIDHeader, serverID)
 	json.NewEncoder(w).Encode(resp)
 }
 
+// versionHandler reports the build metadata of the running image, which tells
+// old and new tasks apart during a rolling deployment
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"server_id":  serverID,
+	})
+}
+
 func echoHandler(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(echoDuration)
+	defer timer.ObserveDuration()
+
+	// Set before any error response, which http.Error writes as plain text
+	w.Header().Set(serverIDHeader, serverID)
+
+	if echoLatency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(echoLatency):
+		}
+	}
+
+	if failures.shouldFail() {
+		log.Printf("Injected failure on echo request (Server ID: %s)", serverID)
+		http.Error(w, "injected failure", failures.status)
+		return
+	}
+
 	var input map[string]interface{}
 
 	if r.Method == http.MethodPost && r.Body != nil {
@@ -108,3 +228,102 @@ func echoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// echoLatencyFromEnv reads the /api/echo delay from BACKEND_LATENCY_MS; unset
+// means none. The delay must be below writeTimeout, after which the server
+// closes the connection before the response is written.
+func echoLatencyFromEnv() (time.Duration, error) {
+	v := os.Getenv("BACKEND_LATENCY_MS")
+	if v == "" {
+		return 0, nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid BACKEND_LATENCY_MS %q: must be a non-negative integer", v)
+	}
+	latency := time.Duration(ms) * time.Millisecond
+	if latency >= writeTimeout {
+		return 0, fmt.Errorf("invalid BACKEND_LATENCY_MS %q: must be below the server's %v write timeout", v, writeTimeout)
+	}
+	return latency, nil
+}
+
+// failureInjector randomly fails requests at a configured rate, replying
+// with status
+type failureInjector struct {
+	rate   float64
+	status int
+	mu     sync.Mutex
+	rng    *rand.Rand
+}
+
+// newFailureInjector creates an injector failing with probability rate.
+// The same seed always yields the same sequence of failures.
+func newFailureInjector(rate float64, status int, seed int64) (*failureInjector, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("failure rate must be between 0.0 and 1.0, got %v", rate)
+	}
+	return &failureInjector{rate: rate, status: status, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// newFailureInjectorFromEnv reads FAILURE_RATE, FAILURE_STATUS (500 by
+// default, e.g. 503 to look like an overloaded backend) and FAILURE_SEED. It
+// returns nil when the rate is unset or zero.
+func newFailureInjectorFromEnv() (*failureInjector, error) {
+	v := os.Getenv("FAILURE_RATE")
+	if v == "" {
+		return nil, nil
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FAILURE_RATE %q: %w", v, err)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+
+	status := http.StatusInternalServerError
+	if s := os.Getenv("FAILURE_STATUS"); s != "" {
+		status, err = strconv.Atoi(s)
+		if err != nil || status < 500 || status > 599 {
+			return nil, fmt.Errorf("invalid FAILURE_STATUS %q: must be a 5xx status code", s)
+		}
+	}
+
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("FAILURE_SEED"); s != "" {
+		seed, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FAILURE_SEED %q: %w", s, err)
+		}
+	}
+	return newFailureInjector(rate, status, seed)
+}
+
+// shouldFail reports whether the current request should fail
+func (f *failureInjector) shouldFail() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < f.rate
+}
+
+// newMux routes the backend endpoints, all instrumented except /metrics itself
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/health", instrument("health", healthHandler))
+	mux.Handle("/ready", instrument("ready", drainer.ServeHTTP))
+	mux.Handle("/version", instrument("version", versionHandler))
+	mux.Handle("/api/echo", instrument("echo", echoHandler))
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// instrument wraps h to count requests by handler and track in-flight requests
+func instrument(name string, h http.HandlerFunc) http.Handler {
+	counter := requestsTotal.MustCurryWith(prometheus.Labels{"handler": name})
+	return promhttp.InstrumentHandlerInFlight(inFlightRequests,
+		promhttp.InstrumentHandlerCounter(counter, h))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setFailures installs f as the echo failure injector for the test
+func setFailures(t *testing.T, f *failureInjector) {
+	t.Helper()
+	prev := failures
+	failures = f
+	t.Cleanup(func() { failures = prev })
+}
+
+func TestFailureInjectionRate(t *testing.T) {
+	for _, tt := range []struct {
+		rate       float64
+		wantStatus int
+	}{
+		{1.0, http.StatusInternalServerError},
+		{0.0, http.StatusOK},
+	} {
+		t.Run(fmt.Sprint(tt.rate), func(t *testing.T) {
+			f, err := newFailureInjector(tt.rate, http.StatusInternalServerError, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setFailures(t, f)
+			for i := 0; i < 50; i++ {
+				rec := httptest.NewRecorder()
+				echoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/echo", nil))
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d", i, rec.Code, tt.wantStatus)
+				}
+			}
+
+			// /health is never subject to injection
+			rec := httptest.NewRecorder()
+			healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("/health status = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
+
+func TestFailureInjectionSeeded(t *testing.T) {
+	const n = 1000
+	sequence := func() []bool {
+		f, err := newFailureInjector(0.3, http.StatusInternalServerError, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq := make([]bool, n)
+		for i := range seq {
+			seq[i] = f.shouldFail()
+		}
+		return seq
+	}
+
+	first, second := sequence(), sequence()
+	failed := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("decision %d differs between runs with the same seed", i)
+		}
+		if first[i] {
+			failed++
+		}
+	}
+	if failed < 250 || failed > 350 {
+		t.Errorf("%d of %d requests failed at rate 0.3, want about 300", failed, n)
+	}
+}
+
+func TestNewFailureInjectorRejectsInvalidRate(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.5} {
+		if _, err := newFailureInjector(rate, http.StatusInternalServerError, 1); err == nil {
+			t.Errorf("newFailureInjector(%v) succeeded, want an error", rate)
+		}
+	}
+}
+
+// metricValue scrapes the /metrics endpoint of srv and returns the value of
+// series, or 0 when it is not exported yet
+func metricValue(t *testing.T, srv *httptest.Server, series string) float64 {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("invalid value in %q: %v", line, err)
+			}
+			return f
+		}
+	}
+	return 0
+}
+
+func TestMetricsCountEchoRequests(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	const echoes = `backend_http_requests_total{code="200",handler="echo"}`
+	const latency = `backend_echo_duration_seconds_count`
+	beforeEchoes, beforeLatency := metricValue(t, srv, echoes), metricValue(t, srv, latency)
+
+	for i := 0; i < 2; i++ {
+		resp, err := srv.Client().Post(srv.URL+"/api/echo", "application/json", strings.NewReader(`{"n":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := metricValue(t, srv, echoes) - beforeEchoes; got != 2 {
+		t.Errorf("%s increased by %v, want 2", echoes, got)
+	}
+	if got := metricValue(t, srv, latency) - beforeLatency; got != 2 {
+		t.Errorf("%s increased by %v, want 2", latency, got)
+	}
+	// Scrapes are not counted as requests
+	if got := metricValue(t, srv, `backend_http_requests_total{code="200",handler="metrics"}`); got != 0 {
+		t.Errorf("/metrics requests counted: %v", got)
+	}
+}
+
+func TestServerIDHeader(t *testing.T) {
+	prevID, prevFailures := serverID, failures
+	t.Cleanup(func() { serverID, failures = prevID, prevFailures })
+	serverID = "backend-1"
+
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	check := func(name string, resp *http.Response, err error, wantStatus int) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus || resp.Header.Get(serverIDHeader) != "backend-1" {
+			t.Errorf("%s = %d with %s %q, want %d with backend-1", name, resp.StatusCode, serverIDHeader, resp.Header.Get(serverIDHeader), wantStatus)
+		}
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/health")
+	check("GET /health", resp, err, http.StatusOK)
+	resp, err = srv.Client().Post(srv.URL+"/api/echo", "application/json", strings.NewReader(`{"n":1}`))
+	check("POST /api/echo", resp, err, http.StatusOK)
+
+	// Injected failures are plain-text error pages, which still carry the header
+	failures, err = newFailureInjector(1, http.StatusServiceUnavailable, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = srv.Client().Post(srv.URL+"/api/echo", "application/json", strings.NewReader(`{"n":1}`))
+	check("failing POST /api/echo", resp, err, http.StatusServiceUnavailable)
+}
+
+func TestVersionHandler(t *testing.T) {
+	prev := serverID
+	t.Cleanup(func() { serverID = prev })
+	serverID = "backend-1"
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/version returned invalid JSON %q: %v", rec.Body, err)
+	}
+	// Without -ldflags the build metadata defaults to dev
+	want := map[string]string{"version": "dev", "commit": "dev", "build_time": "dev", "server_id": "backend-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/version = %v, want %v", got, want)
+	}
+}
+
+func TestEchoLatencyFromEnv(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": 0, "0": 0, "250": 250 * time.Millisecond, "9999": 9999 * time.Millisecond} {
+		t.Setenv("BACKEND_LATENCY_MS", v)
+		if got, err := echoLatencyFromEnv(); err != nil || got != want {
+			t.Errorf("BACKEND_LATENCY_MS=%q: echoLatencyFromEnv() = %v, %v, want %v", v, got, err, want)
+		}
+	}
+	// The response must be written before the server's write timeout
+	for _, v := range []string{"-1", "abc", "10000", "60000"} {
+		t.Setenv("BACKEND_LATENCY_MS", v)
+		if _, err := echoLatencyFromEnv(); err == nil {
+			t.Errorf("BACKEND_LATENCY_MS=%q: echoLatencyFromEnv() succeeded, want an error", v)
+		}
+	}
+}
+
+func TestEchoHandlerLatency(t *testing.T) {
+	prev := echoLatency
+	t.Cleanup(func() { echoLatency = prev })
+	echoLatency = 50 * time.Millisecond
+	setFailures(t, nil)
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	echoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/echo", nil))
+	if elapsed := time.Since(start); elapsed < echoLatency || rec.Code != http.StatusOK {
+		t.Errorf("echo = %d after %v, want 200 after at least %v", rec.Code, elapsed, echoLatency)
+	}
+
+	// A request that goes away stops waiting without a response
+	echoLatency = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec = httptest.NewRecorder()
+	start = time.Now()
+	echoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/echo", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed > time.Second || rec.Body.Len() != 0 {
+		t.Errorf("canceled echo returned %q after %v, want nothing right after the cancellation", rec.Body, elapsed)
+	}
+}
+
+func TestNewFailureInjectorFromEnvStatus(t *testing.T) {
+	t.Setenv("FAILURE_RATE", "1")
+	for v, want := range map[string]int{"": http.StatusInternalServerError, "503": http.StatusServiceUnavailable} {
+		t.Setenv("FAILURE_STATUS", v)
+		f, err := newFailureInjectorFromEnv()
+		if err != nil || f == nil || f.status != want {
+			t.Errorf("FAILURE_STATUS=%q: injector = %+v, %v, want status %d", v, f, err, want)
+		}
+	}
+	for _, v := range []string{"abc", "200", "404", "600"} {
+		t.Setenv("FAILURE_STATUS", v)
+		if _, err := newFailureInjectorFromEnv(); err == nil {
+			t.Errorf("FAILURE_STATUS=%q accepted, want an error", v)
+		}
+	}
+
+	// Without a rate, nothing is injected whatever the status
+	t.Setenv("FAILURE_RATE", "")
+	t.Setenv("FAILURE_STATUS", "503")
+	if f, err := newFailureInjectorFromEnv(); f != nil || err != nil {
+		t.Errorf("FAILURE_RATE unset: injector = %+v, %v, want none", f, err)
+	}
+}
+
+func TestEchoHandlerSeededErrorRate(t *testing.T) {
+	t.Setenv("FAILURE_RATE", "0.5")
+	t.Setenv("FAILURE_STATUS", "503")
+	t.Setenv("FAILURE_SEED", "7")
+
+	statuses := func() []int {
+		f, err := newFailureInjectorFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		setFailures(t, f)
+		var codes []int
+		for i := 0; i < 20; i++ {
+			rec := httptest.NewRecorder()
+			echoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/echo", nil))
+			codes = append(codes, rec.Code)
+		}
+		return codes
+	}
+
+	first, again := statuses(), statuses()
+	if !reflect.DeepEqual(first, again) {
+		t.Fatalf("FAILURE_SEED=7 replied %v, then %v, want the same statuses", first, again)
+	}
+	counts := map[int]int{}
+	for _, code := range first {
+		counts[code]++
+	}
+	if counts[http.StatusServiceUnavailable] == 0 || counts[http.StatusOK] == 0 || counts[http.StatusOK]+counts[http.StatusServiceUnavailable] != len(first) {
+		t.Errorf("statuses = %v, want a mix of 200 and 503 only", first)
+	}
+}
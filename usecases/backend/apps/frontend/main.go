@@ -3,16 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/example/hello-fargate-app/drain"
+	"github.com/example/hello-fargate-app/handlertimeout"
+	"github.com/example/hello-fargate-app/serverid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // HealthResponse represents the health check response
@@ -31,28 +43,109 @@ type BackendEchoResponse struct {
 
 // TestResponse represents the /api/test endpoint response
 type TestResponse struct {
-	TotalRequests  int            `json:"total_requests"`
-	SuccessCount   int            `json:"success_count"`
-	FailureCount   int            `json:"failure_count"`
-	UniqueBackends int            `json:"unique_backends"`
-	Distribution   map[string]int `json:"distribution"`
-	Success        bool           `json:"success"`
-	Message        string         `json:"message"`
-	FrontendID     string         `json:"frontend_id"`
+	TotalRequests  int             `json:"total_requests"`
+	SuccessCount   int             `json:"success_count"`
+	FailureCount   int             `json:"failure_count"`
+	UniqueBackends int             `json:"unique_backends"`
+	SkewViolations int             `json:"skew_violations"`
+	DurationMs     int64           `json:"duration_ms"`
+	RetryCount     int             `json:"retry_count"`
+	Distribution   map[string]int  `json:"distribution"`
+	Success        bool            `json:"success"`
+	Message        string          `json:"message"`
+	FrontendID     string          `json:"frontend_id"`
+	Connection     *ConnectionInfo `json:"backend_connection,omitempty"`
+	Session        string          `json:"session,omitempty"`
+}
+
+// SessionHistory is the /api/test/history response: every backend server ID
+// observed across the /api/test runs of a session
+type SessionHistory struct {
+	Session  string                      `json:"session"`
+	Runs     int                         `json:"runs"`
+	Backends map[string]*BackendSighting `json:"backends"`
+
+	lastRun time.Time // when the session last recorded a run, for eviction
+}
+
+// Limits on the in-memory session histories: once maxSessions are kept, the
+// least recently run session is evicted to make room for a new one
+const (
+	maxSessions      = 100
+	maxSessionLength = 128
+)
+
+// BackendSighting records when a backend server ID was seen within a session.
+// A backend whose last_seen_run is behind the session's runs has stopped
+// responding, e.g. because its task was replaced during a deployment.
+type BackendSighting struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	FirstSeenRun int       `json:"first_seen_run"`
+	LastSeenRun  int       `json:"last_seen_run"`
+	Requests     int       `json:"requests"`
+}
+
+// ConnectionInfo describes how a sample request reached the backend
+type ConnectionInfo struct {
+	Host              string   `json:"host"`
+	ResolvedAddrs     []string `json:"resolved_addrs,omitempty"`
+	RemoteAddr        string   `json:"remote_addr"`
+	ViaServiceConnect bool     `json:"via_service_connect"`
+	Error             string   `json:"error,omitempty"`
 }
 
 var (
 	serverID   string
 	backendURL string
+	retryMax   int // retries per backend request after transient errors
+
+	historyMu sync.Mutex
+	histories = make(map[string]*SessionHistory) // keyed by session
 )
 
+// writeTimeout is the server's WriteTimeout, which HANDLER_TIMEOUT_SECONDS
+// must stay below
+const writeTimeout = 60 * time.Second
+
+// Build metadata, set at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "frontend_http_requests_total",
+		Help: "Total HTTP requests by handler and status code.",
+	}, []string{"handler", "code"})
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "frontend_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+	backendEchoDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "frontend_backend_echo_duration_seconds",
+		Help:    "Latency of requests from the frontend to the backend /api/echo.",
+		Buckets: prometheus.DefBuckets,
+	})
+	backendResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "frontend_backend_responses_total",
+		Help: "Successful backend echo responses by backend server ID.",
+	}, []string{"server_id"})
+)
+
+// errClockSkew is returned when a backend timestamp is outside the skew window
+var errClockSkew = errors.New("backend timestamp outside skew window")
+
+// drainer serves /ready and drains the server on shutdown
+var drainer drain.Drainer
+
 func init() {
 	// Use container hostname as unique server ID
-	var err error
-	serverID, err = os.Hostname()
-	if err != nil {
-		serverID = "unknown"
-	}
+	serverID = serverid.Resolve()
 
 	// Backend URL from environment (defaults to Service Connect endpoint)
 	backendURL = os.Getenv("BACKEND_URL")
@@ -67,36 +160,46 @@ func main() {
 		port = "8080"
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/api/test", testHandler)
+	if v := os.Getenv("RETRY_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid RETRY_MAX %q: must be a non-negative integer", v)
+		}
+		retryMax = n
+	}
+
+	drainDelay, err := drain.DelayFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	drainer.ServerID = serverID
+	drainer.Delay = drainDelay
+
+	// Bound the processing time of every request
+	handlerTimeout, err := handlertimeout.FromEnv(writeTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := newMux()
 
 	server := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      handlertimeout.Wrap(mux, handlerTimeout),
 		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	// Graceful shutdown handling
+	// Graceful shutdown: fail /ready, drain, then shut down
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal %v, initiating graceful shutdown...", sig)
-
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
-		}
-	}()
+	go drainer.ShutdownOnSignal(server, sigChan)
 
 	log.Printf("Frontend server starting on port %s (Server ID: %s)", port, serverID)
 	log.Printf("Backend URL: %s", backendURL)
+	log.Printf("Backend request retries: %d", retryMax)
+	drainer.SetReady()
 
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
@@ -115,98 +218,474 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// versionHandler reports the build metadata of the running image, which tells
+// old and new tasks apart during a rolling deployment
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"server_id":  serverID,
+	})
+}
+
+// LoadTestConfig describes one load test run against the backend
+type LoadTestConfig struct {
+	BackendURL  string        // Base URL of the backend service
+	Requests    int           // Total number of echo requests
+	Concurrency int           // Number of concurrent workers
+	Ramp        time.Duration // Period over which concurrency grows to the target
+	Delay       time.Duration // Delay between requests of each worker
+	MaxSkew     time.Duration // Maximum backend timestamp skew; 0 disables the check
+	MaxRetries  int           // Retries per request after transient errors
+	MinBackends int           // Unique backends required for success; at least 1
+}
+
 func testHandler(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if len(session) > maxSessionLength {
+		http.Error(w, fmt.Sprintf("session must be at most %d characters", maxSessionLength), http.StatusBadRequest)
+		return
+	}
+
+	cfg := LoadTestConfig{
+		BackendURL:  backendURL,
+		Requests:    20,
+		Concurrency: 1,
+		Delay:       50 * time.Millisecond,
+		MaxRetries:  retryMax,
+		MinBackends: 2,
+	}
+
 	// Get number of requests from query param (default 20)
-	requestCountStr := r.URL.Query().Get("requests")
-	requestCount := 20
-	if requestCountStr != "" {
-		if n, err := strconv.Atoi(requestCountStr); err == nil && n > 0 {
-			requestCount = n
+	if v := r.URL.Query().Get("requests"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Requests = n
 		}
 	}
 
-	log.Printf("Starting test with %d requests to backend", requestCount)
+	// Number of concurrent workers (default 1, i.e. sequential)
+	if v := r.URL.Query().Get("concurrency"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Concurrency = n
+		}
+	}
 
-	// Track responses from each backend server
-	distribution := make(map[string]int)
-	successCount := 0
-	failureCount := 0
+	// Optional ramp period over which concurrency grows from 1 to the target
+	if v := r.URL.Query().Get("ramp_ms"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Ramp = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	// Delay between requests of each worker (default 50ms, 0 disables)
+	if v := r.URL.Query().Get("delay_ms"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.Delay = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	// Unique backends that must respond for the test to succeed (default 2)
+	if v := r.URL.Query().Get("min_backends"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.MinBackends = n
+		}
+	}
+
+	// Optional maximum allowed skew between backend timestamps and local time
+	if v := r.URL.Query().Get("max_skew_ms"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.MaxSkew = time.Duration(n) * time.Millisecond
+		}
+	}
 
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
+	// The request context is canceled when the handler times out or the
+	// caller goes away, which stops the remaining backend requests
+	result := runLoadTest(r.Context(), client, cfg)
+
+	// Accumulate the observed backends when the caller names a session
+	if session != "" {
+		recordHistory(session, result.Distribution, time.Now())
+		result.Session = session
+	}
 
-	for i := 0; i < requestCount; i++ {
-		payload := fmt.Sprintf(`{"request_number": %d, "frontend_id": "%s"}`, i, serverID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
 
-		resp, err := client.Post(
-			backendURL+"/api/echo",
-			"application/json",
-			strings.NewReader(payload),
-		)
-		if err != nil {
-			log.Printf("Request %d failed: %v", i, err)
-			failureCount++
-			continue
-		}
+// runLoadTest sends cfg.Requests echo requests to the backend through client
+// and summarizes which backends handled them. The test succeeds when at least
+// cfg.MinBackends unique backends responded and no backend timestamp was
+// skewed. Requests sent after ctx is done fail immediately.
+func runLoadTest(ctx context.Context, client *http.Client, cfg LoadTestConfig) TestResponse {
+	log.Printf("Starting test with %d requests to backend (concurrency: %d, ramp: %v, delay: %v)", cfg.Requests, cfg.Concurrency, cfg.Ramp, cfg.Delay)
 
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+	// Track responses from each backend server
+	distribution := make(map[string]int)
+	successCount := 0
+	failureCount := 0
+	skewViolations := 0
+	retryCount := 0
+	var mu sync.Mutex
 
-		if err != nil {
-			log.Printf("Request %d: failed to read response: %v", i, err)
-			failureCount++
-			continue
-		}
+	// Capture how the backend is reached before sending the load
+	conn := traceBackendConnection(client, cfg.BackendURL+"/health")
+	log.Printf("Backend connection: host=%s resolved=%v remote=%s via_service_connect=%t",
+		conn.Host, conn.ResolvedAddrs, conn.RemoteAddr, conn.ViaServiceConnect)
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Request %d: unexpected status %d", i, resp.StatusCode)
-			failureCount++
-			continue
-		}
+	start := time.Now()
+	runRamped(cfg.Requests, cfg.Concurrency, cfg.Ramp, cfg.Delay, func(i int) {
+		backendID, retried, err := sendEchoWithRetry(ctx, client, cfg.BackendURL, i, cfg.MaxSkew, cfg.MaxRetries)
 
-		var echoResp BackendEchoResponse
-		if err := json.Unmarshal(body, &echoResp); err != nil {
-			log.Printf("Request %d: failed to parse response: %v", i, err)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			log.Printf("Request %d: %v", i, err)
+			if errors.Is(err, errClockSkew) {
+				skewViolations++
+			}
 			failureCount++
-			continue
+			return
 		}
-
-		distribution[echoResp.ServerID]++
+		if retried {
+			retryCount++
+		}
+		distribution[backendID]++
+		backendResponses.WithLabelValues(backendID).Inc()
 		successCount++
-		log.Printf("Request %d: handled by backend %s", i, echoResp.ServerID)
+		log.Printf("Request %d: handled by backend %s", i, backendID)
+	})
 
-		// Small delay to allow load balancing
-		time.Sleep(50 * time.Millisecond)
-	}
+	duration := time.Since(start)
 
-	// Determine success (at least 2 unique backends)
+	// Determine success (enough unique backends and no clock skew)
 	uniqueBackends := len(distribution)
-	success := uniqueBackends >= 2
+	minBackends := max(cfg.MinBackends, 1)
+	success := uniqueBackends >= minBackends && skewViolations == 0
 
-	message := fmt.Sprintf("Sent %d requests, %d unique backends responded", requestCount, uniqueBackends)
-	if success {
+	message := fmt.Sprintf("Sent %d requests, %d unique backends responded", cfg.Requests, uniqueBackends)
+	switch {
+	case success:
 		message = "SUCCESS: " + message
-	} else {
-		message = "FAIL: " + message + " (expected at least 2)"
+	case uniqueBackends < minBackends:
+		message = fmt.Sprintf("FAIL: %s (expected at least %d)", message, minBackends)
+	default:
+		message = fmt.Sprintf("FAIL: %s (%d backend timestamps outside the %v skew window)", message, skewViolations, cfg.MaxSkew)
+	}
+
+	log.Printf("Test completed in %v: %s", duration.Round(time.Millisecond), message)
+	for backendID, count := range distribution {
+		log.Printf("  Backend %s: %d requests (%.1f%%)", backendID, count, float64(count)/float64(cfg.Requests)*100)
 	}
 
-	result := TestResponse{
-		TotalRequests:  requestCount,
+	return TestResponse{
+		TotalRequests:  cfg.Requests,
 		SuccessCount:   successCount,
 		FailureCount:   failureCount,
 		UniqueBackends: uniqueBackends,
+		SkewViolations: skewViolations,
+		DurationMs:     duration.Milliseconds(),
+		RetryCount:     retryCount,
 		Distribution:   distribution,
 		Success:        success,
 		Message:        message,
 		FrontendID:     serverID,
+		Connection:     conn,
 	}
+}
 
-	log.Printf("Test completed: %s", message)
-	for backendID, count := range distribution {
-		log.Printf("  Backend %s: %d requests (%.1f%%)", backendID, count, float64(count)/float64(requestCount)*100)
+// historyHandler returns the backends observed across the /api/test runs of
+// the session given by the session query param
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		http.Error(w, "session query param is required", http.StatusBadRequest)
+		return
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, ok := histories[session]
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(h)
+}
+
+// recordHistory adds the backends of one /api/test run to the session
+// history, evicting the least recently run session when a new one would
+// exceed maxSessions
+func recordHistory(session string, distribution map[string]int, now time.Time) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, ok := histories[session]
+	if !ok {
+		if len(histories) >= maxSessions {
+			evictOldestSession()
+		}
+		h = &SessionHistory{Session: session, Backends: make(map[string]*BackendSighting)}
+		histories[session] = h
+	}
+	h.Runs++
+	h.lastRun = now
+
+	for backendID, count := range distribution {
+		b, ok := h.Backends[backendID]
+		if !ok {
+			b = &BackendSighting{FirstSeen: now, FirstSeenRun: h.Runs}
+			h.Backends[backendID] = b
+		}
+		b.LastSeen = now
+		b.LastSeenRun = h.Runs
+		b.Requests += count
+	}
+}
+
+// evictOldestSession removes the least recently run session history. The
+// caller must hold historyMu.
+func evictOldestSession() {
+	var oldest *SessionHistory
+	for _, h := range histories {
+		if oldest == nil || h.lastRun.Before(oldest.lastRun) {
+			oldest = h
+		}
+	}
+	if oldest != nil {
+		delete(histories, oldest.Session)
+	}
+}
+
+// retryableError marks a transient backend error worth retrying
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// sendEchoWithRetry calls sendEcho, retrying transient errors up to
+// maxRetries times with exponential backoff and jitter. retried reports
+// whether more than one attempt was made.
+func sendEchoWithRetry(ctx context.Context, client *http.Client, baseURL string, i int, maxSkew time.Duration, maxRetries int) (backendID string, retried bool, err error) {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		backendID, err = sendEcho(ctx, client, baseURL, i, maxSkew)
+		var re retryableError
+		if err == nil || !errors.As(err, &re) || attempt >= maxRetries || ctx.Err() != nil {
+			return backendID, attempt > 0, err
+		}
+
+		// Sleep between half and the full backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Printf("Request %d: attempt %d failed, retrying in %v: %v", i, attempt+1, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return "", attempt > 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// sendEcho sends request i to the backend at baseURL and returns the ID of the backend
+// server that handled it. A non-zero maxSkew also checks the backend timestamp.
+func sendEcho(ctx context.Context, client *http.Client, baseURL string, i int, maxSkew time.Duration) (string, error) {
+	payload := fmt.Sprintf(`{"request_number": %d, "frontend_id": "%s"}`, i, serverID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/api/echo", strings.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	timer := prometheus.NewTimer(backendEchoDuration)
+	resp, err := client.Do(req)
+	timer.ObserveDuration()
+	if err != nil {
+		return "", retryableError{fmt.Errorf("request failed: %w", err)}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 500 {
+		return "", retryableError{fmt.Errorf("unexpected status %d%s", resp.StatusCode, fromBackend(resp.Header))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d%s", resp.StatusCode, fromBackend(resp.Header))
+	}
+
+	backendID, echoResp, parsed := parseEchoResponse(resp.Header, body)
+	if backendID == "" {
+		return "", fmt.Errorf("no server ID in response body or %s header", serverIDHeader)
+	}
+
+	// The timestamp is only in the body
+	if maxSkew > 0 {
+		if !parsed {
+			return "", fmt.Errorf("backend %s: unparseable response, cannot check timestamp", backendID)
+		}
+		if err := checkTimestamp(echoResp.Timestamp, time.Now(), maxSkew); err != nil {
+			return "", fmt.Errorf("backend %s: %w", backendID, err)
+		}
+	}
+	return backendID, nil
+}
+
+// serverIDHeader is the response header in which the backend reports its
+// server ID
+const serverIDHeader = "X-Server-Id"
+
+// parseEchoResponse returns the ID of the backend that handled an echo
+// request, taken from the JSON body or, when the body cannot be parsed or
+// lacks it, from the X-Server-Id header. parsed reports whether the body was
+// a valid echo response.
+func parseEchoResponse(header http.Header, body []byte) (backendID string, echoResp BackendEchoResponse, parsed bool) {
+	parsed = json.Unmarshal(body, &echoResp) == nil
+	if parsed && echoResp.ServerID != "" {
+		return echoResp.ServerID, echoResp, parsed
+	}
+	return header.Get(serverIDHeader), echoResp, parsed
+}
+
+// fromBackend names the backend of an error response when it set the
+// X-Server-Id header
+func fromBackend(header http.Header) string {
+	if id := header.Get(serverIDHeader); id != "" {
+		return " from backend " + id
+	}
+	return ""
+}
+
+// checkTimestamp verifies that ts is an RFC3339 time within maxSkew of now
+func checkTimestamp(ts string, now time.Time, maxSkew time.Duration) error {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", ts, err)
+	}
+	skew := now.Sub(t)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return fmt.Errorf("%w: %s is %v from now (max %v)", errClockSkew, ts, skew.Round(time.Millisecond), maxSkew)
+	}
+	return nil
+}
+
+// runRamped calls send for each of count requests using up to concurrency
+// workers. With a non-zero ramp, workers start one at a time so that
+// concurrency grows linearly from 1 to the target over the ramp period.
+// Each worker waits delay between its requests.
+func runRamped(count, concurrency int, ramp, delay time.Duration, send func(i int)) {
+	if concurrency > count {
+		concurrency = count
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for w := 0; w < concurrency; w++ {
+		// Worker w joins at ramp * w / (concurrency-1)
+		var joinAt time.Duration
+		if ramp > 0 && concurrency > 1 {
+			joinAt = ramp * time.Duration(w) / time.Duration(concurrency-1)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d := joinAt - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+			for i := range jobs {
+				send(i)
+				// Small delay to allow load balancing
+				if delay > 0 {
+					time.Sleep(delay)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// traceBackendConnection sends a GET to url and records the DNS answer and
+// the address the connection was made to. Service Connect maps backend names
+// to a loopback address served by the local Envoy proxy, so a loopback remote
+// address means the mesh is in the path rather than direct DNS.
+func traceBackendConnection(client *http.Client, url string) *ConnectionInfo {
+	info := &ConnectionInfo{}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(di httptrace.DNSStartInfo) {
+			info.Host = di.Host
+		},
+		DNSDone: func(di httptrace.DNSDoneInfo) {
+			for _, a := range di.Addrs {
+				info.ResolvedAddrs = append(info.ResolvedAddrs, a.String())
+			}
+		},
+		GotConn: func(ci httptrace.GotConnInfo) {
+			if ci.Conn != nil {
+				info.RemoteAddr = ci.Conn.RemoteAddr().String()
+			}
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, url, nil)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		info.Error = err.Error()
+	} else {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if host, _, err := net.SplitHostPort(info.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			info.ViaServiceConnect = ip.IsLoopback()
+		}
+	}
+	return info
+}
+
+// newMux routes the frontend endpoints, all instrumented except /metrics itself
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/health", instrument("health", healthHandler))
+	mux.Handle("/ready", instrument("ready", drainer.ServeHTTP))
+	mux.Handle("/version", instrument("version", versionHandler))
+	mux.Handle("/api/test", instrument("test", testHandler))
+	mux.Handle("/api/test/history", instrument("history", historyHandler))
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// instrument wraps h to count requests by handler and track in-flight requests
+func instrument(name string, h http.HandlerFunc) http.Handler {
+	counter := requestsTotal.MustCurryWith(prometheus.Labels{"handler": name})
+	return promhttp.InstrumentHandlerInFlight(inFlightRequests,
+		promhttp.InstrumentHandlerCounter(counter, h))
 }
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyStub is a send func for runRamped that records how many calls
+// were in flight when each call started, relative to the start of the run
+type concurrencyStub struct {
+	start    time.Time
+	hold     time.Duration
+	mu       sync.Mutex
+	inFlight int
+	samples  []concurrencySample
+}
+
+type concurrencySample struct {
+	at       time.Duration
+	inFlight int
+}
+
+func (s *concurrencyStub) send(i int) {
+	s.mu.Lock()
+	s.inFlight++
+	s.samples = append(s.samples, concurrencySample{at: time.Since(s.start), inFlight: s.inFlight})
+	s.mu.Unlock()
+
+	time.Sleep(s.hold)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+}
+
+// maxInFlight returns the highest concurrency seen between from and to
+func (s *concurrencyStub) maxInFlight(from, to time.Duration) int {
+	max := 0
+	for _, c := range s.samples {
+		if c.at >= from && c.at < to && c.inFlight > max {
+			max = c.inFlight
+		}
+	}
+	return max
+}
+
+func TestRunRampedRampsConcurrency(t *testing.T) {
+	stub := &concurrencyStub{start: time.Now(), hold: 20 * time.Millisecond}
+	runRamped(60, 4, 300*time.Millisecond, 0, stub.send)
+
+	if len(stub.samples) != 60 {
+		t.Fatalf("sent %d requests, want 60", len(stub.samples))
+	}
+	// Workers join at 0, 100ms, 200ms and 300ms
+	if got := stub.maxInFlight(0, 80*time.Millisecond); got != 1 {
+		t.Errorf("concurrency in the first 80ms = %d, want 1", got)
+	}
+	if got := stub.maxInFlight(120*time.Millisecond, 180*time.Millisecond); got != 2 {
+		t.Errorf("concurrency between 120ms and 180ms = %d, want 2", got)
+	}
+	if got := stub.maxInFlight(320*time.Millisecond, time.Hour); got != 4 {
+		t.Errorf("concurrency after the ramp = %d, want 4", got)
+	}
+}
+
+func TestRunRampedWithoutRampStartsAtFullConcurrency(t *testing.T) {
+	stub := &concurrencyStub{start: time.Now(), hold: 50 * time.Millisecond}
+	runRamped(8, 4, 0, 0, stub.send)
+
+	if got := stub.maxInFlight(0, 40*time.Millisecond); got != 4 {
+		t.Errorf("concurrency in the first 40ms = %d, want 4", got)
+	}
+}
+
+// echoStub is a backend whose /api/echo reports serverID and the timestamp
+// returned by now
+func echoStub(t *testing.T, serverID string, now func() time.Time) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BackendEchoResponse{
+			ServerID:  serverID,
+			Timestamp: now().UTC().Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		ts      string
+		wantErr error
+	}{
+		{"2024-01-01T12:00:03Z", nil},
+		{"2024-01-01T11:59:57Z", nil},
+		{"2024-01-01T12:00:06Z", errClockSkew},
+		{"2024-01-01T11:50:00Z", errClockSkew},
+	} {
+		err := checkTimestamp(tt.ts, now, 5*time.Second)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("checkTimestamp(%q) = %v, want %v", tt.ts, err, tt.wantErr)
+		}
+	}
+	if err := checkTimestamp("yesterday", now, 5*time.Second); err == nil || errors.Is(err, errClockSkew) {
+		t.Errorf("checkTimestamp(\"yesterday\") = %v, want a parse error", err)
+	}
+}
+
+func TestRunLoadTestFlagsSkewedBackend(t *testing.T) {
+	drifted := echoStub(t, "drifted", func() time.Time { return time.Now().Add(-10 * time.Minute) })
+
+	result := runLoadTest(context.Background(), drifted.Client(), LoadTestConfig{
+		BackendURL:  drifted.URL,
+		Requests:    3,
+		Concurrency: 1,
+		MaxSkew:     30 * time.Second,
+	})
+	if result.SkewViolations != 3 || result.FailureCount != 3 {
+		t.Errorf("skew violations = %d, failures = %d, want 3 and 3", result.SkewViolations, result.FailureCount)
+	}
+	if result.Success {
+		t.Errorf("result succeeded with skewed timestamps: %s", result.Message)
+	}
+
+	// The same backend passes with the check disabled
+	result = runLoadTest(context.Background(), drifted.Client(), LoadTestConfig{
+		BackendURL:  drifted.URL,
+		Requests:    3,
+		Concurrency: 1,
+	})
+	if result.SkewViolations != 0 || result.SuccessCount != 3 {
+		t.Errorf("without max_skew_ms: skew violations = %d, successes = %d, want 0 and 3", result.SkewViolations, result.SuccessCount)
+	}
+}
+
+func TestRunRampedDelay(t *testing.T) {
+	for _, tt := range []struct {
+		delay  time.Duration
+		minGap time.Duration
+		maxGap time.Duration
+		name   string
+	}{
+		{40 * time.Millisecond, 40 * time.Millisecond, time.Second, "honored between serial requests"},
+		{0, 0, 20 * time.Millisecond, "skipped when zero"},
+	} {
+		var sentAt []time.Time
+		runRamped(4, 1, 0, tt.delay, func(i int) { sentAt = append(sentAt, time.Now()) })
+
+		for i := 1; i < len(sentAt); i++ {
+			gap := sentAt[i].Sub(sentAt[i-1])
+			if gap < tt.minGap || gap > tt.maxGap {
+				t.Errorf("delay %v (%s): gap before request %d = %v, want between %v and %v", tt.delay, tt.name, i, gap, tt.minGap, tt.maxGap)
+			}
+		}
+	}
+}
+
+func TestTestHandlerDelayParam(t *testing.T) {
+	backend := echoStub(t, "b1", time.Now)
+	prev := backendURL
+	backendURL = backend.URL
+	t.Cleanup(func() { backendURL = prev })
+
+	for _, tt := range []struct {
+		query   string
+		minTime time.Duration
+		maxTime time.Duration
+	}{
+		{"requests=3&delay_ms=0", 0, 100 * time.Millisecond},
+		{"requests=3&delay_ms=100", 300 * time.Millisecond, time.Second},
+	} {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		testHandler(rec, httptest.NewRequest(http.MethodGet, "/api/test?"+tt.query, nil))
+		if elapsed := time.Since(start); elapsed < tt.minTime || elapsed > tt.maxTime {
+			t.Errorf("/api/test?%s took %v, want between %v and %v", tt.query, elapsed, tt.minTime, tt.maxTime)
+		}
+	}
+}
+
+func TestTestHandlerMinBackendsParam(t *testing.T) {
+	prev := backendURL
+	backendURL = roundRobinStub(t, "b1", "b2").URL
+	t.Cleanup(func() { backendURL = prev })
+
+	for _, tt := range []struct {
+		query   string
+		success bool
+		message string
+	}{
+		{"requests=4&delay_ms=0", true, "SUCCESS"},
+		{"requests=4&delay_ms=0&min_backends=1", true, "SUCCESS"},
+		{"requests=4&delay_ms=0&min_backends=3", false, "(expected at least 3)"},
+	} {
+		rec := httptest.NewRecorder()
+		testHandler(rec, httptest.NewRequest(http.MethodGet, "/api/test?"+tt.query, nil))
+		var result TestResponse
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("/api/test?%s: %v", tt.query, err)
+		}
+		if result.Success != tt.success || !strings.Contains(result.Message, tt.message) {
+			t.Errorf("/api/test?%s: success = %t, message = %q, want %t and %q", tt.query, result.Success, result.Message, tt.success, tt.message)
+		}
+	}
+}
+
+func TestTraceBackendConnection(t *testing.T) {
+	backend := echoStub(t, "b1", time.Now)
+	_, port, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Going through the name exercises the DNS hooks
+	info := traceBackendConnection(&http.Client{}, "http://localhost:"+port+"/health")
+	if info.Error != "" {
+		t.Fatalf("trace failed: %s", info.Error)
+	}
+	if info.Host != "localhost" {
+		t.Errorf("host = %q, want localhost", info.Host)
+	}
+	if len(info.ResolvedAddrs) == 0 {
+		t.Error("no resolved addresses recorded")
+	}
+	if info.RemoteAddr != backend.Listener.Addr().String() {
+		t.Errorf("remote addr = %q, want %q", info.RemoteAddr, backend.Listener.Addr())
+	}
+	// The stub listens on loopback like the Service Connect proxy does
+	if !info.ViaServiceConnect {
+		t.Error("loopback connection not reported as via Service Connect")
+	}
+}
+
+func TestTraceBackendConnectionUnreachable(t *testing.T) {
+	backend := echoStub(t, "b1", time.Now)
+	url := backend.URL + "/health"
+	backend.Close()
+
+	info := traceBackendConnection(&http.Client{}, url)
+	if info.Error == "" {
+		t.Error("no error recorded for a closed backend")
+	}
+	if info.RemoteAddr != "" || info.ViaServiceConnect {
+		t.Errorf("remote addr = %q, via service connect = %t, want neither", info.RemoteAddr, info.ViaServiceConnect)
+	}
+}
+
+// metricValue scrapes the /metrics endpoint of srv and returns the value of
+// series, or 0 when it is not exported yet
+func metricValue(t *testing.T, srv *httptest.Server, series string) float64 {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("invalid value in %q: %v", line, err)
+			}
+			return f
+		}
+	}
+	return 0
+}
+
+func TestMetricsCountBackendResponses(t *testing.T) {
+	backend := echoStub(t, "metrics-backend", time.Now)
+	prev := backendURL
+	backendURL = backend.URL
+	t.Cleanup(func() { backendURL = prev })
+
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	const tests = `frontend_http_requests_total{code="200",handler="test"}`
+	const responses = `frontend_backend_responses_total{server_id="metrics-backend"}`
+	beforeTests, beforeResponses := metricValue(t, srv, tests), metricValue(t, srv, responses)
+
+	resp, err := srv.Client().Get(srv.URL + "/api/test?requests=3&delay_ms=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := metricValue(t, srv, tests) - beforeTests; got != 1 {
+		t.Errorf("%s increased by %v, want 1", tests, got)
+	}
+	if got := metricValue(t, srv, responses) - beforeResponses; got != 3 {
+		t.Errorf("%s increased by %v, want 3", responses, got)
+	}
+}
+
+// roundRobinStub is a backend that reports each of ids in turn, like
+// several backend tasks behind Service Connect
+func roundRobinStub(t *testing.T, ids ...string) *httptest.Server {
+	t.Helper()
+	var next atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/echo" {
+			return
+		}
+		id := ids[int(next.Add(1)-1)%len(ids)]
+		json.NewEncoder(w).Encode(BackendEchoResponse{ServerID: id, Timestamp: time.Now().UTC().Format(time.RFC3339)})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunLoadTestConcurrentCounts(t *testing.T) {
+	backend := roundRobinStub(t, "b1", "b2", "b3")
+
+	result := runLoadTest(context.Background(), backend.Client(), LoadTestConfig{
+		BackendURL:  backend.URL,
+		Requests:    60,
+		Concurrency: 8,
+	})
+	if result.SuccessCount != 60 || result.FailureCount != 0 {
+		t.Fatalf("successes = %d, failures = %d, want 60 and 0", result.SuccessCount, result.FailureCount)
+	}
+	for _, id := range []string{"b1", "b2", "b3"} {
+		if result.Distribution[id] != 20 {
+			t.Errorf("distribution[%s] = %d, want 20: %v", id, result.Distribution[id], result.Distribution)
+		}
+	}
+	if result.UniqueBackends != 3 || !result.Success {
+		t.Errorf("unique backends = %d, success = %t, want 3 and true", result.UniqueBackends, result.Success)
+	}
+}
+
+func TestSendEchoWithRetryRecoversFromFlakyBackend(t *testing.T) {
+	var calls atomic.Int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(BackendEchoResponse{ServerID: "b1"})
+	}))
+	defer flaky.Close()
+
+	backendID, retried, err := sendEchoWithRetry(context.Background(), flaky.Client(), flaky.URL, 0, 0, 2)
+	if err != nil {
+		t.Fatalf("sendEchoWithRetry() error = %v", err)
+	}
+	if backendID != "b1" || !retried || calls.Load() != 2 {
+		t.Errorf("backend = %q, retried = %t after %d calls, want b1 retried after 2", backendID, retried, calls.Load())
+	}
+}
+
+func TestSendEchoWithRetryGivesUp(t *testing.T) {
+	var calls atomic.Int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	if _, _, err := sendEchoWithRetry(context.Background(), down.Client(), down.URL, 0, 0, 0); err == nil || calls.Load() != 1 {
+		t.Errorf("RETRY_MAX=0: error = %v after %d calls, want an error after 1", err, calls.Load())
+	}
+
+	calls.Store(0)
+	if _, _, err := sendEchoWithRetry(context.Background(), down.Client(), down.URL, 0, 0, 2); err == nil || calls.Load() != 3 {
+		t.Errorf("RETRY_MAX=2: error = %v after %d calls, want an error after 3", err, calls.Load())
+	}
+}
+
+func TestRunLoadTestCountsRetries(t *testing.T) {
+	var calls atomic.Int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/echo" {
+			return
+		}
+		// Every other call fails, so each request succeeds on its second attempt
+		if calls.Add(1)%2 == 1 {
+			http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(BackendEchoResponse{ServerID: "b1"})
+	}))
+	defer flaky.Close()
+
+	result := runLoadTest(context.Background(), flaky.Client(), LoadTestConfig{
+		BackendURL:  flaky.URL,
+		Requests:    3,
+		Concurrency: 1,
+		MaxRetries:  1,
+	})
+	if result.SuccessCount != 3 || result.FailureCount != 0 || result.RetryCount != 3 {
+		t.Errorf("successes = %d, failures = %d, retries = %d, want 3, 0 and 3", result.SuccessCount, result.FailureCount, result.RetryCount)
+	}
+}
+
+// resetHistories gives the test an empty set of session histories
+func resetHistories(t *testing.T) {
+	t.Helper()
+	historyMu.Lock()
+	prev := histories
+	histories = make(map[string]*SessionHistory)
+	historyMu.Unlock()
+	t.Cleanup(func() {
+		historyMu.Lock()
+		histories = prev
+		historyMu.Unlock()
+	})
+}
+
+func getHistory(t *testing.T, session string) (int, SessionHistory) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	historyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/test/history?session="+session, nil))
+	var h SessionHistory
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, h
+}
+
+func TestHistoryAccumulatesServerIDs(t *testing.T) {
+	resetHistories(t)
+	prev := backendURL
+	t.Cleanup(func() { backendURL = prev })
+
+	// The second run sees b3 replace b1, as during a rolling deployment
+	for _, ids := range [][]string{{"b1", "b2"}, {"b2", "b3"}} {
+		backendURL = roundRobinStub(t, ids...).URL
+		rec := httptest.NewRecorder()
+		testHandler(rec, httptest.NewRequest(http.MethodGet, "/api/test?requests=4&delay_ms=0&session=soak", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("/api/test returned %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	code, h := getHistory(t, "soak")
+	if code != http.StatusOK {
+		t.Fatalf("/api/test/history returned %d", code)
+	}
+	if h.Runs != 2 || len(h.Backends) != 3 {
+		t.Fatalf("history = %d runs with backends %v, want 2 runs with b1, b2 and b3", h.Runs, h.Backends)
+	}
+	for id, want := range map[string][2]int{"b1": {1, 1}, "b2": {1, 2}, "b3": {2, 2}} {
+		b := h.Backends[id]
+		if b == nil || b.FirstSeenRun != want[0] || b.LastSeenRun != want[1] {
+			t.Errorf("backend %s = %+v, want first/last seen runs %v", id, b, want)
+		}
+	}
+
+	if code, _ := getHistory(t, "other"); code != http.StatusNotFound {
+		t.Errorf("unknown session returned %d, want 404", code)
+	}
+}
+
+func TestHistoryEvictsLeastRecentlyRunSession(t *testing.T) {
+	resetHistories(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxSessions; i++ {
+		recordHistory(fmt.Sprintf("s%d", i), map[string]int{"b1": 1}, start.Add(time.Duration(i)*time.Second))
+	}
+	// Running s0 again makes s1 the least recently run session
+	recordHistory("s0", map[string]int{"b1": 1}, start.Add(time.Hour))
+	recordHistory("new", map[string]int{"b1": 1}, start.Add(2*time.Hour))
+
+	if len(histories) != maxSessions {
+		t.Errorf("kept %d sessions, want %d", len(histories), maxSessions)
+	}
+	for session, want := range map[string]int{"s0": http.StatusOK, "s1": http.StatusNotFound, "s2": http.StatusOK, "new": http.StatusOK} {
+		if code, _ := getHistory(t, session); code != want {
+			t.Errorf("session %s returned %d, want %d", session, code, want)
+		}
+	}
+}
+
+func TestTestHandlerRejectsLongSession(t *testing.T) {
+	resetHistories(t)
+	rec := httptest.NewRecorder()
+	testHandler(rec, httptest.NewRequest(http.MethodGet, "/api/test?session="+strings.Repeat("x", maxSessionLength+1), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	if len(histories) != 0 {
+		t.Errorf("recorded %d sessions for a rejected request", len(histories))
+	}
+}
+
+// roundTripFunc is an http.RoundTripper that answers requests without a network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestSendEchoServerIDHeaderFallback(t *testing.T) {
+	for _, tt := range []struct {
+		name, body, header string
+		status             int
+		wantID             string
+		wantErr            string
+	}{
+		{"body", `{"server_id":"from-body"}`, "from-header", http.StatusOK, "from-body", ""},
+		{"empty body", "", "from-header", http.StatusOK, "from-header", ""},
+		{"error page", "<html>oops</html>", "from-header", http.StatusOK, "from-header", ""},
+		{"body without ID", `{"message":"hi"}`, "from-header", http.StatusOK, "from-header", ""},
+		{"no ID at all", "", "", http.StatusOK, "", "no server ID"},
+		{"error status", "bad request", "from-header", http.StatusBadRequest, "", "from backend from-header"},
+	} {
+		client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set(serverIDHeader, tt.header)
+			}
+			return &http.Response{StatusCode: tt.status, Header: header, Body: io.NopCloser(strings.NewReader(tt.body)), Request: r}, nil
+		})}
+
+		id, err := sendEcho(context.Background(), client, "http://backend.invalid", 0, 0)
+		if id != tt.wantID || (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: sendEcho() = %q, %v, want %q and error %q", tt.name, id, err, tt.wantID, tt.wantErr)
+		}
+	}
+}
+
+func TestRunLoadTestFakeTransport(t *testing.T) {
+	var calls atomic.Int64
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/api/echo" {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Request: r}, nil
+		}
+		id := fmt.Sprintf("b%d", calls.Add(1)%2+1)
+		body, _ := json.Marshal(BackendEchoResponse{ServerID: id, Timestamp: time.Now().UTC().Format(time.RFC3339)})
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body)), Request: r}, nil
+	})}
+
+	result := runLoadTest(context.Background(), client, LoadTestConfig{BackendURL: "http://backend.invalid", Requests: 10, Concurrency: 2})
+	if result.SuccessCount != 10 || result.Distribution["b1"] != 5 || result.Distribution["b2"] != 5 {
+		t.Errorf("successes = %d, distribution = %v, want 10 split evenly over b1 and b2", result.SuccessCount, result.Distribution)
+	}
+	if !result.Success || !strings.HasPrefix(result.Message, "SUCCESS") {
+		t.Errorf("success = %t, message = %q, want a successful test", result.Success, result.Message)
+	}
+}
+
+func TestRunLoadTestFakeTransportErrors(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})}
+
+	result := runLoadTest(context.Background(), client, LoadTestConfig{BackendURL: "http://backend.invalid", Requests: 4, Concurrency: 2})
+	if result.SuccessCount != 0 || result.FailureCount != 4 {
+		t.Errorf("successes = %d, failures = %d, want 0 and 4", result.SuccessCount, result.FailureCount)
+	}
+	if result.Success || result.Connection.Error == "" {
+		t.Errorf("success = %t, connection error = %q, want a failed test and the connection error", result.Success, result.Connection.Error)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	prev := serverID
+	t.Cleanup(func() { serverID = prev })
+	serverID = "frontend-1"
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/version returned invalid JSON %q: %v", rec.Body, err)
+	}
+	// Without -ldflags the build metadata defaults to dev
+	want := map[string]string{"version": "dev", "commit": "dev", "build_time": "dev", "server_id": "frontend-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/version = %v, want %v", got, want)
+	}
+}
@@ -160,9 +160,17 @@ resource "aws_ecs_task_definition" "backend" {
         {
           name  = "PORT"
           value = "8080"
+        },
+        {
+          name  = "DRAIN_SECONDS"
+          value = tostring(var.drain_seconds)
         }
       ]
 
+      # How long ECS waits after SIGTERM before killing the container; the
+      # app fits its drain and shutdown within it (drain.StopTimeout in lib/app)
+      stopTimeout = 30
+
       logConfiguration = {
         logDriver = "awslogs"
         options = {
@@ -214,9 +222,17 @@ resource "aws_ecs_task_definition" "frontend" {
           # Service Connect will resolve this hostname
           name  = "BACKEND_URL"
           value = "http://backend:8080"
+        },
+        {
+          name  = "DRAIN_SECONDS"
+          value = tostring(var.drain_seconds)
         }
       ]
 
+      # How long ECS waits after SIGTERM before killing the container; the
+      # app fits its drain and shutdown within it (drain.StopTimeout in lib/app)
+      stopTimeout = 30
+
       logConfiguration = {
         logDriver = "awslogs"
         options = {
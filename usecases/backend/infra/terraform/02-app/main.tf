@@ -61,3 +61,15 @@ variable "security_group_ids" {
   type        = list(string)
   default     = []
 }
+
+variable "drain_seconds" {
+  description = "Seconds the apps keep serving after SIGTERM while /ready fails (DRAIN_SECONDS)"
+  type        = number
+  default     = 5
+
+  # The drain and the shutdown of the connections must fit in the 30s stopTimeout
+  validation {
+    condition     = var.drain_seconds >= 0 && var.drain_seconds <= 23
+    error_message = "drain_seconds must be from 0 to 23."
+  }
+}
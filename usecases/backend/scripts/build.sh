@@ -6,6 +6,8 @@ set -e
 
 SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
 PROJECT_ROOT=$(realpath "$SCRIPT_DIR/..")
+# Images are built from the repository root, which holds the shared lib/app module
+REPO_ROOT=$(realpath "$PROJECT_ROOT/../..")
 TF_ECR_DIR="$PROJECT_ROOT/infra/terraform/01-ecr"
 
 # Get AWS region
@@ -21,15 +23,22 @@ if [ -z "$BACKEND_REPO_URL" ] || [ -z "$FRONTEND_REPO_URL" ]; then
     exit 1
 fi
 
+# Build metadata reported by /version
+BUILD_ARGS=(
+    --build-arg "VERSION=$(git -C "$PROJECT_ROOT" describe --tags --always --dirty 2>/dev/null || echo dev)"
+    --build-arg "COMMIT=$(git -C "$PROJECT_ROOT" rev-parse --short HEAD 2>/dev/null || echo dev)"
+    --build-arg "BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+)
+
 echo "Building Docker images..."
 
 # Build backend image
 echo "Building backend image..."
-docker build -t hello-fargate-backend-backend:latest "$PROJECT_ROOT/apps/backend"
+docker build "${BUILD_ARGS[@]}" -t hello-fargate-backend-backend:latest -f "$PROJECT_ROOT/apps/backend/Dockerfile" "$REPO_ROOT"
 
 # Build frontend image
 echo "Building frontend image..."
-docker build -t hello-fargate-backend-frontend:latest "$PROJECT_ROOT/apps/frontend"
+docker build "${BUILD_ARGS[@]}" -t hello-fargate-backend-frontend:latest -f "$PROJECT_ROOT/apps/frontend/Dockerfile" "$REPO_ROOT"
 
 # Login to ECR
 echo "Logging into ECR..."
@@ -5,12 +5,13 @@ go 1.23
 require github.com/aws/aws-sdk-go-v2 v1.40.0
 
 require (
-	github.com/aws/aws-sdk-go-v2/config v1.28.6
 	github.com/aws/aws-sdk-go-v2/service/ec2 v1.275.0
 	github.com/aws/aws-sdk-go-v2/service/ecs v1.52.1
+	github.com/example/hello-fargate-harness v0.0.0
 )
 
 require (
+	github.com/aws/aws-sdk-go-v2/config v1.28.6 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.17.47 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.16.21 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/configsources v1.4.14 // indirect
@@ -24,3 +25,5 @@ require (
 	github.com/aws/smithy-go v1.23.2 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )
+
+replace github.com/example/hello-fargate-harness => ../../../../lib/harness
@@ -3,30 +3,50 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	"github.com/example/hello-fargate-harness"
 )
 
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
 // TestResponse represents the response from frontend's /api/test endpoint
 type TestResponse struct {
 	TotalRequests  int            `json:"total_requests"`
 	SuccessCount   int            `json:"success_count"`
 	FailureCount   int            `json:"failure_count"`
 	UniqueBackends int            `json:"unique_backends"`
+	SkewViolations int            `json:"skew_violations"`
+	DurationMs     int64          `json:"duration_ms"`
+	RetryCount     int            `json:"retry_count"`
 	Distribution   map[string]int `json:"distribution"`
 	Success        bool           `json:"success"`
 	Message        string         `json:"message"`
 	FrontendID     string         `json:"frontend_id"`
+	Connection     *struct {
+		Host              string   `json:"host"`
+		ResolvedAddrs     []string `json:"resolved_addrs"`
+		RemoteAddr        string   `json:"remote_addr"`
+		ViaServiceConnect bool     `json:"via_service_connect"`
+		Error             string   `json:"error"`
+	} `json:"backend_connection"`
 }
 
 func main() {
@@ -35,17 +55,50 @@ func main() {
 	backendService := flag.String("backend-service", "", "Backend service name")
 	requestCount := flag.Int("requests", 20, "Number of requests to send to backend")
 	timeout := flag.Duration("timeout", 5*time.Minute, "Timeout for the test")
+	minBackendShare := flag.Float64("min-backend-share", 0, "Minimum fraction (0.0-1.0) of successful requests every observed backend must receive (0 disables)")
+	minBackends := flag.Int("min-backends", 2, "Minimum number of distinct backends that must respond")
+	addressTypeFlag := flag.String("address-type", string(addressPublic), "Frontend task address to test against: public, private (VPC-internal testing) or ipv6")
+	usePrivateIP := flag.Bool("use-private-ip", false, "Shorthand for -address-type=private")
+	rollingUpdate := flag.Bool("rolling-update", false, "After the load balancing test, force a new deployment of the backend service and send test requests until it is steady again")
+	maxRolloutFailures := flag.Int("max-rollout-failures", 0, "Maximum failed backend requests tolerated during the -rolling-update deployment")
+	rolloutTimeout := flag.Duration("rollout-timeout", 15*time.Minute, "Timeout for the -rolling-update deployment to reach steady state, counted separately from -timeout")
+	serverIDPattern := flag.String("server-id-pattern", `^[A-Za-z0-9][A-Za-z0-9.-]*$`, "Regexp every backend server ID must match; empty or \"unknown\" IDs always fail (empty pattern disables only the pattern check)")
+	maxSkew := flag.Duration("max-timestamp-skew", 0, "Fail if a backend timestamp differs from the frontend clock by more than this (0 disables)")
+	headerTransport := harness.RegisterHeaderFlags(flag.CommandLine, version)
+	maxRetries := flag.Int("max-retries", 2, "Maximum retries per AWS API call (throttling and transient errors)")
+	opTimeout := flag.Duration("op-timeout", 30*time.Second, "Timeout for each AWS API call, retries included (0 disables it)")
 	flag.Parse()
 
 	if *clusterArn == "" || *frontendService == "" || *backendService == "" {
 		log.Fatal("Required flags: -cluster-arn, -frontend-service, -backend-service")
 	}
 
+	if *minBackends < 1 {
+		log.Fatalf("Invalid -min-backends %d: must be at least 1", *minBackends)
+	}
+
+	kind := addressType(*addressTypeFlag)
+	if *usePrivateIP {
+		kind = addressPrivate
+	}
+	if kind != addressPublic && kind != addressPrivate && kind != addressIPv6 {
+		log.Fatalf("Invalid -address-type %q: must be public, private or ipv6", *addressTypeFlag)
+	}
+
+	var serverIDRe *regexp.Regexp
+	if *serverIDPattern != "" {
+		re, err := regexp.Compile(*serverIDPattern)
+		if err != nil {
+			log.Fatalf("Invalid -server-id-pattern: %v", err)
+		}
+		serverIDRe = re
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
 
 	// Load AWS config
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := harness.LoadAWSConfig(ctx, *maxRetries, *opTimeout)
 	if err != nil {
 		log.Fatalf("Failed to load AWS config: %v", err)
 	}
@@ -55,31 +108,38 @@ func main() {
 
 	// Wait for services to be ready
 	log.Println("Waiting for ECS services to be ready...")
-	if err := waitForServices(ctx, ecsClient, *clusterArn, *backendService, 2, *frontendService, 1); err != nil {
+	// At least as many backend tasks must run as backends are expected to respond
+	if err := waitForServices(ctx, ecsClient, *clusterArn, *backendService, *minBackends, *frontendService, 1); err != nil {
 		log.Fatalf("Services not ready: %v", err)
 	}
 
-	// Get frontend task's public IP
-	log.Println("Getting frontend task public IP...")
-	frontendIP, err := getFrontendPublicIP(ctx, ecsClient, ec2Client, *clusterArn, *frontendService)
+	// Get frontend task's IP
+	log.Printf("Getting frontend task %s IP...", kind)
+	frontendIP, err := getFrontendIP(ctx, ecsClient, ec2Client, *clusterArn, *frontendService, kind)
 	if err != nil {
 		log.Fatalf("Failed to get frontend IP: %v", err)
 	}
-	log.Printf("Frontend public IP: %s", frontendIP)
+	log.Printf("Frontend %s IP: %s", kind, frontendIP)
+
+	// Sets the User-Agent and custom headers on every request to the frontend
+	transport := headerTransport.Wrap(http.DefaultTransport)
 
 	// Wait for frontend to be healthy
-	frontendURL := fmt.Sprintf("http://%s:8080", frontendIP)
+	frontendURL := "http://" + net.JoinHostPort(frontendIP, "8080")
 	log.Printf("Waiting for frontend to be healthy at %s/health...", frontendURL)
-	if err := waitForHealth(ctx, frontendURL+"/health"); err != nil {
+	if err := harness.WaitForHealth(ctx, &http.Client{Timeout: 5 * time.Second, Transport: transport}, frontendURL+"/health"); err != nil {
 		log.Fatalf("Frontend not healthy: %v", err)
 	}
 	log.Println("Frontend is healthy!")
 
 	// Run the test
-	testURL := fmt.Sprintf("%s/api/test?requests=%d", frontendURL, *requestCount)
+	testURL := fmt.Sprintf("%s/api/test?requests=%d&min_backends=%d", frontendURL, *requestCount, *minBackends)
+	if *maxSkew > 0 {
+		testURL += fmt.Sprintf("&max_skew_ms=%d", maxSkew.Milliseconds())
+	}
 	log.Printf("Running Service Connect test: %s", testURL)
 
-	result, err := runTest(ctx, testURL)
+	result, err := runTest(ctx, &http.Client{Timeout: 60 * time.Second, Transport: transport}, testURL)
 	if err != nil {
 		log.Fatalf("Test failed: %v", err)
 	}
@@ -89,23 +149,274 @@ func main() {
 	fmt.Printf("Total Requests: %d\n", result.TotalRequests)
 	fmt.Printf("Successful: %d\n", result.SuccessCount)
 	fmt.Printf("Failed: %d\n", result.FailureCount)
+	fmt.Printf("Succeeded After Retry: %d\n", result.RetryCount)
 	fmt.Printf("Unique Backends: %d\n", result.UniqueBackends)
+	fmt.Printf("Duration: %dms\n", result.DurationMs)
+	if *maxSkew > 0 {
+		fmt.Printf("Timestamp Skew Violations: %d (max skew %v)\n", result.SkewViolations, *maxSkew)
+	}
 	fmt.Println("\nDistribution:")
 	for backendID, count := range result.Distribution {
 		pct := float64(count) / float64(result.TotalRequests) * 100
 		fmt.Printf("  %s: %d requests (%.1f%%)\n", backendID, count, pct)
 	}
+	if c := result.Connection; c != nil {
+		fmt.Println("\nBackend Connection:")
+		fmt.Printf("  Host: %s\n", c.Host)
+		fmt.Printf("  Resolved: %s\n", strings.Join(c.ResolvedAddrs, ", "))
+		fmt.Printf("  Remote Address: %s\n", c.RemoteAddr)
+		fmt.Printf("  Via Service Connect: %t\n", c.ViaServiceConnect)
+		if c.Error != "" {
+			fmt.Printf("  Error: %s\n", c.Error)
+		}
+	}
 	fmt.Printf("\nFrontend ID: %s\n", result.FrontendID)
 	fmt.Printf("Result: %s\n", result.Message)
 	fmt.Println("------------------------------------")
 
-	if !result.Success {
-		log.Fatal("Test FAILED: Expected at least 2 unique backends")
+	if invalid := invalidServerIDs(result.Distribution, serverIDRe); len(invalid) > 0 {
+		log.Fatalf("Test FAILED: backend server IDs do not look like hostnames: %s", strings.Join(invalid, ", "))
+	}
+	if err := evaluateFairness(result.Distribution, *minBackends, *minBackendShare); err != nil {
+		log.Fatalf("Test FAILED: %v", err)
+	}
+	if result.SkewViolations > 0 {
+		log.Fatalf("Test FAILED: %d backend timestamps outside the %v skew window (clock drift?)", result.SkewViolations, *maxSkew)
+	}
+
+	if *rollingUpdate {
+		log.Printf("Forcing a new deployment of %s while sending test requests...", *backendService)
+		probe := func(ctx context.Context) (*TestResponse, error) {
+			return runTest(ctx, &http.Client{Timeout: 60 * time.Second, Transport: transport}, testURL)
+		}
+		// A rollout takes minutes, so it gets its own deadline instead of
+		// whatever is left of -timeout
+		rolloutCtx, cancelRollout := context.WithTimeout(context.Background(), *rolloutTimeout)
+		stats, err := runDuringDeployment(rolloutCtx, ecsClient, *clusterArn, *backendService, 10*time.Second, probe)
+		cancelRollout()
+		fmt.Println("\n--- Rolling Update Results ---")
+		fmt.Printf("Test Rounds: %d\n", stats.Rounds)
+		fmt.Printf("Backend Requests: %d\n", stats.Requests)
+		fmt.Printf("Failed Requests: %d\n", stats.Failures)
+		fmt.Printf("Failed Rounds: %d\n", stats.FailedRounds)
+		fmt.Println("------------------------------")
+		if err != nil {
+			log.Fatalf("Test FAILED: rolling update: %v", err)
+		}
+		if err := checkRolloutFailures(stats, *maxRolloutFailures); err != nil {
+			log.Fatalf("Test FAILED: %v", err)
+		}
+		log.Println("Rolling update completed without dropping requests")
 	}
 
 	log.Println("Test PASSED: Service Connect load balancing verified!")
 }
 
+// serviceDeployAPI is the subset of the ECS client used to redeploy a
+// service and wait for it, so that tests can substitute a fake
+type serviceDeployAPI interface {
+	UpdateService(ctx context.Context, params *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error)
+	DescribeServices(ctx context.Context, params *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+}
+
+// rolloutStats counts the test traffic sent during a deployment. A failed
+// round is a call to the frontend's /api/test that failed as a whole.
+type rolloutStats struct {
+	Rounds       int
+	Requests     int
+	Failures     int
+	FailedRounds int
+}
+
+// runDuringDeployment forces a new deployment of the service and calls probe
+// repeatedly until the deployment reaches steady state, polling the service
+// every interval
+func runDuringDeployment(ctx context.Context, client serviceDeployAPI, cluster, service string, interval time.Duration, probe func(context.Context) (*TestResponse, error)) (rolloutStats, error) {
+	deploymentID, err := startDeployment(ctx, client, cluster, service)
+	if err != nil {
+		return rolloutStats{}, err
+	}
+	log.Printf("Started deployment %s", deploymentID)
+
+	trafficCtx, stopTraffic := context.WithCancel(ctx)
+	statsCh := make(chan rolloutStats, 1)
+	go func() {
+		statsCh <- generateTraffic(trafficCtx, probe)
+	}()
+
+	err = waitForDeployment(ctx, client, cluster, service, deploymentID, interval)
+	stopTraffic()
+	return <-statsCh, err
+}
+
+// startDeployment forces a new deployment of the service and returns the ID
+// of the new primary deployment
+func startDeployment(ctx context.Context, client serviceDeployAPI, cluster, service string) (string, error) {
+	out, err := client.UpdateService(ctx, &ecs.UpdateServiceInput{
+		Cluster:            &cluster,
+		Service:            &service,
+		ForceNewDeployment: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to force a new deployment: %w", err)
+	}
+	if out.Service != nil {
+		for _, d := range out.Service.Deployments {
+			if aws.ToString(d.Status) == "PRIMARY" {
+				return aws.ToString(d.Id), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no primary deployment in the UpdateService response")
+}
+
+// waitForDeployment polls the service until the deployment is its only one
+// and has completed, failing if ECS reports the rollout as failed
+func waitForDeployment(ctx context.Context, client serviceDeployAPI, cluster, service, deploymentID string, interval time.Duration) error {
+	for {
+		resp, err := client.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  &cluster,
+			Services: []string{service},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe service: %w", err)
+		}
+		if len(resp.Services) == 0 {
+			return fmt.Errorf("service %s not found", service)
+		}
+
+		steady, err := deploymentSteady(resp.Services[0], deploymentID)
+		if err != nil || steady {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("deployment %s did not reach steady state: %w", deploymentID, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// deploymentSteady reports whether the deployment has replaced all others and
+// runs its desired number of tasks
+func deploymentSteady(svc ecstypes.Service, deploymentID string) (bool, error) {
+	for _, d := range svc.Deployments {
+		if aws.ToString(d.Id) != deploymentID {
+			continue
+		}
+		if d.RolloutState == ecstypes.DeploymentRolloutStateFailed {
+			return false, fmt.Errorf("deployment %s failed: %s", deploymentID, aws.ToString(d.RolloutStateReason))
+		}
+		log.Printf("Deployment %s: %s, running %d/%d, %d deployment(s) active",
+			deploymentID, d.RolloutState, d.RunningCount, d.DesiredCount, len(svc.Deployments))
+		return len(svc.Deployments) == 1 && d.RunningCount == d.DesiredCount, nil
+	}
+	return false, fmt.Errorf("deployment %s no longer exists (replaced by another deployment?)", deploymentID)
+}
+
+// generateTraffic calls probe back to back until ctx is done, pausing one
+// second between calls, and counts the outcomes. Calls cut short by ctx are
+// not counted.
+func generateTraffic(ctx context.Context, probe func(context.Context) (*TestResponse, error)) rolloutStats {
+	var stats rolloutStats
+	for {
+		result, err := probe(ctx)
+		if ctx.Err() != nil {
+			return stats
+		}
+
+		stats.Rounds++
+		if err != nil {
+			stats.FailedRounds++
+			log.Printf("Test request failed during deployment: %v", err)
+		} else {
+			stats.Requests += result.TotalRequests
+			stats.Failures += result.FailureCount
+			if result.FailureCount > 0 {
+				log.Printf("%d of %d backend requests failed during deployment", result.FailureCount, result.TotalRequests)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return stats
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// checkRolloutFailures fails when the deployment dropped more backend
+// requests than allowed or any call to the frontend failed as a whole
+func checkRolloutFailures(stats rolloutStats, maxFailures int) error {
+	if stats.Rounds == 0 {
+		return fmt.Errorf("no test requests completed during the deployment")
+	}
+	if stats.FailedRounds > 0 {
+		return fmt.Errorf("%d of %d test rounds failed during the deployment", stats.FailedRounds, stats.Rounds)
+	}
+	if stats.Failures > maxFailures {
+		return fmt.Errorf("%d backend requests failed during the deployment (max %d)", stats.Failures, maxFailures)
+	}
+	return nil
+}
+
+// backendsBelowShare returns the backends that handled less than minShare of
+// the successful requests, formatted with their actual share
+func backendsBelowShare(distribution map[string]int, successCount int, minShare float64) []string {
+	if successCount == 0 {
+		return nil
+	}
+
+	var below []string
+	for backendID, count := range distribution {
+		share := float64(count) / float64(successCount)
+		if share < minShare {
+			below = append(below, fmt.Sprintf("%s (%.1f%%)", backendID, share*100))
+		}
+	}
+	sort.Strings(below)
+	return below
+}
+
+// evaluateFairness decides whether the load was balanced: at least
+// minBackends distinct backends must have responded and, unless minShare is
+// 0, each of them must have handled at least minShare of the successful
+// requests. Only backends that responded appear in the distribution, so
+// missing ones are caught by minBackends.
+func evaluateFairness(distribution map[string]int, minBackends int, minShare float64) error {
+	if len(distribution) < minBackends {
+		return fmt.Errorf("expected at least %d unique backends, got %d", minBackends, len(distribution))
+	}
+	if minShare == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, count := range distribution {
+		total += count
+	}
+	if underserved := backendsBelowShare(distribution, total, minShare); len(underserved) > 0 {
+		return fmt.Errorf("distribution is skewed: backends below the %.0f%% minimum share: %s", minShare*100, strings.Join(underserved, ", "))
+	}
+	return nil
+}
+
+// invalidServerIDs returns the backend server IDs that are empty, the
+// "unknown" placeholder for a missing hostname, or do not match re (a nil re
+// matches everything). Identical placeholder IDs would make distinct backends
+// look like one.
+func invalidServerIDs(distribution map[string]int, re *regexp.Regexp) []string {
+	var invalid []string
+	for backendID := range distribution {
+		if backendID == "" || backendID == "unknown" || (re != nil && !re.MatchString(backendID)) {
+			invalid = append(invalid, fmt.Sprintf("%q", backendID))
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
 func waitForServices(ctx context.Context, client *ecs.Client, cluster, backendSvc string, backendCount int, frontendSvc string, frontendCount int) error {
 	for {
 		select {
@@ -151,7 +462,7 @@ func waitForServices(ctx context.Context, client *ecs.Client, cluster, backendSv
 	}
 }
 
-func getFrontendPublicIP(ctx context.Context, ecsClient *ecs.Client, ec2Client *ec2.Client, cluster, serviceName string) (string, error) {
+func getFrontendIP(ctx context.Context, ecsClient *ecs.Client, ec2Client *ec2.Client, cluster, serviceName string, kind addressType) (string, error) {
 	// List tasks for the frontend service
 	listResp, err := ecsClient.ListTasks(ctx, &ecs.ListTasksInput{
 		Cluster:     &cluster,
@@ -166,10 +477,59 @@ func getFrontendPublicIP(ctx context.Context, ecsClient *ecs.Client, ec2Client *
 
 	log.Printf("Found %d task(s) for service %s", len(listResp.TaskArns), serviceName)
 
-	// Describe the first task to get its network details
+	return waitForTaskAddress(ctx, ecsClient, ec2Client, cluster, listResp.TaskArns[0], kind, time.Second)
+}
+
+// describeTasksAPI and describeENIsAPI are the subsets of the ECS and EC2
+// clients used to look up a task's address, so that tests can substitute
+// fakes
+type describeTasksAPI interface {
+	DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+}
+
+type describeENIsAPI interface {
+	DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error)
+}
+
+// errAddressNotReady is returned while the task's ENI is not attached yet or
+// its address details are not available yet
+var errAddressNotReady = errors.New("task address not ready")
+
+// waitForTaskAddress polls the task with exponential backoff, starting at
+// backoff and capped at 10 seconds, until its ENI is attached and has an
+// address of the given type. It gives up when ctx is done, and right away
+// when the task is stopping or its attached ENI lacks a public or IPv6
+// address, which waiting would not fix.
+func waitForTaskAddress(ctx context.Context, ecsClient describeTasksAPI, ec2Client describeENIsAPI, cluster, taskArn string, kind addressType, backoff time.Duration) (string, error) {
+	for {
+		addr, err := taskAddress(ctx, ecsClient, ec2Client, cluster, taskArn, kind)
+		if !errors.Is(err, errAddressNotReady) {
+			return addr, err
+		}
+
+		log.Printf("%v, retrying in %v...", err, backoff)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+}
+
+// taskAddress returns the task's address of the given type from its ENI
+// attachment details, falling back to the EC2 API. It returns an error
+// wrapping errAddressNotReady when the address is not available yet.
+// Misconfigurations, such as a subnet without public IPs, and stopping tasks
+// are reported as other errors.
+func taskAddress(ctx context.Context, ecsClient describeTasksAPI, ec2Client describeENIsAPI, cluster, taskArn string, kind addressType) (string, error) {
+	// Describe the task to get its network details
 	descResp, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{
 		Cluster: &cluster,
-		Tasks:   []string{listResp.TaskArns[0]},
+		Tasks:   []string{taskArn},
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to describe task: %w", err)
@@ -181,17 +541,20 @@ func getFrontendPublicIP(ctx context.Context, ecsClient *ecs.Client, ec2Client *
 	task := descResp.Tasks[0]
 
 	// Log task details for debugging
-	log.Printf("Task ARN: %s", *task.TaskArn)
-	log.Printf("Task Status: %s", *task.LastStatus)
+	log.Printf("Task ARN: %s", aws.ToString(task.TaskArn))
+	log.Printf("Task Status: %s", aws.ToString(task.LastStatus))
 	if task.StoppedReason != nil {
 		log.Printf("Stopped Reason: %s", *task.StoppedReason)
 	}
 
 	// Log all attachments for debugging
 	log.Printf("Task has %d attachment(s)", len(task.Attachments))
-	var eniID string
+	var eniID, eniStatus string
 	for i, attachment := range task.Attachments {
-		log.Printf("  Attachment[%d]: Type=%s, Status=%s", i, *attachment.Type, *attachment.Status)
+		log.Printf("  Attachment[%d]: Type=%s, Status=%s", i, aws.ToString(attachment.Type), aws.ToString(attachment.Status))
+		if aws.ToString(attachment.Type) == "ElasticNetworkInterface" {
+			eniStatus = aws.ToString(attachment.Status)
+		}
 		for _, detail := range attachment.Details {
 			if detail.Name != nil && detail.Value != nil {
 				log.Printf("    %s = %s", *detail.Name, *detail.Value)
@@ -203,46 +566,118 @@ func getFrontendPublicIP(ctx context.Context, ecsClient *ecs.Client, ec2Client *
 		}
 	}
 
-	// Look for public IP in attachments first
-	for _, attachment := range task.Attachments {
-		if *attachment.Type == "ElasticNetworkInterface" {
-			for _, detail := range attachment.Details {
-				if detail.Name != nil && *detail.Name == "publicIPv4Address" && detail.Value != nil {
-					return *detail.Value, nil
-				}
-			}
-		}
+	// A stopping task never becomes reachable
+	if status := aws.ToString(task.LastStatus); stoppingTaskStatuses[status] {
+		return "", fmt.Errorf("task is %s: %s", status, aws.ToString(task.StoppedReason))
 	}
 
-	// Check if we need to wait for ENI attachment
-	for _, attachment := range task.Attachments {
-		if *attachment.Type == "ElasticNetworkInterface" {
-			if *attachment.Status != "ATTACHED" {
-				return "", fmt.Errorf("ENI not yet attached (status: %s), need to wait", *attachment.Status)
-			}
-		}
+	// Look for the address in attachments first
+	if addr, ok := attachmentAddress(task.Attachments, kind); ok {
+		return addr, nil
+	}
+
+	// Tasks take a few seconds to attach their ENI
+	if eniStatus == "" {
+		return "", fmt.Errorf("%w: no ENI attachment yet", errAddressNotReady)
+	}
+	if eniStatus != "ATTACHED" {
+		return "", fmt.Errorf("%w: ENI not yet attached (status: %s)", errAddressNotReady, eniStatus)
+	}
+
+	if eniID == "" {
+		return "", fmt.Errorf("%w: ENI attached but its details are missing", errAddressNotReady)
 	}
 
-	// Fallback: Query EC2 API directly for the ENI's public IP
+	// Fallback: Query EC2 API directly for the ENI's address
 	// ECS task attachment details sometimes don't include publicIPv4Address even when assigned
-	if eniID != "" {
-		log.Printf("Public IP not in ECS task details, querying EC2 API for ENI %s...", eniID)
-		eniResp, err := ec2Client.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
-			NetworkInterfaceIds: []string{eniID},
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to describe ENI %s: %w", eniID, err)
+	log.Printf("%s IP not in ECS task details, querying EC2 API for ENI %s...", kind, eniID)
+	eniResp, err := ec2Client.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: []string{eniID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe ENI %s: %w", eniID, err)
+	}
+	if len(eniResp.NetworkInterfaces) == 0 {
+		return "", fmt.Errorf("%w: ENI %s not visible in EC2 yet", errAddressNotReady, eniID)
+	}
+	if addr, ok := eniAddress(eniResp.NetworkInterfaces[0], kind); ok {
+		log.Printf("Found %s IP via EC2 API: %s", kind, addr)
+		return addr, nil
+	}
+
+	// Addresses are assigned when the ENI is created, so an attached ENI
+	// without one will not get it by waiting
+	switch kind {
+	case addressPrivate:
+		return "", fmt.Errorf("%w: no private IP found for ENI %s", errAddressNotReady, eniID)
+	case addressIPv6:
+		return "", fmt.Errorf("no IPv6 address found for task - check if the subnet has an IPv6 CIDR and assigns IPv6 addresses")
+	default:
+		return "", fmt.Errorf("no public IP found for task - check if assign_public_ip is enabled in network configuration, or use -address-type=private")
+	}
+}
+
+// stoppingTaskStatuses are the task statuses after which it is never RUNNING
+// again
+var stoppingTaskStatuses = map[string]bool{
+	"DEACTIVATING":   true,
+	"STOPPING":       true,
+	"DEPROVISIONING": true,
+	"STOPPED":        true,
+}
+
+// addressType selects which address of the frontend task is tested
+type addressType string
+
+const (
+	addressPublic  addressType = "public"
+	addressPrivate addressType = "private"
+	addressIPv6    addressType = "ipv6"
+)
+
+// attachmentDetailNames maps address types to the ENI attachment detail
+// that ECS reports them in
+var attachmentDetailNames = map[addressType]string{
+	addressPublic:  "publicIPv4Address",
+	addressPrivate: "privateIPv4Address",
+	addressIPv6:    "ipv6Address",
+}
+
+// attachmentAddress returns the address of the given type from the details
+// of the task's ENI attachments
+func attachmentAddress(attachments []ecstypes.Attachment, kind addressType) (string, bool) {
+	name := attachmentDetailNames[kind]
+	for _, attachment := range attachments {
+		if aws.ToString(attachment.Type) != "ElasticNetworkInterface" {
+			continue
 		}
-		if len(eniResp.NetworkInterfaces) > 0 {
-			eni := eniResp.NetworkInterfaces[0]
-			if eni.Association != nil && eni.Association.PublicIp != nil {
-				log.Printf("Found public IP via EC2 API: %s", *eni.Association.PublicIp)
-				return *eni.Association.PublicIp, nil
+		for _, detail := range attachment.Details {
+			if aws.ToString(detail.Name) == name && aws.ToString(detail.Value) != "" {
+				return *detail.Value, true
 			}
 		}
 	}
+	return "", false
+}
 
-	return "", fmt.Errorf("no public IP found for task - check if assign_public_ip is enabled in network configuration")
+// eniAddress returns the address of the given type of an ENI as reported by
+// EC2
+func eniAddress(eni ec2types.NetworkInterface, kind addressType) (string, bool) {
+	var addr string
+	switch kind {
+	case addressPublic:
+		if eni.Association != nil {
+			addr = aws.ToString(eni.Association.PublicIp)
+		}
+	case addressPrivate:
+		addr = aws.ToString(eni.PrivateIpAddress)
+	case addressIPv6:
+		addr = aws.ToString(eni.Ipv6Address)
+		if addr == "" && len(eni.Ipv6Addresses) > 0 {
+			addr = aws.ToString(eni.Ipv6Addresses[0].Ipv6Address)
+		}
+	}
+	return addr, addr != ""
 }
 
 // getPublicIPFromENI queries EC2 API to get public IP for an ENI
@@ -265,33 +700,7 @@ func getPublicIPFromENI(ctx context.Context, ec2Client *ec2.Client, eniID string
 	return "", fmt.Errorf("no public IP associated with ENI")
 }
 
-func waitForHealth(ctx context.Context, healthURL string) error {
-	client := &http.Client{Timeout: 5 * time.Second}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		resp, err := client.Get(healthURL)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			resp.Body.Close()
-			return nil
-		}
-		if resp != nil {
-			resp.Body.Close()
-		}
-
-		log.Printf("Waiting for health check... (%v)", err)
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func runTest(ctx context.Context, testURL string) (*TestResponse, error) {
-	client := &http.Client{Timeout: 60 * time.Second}
-
+func runTest(ctx context.Context, client *http.Client, testURL string) (*TestResponse, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, testURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestBackendsBelowShare(t *testing.T) {
+	distribution := map[string]int{"backend-a": 45, "backend-b": 52, "backend-c": 3}
+
+	got := backendsBelowShare(distribution, 100, 0.1)
+	if want := []string{"backend-c (3.0%)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("backendsBelowShare(0.1) = %v, want %v", got, want)
+	}
+	if got := backendsBelowShare(distribution, 100, 0.03); len(got) != 0 {
+		t.Errorf("backendsBelowShare(0.03) = %v, want none (the threshold itself is enough)", got)
+	}
+	if got := backendsBelowShare(map[string]int{}, 0, 0.1); len(got) != 0 {
+		t.Errorf("backendsBelowShare() with no successes = %v, want none", got)
+	}
+}
+
+func TestEvaluateFairness(t *testing.T) {
+	even := map[string]int{"b1": 20, "b2": 20, "b3": 20, "b4": 20, "b5": 20}
+	for _, tt := range []struct {
+		name         string
+		distribution map[string]int
+		minBackends  int
+		minShare     float64
+		ok           bool
+	}{
+		{"even", even, 5, 0.15, true},
+		{"even without share check", even, 5, 0, true},
+		{"slightly uneven", map[string]int{"b1": 24, "b2": 18, "b3": 20, "b4": 17, "b5": 21}, 5, 0.15, true},
+		{"two of five", map[string]int{"b1": 50, "b2": 50}, 5, 0, false},
+		{"skewed", map[string]int{"b1": 80, "b2": 5, "b3": 5, "b4": 5, "b5": 5}, 5, 0.1, false},
+		{"skewed without share check", map[string]int{"b1": 80, "b2": 5, "b3": 5, "b4": 5, "b5": 5}, 5, 0, true},
+		{"single backend allowed", map[string]int{"b1": 10}, 1, 0.5, true},
+		{"no backend", map[string]int{}, 1, 0, false},
+	} {
+		if err := evaluateFairness(tt.distribution, tt.minBackends, tt.minShare); (err == nil) != tt.ok {
+			t.Errorf("%s: evaluateFairness(%v, %d, %v) = %v, want ok %v", tt.name, tt.distribution, tt.minBackends, tt.minShare, err, tt.ok)
+		}
+	}
+}
+
+func TestInvalidServerIDs(t *testing.T) {
+	re := regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*$`)
+	distribution := map[string]int{
+		"ip-10-0-1-23.ec2.internal":        10,
+		"0123456789abcdef0123456789abcdef": 10,
+		"unknown":                          5,
+		"":                                 1,
+		"has space":                        1,
+	}
+
+	got := invalidServerIDs(distribution, re)
+	if want := []string{`""`, `"has space"`, `"unknown"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalidServerIDs() = %v, want %v", got, want)
+	}
+
+	// Without a pattern the placeholders are still flagged
+	got = invalidServerIDs(distribution, nil)
+	if want := []string{`""`, `"unknown"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalidServerIDs(nil) = %v, want %v", got, want)
+	}
+	if got := invalidServerIDs(map[string]int{"backend-a": 1}, re); len(got) != 0 {
+		t.Errorf("invalidServerIDs() = %v for a valid ID, want none", got)
+	}
+}
+
+func TestAttachmentAddress(t *testing.T) {
+	detail := func(name, value string) ecstypes.KeyValuePair {
+		return ecstypes.KeyValuePair{Name: aws.String(name), Value: aws.String(value)}
+	}
+	eni := ecstypes.Attachment{
+		Type: aws.String("ElasticNetworkInterface"),
+		Details: []ecstypes.KeyValuePair{
+			detail("networkInterfaceId", "eni-1"),
+			detail("privateIPv4Address", "10.0.1.5"),
+			detail("publicIPv4Address", "203.0.113.7"),
+			detail("ipv6Address", "2001:db8::5"),
+		},
+	}
+	for kind, want := range map[addressType]string{addressPublic: "203.0.113.7", addressPrivate: "10.0.1.5", addressIPv6: "2001:db8::5"} {
+		if got, ok := attachmentAddress([]ecstypes.Attachment{eni}, kind); !ok || got != want {
+			t.Errorf("attachmentAddress(%s) = %q, %t, want %q", kind, got, ok, want)
+		}
+	}
+
+	// A private-only ENI in an IPv4 subnet, next to an attachment of another type
+	privateOnly := []ecstypes.Attachment{
+		{Type: aws.String("ServiceConnect"), Details: []ecstypes.KeyValuePair{detail("publicIPv4Address", "198.51.100.1")}},
+		{Type: aws.String("ElasticNetworkInterface"), Details: []ecstypes.KeyValuePair{detail("privateIPv4Address", "10.0.1.6"), detail("publicIPv4Address", "")}},
+	}
+	if got, ok := attachmentAddress(privateOnly, addressPrivate); !ok || got != "10.0.1.6" {
+		t.Errorf("attachmentAddress(private) = %q, %t, want 10.0.1.6", got, ok)
+	}
+	for _, kind := range []addressType{addressPublic, addressIPv6} {
+		if got, ok := attachmentAddress(privateOnly, kind); ok {
+			t.Errorf("attachmentAddress(%s) = %q, want none", kind, got)
+		}
+	}
+}
+
+func TestENIAddress(t *testing.T) {
+	eni := ec2types.NetworkInterface{
+		PrivateIpAddress: aws.String("10.0.1.5"),
+		Association:      &ec2types.NetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.7")},
+		Ipv6Address:      aws.String("2001:db8::5"),
+	}
+	for kind, want := range map[addressType]string{addressPublic: "203.0.113.7", addressPrivate: "10.0.1.5", addressIPv6: "2001:db8::5"} {
+		if got, ok := eniAddress(eni, kind); !ok || got != want {
+			t.Errorf("eniAddress(%s) = %q, %t, want %q", kind, got, ok, want)
+		}
+	}
+
+	// Without a primary IPv6 address, the first assigned one is used
+	eni = ec2types.NetworkInterface{
+		PrivateIpAddress: aws.String("10.0.1.6"),
+		Ipv6Addresses:    []ec2types.NetworkInterfaceIpv6Address{{Ipv6Address: aws.String("2001:db8::6")}},
+	}
+	if got, ok := eniAddress(eni, addressIPv6); !ok || got != "2001:db8::6" {
+		t.Errorf("eniAddress(ipv6) = %q, %t, want 2001:db8::6", got, ok)
+	}
+	if got, ok := eniAddress(eni, addressPublic); ok {
+		t.Errorf("eniAddress(public) without an association = %q, want none", got)
+	}
+}
+
+// fakeTasks answers DescribeTasks with tasks in turn, the last one
+// repeatedly, and counts the calls
+type fakeTasks struct {
+	tasks []ecstypes.Task
+	calls int
+}
+
+func (f *fakeTasks) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	task := f.tasks[min(f.calls, len(f.tasks)-1)]
+	f.calls++
+	return &ecs.DescribeTasksOutput{Tasks: []ecstypes.Task{task}}, nil
+}
+
+// fakeENIs answers DescribeNetworkInterfaces with enis
+type fakeENIs struct {
+	enis  []ec2types.NetworkInterface
+	calls int
+}
+
+func (f *fakeENIs) DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	f.calls++
+	return &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: f.enis}, nil
+}
+
+// eniTask returns a running task whose ENI attachment has the given status
+// and details, given as name/value pairs
+func eniTask(status string, details ...string) ecstypes.Task {
+	attachment := ecstypes.Attachment{Type: aws.String("ElasticNetworkInterface"), Status: aws.String(status)}
+	for i := 0; i+1 < len(details); i += 2 {
+		attachment.Details = append(attachment.Details, ecstypes.KeyValuePair{Name: aws.String(details[i]), Value: aws.String(details[i+1])})
+	}
+	return ecstypes.Task{LastStatus: aws.String("RUNNING"), Attachments: []ecstypes.Attachment{attachment}}
+}
+
+func TestWaitForTaskAddressPollsUntilAttached(t *testing.T) {
+	tasks := &fakeTasks{tasks: []ecstypes.Task{
+		{LastStatus: aws.String("PROVISIONING")},
+		eniTask("ATTACHING", "networkInterfaceId", "eni-1"),
+		eniTask("ATTACHED", "networkInterfaceId", "eni-1", "publicIPv4Address", "203.0.113.7"),
+	}}
+
+	addr, err := waitForTaskAddress(context.Background(), tasks, &fakeENIs{}, "cluster", "task", addressPublic, time.Millisecond)
+	if err != nil || addr != "203.0.113.7" {
+		t.Fatalf("waitForTaskAddress() = %q, %v, want 203.0.113.7", addr, err)
+	}
+	if tasks.calls != 3 {
+		t.Errorf("DescribeTasks called %d times, want 3", tasks.calls)
+	}
+}
+
+func TestWaitForTaskAddressRetriesMissingDetails(t *testing.T) {
+	tasks := &fakeTasks{tasks: []ecstypes.Task{
+		eniTask("ATTACHED"),
+		eniTask("ATTACHED", "networkInterfaceId", "eni-1"),
+	}}
+	enis := &fakeENIs{enis: []ec2types.NetworkInterface{{
+		Association: &ec2types.NetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.7")},
+	}}}
+
+	addr, err := waitForTaskAddress(context.Background(), tasks, enis, "cluster", "task", addressPublic, time.Millisecond)
+	if err != nil || addr != "203.0.113.7" {
+		t.Fatalf("waitForTaskAddress() = %q, %v, want the public IP from EC2", addr, err)
+	}
+	if tasks.calls != 2 || enis.calls != 1 {
+		t.Errorf("DescribeTasks called %d times and DescribeNetworkInterfaces %d times, want 2 and 1", tasks.calls, enis.calls)
+	}
+}
+
+func TestWaitForTaskAddressFailsFast(t *testing.T) {
+	attached := eniTask("ATTACHED", "networkInterfaceId", "eni-1", "privateIPv4Address", "10.0.1.5")
+	stopped := eniTask("ATTACHED", "networkInterfaceId", "eni-1", "publicIPv4Address", "203.0.113.7")
+	stopped.LastStatus = aws.String("STOPPED")
+	stopped.StoppedReason = aws.String("Essential container in task exited")
+
+	for _, tt := range []struct {
+		name string
+		task ecstypes.Task
+		kind addressType
+		want string
+	}{
+		{"no public IP", attached, addressPublic, "assign_public_ip"},
+		{"no IPv6 CIDR", attached, addressIPv6, "IPv6 CIDR"},
+		{"stopped task", stopped, addressPublic, "Essential container in task exited"},
+	} {
+		tasks := &fakeTasks{tasks: []ecstypes.Task{tt.task}}
+		enis := &fakeENIs{enis: []ec2types.NetworkInterface{{PrivateIpAddress: aws.String("10.0.1.5")}}}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := waitForTaskAddress(ctx, tasks, enis, "cluster", "task", tt.kind, time.Millisecond)
+		cancel()
+		if err == nil || errors.Is(err, errAddressNotReady) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: waitForTaskAddress() error = %v, want a permanent error mentioning %q", tt.name, err, tt.want)
+		}
+		if tasks.calls != 1 {
+			t.Errorf("%s: DescribeTasks called %d times, want 1", tt.name, tasks.calls)
+		}
+	}
+}
+
+// fakeDeployer starts deployment "new" on UpdateService and answers
+// DescribeServices with services in turn, the last one repeatedly
+type fakeDeployer struct {
+	updates   []*ecs.UpdateServiceInput
+	services  []ecstypes.Service
+	describes int
+	// ready gates the move past the first service, e.g. until traffic was sent
+	ready func() bool
+}
+
+func (f *fakeDeployer) UpdateService(ctx context.Context, params *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error) {
+	f.updates = append(f.updates, params)
+	return &ecs.UpdateServiceOutput{Service: &ecstypes.Service{Deployments: []ecstypes.Deployment{
+		{Id: aws.String("new"), Status: aws.String("PRIMARY")},
+		{Id: aws.String("old"), Status: aws.String("ACTIVE")},
+	}}}, nil
+}
+
+func (f *fakeDeployer) DescribeServices(ctx context.Context, params *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	i := min(f.describes, len(f.services)-1)
+	if f.ready != nil && !f.ready() {
+		i = 0
+	}
+	f.describes++
+	return &ecs.DescribeServicesOutput{Services: []ecstypes.Service{f.services[i]}}, nil
+}
+
+// deployment returns a deployment with the given running and desired counts
+func deployment(id string, state ecstypes.DeploymentRolloutState, running, desired int32) ecstypes.Deployment {
+	return ecstypes.Deployment{Id: aws.String(id), RolloutState: state, RunningCount: running, DesiredCount: desired}
+}
+
+func TestRunDuringDeploymentWaitsForSteadyState(t *testing.T) {
+	var probes atomic.Int32
+	client := &fakeDeployer{
+		services: []ecstypes.Service{
+			{Deployments: []ecstypes.Deployment{deployment("new", ecstypes.DeploymentRolloutStateInProgress, 1, 2), deployment("old", ecstypes.DeploymentRolloutStateCompleted, 2, 2)}},
+			{Deployments: []ecstypes.Deployment{deployment("new", ecstypes.DeploymentRolloutStateCompleted, 2, 2)}},
+		},
+		// Keep the rollout going until the first round of traffic was counted
+		ready: func() bool { return probes.Load() >= 2 },
+	}
+	probe := func(ctx context.Context) (*TestResponse, error) {
+		if probes.Add(1) == 1 {
+			return &TestResponse{TotalRequests: 20, FailureCount: 1}, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	stats, err := runDuringDeployment(context.Background(), client, "cluster", "backend", time.Millisecond, probe)
+	if err != nil {
+		t.Fatalf("runDuringDeployment() error = %v", err)
+	}
+	if len(client.updates) != 1 || !client.updates[0].ForceNewDeployment || aws.ToString(client.updates[0].Service) != "backend" {
+		t.Errorf("UpdateService calls = %+v, want one forced deployment of backend", client.updates)
+	}
+	if want := (rolloutStats{Rounds: 1, Requests: 20, Failures: 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if err := checkRolloutFailures(stats, 0); err == nil {
+		t.Error("checkRolloutFailures() accepted a failed request with -max-rollout-failures=0")
+	}
+	if err := checkRolloutFailures(stats, 1); err != nil {
+		t.Errorf("checkRolloutFailures() = %v, want the failure tolerated", err)
+	}
+}
+
+func TestRunDuringDeploymentRolloutFailed(t *testing.T) {
+	failed := deployment("new", ecstypes.DeploymentRolloutStateFailed, 0, 2)
+	failed.RolloutStateReason = aws.String("tasks failed to start")
+	client := &fakeDeployer{services: []ecstypes.Service{{Deployments: []ecstypes.Deployment{failed}}}}
+	probe := func(ctx context.Context) (*TestResponse, error) { return &TestResponse{}, nil }
+
+	_, err := runDuringDeployment(context.Background(), client, "cluster", "backend", time.Millisecond, probe)
+	if err == nil || !strings.Contains(err.Error(), "tasks failed to start") {
+		t.Errorf("runDuringDeployment() error = %v, want the rollout failure reason", err)
+	}
+}
+
+func TestRunDuringDeploymentTimesOut(t *testing.T) {
+	client := &fakeDeployer{services: []ecstypes.Service{
+		{Deployments: []ecstypes.Deployment{deployment("new", ecstypes.DeploymentRolloutStateInProgress, 1, 2)}},
+	}}
+	probe := func(ctx context.Context) (*TestResponse, error) { return &TestResponse{}, nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := runDuringDeployment(ctx, client, "cluster", "backend", time.Millisecond, probe)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "did not reach steady state") {
+		t.Errorf("runDuringDeployment() error = %v, want a steady state timeout", err)
+	}
+}
+
+func TestCheckRolloutFailures(t *testing.T) {
+	for _, tt := range []struct {
+		stats       rolloutStats
+		maxFailures int
+		ok          bool
+	}{
+		{rolloutStats{Rounds: 5, Requests: 100}, 0, true},
+		{rolloutStats{Rounds: 5, Requests: 100, Failures: 2}, 2, true},
+		{rolloutStats{Rounds: 5, Requests: 100, Failures: 3}, 2, false},
+		{rolloutStats{Rounds: 5, Requests: 80, FailedRounds: 1}, 10, false},
+		{rolloutStats{}, 0, false},
+	} {
+		if err := checkRolloutFailures(tt.stats, tt.maxFailures); (err == nil) != tt.ok {
+			t.Errorf("checkRolloutFailures(%+v, %d) = %v, want ok %v", tt.stats, tt.maxFailures, err, tt.ok)
+		}
+	}
+}
@@ -3,15 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/example/hello-fargate-app/jsonlog"
 )
 
 // JobMessage represents the input JSON structure for a job
@@ -26,131 +35,814 @@ type JobResult struct {
 	JobID   string `json:"job_id"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+	RawBody string `json:"raw_body,omitempty"` // Original message body, set when it is not a valid JobMessage
 }
 
+// ActionHandler processes a job with a given action
+type ActionHandler func(ctx context.Context, job JobMessage) (JobResult, error)
+
+// actionHandlers maps job actions to their handlers. Messages without an
+// action are handled as "test".
+var actionHandlers = map[string]ActionHandler{
+	"test":   handleTest,
+	"resize": handleResize,
+	"email":  handleEmail,
+}
+
+// errUnknownAction is returned for jobs whose action has no handler
+var errUnknownAction = errors.New("unknown action")
+
+// errInvalidMessage is returned for messages whose body is not a JobMessage
+var errInvalidMessage = errors.New("invalid job message")
+
+// isPermanent reports whether err fails the message on every receive, so
+// that retrying it is pointless
+func isPermanent(err error) bool {
+	return errors.Is(err, errUnknownAction) || errors.Is(err, errInvalidMessage)
+}
+
+// WorkerConfig holds the tunable worker settings
+type WorkerConfig struct {
+	Concurrency       int    // Number of messages processed in parallel
+	VisibilityTimeout int32  // Seconds a received message stays hidden from other consumers
+	MaxReceiveCount   int    // Receives a failing message gets before it is moved to the DLQ (mirrors the redrive policy)
+	HeartbeatSeconds  int    // Seconds between visibility timeout extensions while a message is processed (0 disables)
+	DLQURL            string // Dead-letter queue for failing messages; empty leaves them to the redrive policy
+	AckMode           string // When the message is deleted relative to processing: ackModeBefore or ackModeAfter
+}
+
+// Ack modes: ackModeAfter deletes a message only once its job succeeded, so
+// failing jobs are retried; ackModeBefore deletes it before the job runs, so
+// a job is never processed twice but is lost if it fails
+const (
+	ackModeBefore = "before"
+	ackModeAfter  = "after"
+)
+
 func main() {
-	log.Println("Background job worker started.")
+	logJSON("info", "", "Background job worker started", nil)
 
 	// Get SQS queue URL from environment variable
 	queueURL := os.Getenv("SQS_QUEUE_URL")
 	if queueURL == "" {
-		log.Fatal("Error: SQS_QUEUE_URL environment variable is not set.")
+		logFatal("SQS_QUEUE_URL environment variable is not set", nil)
 	}
-	log.Printf("Queue URL: %s\n", queueURL)
+	logJSON("info", "", "Queue URL configured", logFields{"queue_url": queueURL})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle graceful shutdown
+	// Load AWS configuration
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		logFatal("Failed to load AWS SDK config", logFields{"error": err.Error()})
+	}
+
+	// Load worker config from the environment, optionally overridden by SSM
+	workerCfg := loadConfigFromEnv()
+	if ssmPath := os.Getenv("CONFIG_SSM_PATH"); ssmPath != "" {
+		if err := applySSMConfig(ctx, ssm.NewFromConfig(cfg), ssmPath, &workerCfg); err != nil {
+			logJSON("warn", "", "Failed to load config from SSM, using environment/default values", logFields{"ssm_path": ssmPath, "error": err.Error()})
+		}
+	}
+	logJSON("info", "", "Worker config", logFields{
+		"concurrency":                  workerCfg.Concurrency,
+		"visibility_timeout":           workerCfg.VisibilityTimeout,
+		"max_receive_count":            workerCfg.MaxReceiveCount,
+		"visibility_heartbeat_seconds": workerCfg.HeartbeatSeconds,
+		"dlq_url":                      workerCfg.DLQURL,
+		"ack_mode":                     workerCfg.AckMode,
+	})
+
+	sqsClient := sqs.NewFromConfig(cfg)
+
+	// Periodically log the queue backlog, until shutdown cancels ctx
+	metricsInterval := 60 * time.Second
+	if v := os.Getenv("METRICS_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logJSON("warn", "", "Ignoring invalid METRICS_INTERVAL", logFields{"value": v, "error": err.Error()})
+		} else {
+			metricsInterval = d
+		}
+	}
+	if metricsInterval > 0 {
+		metrics, err := emfConfigFromEnv()
+		if err != nil {
+			logFatal("Invalid metrics config", logFields{"error": err.Error()})
+		}
+		ticker := time.NewTicker(metricsInterval)
+		defer ticker.Stop()
+		go logQueueDepth(ctx, sqsClient, queueURL, ticker.C, metrics)
+	}
+
+	worker := NewWorker(sqsClient, queueURL, workerCfg)
+
+	// Handle graceful shutdown: stop polling, wait for in-flight handlers,
+	// then cancel everything else
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
-		log.Printf("Received signal %v, shutting down...\n", sig)
+		logJSON("info", "", "Received signal, shutting down", logFields{"signal": sig.String()})
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+		if err := worker.Shutdown(shutdownCtx); err != nil {
+			logJSON("warn", "", "In-flight handlers did not finish before the shutdown timeout, cancelled them", logFields{"error": err.Error()})
+		}
 		cancel()
 	}()
 
-	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatalf("Failed to load AWS SDK config: %v", err)
+	logJSON("info", "", "Starting to poll for messages", nil)
+	worker.Run(ctx)
+	logJSON("info", "", "Worker stopped", nil)
+}
+
+// shutdownTimeout bounds how long Shutdown waits for in-flight handlers; it
+// stays below the 30s ECS gives a task between SIGTERM and SIGKILL
+const shutdownTimeout = 25 * time.Second
+
+// SQSAPI is the subset of the SQS client used by the worker, so that tests
+// can inject a fake
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// Worker polls the queue and processes messages until it is shut down
+type Worker struct {
+	client   SQSAPI
+	queueURL string
+	cfg      WorkerConfig
+
+	stopPolling chan struct{} // closed by Shutdown to stop receiving messages
+	stopOnce    sync.Once
+	done        chan struct{} // closed when Run returns
+
+	// Handlers run on handlerCtx rather than on the polling context, so that
+	// stopping the poll lets in-flight jobs finish; Shutdown cancels it once
+	// its deadline passes
+	handlerCtx     context.Context
+	cancelHandlers context.CancelFunc
+}
+
+// NewWorker returns a Worker for the queue
+func NewWorker(client SQSAPI, queueURL string, cfg WorkerConfig) *Worker {
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	return &Worker{
+		client:         client,
+		queueURL:       queueURL,
+		cfg:            cfg,
+		stopPolling:    make(chan struct{}),
+		done:           make(chan struct{}),
+		handlerCtx:     handlerCtx,
+		cancelHandlers: cancelHandlers,
 	}
+}
 
-	sqsClient := sqs.NewFromConfig(cfg)
+// Run polls for messages and processes them until Shutdown is called or ctx
+// is cancelled. It returns once the handlers of the last batch are done.
+func (w *Worker) Run(ctx context.Context) {
+	defer close(w.done)
 
-	log.Println("Starting to poll for messages...")
+	// Stopping the poll also aborts a ReceiveMessage long poll in progress
+	pollCtx, cancelPoll := context.WithCancel(ctx)
+	defer cancelPoll()
+	go func() {
+		select {
+		case <-w.stopPolling:
+			cancelPoll()
+		case <-pollCtx.Done():
+		}
+	}()
 
-	// Main polling loop
 	for {
 		select {
-		case <-ctx.Done():
-			log.Println("Shutdown requested, stopping worker...")
+		case <-pollCtx.Done():
+			logJSON("info", "", "Shutdown requested, stopped polling", nil)
 			return
 		default:
-			if err := pollAndProcess(ctx, sqsClient, queueURL); err != nil {
-				log.Printf("Error polling messages: %v\n", err)
+			if err := w.Poll(pollCtx); err != nil {
+				if pollCtx.Err() != nil {
+					continue
+				}
+				logJSON("error", "", "Error polling messages", logFields{"error": err.Error()})
 				// Brief sleep before retrying on error
-				time.Sleep(5 * time.Second)
+				select {
+				case <-pollCtx.Done():
+				case <-time.After(5 * time.Second):
+				}
 			}
 		}
 	}
 }
 
-func pollAndProcess(ctx context.Context, client *sqs.Client, queueURL string) error {
+// Shutdown stops receiving new messages, then waits for the in-flight
+// handlers to finish. If they are not done before ctx is, it cancels the
+// context of the handlers still running and returns the error of ctx.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stopPolling) })
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancelHandlers()
+		return ctx.Err()
+	}
+}
+
+// Poll receives one batch of messages and processes them, returning once
+// all of their handlers are done
+func (w *Worker) Poll(ctx context.Context) error {
+	client, queueURL, workerCfg := w.client, w.queueURL, w.cfg
+
+	// Receive at most as many messages as can be processed at once, so that no
+	// message sits in the batch waiting for a free handler while its
+	// visibility timeout runs down
+	maxMessages := int32(workerCfg.Concurrency)
+	if maxMessages > 10 {
+		maxMessages = 10
+	}
+
 	// Receive messages with long polling
 	result, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 		QueueUrl:            &queueURL,
-		MaxNumberOfMessages: 10,
+		MaxNumberOfMessages: maxMessages,
 		WaitTimeSeconds:     20, // Long polling
-		VisibilityTimeout:   300,
+		VisibilityTimeout:   workerCfg.VisibilityTimeout,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameApproximateReceiveCount,
+			types.MessageSystemAttributeNameMessageGroupId,
+			types.MessageSystemAttributeNameMessageDeduplicationId,
+		},
+		MessageAttributeNames: []string{"All"},
 	})
 	if err != nil {
 		return err
 	}
 
 	if len(result.Messages) == 0 {
-		log.Println("No messages received, continuing to poll...")
+		logJSON("info", "", "No messages received, continuing to poll", nil)
 		return nil
 	}
 
-	log.Printf("Received %d message(s)\n", len(result.Messages))
+	logJSON("info", "", "Received messages", logFields{"count": len(result.Messages)})
 
+	// Process the batch in parallel, bounded by the configured concurrency.
+	// Handlers run on the worker's handler context rather than ctx, so that
+	// in-flight jobs can finish and delete their messages after polling
+	// stops; we wait for all of them before returning to the polling loop.
+	sem := make(chan struct{}, workerCfg.Concurrency)
+	var wg sync.WaitGroup
 	for _, msg := range result.Messages {
-		if err := processMessage(ctx, client, queueURL, msg); err != nil {
-			log.Printf("Error processing message %s: %v\n", *msg.MessageId, err)
-			// Don't delete the message on error - it will be retried
-			continue
-		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(msg types.Message) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := w.ProcessMessage(w.handlerCtx, msg); err != nil {
+				logJSON("error", "", "Error processing message", logFields{"message_id": *msg.MessageId, "error": err.Error()})
+				// Don't delete the message on error - it will be retried
+			}
+		}(msg)
 	}
+	wg.Wait()
 
 	return nil
 }
 
-func processMessage(ctx context.Context, client *sqs.Client, queueURL string, msg types.Message) error {
+// logQueueDepth logs the approximate number of visible and in-flight messages
+// on every tick until ctx is cancelled. Each entry is in CloudWatch embedded
+// metric format, so the counts are also published as metrics.
+func logQueueDepth(ctx context.Context, client SQSAPI, queueURL string, ticks <-chan time.Time, metrics emfConfig) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			output, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+				QueueUrl: &queueURL,
+				AttributeNames: []types.QueueAttributeName{
+					types.QueueAttributeNameApproximateNumberOfMessages,
+					types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+				},
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					logJSON("warn", "", "Failed to get queue depth", logFields{"error": err.Error()})
+				}
+				continue
+			}
+
+			visible, _ := strconv.Atoi(output.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+			notVisible, _ := strconv.Atoi(output.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)])
+			logJSON("info", "", "Queue depth", metrics.fields(time.Now(), map[string]int{
+				"ApproximateNumberOfMessagesVisible":    visible,
+				"ApproximateNumberOfMessagesNotVisible": notVisible,
+			}))
+		}
+	}
+}
+
+// emfConfig is the CloudWatch embedded metric format (EMF) metadata attached
+// to metric log entries
+type emfConfig struct {
+	Namespace  string
+	Dimensions map[string]string
+}
+
+// defaultMetricsNamespace is the CloudWatch namespace used when
+// METRICS_NAMESPACE is unset
+const defaultMetricsNamespace = "HelloFargate/Worker"
+
+// emfConfigFromEnv reads the metric namespace from METRICS_NAMESPACE and the
+// dimensions from METRICS_DIMENSIONS, a comma-separated list of key=value
+// pairs such as "Environment=dev,Service=worker"
+func emfConfigFromEnv() (emfConfig, error) {
+	metrics := emfConfig{
+		Namespace:  defaultMetricsNamespace,
+		Dimensions: map[string]string{},
+	}
+	if v := os.Getenv("METRICS_NAMESPACE"); v != "" {
+		metrics.Namespace = v
+	}
+	if v := os.Getenv("METRICS_DIMENSIONS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || key == "" || value == "" {
+				return emfConfig{}, fmt.Errorf("invalid METRICS_DIMENSIONS entry %q: expected key=value", pair)
+			}
+			metrics.Dimensions[key] = value
+		}
+	}
+	return metrics, nil
+}
+
+// fields returns log fields holding the metric values, the dimension values
+// and the _aws metadata that tells CloudWatch to extract them as metrics
+func (c emfConfig) fields(now time.Time, values map[string]int) logFields {
+	fields := make(logFields, len(values)+len(c.Dimensions)+1)
+
+	dimensionKeys := make([]string, 0, len(c.Dimensions))
+	for k, v := range c.Dimensions {
+		dimensionKeys = append(dimensionKeys, k)
+		fields[k] = v
+	}
+	sort.Strings(dimensionKeys)
+
+	metricNames := make([]string, 0, len(values))
+	for name, v := range values {
+		metricNames = append(metricNames, name)
+		fields[name] = v
+	}
+	sort.Strings(metricNames)
+	definitions := make([]map[string]string, len(metricNames))
+	for i, name := range metricNames {
+		definitions[i] = map[string]string{"Name": name, "Unit": "Count"}
+	}
+
+	fields["_aws"] = map[string]interface{}{
+		"Timestamp": now.UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  c.Namespace,
+			"Dimensions": [][]string{dimensionKeys},
+			"Metrics":    definitions,
+		}},
+	}
+	return fields
+}
+
+// ProcessMessage runs the job in msg and deletes the message on success.
+// Failing messages are left for redelivery or moved to the DLQ.
+func (w *Worker) ProcessMessage(ctx context.Context, msg types.Message) error {
+	client, queueURL, workerCfg := w.client, w.queueURL, w.cfg
 	messageID := *msg.MessageId
-	log.Printf("Processing message: %s\n", messageID)
+	// A receive count above 1 means the message was redelivered, e.g. after
+	// a failure or a visibility timeout
+	receiveCount := approximateReceiveCount(msg)
+	msgFields := logFields{"message_id": messageID, "receive_count": receiveCount}
+	// FIFO queues report the group and deduplication IDs of the message
+	if groupID := msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; groupID != "" {
+		msgFields["message_group_id"] = groupID
+	}
+	if dedupID := msg.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]; dedupID != "" {
+		msgFields["dedup_id"] = dedupID
+	}
+	if attrs := messageAttributes(msg); len(attrs) > 0 {
+		msgFields["attributes"] = attrs
+	}
+	logJSON("info", "", "Processing message", msgFields)
+
+	ackBefore := workerCfg.AckMode == ackModeBefore
+
+	// Keep the message hidden from other consumers while the job runs
+	stopHeartbeat := func() {}
+	if workerCfg.HeartbeatSeconds > 0 && !ackBefore {
+		stopHeartbeat = startVisibilityHeartbeat(ctx, client, queueURL, msg,
+			time.Duration(workerCfg.HeartbeatSeconds)*time.Second, workerCfg.VisibilityTimeout)
+		defer stopHeartbeat()
+	}
 
 	// Parse the message body
 	var job JobMessage
-	if err := json.Unmarshal([]byte(*msg.Body), &job); err != nil {
-		log.Printf("Warning: Failed to parse message as JobMessage: %v\n", err)
-		// Try to parse as generic JSON for logging
-		var generic map[string]interface{}
-		if err := json.Unmarshal([]byte(*msg.Body), &generic); err != nil {
-			log.Printf("Message body: %s\n", *msg.Body)
-		} else {
-			job.Payload = generic
-		}
+	parseErr := json.Unmarshal([]byte(*msg.Body), &job)
+	if parseErr != nil {
+		logJSON("warn", "", "Failed to parse message as JobMessage", logFields{"message_id": messageID, "error": parseErr.Error()})
 	}
 
-	// Process the job (simple example - just log and create result)
-	result := JobResult{
-		JobID:   job.JobID,
-		Status:  "success",
-		Message: "Job processed successfully",
+	if job.JobID == "" {
+		job.JobID = messageID
+	}
+	if job.Action == "" {
+		job.Action = "test"
 	}
 
-	if job.JobID == "" {
-		result.JobID = messageID
+	if ackBefore {
+		if err := w.deleteMessage(ctx, msg); err != nil {
+			return err
+		}
+		logJSON("info", job.JobID, "Message deleted", logFields{"message_id": messageID, "ack_mode": workerCfg.AckMode})
 	}
 
-	if job.Action != "" {
-		result.Message = "Processed action: " + job.Action
+	var result JobResult
+	var err error
+	if parseErr != nil {
+		err = fmt.Errorf("%w: %v", errInvalidMessage, parseErr)
+	} else {
+		result, err = dispatch(ctx, job)
+	}
+	// The job is done, so a late heartbeat must not hide the message again
+	// after it is released below
+	stopHeartbeat()
+	if err != nil {
+		result = JobResult{
+			JobID:   job.JobID,
+			Status:  "error",
+			Message: err.Error(),
+		}
+		if parseErr != nil {
+			// Keep the payload for debugging, since it never became a job
+			result.RawBody = *msg.Body
+		}
 	}
 
 	// Output the result
-	resultBytes, _ := json.MarshalIndent(result, "", "  ")
-	log.Printf("--- Job Result ---\n%s\n------------------\n", string(resultBytes))
+	fields := logFields{
+		"message_id":    messageID,
+		"receive_count": receiveCount,
+		"status":        result.Status,
+		"result":        result.Message,
+	}
+	if result.RawBody != "" {
+		fields["raw_body"] = result.RawBody
+	}
+	logJSON("info", result.JobID, "Job result", fields)
+
+	if ackBefore {
+		// The message is already gone, so a failed job is not retried
+		return err
+	}
+
+	if err != nil && workerCfg.DLQURL != "" {
+		// Permanent failures never succeed, others get MaxReceiveCount tries:
+		// a message failing on its MaxReceiveCount-th receive is moved, just
+		// as the redrive policy would instead of delivering it once more
+		if isPermanent(err) || receiveCount >= workerCfg.MaxReceiveCount {
+			if derr := moveToDLQ(ctx, client, queueURL, workerCfg.DLQURL, msg, err.Error()); derr != nil {
+				return fmt.Errorf("%v (and failed to move message to DLQ: %w)", err, derr)
+			}
+			logJSON("warn", job.JobID, "Message moved to DLQ", logFields{"message_id": messageID, "receive_count": receiveCount, "error": err.Error()})
+			return nil
+		}
+	}
+
+	if isPermanent(err) {
+		// Retrying cannot help, so make the message visible again right away
+		// instead of deleting it. The queue's redrive policy moves it to the
+		// DLQ once it has been received MaxReceiveCount times; a queue without
+		// one would redeliver the message forever, so without DLQ_URL the
+		// queue must have a redrive policy.
+		if _, verr := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: 0,
+		}); verr != nil {
+			logJSON("warn", job.JobID, "Failed to release message", logFields{"message_id": messageID, "error": verr.Error()})
+		}
+		return err
+	}
+	if err != nil {
+		// Don't delete the message - it will be retried
+		return err
+	}
 
 	// Delete the message from the queue
-	_, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+	if err := w.deleteMessage(ctx, msg); err != nil {
+		return err
+	}
+
+	logJSON("info", job.JobID, "Message deleted", logFields{"message_id": messageID})
+	return nil
+}
+
+// deleteMessage acknowledges msg by deleting it from the queue
+func (w *Worker) deleteMessage(ctx context.Context, msg types.Message) error {
+	_, err := w.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &w.queueURL,
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	return err
+}
+
+// approximateReceiveCount returns how many times msg has been received, or 0
+// if the attribute was not requested
+func approximateReceiveCount(msg types.Message) int {
+	n, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	return n
+}
+
+// messageAttributes returns the String and Number message attributes of msg
+// by name. Binary attributes are reported by their size only.
+func messageAttributes(msg types.Message) map[string]string {
+	if len(msg.MessageAttributes) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]string, len(msg.MessageAttributes))
+	for name, attr := range msg.MessageAttributes {
+		switch {
+		case attr.StringValue != nil:
+			attrs[name] = *attr.StringValue
+		case attr.BinaryValue != nil:
+			attrs[name] = fmt.Sprintf("(binary, %d bytes)", len(attr.BinaryValue))
+		default:
+			attrs[name] = ""
+		}
+	}
+	return attrs
+}
+
+// moveToDLQ sends the body of msg to the dead-letter queue with the failure
+// reason as a message attribute, then deletes msg from the main queue
+func moveToDLQ(ctx context.Context, client SQSAPI, queueURL, dlqURL string, msg types.Message, reason string) error {
+	_, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    &dlqURL,
+		MessageBody: msg.Body,
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"FailureReason": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(reason),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send to DLQ: %w", err)
+	}
+
+	_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
 		QueueUrl:      &queueURL,
 		ReceiptHandle: msg.ReceiptHandle,
 	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from queue: %w", err)
+	}
+	return nil
+}
+
+// dispatch runs the handler registered for the job's action
+func dispatch(ctx context.Context, job JobMessage) (JobResult, error) {
+	handler, ok := actionHandlers[job.Action]
+	if !ok {
+		return JobResult{}, fmt.Errorf("%w: %q", errUnknownAction, job.Action)
+	}
+	return handler(ctx, job)
+}
+
+// handleTest acknowledges the job without doing any work
+func handleTest(ctx context.Context, job JobMessage) (JobResult, error) {
+	return JobResult{
+		JobID:   job.JobID,
+		Status:  "success",
+		Message: "Processed action: " + job.Action,
+	}, nil
+}
+
+// handleResize is an example handler that would resize the image in the
+// payload to the requested width and height
+func handleResize(ctx context.Context, job JobMessage) (JobResult, error) {
+	image, _ := job.Payload["image"].(string)
+	width, _ := job.Payload["width"].(float64)
+	height, _ := job.Payload["height"].(float64)
+	if image == "" || width <= 0 || height <= 0 {
+		return JobResult{}, fmt.Errorf("resize requires image, width and height in the payload")
+	}
+
+	logJSON("info", job.JobID, "Resizing image", logFields{"image": image, "width": int(width), "height": int(height)})
+	return JobResult{
+		JobID:   job.JobID,
+		Status:  "success",
+		Message: fmt.Sprintf("Resized %s to %dx%d", image, int(width), int(height)),
+	}, nil
+}
+
+// handleEmail is an example handler that would send an email to the address
+// in the payload
+func handleEmail(ctx context.Context, job JobMessage) (JobResult, error) {
+	to, _ := job.Payload["to"].(string)
+	if to == "" {
+		return JobResult{}, fmt.Errorf("email requires to in the payload")
+	}
+
+	logJSON("info", job.JobID, "Sending email", logFields{"to": to})
+	return JobResult{
+		JobID:   job.JobID,
+		Status:  "success",
+		Message: "Sent email to " + to,
+	}, nil
+}
+
+// startVisibilityHeartbeat extends the visibility timeout of msg by
+// visibilityTimeout seconds every interval, so that a job running longer than
+// the initial timeout is not redelivered mid-processing. The returned function
+// stops the heartbeat and waits for it to exit.
+func startVisibilityHeartbeat(ctx context.Context, client SQSAPI, queueURL string, msg types.Message, interval time.Duration, visibilityTimeout int32) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          &queueURL,
+					ReceiptHandle:     msg.ReceiptHandle,
+					VisibilityTimeout: visibilityTimeout,
+				})
+				if err != nil {
+					if ctx.Err() == nil {
+						logJSON("warn", "", "Failed to extend message visibility", logFields{"message_id": *msg.MessageId, "error": err.Error()})
+					}
+					continue
+				}
+				logJSON("info", "", "Extended message visibility", logFields{"message_id": *msg.MessageId, "visibility_timeout": visibilityTimeout})
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// logFields holds the extra fields of a structured log entry
+type logFields = jsonlog.Fields
+
+// jsonLogger writes one JSON object per line, also from concurrent goroutines
+var jsonLogger = jsonlog.New(os.Stderr)
+
+// logJSON writes a log entry with the level, message, job ID (omitted when
+// empty), timestamp and any extra fields
+func logJSON(level, jobID, msg string, fields logFields) {
+	jsonLogger.Log(level, jobID, msg, fields)
+}
+
+// logFatal logs an error entry and exits
+func logFatal(msg string, fields logFields) {
+	logJSON("error", "", msg, fields)
+	os.Exit(1)
+}
+
+// loadConfigFromEnv returns the worker config from environment variables,
+// falling back to defaults that match the queue settings in Terraform.
+func loadConfigFromEnv() WorkerConfig {
+	workerCfg := WorkerConfig{
+		Concurrency:       1,
+		VisibilityTimeout: 300,
+		MaxReceiveCount:   3,
+		HeartbeatSeconds:  60,
+		DLQURL:            os.Getenv("DLQ_URL"),
+		AckMode:           ackModeAfter,
+	}
+
+	for name, value := range map[string]string{
+		"concurrency":                  os.Getenv("WORKER_CONCURRENCY"),
+		"visibility_timeout":           os.Getenv("VISIBILITY_TIMEOUT"),
+		"max_receive_count":            os.Getenv("MAX_RECEIVE_COUNT"),
+		"visibility_heartbeat_seconds": os.Getenv("VISIBILITY_HEARTBEAT_SECONDS"),
+		"ack_mode":                     os.Getenv("ACK_MODE"),
+	} {
+		if value == "" {
+			continue
+		}
+		if err := workerCfg.set(name, value); err != nil {
+			logJSON("warn", "", "Ignoring invalid environment value", logFields{"setting": name, "error": err.Error()})
+		}
+	}
+
+	// A heartbeat must fire while the message is still hidden
+	if err := workerCfg.validate(); err != nil {
+		workerCfg.HeartbeatSeconds = int(workerCfg.VisibilityTimeout / 2)
+		logJSON("warn", "", "Adjusting visibility heartbeat", logFields{"error": err.Error(), "visibility_heartbeat_seconds": workerCfg.HeartbeatSeconds})
+	}
+
+	return workerCfg
+}
+
+// applySSMConfig overrides workerCfg with the parameters stored under the SSM
+// path, e.g. /hello-fargate/worker/concurrency. workerCfg is left untouched
+// if any parameter cannot be loaded or parsed.
+func applySSMConfig(ctx context.Context, client ssm.GetParametersByPathAPIClient, ssmPath string, workerCfg *WorkerConfig) error {
+	updated := *workerCfg
+
+	paginator := ssm.NewGetParametersByPathPaginator(client, &ssm.GetParametersByPathInput{
+		Path:           &ssmPath,
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get parameters: %w", err)
+		}
+
+		for _, param := range page.Parameters {
+			name := path.Base(aws.ToString(param.Name))
+			if err := updated.set(name, aws.ToString(param.Value)); err != nil {
+				return fmt.Errorf("invalid parameter %s: %w", aws.ToString(param.Name), err)
+			}
+			logJSON("info", "", "Loaded setting from SSM", logFields{"setting": name, "value": aws.ToString(param.Value)})
+		}
+	}
+
+	if err := updated.validate(); err != nil {
+		return err
+	}
+
+	*workerCfg = updated
+	return nil
+}
+
+// set updates the setting with the given name from its string value.
+// Unknown names are ignored so that the SSM path can hold other parameters.
+func (c *WorkerConfig) set(name, value string) error {
+	switch name {
+	case "ack_mode":
+		if value != ackModeBefore && value != ackModeAfter {
+			return fmt.Errorf("invalid ack mode %q (expected %q or %q)", value, ackModeBefore, ackModeAfter)
+		}
+		c.AckMode = value
+		return nil
+	case "concurrency", "visibility_timeout", "max_receive_count", "visibility_heartbeat_seconds":
+	default:
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
 	if err != nil {
 		return err
 	}
+	if n < 0 || (n == 0 && name != "visibility_heartbeat_seconds") {
+		return fmt.Errorf("value out of range: %d", n)
+	}
+	if name == "visibility_timeout" && n > maxVisibilityTimeout {
+		return fmt.Errorf("value out of range: %d (SQS allows at most %d)", n, maxVisibilityTimeout)
+	}
 
-	log.Printf("Message %s deleted successfully\n", messageID)
+	switch name {
+	case "concurrency":
+		c.Concurrency = n
+	case "visibility_timeout":
+		c.VisibilityTimeout = int32(n)
+	case "max_receive_count":
+		c.MaxReceiveCount = n
+	case "visibility_heartbeat_seconds":
+		c.HeartbeatSeconds = n
+	}
+	return nil
+}
+
+// maxVisibilityTimeout is the longest visibility timeout SQS accepts, in
+// seconds
+const maxVisibilityTimeout = 43200
+
+// validate checks the settings that depend on each other: a heartbeat that is
+// not shorter than the visibility timeout would only extend it after the
+// message was already redelivered
+func (c WorkerConfig) validate() error {
+	if c.HeartbeatSeconds > 0 && c.HeartbeatSeconds >= int(c.VisibilityTimeout) {
+		return fmt.Errorf("visibility heartbeat of %ds must be shorter than the visibility timeout of %ds", c.HeartbeatSeconds, c.VisibilityTimeout)
+	}
 	return nil
 }
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeSQS is an in-memory SQSAPI that records the calls made by the worker
+type fakeSQS struct {
+	mu         sync.Mutex
+	batches    [][]types.Message          // returned by successive ReceiveMessage calls
+	receives   []*sqs.ReceiveMessageInput // inputs of the ReceiveMessage calls
+	deleted    []string                   // receipt handles passed to DeleteMessage
+	visibility []int32                    // timeouts passed to ChangeMessageVisibility
+	sent       []*sqs.SendMessageInput
+	depth      map[string]string // queue attributes returned by GetQueueAttributes
+	depthCalls int
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	f.receives = append(f.receives, params)
+	if len(f.batches) > 0 {
+		batch := f.batches[0]
+		f.batches = f.batches[1:]
+		f.mu.Unlock()
+		return &sqs.ReceiveMessageOutput{Messages: batch}, nil
+	}
+	f.mu.Unlock()
+
+	// An empty queue long-polls until the worker stops polling
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visibility = append(f.visibility, params.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, params)
+	return &sqs.SendMessageOutput{MessageId: aws.String("dlq-1")}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.depthCalls++
+	return &sqs.GetQueueAttributesOutput{Attributes: f.depth}, nil
+}
+
+// captureLogs redirects the worker's JSON log lines to a buffer for the rest
+// of the test
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	jsonLogger.SetOutput(buf)
+	t.Cleanup(func() { jsonLogger.SetOutput(os.Stderr) })
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of handlers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries parses the captured log lines with the given msg
+func (b *syncBuffer) entries(t *testing.T, msg string) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		if entry["msg"] == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// fakeSSM serves GetParametersByPath from a fixed set of parameters, one per
+// page to exercise pagination
+type fakeSSM struct {
+	params []ssmtypes.Parameter
+}
+
+func (f *fakeSSM) GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	i := 0
+	if params.NextToken != nil {
+		for i < len(f.params) && aws.ToString(f.params[i].Name) != *params.NextToken {
+			i++
+		}
+	}
+	out := &ssm.GetParametersByPathOutput{}
+	if i < len(f.params) {
+		out.Parameters = f.params[i : i+1]
+	}
+	if i+1 < len(f.params) {
+		out.NextToken = f.params[i+1].Name
+	}
+	return out, nil
+}
+
+// ssmParams returns SSM parameters under /hello-fargate/worker
+func ssmParams(kv ...string) []ssmtypes.Parameter {
+	var params []ssmtypes.Parameter
+	for i := 0; i < len(kv); i += 2 {
+		params = append(params, ssmtypes.Parameter{Name: aws.String("/hello-fargate/worker/" + kv[i]), Value: aws.String(kv[i+1])})
+	}
+	return params
+}
+
+func TestApplySSMConfig(t *testing.T) {
+	client := &fakeSSM{params: ssmParams("concurrency", "4", "max_receive_count", "5", "visibility_timeout", "120", "unrelated", "x")}
+
+	cfg := WorkerConfig{Concurrency: 1, VisibilityTimeout: 300, MaxReceiveCount: 3}
+	if err := applySSMConfig(context.Background(), client, "/hello-fargate/worker", &cfg); err != nil {
+		t.Fatalf("applySSMConfig() error = %v", err)
+	}
+	if cfg.Concurrency != 4 || cfg.MaxReceiveCount != 5 || cfg.VisibilityTimeout != 120 {
+		t.Errorf("applySSMConfig() = %+v, want concurrency 4, max_receive_count 5, visibility_timeout 120", cfg)
+	}
+}
+
+func TestApplySSMConfigInvalidLeavesConfig(t *testing.T) {
+	client := &fakeSSM{params: ssmParams("concurrency", "4", "max_receive_count", "many")}
+
+	cfg := WorkerConfig{Concurrency: 1, MaxReceiveCount: 3}
+	if err := applySSMConfig(context.Background(), client, "/hello-fargate/worker", &cfg); err == nil {
+		t.Fatal("applySSMConfig() succeeded with an invalid parameter")
+	}
+	if cfg.Concurrency != 1 || cfg.MaxReceiveCount != 3 {
+		t.Errorf("config changed to %+v after a failed load", cfg)
+	}
+}
+
+func TestLogQueueDepthOnEveryTick(t *testing.T) {
+	logs := captureLogs(t)
+	client := &fakeSQS{depth: map[string]string{
+		string(types.QueueAttributeNameApproximateNumberOfMessages):           "7",
+		string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible): "2",
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		logQueueDepth(ctx, client, "https://sqs.example/queue", ticks, emfConfig{Namespace: "Test"})
+		close(done)
+	}()
+
+	// Nothing is logged before the first tick
+	time.Sleep(10 * time.Millisecond)
+	if n := len(logs.entries(t, "Queue depth")); n != 0 {
+		t.Fatalf("logged %d depth entries before the first tick", n)
+	}
+
+	ticks <- time.Now()
+	ticks <- time.Now()
+	ticks <- time.Now() // the unbuffered send returns once the second tick is logged
+	cancel()
+	<-done
+
+	entries := logs.entries(t, "Queue depth")
+	if len(entries) < 2 {
+		t.Fatalf("logged %d depth entries after 3 ticks, want at least 2", len(entries))
+	}
+	if entries[0]["ApproximateNumberOfMessagesVisible"] != 7.0 || entries[0]["ApproximateNumberOfMessagesNotVisible"] != 2.0 {
+		t.Errorf("depth entry = %v, want 7 visible and 2 not visible", entries[0])
+	}
+}
+
+// countingHandler registers an action that records how often each job runs
+// and how many jobs run at once
+func countingHandler(t *testing.T, action string) (runs map[string]int, maxInFlight *int) {
+	t.Helper()
+	var mu sync.Mutex
+	runs = map[string]int{}
+	maxInFlight = new(int)
+	inFlight := 0
+
+	actionHandlers[action] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		mu.Lock()
+		runs[job.JobID]++
+		inFlight++
+		if inFlight > *maxInFlight {
+			*maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return JobResult{JobID: job.JobID, Status: "success"}, nil
+	}
+	t.Cleanup(func() { delete(actionHandlers, action) })
+	return runs, maxInFlight
+}
+
+func testMessage(id, action string) types.Message {
+	return types.Message{
+		MessageId:     aws.String("msg-" + id),
+		ReceiptHandle: aws.String("rh-" + id),
+		Body:          aws.String(`{"job_id":"` + id + `","action":"` + action + `"}`),
+	}
+}
+
+func TestRunProcessesEachMessageOnce(t *testing.T) {
+	captureLogs(t)
+	runs, maxInFlight := countingHandler(t, "count")
+
+	var batches [][]types.Message
+	var want []string
+	for b := 0; b < 3; b++ {
+		var batch []types.Message
+		for i := 0; i < 3; i++ {
+			id := fmt.Sprintf("job-%d-%d", b, i)
+			batch = append(batch, testMessage(id, "count"))
+			want = append(want, "rh-"+id)
+		}
+		batches = append(batches, batch)
+	}
+	client := &fakeSQS{batches: batches}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{Concurrency: 3, AckMode: ackModeAfter})
+
+	go w.Run(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mu.Lock()
+		n := len(client.deleted)
+		client.mu.Unlock()
+		if n >= len(want) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	deleted := append([]string(nil), client.deleted...)
+	sort.Strings(deleted)
+	sort.Strings(want)
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v, want each of %v exactly once", deleted, want)
+	}
+	for id, n := range runs {
+		if n != 1 {
+			t.Errorf("job %s ran %d times, want 1", id, n)
+		}
+	}
+	if len(runs) != len(want) {
+		t.Errorf("ran %d jobs, want %d", len(runs), len(want))
+	}
+	if *maxInFlight < 2 || *maxInFlight > 3 {
+		t.Errorf("max in-flight jobs = %d, want 2-3 with concurrency 3", *maxInFlight)
+	}
+}
+
+func TestLoadConfigFromEnvHeartbeat(t *testing.T) {
+	for _, tt := range []struct {
+		timeout, heartbeat string
+		wantTimeout        int32
+		wantHeartbeat      int
+	}{
+		{"300", "60", 300, 60},
+		{"30", "60", 30, 15},   // longer than the timeout: half of it is used
+		{"30", "30", 30, 15},   // firing at the timeout is already too late
+		{"30", "0", 30, 0},     // disabled
+		{"0", "10", 300, 10},   // a zero timeout is ignored
+		{"50000", "", 300, 60}, // above the SQS maximum
+	} {
+		t.Run(tt.timeout+"/"+tt.heartbeat, func(t *testing.T) {
+			captureLogs(t)
+			t.Setenv("VISIBILITY_TIMEOUT", tt.timeout)
+			t.Setenv("VISIBILITY_HEARTBEAT_SECONDS", tt.heartbeat)
+
+			cfg := loadConfigFromEnv()
+			if cfg.VisibilityTimeout != tt.wantTimeout || cfg.HeartbeatSeconds != tt.wantHeartbeat {
+				t.Errorf("timeout, heartbeat = %d, %d, want %d, %d", cfg.VisibilityTimeout, cfg.HeartbeatSeconds, tt.wantTimeout, tt.wantHeartbeat)
+			}
+		})
+	}
+}
+
+func TestApplySSMConfigRejectsHeartbeatAtTimeout(t *testing.T) {
+	client := &fakeSSM{params: ssmParams("visibility_timeout", "60", "visibility_heartbeat_seconds", "60")}
+
+	cfg := WorkerConfig{VisibilityTimeout: 300, HeartbeatSeconds: 30}
+	if err := applySSMConfig(context.Background(), client, "/hello-fargate/worker", &cfg); err == nil {
+		t.Fatal("applySSMConfig() accepted a heartbeat equal to the visibility timeout")
+	}
+	if cfg.VisibilityTimeout != 300 || cfg.HeartbeatSeconds != 30 {
+		t.Errorf("config changed to %+v after a failed load", cfg)
+	}
+}
+
+func TestVisibilityHeartbeatExtendsWhileRunning(t *testing.T) {
+	captureLogs(t)
+	client := &fakeSQS{}
+
+	// The job runs for several heartbeat intervals
+	stop := startVisibilityHeartbeat(context.Background(), client, "https://sqs.example/queue", testMessage("slow", "test"), 20*time.Millisecond, 90)
+	time.Sleep(110 * time.Millisecond)
+	stop()
+
+	client.mu.Lock()
+	extended := append([]int32(nil), client.visibility...)
+	client.mu.Unlock()
+	if len(extended) < 3 {
+		t.Fatalf("visibility extended %d times during 5 intervals, want at least 3", len(extended))
+	}
+	for _, v := range extended {
+		if v != 90 {
+			t.Errorf("visibility extended to %ds, want 90s", v)
+		}
+	}
+
+	// Nothing is extended once the job is done
+	time.Sleep(60 * time.Millisecond)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.visibility) != len(extended) {
+		t.Errorf("visibility extended %d more times after stop", len(client.visibility)-len(extended))
+	}
+}
+
+func TestDispatchRegisteredActions(t *testing.T) {
+	for _, action := range []string{"fake-upper", "fake-len"} {
+		action := action
+		actionHandlers[action] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+			return JobResult{JobID: job.JobID, Status: "success", Message: action}, nil
+		}
+		t.Cleanup(func() { delete(actionHandlers, action) })
+	}
+
+	for _, action := range []string{"fake-upper", "fake-len"} {
+		result, err := dispatch(context.Background(), JobMessage{JobID: "j1", Action: action})
+		if err != nil || result.Message != action {
+			t.Errorf("dispatch(%s) = %+v, %v, want the %s handler's result", action, result, err, action)
+		}
+	}
+
+	if _, err := dispatch(context.Background(), JobMessage{JobID: "j1", Action: "fake-missing"}); !errors.Is(err, errUnknownAction) {
+		t.Errorf("dispatch(fake-missing) error = %v, want errUnknownAction", err)
+	}
+}
+
+func TestProcessMessageUnknownActionMovesToDLQ(t *testing.T) {
+	logs := captureLogs(t)
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, DLQURL: "https://sqs.example/dlq", AckMode: ackModeAfter})
+
+	if err := w.ProcessMessage(context.Background(), testMessage("j1", "fake-missing")); err != nil {
+		t.Fatalf("ProcessMessage() error = %v", err)
+	}
+
+	if len(client.sent) != 1 || aws.ToString(client.sent[0].QueueUrl) != "https://sqs.example/dlq" {
+		t.Fatalf("sent %d messages, want 1 to the DLQ on the first receive", len(client.sent))
+	}
+	if reason := aws.ToString(client.sent[0].MessageAttributes["FailureReason"].StringValue); !strings.Contains(reason, "unknown action") {
+		t.Errorf("FailureReason = %q, want the unknown action", reason)
+	}
+	if !reflect.DeepEqual(client.deleted, []string{"rh-j1"}) {
+		t.Errorf("deleted %v, want the message removed from the main queue", client.deleted)
+	}
+	results := logs.entries(t, "Job result")
+	if len(results) != 1 || results[0]["status"] != "error" {
+		t.Errorf("job results = %v, want one with status error", results)
+	}
+}
+
+func TestProcessMessageUnknownActionWithoutDLQ(t *testing.T) {
+	captureLogs(t)
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, AckMode: ackModeAfter})
+
+	if err := w.ProcessMessage(context.Background(), testMessage("j1", "fake-missing")); !errors.Is(err, errUnknownAction) {
+		t.Fatalf("ProcessMessage() error = %v, want errUnknownAction", err)
+	}
+	// Left to the redrive policy: released right away, not deleted
+	if len(client.deleted) != 0 || len(client.sent) != 0 {
+		t.Errorf("deleted %v and sent %d messages, want neither", client.deleted, len(client.sent))
+	}
+	if !reflect.DeepEqual(client.visibility, []int32{0}) {
+		t.Errorf("visibility changes = %v, want the message released with 0", client.visibility)
+	}
+}
+
+func TestProcessMessageStopsHeartbeatBeforeRelease(t *testing.T) {
+	captureLogs(t)
+	// A slow handler that rejects its payload, so the message is released
+	actionHandlers["fake-slow-invalid"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		time.Sleep(1200 * time.Millisecond)
+		return JobResult{}, fmt.Errorf("%w: bad payload", errInvalidMessage)
+	}
+	t.Cleanup(func() { delete(actionHandlers, "fake-slow-invalid") })
+
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{VisibilityTimeout: 30, HeartbeatSeconds: 1, AckMode: ackModeAfter})
+	if err := w.ProcessMessage(context.Background(), testMessage("j1", "fake-slow-invalid")); err == nil {
+		t.Fatal("ProcessMessage() succeeded for a failing job")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if want := []int32{30, 0}; !reflect.DeepEqual(client.visibility, want) {
+		t.Errorf("visibility changes = %v, want %v: one heartbeat, then the release", client.visibility, want)
+	}
+}
+
+func TestProcessMessageDLQBoundary(t *testing.T) {
+	actionHandlers["fake-fail"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		return JobResult{}, errors.New("temporary failure")
+	}
+	t.Cleanup(func() { delete(actionHandlers, "fake-fail") })
+
+	for _, tt := range []struct {
+		receiveCount string
+		wantMoved    bool
+	}{
+		{"1", false},
+		{"2", false},
+		{"3", true}, // the MaxReceiveCount-th receive is the last attempt
+		{"4", true},
+	} {
+		t.Run("receive "+tt.receiveCount, func(t *testing.T) {
+			captureLogs(t)
+			client := &fakeSQS{}
+			w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, DLQURL: "https://sqs.example/dlq", AckMode: ackModeAfter})
+			msg := testMessage("j1", "fake-fail")
+			msg.Attributes = map[string]string{string(types.MessageSystemAttributeNameApproximateReceiveCount): tt.receiveCount}
+
+			err := w.ProcessMessage(context.Background(), msg)
+			moved := len(client.sent) == 1 && reflect.DeepEqual(client.deleted, []string{"rh-j1"})
+			if moved != tt.wantMoved {
+				t.Errorf("moved to DLQ = %v (sent %d, deleted %v), want %v", moved, len(client.sent), client.deleted, tt.wantMoved)
+			}
+			if tt.wantMoved && err != nil {
+				t.Errorf("ProcessMessage() error = %v after moving the message", err)
+			}
+			if !tt.wantMoved && (err == nil || len(client.deleted) != 0) {
+				t.Errorf("ProcessMessage() error = %v, deleted %v, want the message left for a retry", err, client.deleted)
+			}
+		})
+	}
+}
+
+func TestPollLogsReceiveCount(t *testing.T) {
+	logs := captureLogs(t)
+	msg := testMessage("j1", "test")
+	msg.Attributes = map[string]string{string(types.MessageSystemAttributeNameApproximateReceiveCount): "2"}
+	client := &fakeSQS{batches: [][]types.Message{{msg}}}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{Concurrency: 1, AckMode: ackModeAfter})
+
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	attrs := client.receives[0].MessageSystemAttributeNames
+	if !slices.Contains(attrs, types.MessageSystemAttributeNameApproximateReceiveCount) {
+		t.Errorf("ReceiveMessage requested %v, want ApproximateReceiveCount", attrs)
+	}
+	for _, name := range []string{"Processing message", "Job result"} {
+		entries := logs.entries(t, name)
+		if len(entries) != 1 || entries[0]["receive_count"] != 2.0 {
+			t.Errorf("%s entries = %v, want one with receive_count 2", name, entries)
+		}
+	}
+}
+
+func TestPollLogsMessageAttributes(t *testing.T) {
+	logs := captureLogs(t)
+	msg := testMessage("j1", "test")
+	msg.Attributes = map[string]string{
+		string(types.MessageSystemAttributeNameMessageGroupId):         "group-1",
+		string(types.MessageSystemAttributeNameMessageDeduplicationId): "dedup-1",
+	}
+	msg.MessageAttributes = map[string]types.MessageAttributeValue{
+		"env":  {DataType: aws.String("String"), StringValue: aws.String("ci")},
+		"blob": {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2, 3}},
+	}
+	client := &fakeSQS{batches: [][]types.Message{{msg}}}
+	w := NewWorker(client, "https://sqs.example/queue.fifo", WorkerConfig{Concurrency: 1, AckMode: ackModeAfter})
+
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	receive := client.receives[0]
+	if !reflect.DeepEqual(receive.MessageAttributeNames, []string{"All"}) ||
+		!slices.Contains(receive.MessageSystemAttributeNames, types.MessageSystemAttributeNameMessageGroupId) {
+		t.Errorf("ReceiveMessage requested attributes %v and system attributes %v, want All and MessageGroupId",
+			receive.MessageAttributeNames, receive.MessageSystemAttributeNames)
+	}
+	entries := logs.entries(t, "Processing message")
+	if len(entries) != 1 {
+		t.Fatalf("Processing message entries = %v, want one", entries)
+	}
+	entry := entries[0]
+	wantAttrs := map[string]any{"env": "ci", "blob": "(binary, 3 bytes)"}
+	if entry["message_group_id"] != "group-1" || entry["dedup_id"] != "dedup-1" || !reflect.DeepEqual(entry["attributes"], wantAttrs) {
+		t.Errorf("Processing message entry = %v, want group-1, dedup-1 and attributes %v", entry, wantAttrs)
+	}
+}
+
+func TestShutdownWaitsForInFlightHandlers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	actionHandlers["slow"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		close(started)
+		<-release
+		return JobResult{JobID: job.JobID, Status: "success"}, ctx.Err()
+	}
+	t.Cleanup(func() { delete(actionHandlers, "slow") })
+
+	client := &fakeSQS{batches: [][]types.Message{{testMessage("slow", "slow")}}}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{Concurrency: 1, AckMode: ackModeAfter})
+	go w.Run(context.Background())
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- w.Shutdown(ctx)
+	}()
+	// Give Shutdown time to stop the poll before the handler finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if len(client.receives) != 1 {
+		t.Errorf("ReceiveMessage called %d times, want 1", len(client.receives))
+	}
+	if !reflect.DeepEqual(client.deleted, []string{"rh-slow"}) {
+		t.Errorf("deleted %v, want the in-flight message", client.deleted)
+	}
+}
+
+func TestShutdownCancelsHandlersAtDeadline(t *testing.T) {
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+	actionHandlers["stuck"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		close(started)
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return JobResult{}, ctx.Err()
+	}
+	t.Cleanup(func() { delete(actionHandlers, "stuck") })
+
+	client := &fakeSQS{batches: [][]types.Message{{testMessage("stuck", "stuck")}}}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{Concurrency: 1, AckMode: ackModeAfter})
+	go w.Run(context.Background())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context was not cancelled at the shutdown deadline")
+	}
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the handlers were cancelled")
+	}
+	if len(client.deleted) != 0 {
+		t.Errorf("deleted %v, want the cancelled message left on the queue", client.deleted)
+	}
+}
+
+func TestPollProcessesBatch(t *testing.T) {
+	captureLogs(t)
+	client := &fakeSQS{batches: [][]types.Message{{testMessage("j1", "test"), testMessage("j2", "test")}}}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{Concurrency: 2, AckMode: ackModeAfter})
+
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	if got := client.receives[0].MaxNumberOfMessages; got != 2 {
+		t.Errorf("MaxNumberOfMessages = %d, want the concurrency 2", got)
+	}
+	deleted := append([]string(nil), client.deleted...)
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, []string{"rh-j1", "rh-j2"}) {
+		t.Errorf("deleted %v, want both messages of the batch", deleted)
+	}
+}
+
+func TestPollReturnsReceiveError(t *testing.T) {
+	captureLogs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := NewWorker(&fakeSQS{}, "https://sqs.example/queue", WorkerConfig{Concurrency: 1, AckMode: ackModeAfter})
+
+	if err := w.Poll(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Poll() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestProcessMessageDeletesOnSuccess(t *testing.T) {
+	logs := captureLogs(t)
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, AckMode: ackModeAfter})
+
+	if err := w.ProcessMessage(context.Background(), testMessage("j1", "test")); err != nil {
+		t.Fatalf("ProcessMessage() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(client.deleted, []string{"rh-j1"}) {
+		t.Errorf("deleted %v, want the processed message", client.deleted)
+	}
+	results := logs.entries(t, "Job result")
+	if len(results) != 1 || results[0]["status"] != "success" || results[0]["job_id"] != "j1" {
+		t.Errorf("job results = %v, want one successful result for j1", results)
+	}
+}
+
+func TestProcessMessageKeepsFailedMessage(t *testing.T) {
+	captureLogs(t)
+	actionHandlers["fake-fail"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+		return JobResult{}, errors.New("boom")
+	}
+	t.Cleanup(func() { delete(actionHandlers, "fake-fail") })
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, AckMode: ackModeAfter})
+
+	if err := w.ProcessMessage(context.Background(), testMessage("j1", "fake-fail")); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("ProcessMessage() error = %v, want the handler's error", err)
+	}
+	// Left for SQS to redeliver once the visibility timeout expires
+	if len(client.deleted) != 0 || len(client.sent) != 0 || len(client.visibility) != 0 {
+		t.Errorf("deleted %v, sent %d, visibility changes %v, want none", client.deleted, len(client.sent), client.visibility)
+	}
+}
+
+func TestEMFConfigFromEnv(t *testing.T) {
+	t.Setenv("METRICS_NAMESPACE", "")
+	t.Setenv("METRICS_DIMENSIONS", "")
+	metrics, err := emfConfigFromEnv()
+	if err != nil || metrics.Namespace != defaultMetricsNamespace || len(metrics.Dimensions) != 0 {
+		t.Errorf("emfConfigFromEnv() = %+v, %v, want the default namespace and no dimensions", metrics, err)
+	}
+
+	t.Setenv("METRICS_NAMESPACE", "Staging/Worker")
+	t.Setenv("METRICS_DIMENSIONS", "Environment=staging, Service=worker")
+	metrics, err = emfConfigFromEnv()
+	want := emfConfig{Namespace: "Staging/Worker", Dimensions: map[string]string{"Environment": "staging", "Service": "worker"}}
+	if err != nil || !reflect.DeepEqual(metrics, want) {
+		t.Errorf("emfConfigFromEnv() = %+v, %v, want %+v", metrics, err, want)
+	}
+
+	for _, v := range []string{"Environment", "Environment=", "=staging", "Environment=staging,,"} {
+		t.Setenv("METRICS_DIMENSIONS", v)
+		if _, err := emfConfigFromEnv(); err == nil {
+			t.Errorf("METRICS_DIMENSIONS=%q: emfConfigFromEnv() succeeded, want an error", v)
+		}
+	}
+}
+
+func TestLogQueueDepthEMFMetadata(t *testing.T) {
+	logs := captureLogs(t)
+	client := &fakeSQS{depth: map[string]string{
+		string(types.QueueAttributeNameApproximateNumberOfMessages):           "7",
+		string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible): "2",
+	}}
+	metrics := emfConfig{Namespace: "Staging/Worker", Dimensions: map[string]string{"Service": "worker", "Environment": "staging"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		logQueueDepth(ctx, client, "https://sqs.example/queue", ticks, metrics)
+		close(done)
+	}()
+	ticks <- time.Now()
+	ticks <- time.Now() // returns once the first tick is logged
+	cancel()
+	<-done
+
+	entries := logs.entries(t, "Queue depth")
+	if len(entries) == 0 {
+		t.Fatal("no depth entry logged")
+	}
+	entry := entries[0]
+	if entry["Environment"] != "staging" || entry["Service"] != "worker" {
+		t.Errorf("dimension values = %v and %v, want staging and worker", entry["Environment"], entry["Service"])
+	}
+
+	// Round-trip the metadata through JSON to compare it as CloudWatch sees it
+	raw, err := json.Marshal(entry["_aws"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta struct {
+		Timestamp         int64
+		CloudWatchMetrics []struct {
+			Namespace  string
+			Dimensions [][]string
+			Metrics    []struct{ Name, Unit string }
+		}
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		t.Fatalf("_aws metadata %s: %v", raw, err)
+	}
+	if meta.Timestamp == 0 || len(meta.CloudWatchMetrics) != 1 {
+		t.Fatalf("_aws metadata = %s, want a timestamp and one metric directive", raw)
+	}
+	directive := meta.CloudWatchMetrics[0]
+	if directive.Namespace != "Staging/Worker" {
+		t.Errorf("namespace = %q, want Staging/Worker", directive.Namespace)
+	}
+	if !reflect.DeepEqual(directive.Dimensions, [][]string{{"Environment", "Service"}}) {
+		t.Errorf("dimensions = %v, want [[Environment Service]]", directive.Dimensions)
+	}
+	var names []string
+	for _, m := range directive.Metrics {
+		names = append(names, m.Name)
+		if entry[m.Name] == nil {
+			t.Errorf("metric %s has no value in the entry", m.Name)
+		}
+	}
+	if want := []string{"ApproximateNumberOfMessagesNotVisible", "ApproximateNumberOfMessagesVisible"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("metrics = %v, want %v", names, want)
+	}
+}
+
+func TestLoadConfigFromEnvAckMode(t *testing.T) {
+	for value, want := range map[string]string{"": ackModeAfter, "after": ackModeAfter, "before": ackModeBefore, "sometimes": ackModeAfter} {
+		captureLogs(t)
+		t.Setenv("ACK_MODE", value)
+		if got := loadConfigFromEnv().AckMode; got != want {
+			t.Errorf("ACK_MODE=%q: ack mode = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestProcessMessageAckOrdering(t *testing.T) {
+	for _, tt := range []struct {
+		ackMode       string
+		fail          bool
+		deletedDuring bool // whether the message is already deleted while the job runs
+		deletedAfter  bool
+	}{
+		{ackModeAfter, false, false, true},
+		{ackModeAfter, true, false, false}, // kept for a retry
+		{ackModeBefore, false, true, true},
+		{ackModeBefore, true, true, true}, // already gone, so not retried
+	} {
+		t.Run(fmt.Sprintf("%s/fail=%t", tt.ackMode, tt.fail), func(t *testing.T) {
+			captureLogs(t)
+			client := &fakeSQS{}
+			var deletedDuring bool
+			actionHandlers["fake-ack"] = func(ctx context.Context, job JobMessage) (JobResult, error) {
+				client.mu.Lock()
+				deletedDuring = len(client.deleted) > 0
+				client.mu.Unlock()
+				if tt.fail {
+					return JobResult{}, errors.New("boom")
+				}
+				return JobResult{JobID: job.JobID, Status: "success"}, nil
+			}
+			t.Cleanup(func() { delete(actionHandlers, "fake-ack") })
+
+			w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, AckMode: tt.ackMode})
+			err := w.ProcessMessage(context.Background(), testMessage("j1", "fake-ack"))
+			if tt.fail != (err != nil) {
+				t.Errorf("ProcessMessage() error = %v, want an error: %t", err, tt.fail)
+			}
+			if deletedDuring != tt.deletedDuring {
+				t.Errorf("deleted while the job ran = %t, want %t", deletedDuring, tt.deletedDuring)
+			}
+			if deletedAfter := reflect.DeepEqual(client.deleted, []string{"rh-j1"}); deletedAfter != tt.deletedAfter {
+				t.Errorf("deleted %v afterwards, want deleted once: %t", client.deleted, tt.deletedAfter)
+			}
+		})
+	}
+}
+
+func TestProcessMessageKeepsRawBodyOfInvalidMessage(t *testing.T) {
+	logs := captureLogs(t)
+	client := &fakeSQS{}
+	w := NewWorker(client, "https://sqs.example/queue", WorkerConfig{MaxReceiveCount: 3, DLQURL: "https://sqs.example/dlq", AckMode: ackModeAfter})
+	msg := testMessage("j1", "test")
+	msg.Body = aws.String("not json {")
+
+	if err := w.ProcessMessage(context.Background(), msg); err != nil {
+		t.Fatalf("ProcessMessage() error = %v", err)
+	}
+
+	results := logs.entries(t, "Job result")
+	if len(results) != 1 {
+		t.Fatalf("logged %d job results, want 1", len(results))
+	}
+	if results[0]["status"] != "error" || results[0]["raw_body"] != "not json {" {
+		t.Errorf("job result = %v, want status error and the body in raw_body", results[0])
+	}
+	// The payload can never become a job, so it goes to the DLQ right away
+	if len(client.sent) != 1 {
+		t.Errorf("sent %d messages to the DLQ, want 1", len(client.sent))
+	}
+
+	// A valid message does not repeat its body
+	logs = captureLogs(t)
+	if err := w.ProcessMessage(context.Background(), testMessage("j2", "test")); err != nil {
+		t.Fatalf("ProcessMessage() error = %v", err)
+	}
+	if results := logs.entries(t, "Job result"); len(results) != 1 || results[0]["raw_body"] != nil {
+		t.Errorf("job results = %v, want one without raw_body", results)
+	}
+}
@@ -85,6 +85,30 @@ resource "aws_iam_role_policy" "task_sqs_policy" {
           "sqs:ChangeMessageVisibility"
         ]
         Resource = aws_sqs_queue.jobs.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["sqs:SendMessage"]
+        Resource = aws_sqs_queue.dlq.arn
+      }
+    ]
+  })
+}
+
+# IAM Policy for reading worker config from SSM (only when a config path is set)
+resource "aws_iam_role_policy" "task_ssm_policy" {
+  count = var.config_ssm_path != "" ? 1 : 0
+
+  name = "hello-fargate-backgroundjobs-ssm-policy"
+  role = aws_iam_role.ecs_task_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["ssm:GetParametersByPath"]
+        Resource = "arn:aws:ssm:${data.aws_region.current.id}:*:parameter${var.config_ssm_path}"
       }
     ]
   })
@@ -109,6 +133,22 @@ resource "aws_ecs_task_definition" "worker" {
         {
           name  = "SQS_QUEUE_URL"
           value = aws_sqs_queue.jobs.url
+        },
+        {
+          name  = "VISIBILITY_TIMEOUT"
+          value = tostring(aws_sqs_queue.jobs.visibility_timeout_seconds)
+        },
+        {
+          name  = "MAX_RECEIVE_COUNT"
+          value = tostring(jsondecode(aws_sqs_queue.jobs.redrive_policy).maxReceiveCount)
+        },
+        {
+          name  = "DLQ_URL"
+          value = aws_sqs_queue.dlq.url
+        },
+        {
+          name  = "CONFIG_SSM_PATH"
+          value = var.config_ssm_path
         }
       ]
       logConfiguration = {
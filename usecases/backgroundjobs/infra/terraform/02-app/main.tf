@@ -52,3 +52,9 @@ variable "desired_count" {
   type        = number
   default     = 1
 }
+
+variable "config_ssm_path" {
+  description = "Optional SSM parameter path (e.g. /hello-fargate/worker) the worker loads its config from at startup"
+  type        = string
+  default     = ""
+}
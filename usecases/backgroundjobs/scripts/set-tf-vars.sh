@@ -82,3 +82,8 @@ fi
 if [[ -n "$TF_DESIRED_COUNT" ]]; then
     echo "export TF_VAR_desired_count=${TF_DESIRED_COUNT}"
 fi
+
+# 8. TF_VAR_config_ssm_path (SSM parameter path for worker config)
+if [[ -n "$TF_CONFIG_SSM_PATH" ]]; then
+    echo "export TF_VAR_config_ssm_path=\"${TF_CONFIG_SSM_PATH}\""
+fi
@@ -3,19 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+
+	"github.com/example/hello-fargate-harness"
 )
 
 // JobMessage represents the message structure sent to SQS
@@ -31,6 +36,15 @@ func main() {
 	clusterArn := flag.String("cluster-arn", "", "The ARN of the ECS cluster")
 	serviceName := flag.String("service-name", "", "The name of the ECS service")
 	timeout := flag.Duration("timeout", 120*time.Second, "Timeout for waiting for message processing")
+	prettyLogs := flag.Bool("pretty-logs", true, "Pretty-print JSON log messages; set to false to print them as-is")
+	maxRetries := flag.Int("max-retries", 2, "Maximum retries per AWS API call (throttling and transient errors)")
+	opTimeout := flag.Duration("op-timeout", 30*time.Second, "Timeout for each AWS API call, retries included (0 disables it)")
+	purge := flag.Bool("purge", false, "Purge the queue before sending the test message and check that it is empty again after the run")
+	maxLatency := flag.Duration("max-latency", 0, "Fail when the time from sending the message to its success log entry exceeds this (0 disables the check)")
+	messageGroupID := flag.String("message-group-id", "", "Message group ID of the test message (required for FIFO queues)")
+	dedupID := flag.String("dedup-id", "", "Deduplication ID of the test message (FIFO queues only; defaults to the job ID)")
+	var attrs attrFlags
+	flag.Var(&attrs, "attr", "Message attribute as key=value, sent as a String attribute (repeatable)")
 	flag.Parse()
 
 	if *queueURL == "" || *logGroupName == "" || *clusterArn == "" || *serviceName == "" {
@@ -39,10 +53,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := validateFIFOOptions(*queueURL, *messageGroupID, *dedupID); err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	messageAttributes, err := parseMessageAttributes(attrs)
+	if err != nil {
+		log.Fatalf("Invalid -attr: %v", err)
+	}
+
 	ctx := context.Background()
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := harness.LoadAWSConfig(ctx, *maxRetries, *opTimeout)
 	if err != nil {
 		log.Fatalf("Failed to load AWS SDK config: %v", err)
 	}
@@ -57,6 +79,16 @@ func main() {
 	}
 	fmt.Println("ECS service is running with desired tasks.")
 
+	// Drop leftovers of previous runs so that they cannot be mistaken for
+	// this run's message
+	if *purge {
+		fmt.Printf("Purging queue: %s\n", *queueURL)
+		if err := purgeQueue(ctx, sqsClient, *queueURL, queueDrainTimeout); err != nil {
+			log.Fatalf("Failed to purge queue: %v", err)
+		}
+		fmt.Println("Queue purged.")
+	}
+
 	// Generate a unique job ID to track this specific message
 	jobID := uuid.New().String()
 	fmt.Printf("Generated job ID: %s\n", jobID)
@@ -80,14 +112,28 @@ func main() {
 	fmt.Printf("Sending message to SQS queue: %s\n", *queueURL)
 	fmt.Printf("Message body: %s\n", string(messageBody))
 
-	sendOutput, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    queueURL,
-		MessageBody: aws.String(string(messageBody)),
-	})
+	sendInput := &sqs.SendMessageInput{
+		QueueUrl:          queueURL,
+		MessageBody:       aws.String(string(messageBody)),
+		MessageAttributes: messageAttributes,
+	}
+	if isFIFOQueue(*queueURL) {
+		// The job ID is unique per run, so it is a safe default that works
+		// whether or not the queue has content-based deduplication
+		if *dedupID == "" {
+			*dedupID = jobID
+		}
+		sendInput.MessageGroupId = messageGroupID
+		sendInput.MessageDeduplicationId = dedupID
+		fmt.Printf("Message group ID: %s, deduplication ID: %s\n", *messageGroupID, *dedupID)
+	}
+
+	sendOutput, err := sendMessage(ctx, sqsClient, sendInput)
 	if err != nil {
 		log.Fatalf("Failed to send message: %v", err)
 	}
 
+	sentAt := time.Now()
 	fmt.Printf("Message sent successfully. Message ID: %s\n", *sendOutput.MessageId)
 
 	// Wait for the message to be processed by checking CloudWatch logs
@@ -95,11 +141,12 @@ func main() {
 
 	startTime := time.Now()
 	processed := false
+	var processedAt time.Time
 	checkInterval := 5 * time.Second
+	logsClient := cloudwatchlogs.NewFromConfig(cfg)
 
 	for time.Since(startTime) < *timeout {
-		if checkJobInLogs(ctx, cfg, *logGroupName, jobID, startTime) {
-			processed = true
+		if processedAt, processed = checkJobInLogs(ctx, logsClient, *logGroupName, jobID, sentAt); processed {
 			break
 		}
 		fmt.Printf("  Message not yet processed, waiting %v...\n", checkInterval)
@@ -108,15 +155,181 @@ func main() {
 
 	if !processed {
 		fmt.Println("\n--- CloudWatch Logs (last 50 entries) ---")
-		fetchRecentLogs(ctx, cfg, *logGroupName, 50)
+		fetchRecentLogs(ctx, cfg, *logGroupName, 50, *prettyLogs)
 		fmt.Println("------------------------------------------")
 		log.Fatalf("Timeout: Message was not processed within %v", *timeout)
 	}
 
-	fmt.Printf("\nMessage processed successfully!\n")
+	latency := endToEndLatency(sentAt, processedAt)
+	fmt.Printf("\nMessage processed successfully! End-to-end latency: %v\n", latency)
 	fmt.Println("\n--- Relevant CloudWatch Logs ---")
-	fetchRecentLogs(ctx, cfg, *logGroupName, 20)
+	fetchRecentLogs(ctx, cfg, *logGroupName, 20, *prettyLogs)
 	fmt.Println("--------------------------------")
+
+	if *purge {
+		fmt.Println("Checking that the queue is empty...")
+		if err := waitForQueueDrained(ctx, sqsClient, *queueURL, queueDrainTimeout); err != nil {
+			log.Fatalf("Queue not drained after the run: %v", err)
+		}
+		fmt.Println("Queue is empty.")
+	}
+
+	if err := checkMaxLatency(latency, *maxLatency); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// attrFlags collects the values of the repeatable -attr flag
+type attrFlags []string
+
+func (a *attrFlags) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *attrFlags) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// parseMessageAttributes turns key=value pairs into String message
+// attributes. It returns nil when there are no pairs.
+func parseMessageAttributes(pairs []string) (map[string]types.MessageAttributeValue, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	attrs := make(map[string]types.MessageAttributeValue, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		// SQS rejects empty attribute values
+		if value == "" {
+			return nil, fmt.Errorf("attribute %q has an empty value", key)
+		}
+		if _, dup := attrs[key]; dup {
+			return nil, fmt.Errorf("attribute %q is set more than once", key)
+		}
+		attrs[key] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	// SQS allows at most 10 attributes per message
+	if len(attrs) > 10 {
+		return nil, fmt.Errorf("at most 10 attributes are allowed, got %d", len(attrs))
+	}
+	return attrs, nil
+}
+
+// isFIFOQueue reports whether queueURL names a FIFO queue, whose names must
+// end in .fifo
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// validateFIFOOptions checks that a FIFO queue gets a message group ID and
+// that standard queues get neither a group nor a deduplication ID, which SQS
+// would reject
+func validateFIFOOptions(queueURL, messageGroupID, dedupID string) error {
+	if isFIFOQueue(queueURL) {
+		if messageGroupID == "" {
+			return errors.New("-message-group-id is required for FIFO queues")
+		}
+		return nil
+	}
+	if messageGroupID != "" || dedupID != "" {
+		return errors.New("-message-group-id and -dedup-id are only supported for FIFO queues (URL ending in .fifo)")
+	}
+	return nil
+}
+
+// queueDrainTimeout bounds the wait for the queue to report no messages.
+// SQS takes up to 60 seconds to purge a queue, and its approximate counts
+// lag behind deletions.
+const queueDrainTimeout = 60 * time.Second
+
+// queueAPI is the subset of the SQS client used to purge and inspect the
+// queue, so that tests can substitute a fake
+type queueAPI interface {
+	PurgeQueue(ctx context.Context, params *sqs.PurgeQueueInput, optFns ...func(*sqs.Options)) (*sqs.PurgeQueueOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// purgeQueue deletes all messages in the queue and waits up to timeout for
+// the purge to take effect. SQS allows one purge per queue every 60 seconds,
+// so a purge right after another fails with a cooldown error.
+func purgeQueue(ctx context.Context, client queueAPI, queueURL string, timeout time.Duration) error {
+	_, err := client.PurgeQueue(ctx, &sqs.PurgeQueueInput{QueueUrl: &queueURL})
+	if err != nil {
+		var inProgress *types.PurgeQueueInProgress
+		if errors.As(err, &inProgress) {
+			return fmt.Errorf("queue was already purged within the last 60 seconds; wait for the cooldown to pass and retry: %w", err)
+		}
+		return err
+	}
+	return waitForQueueDrained(ctx, client, queueURL, timeout)
+}
+
+// waitForQueueDrained polls the queue until its ApproximateNumberOfMessages
+// is zero, failing after timeout
+func waitForQueueDrained(ctx context.Context, client queueAPI, queueURL string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		visible, err := approximateNumberOfMessages(ctx, client, queueURL)
+		if err != nil {
+			return err
+		}
+		if visible == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("queue still has %d messages after %v", visible, timeout)
+		}
+
+		fmt.Printf("  Queue has %d messages, waiting...\n", visible)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// approximateNumberOfMessages returns the number of visible messages in the
+// queue
+func approximateNumberOfMessages(ctx context.Context, client queueAPI, queueURL string) (int, error) {
+	name := types.QueueAttributeNameApproximateNumberOfMessages
+	out, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: []types.QueueAttributeName{name},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get queue attributes: %w", err)
+	}
+	n, err := strconv.Atoi(out.Attributes[string(name)])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, out.Attributes[string(name)], err)
+	}
+	return n, nil
+}
+
+// sendAPI is the subset of the SQS client used to send the test message
+type sendAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// sendMessage sends the message. Throttling and 5xx errors are already
+// retried by the SDK retryer (see -max-retries); a missing queue is reported
+// as such, as no amount of retrying would help.
+func sendMessage(ctx context.Context, client sendAPI, input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	output, err := client.SendMessage(ctx, input)
+	var queueNotFound *types.QueueDoesNotExist
+	if errors.As(err, &queueNotFound) {
+		return nil, fmt.Errorf("queue does not exist: %w", err)
+	}
+	return output, err
 }
 
 func waitForService(ctx context.Context, client *ecs.Client, clusterArn, serviceName string, timeout time.Duration) error {
@@ -149,67 +362,92 @@ func waitForService(ctx context.Context, client *ecs.Client, clusterArn, service
 	return fmt.Errorf("timeout waiting for service to have running tasks")
 }
 
-func checkJobInLogs(ctx context.Context, cfg aws.Config, logGroupName, jobID string, since time.Time) bool {
-	logsClient := cloudwatchlogs.NewFromConfig(cfg)
-
+// checkJobInLogs looks for the job's success entry in the log group, among
+// the events since shortly before since, and returns when it was logged
+func checkJobInLogs(ctx context.Context, logsClient cloudwatchlogs.FilterLogEventsAPIClient, logGroupName, jobID string, since time.Time) (time.Time, bool) {
 	// Query logs for our specific job ID
 	startTime := since.Add(-1 * time.Minute).UnixMilli() // Give some buffer
 
-	// List all log streams and check for our job ID
+	// Let CloudWatch Logs find the events mentioning the job ID across all
+	// streams of the group, instead of scanning each stream
+	filterPattern := fmt.Sprintf("%q", jobID)
 	var nextToken *string
 	for {
-		listOutput, err := logsClient.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
-			LogGroupName: &logGroupName,
-			OrderBy:      "LastEventTime",
-			Descending:   aws.Bool(true),
-			NextToken:    nextToken,
-			Limit:        aws.Int32(10),
+		output, err := logsClient.FilterLogEvents(ctx, &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName:  &logGroupName,
+			FilterPattern: &filterPattern,
+			StartTime:     &startTime,
+			NextToken:     nextToken,
 		})
 		if err != nil {
-			fmt.Printf("Warning: Could not list log streams: %v\n", err)
-			return false
+			fmt.Printf("Warning: Could not filter log events: %v\n", err)
+			return time.Time{}, false
 		}
 
-		for _, stream := range listOutput.LogStreams {
-			events, err := logsClient.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
-				LogGroupName:  &logGroupName,
-				LogStreamName: stream.LogStreamName,
-				StartTime:     &startTime,
-				StartFromHead: aws.Bool(false),
-			})
-			if err != nil {
-				continue
-			}
-
-			// Check events for our job ID, and look for success status nearby
-			// The log format has the JSON pretty-printed across multiple lines
-			foundJobID := false
-			for _, event := range events.Events {
-				msg := *event.Message
-				if strings.Contains(msg, jobID) {
-					foundJobID = true
-				}
-				// If we found our job ID and see success status, we're done
-				if foundJobID && strings.Contains(msg, `"status": "success"`) {
-					return true
-				}
-				// Reset if we see a different job starting
-				if strings.Contains(msg, "Processing message:") && !strings.Contains(msg, jobID) {
-					foundJobID = false
-				}
-			}
+		if processedAt, ok := jobSuccessTime(output.Events, jobID); ok {
+			return processedAt, true
 		}
 
-		if listOutput.NextToken == nil {
+		if output.NextToken == nil {
 			break
 		}
-		nextToken = listOutput.NextToken
+		nextToken = output.NextToken
+	}
+
+	return time.Time{}, false
+}
+
+// jobSuccessTime returns the timestamp of the earliest event that reports
+// the job's success. The worker logs one JSON object per line, so the job
+// result is a single event carrying both the job ID and its status.
+func jobSuccessTime(events []logtypes.FilteredLogEvent, jobID string) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for _, event := range events {
+		if event.Timestamp == nil || !isJobSuccess(aws.ToString(event.Message), jobID) {
+			continue
+		}
+		at := time.UnixMilli(*event.Timestamp)
+		if !found || at.Before(earliest) {
+			earliest, found = at, true
+		}
+	}
+	return earliest, found
+}
+
+// endToEndLatency returns the time from sending the message to its success
+// log entry. Clock skew between this host and the worker can make the entry
+// appear to precede the send, which is reported as zero latency.
+func endToEndLatency(sentAt, processedAt time.Time) time.Duration {
+	latency := processedAt.Sub(sentAt)
+	if latency < 0 {
+		return 0
 	}
+	return latency.Round(time.Millisecond)
+}
 
-	return false
+// checkMaxLatency fails when latency exceeds maxLatency; zero means no limit
+func checkMaxLatency(latency, maxLatency time.Duration) error {
+	if maxLatency > 0 && latency > maxLatency {
+		return fmt.Errorf("SLA breach: end-to-end latency %v exceeds -max-latency %v", latency, maxLatency)
+	}
+	return nil
+}
+
+// isJobSuccess reports whether msg is the worker's structured log entry for
+// a successful result of the given job
+func isJobSuccess(msg, jobID string) bool {
+	var entry struct {
+		JobID  string `json:"job_id"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(msg), &entry); err != nil {
+		return false
+	}
+	return entry.JobID == jobID && entry.Status == "success"
 }
 
-func fetchRecentLogs(ctx context.Context, cfg aws.Config, logGroupName string, limit int) {
+func fetchRecentLogs(ctx context.Context, cfg aws.Config, logGroupName string, limit int, pretty bool) {
 	logsClient := cloudwatchlogs.NewFromConfig(cfg)
 
 	// List recent log streams
@@ -246,14 +484,7 @@ func fetchRecentLogs(ctx context.Context, cfg aws.Config, logGroupName string, l
 		}
 
 		for _, event := range getLogsOutput.Events {
-			// Try to pretty print JSON output
-			var prettyJSON map[string]interface{}
-			if err := json.Unmarshal([]byte(*event.Message), &prettyJSON); err == nil {
-				formattedJSON, _ := json.MarshalIndent(prettyJSON, "", "  ")
-				fmt.Println(string(formattedJSON))
-			} else {
-				fmt.Println(*event.Message)
-			}
+			harness.PrintLogEvent(aws.ToString(event.Message), pretty)
 			eventCount++
 		}
 	}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeSender returns the queued errors from SendMessage, then succeeds
+type fakeSender struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeSender) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return &sqs.SendMessageOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+func TestSendMessageLeavesRetriesToSDK(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "RequestThrottled", Message: "slow down"}
+	client := &fakeSender{errs: []error{throttled}}
+
+	// The SDK retryer has already retried the call by the time it fails;
+	// retrying again here would multiply the attempts
+	_, err := sendMessage(context.Background(), client, &sqs.SendMessageInput{})
+	if !errors.Is(err, throttled) {
+		t.Fatalf("sendMessage() error = %v, want %v", err, throttled)
+	}
+	if client.calls != 1 {
+		t.Errorf("SendMessage called %d times, want 1", client.calls)
+	}
+}
+
+func TestSendMessageQueueDoesNotExist(t *testing.T) {
+	client := &fakeSender{errs: []error{&types.QueueDoesNotExist{Message: aws.String("no such queue")}}}
+
+	_, err := sendMessage(context.Background(), client, &sqs.SendMessageInput{})
+	var notFound *types.QueueDoesNotExist
+	if !errors.As(err, &notFound) || !strings.Contains(err.Error(), "queue does not exist") {
+		t.Fatalf("sendMessage() error = %v, want QueueDoesNotExist", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("SendMessage called %d times, want 1", client.calls)
+	}
+}
+
+func TestParseMessageAttributes(t *testing.T) {
+	attrs, err := parseMessageAttributes([]string{"env=ci", "query=a=b"})
+	if err != nil {
+		t.Fatalf("parseMessageAttributes() error = %v", err)
+	}
+	want := map[string]string{"env": "ci", "query": "a=b"}
+	if len(attrs) != len(want) {
+		t.Fatalf("parseMessageAttributes() = %v, want %v", attrs, want)
+	}
+	for key, value := range want {
+		attr := attrs[key]
+		if aws.ToString(attr.DataType) != "String" || aws.ToString(attr.StringValue) != value {
+			t.Errorf("attribute %s = %s %q, want String %q", key, aws.ToString(attr.DataType), aws.ToString(attr.StringValue), value)
+		}
+	}
+
+	if attrs, err := parseMessageAttributes(nil); attrs != nil || err != nil {
+		t.Errorf("parseMessageAttributes(nil) = %v, %v, want nil", attrs, err)
+	}
+
+	eleven := make([]string, 11)
+	for i := range eleven {
+		eleven[i] = "key" + strconv.Itoa(i) + "=v"
+	}
+	for _, pairs := range [][]string{{"env"}, {"=ci"}, {"env="}, {"env=ci", "env=prod"}, eleven} {
+		if _, err := parseMessageAttributes(pairs); err == nil {
+			t.Errorf("parseMessageAttributes(%q) succeeded, want an error", pairs)
+		}
+	}
+}
+
+func TestValidateFIFOOptions(t *testing.T) {
+	const standard, fifo = "https://sqs.example/123/jobs", "https://sqs.example/123/jobs.fifo"
+	for _, tt := range []struct {
+		queueURL, groupID, dedupID string
+		ok                         bool
+	}{
+		{standard, "", "", true},
+		{standard, "group", "", false},
+		{standard, "", "dedup", false},
+		{fifo, "group", "", true},
+		{fifo, "group", "dedup", true},
+		{fifo, "", "", false},
+		{fifo, "", "dedup", false},
+	} {
+		if err := validateFIFOOptions(tt.queueURL, tt.groupID, tt.dedupID); (err == nil) != tt.ok {
+			t.Errorf("validateFIFOOptions(%q, %q, %q) = %v, want ok %v", tt.queueURL, tt.groupID, tt.dedupID, err, tt.ok)
+		}
+	}
+}
+
+// fakeLogEvents serves FilterLogEvents from pages of messages, following
+// NextToken, and records the requests
+type fakeLogEvents struct {
+	pages    [][]string
+	requests []*cloudwatchlogs.FilterLogEventsInput
+}
+
+func (f *fakeLogEvents) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	f.requests = append(f.requests, params)
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(*params.NextToken)
+	}
+
+	out := &cloudwatchlogs.FilterLogEventsOutput{}
+	for i, msg := range f.pages[page] {
+		out.Events = append(out.Events, logtypes.FilteredLogEvent{
+			Message:   aws.String(msg),
+			Timestamp: aws.Int64(int64(1000*(page+1) + i)),
+		})
+	}
+	if page+1 < len(f.pages) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+func TestCheckJobInLogsPaginates(t *testing.T) {
+	client := &fakeLogEvents{pages: [][]string{
+		{`{"level":"info","msg":"Processing message","job_id":"job-1"}`},
+		{`{"level":"info","msg":"Job result","job_id":"job-10","status":"success"}`},
+		{`{"level":"info","msg":"Job result","job_id":"job-1","status":"success"}`},
+	}}
+	since := time.UnixMilli(120_000)
+
+	processedAt, ok := checkJobInLogs(context.Background(), client, "/ecs/worker", "job-1", since)
+	if !ok {
+		t.Fatal("checkJobInLogs() did not find the success entry on the last page")
+	}
+	if want := time.UnixMilli(3000); !processedAt.Equal(want) {
+		t.Errorf("processedAt = %v, want %v", processedAt, want)
+	}
+
+	if len(client.requests) != 3 {
+		t.Fatalf("FilterLogEvents called %d times, want 3 pages", len(client.requests))
+	}
+	first := client.requests[0]
+	if aws.ToString(first.FilterPattern) != `"job-1"` || aws.ToString(first.LogGroupName) != "/ecs/worker" {
+		t.Errorf("filter = %q in %q, want the quoted job ID in the log group", aws.ToString(first.FilterPattern), aws.ToString(first.LogGroupName))
+	}
+	if aws.ToInt64(first.StartTime) != 60_000 {
+		t.Errorf("start time = %d, want a minute before since", aws.ToInt64(first.StartTime))
+	}
+}
+
+func TestCheckJobInLogsNotFound(t *testing.T) {
+	client := &fakeLogEvents{pages: [][]string{
+		{`{"level":"info","msg":"Job result","job_id":"job-1","status":"error"}`},
+		{`not json mentioning job-1`},
+	}}
+
+	if _, ok := checkJobInLogs(context.Background(), client, "/ecs/worker", "job-1", time.Now()); ok {
+		t.Error("checkJobInLogs() found a success entry among failures")
+	}
+	if len(client.requests) != 2 {
+		t.Errorf("FilterLogEvents called %d times, want every page read", len(client.requests))
+	}
+}
+
+func TestJobSuccessTime(t *testing.T) {
+	event := func(ms int64, msg string) logtypes.FilteredLogEvent {
+		return logtypes.FilteredLogEvent{Timestamp: aws.Int64(ms), Message: aws.String(msg)}
+	}
+	events := []logtypes.FilteredLogEvent{
+		event(1000, `{"msg":"Processing message","job_id":"job-1"}`),
+		event(2500, `{"msg":"Job result","job_id":"job-1","status":"success"}`),
+		event(1800, `{"msg":"Job result","job_id":"job-1","status":"success"}`), // out of order
+		event(1200, `{"msg":"Job result","job_id":"job-2","status":"success"}`),
+		event(1100, `{"msg":"Job result","job_id":"job-1","status":"error"}`),
+		{Message: aws.String(`{"msg":"Job result","job_id":"job-1","status":"success"}`)}, // no timestamp
+	}
+
+	processedAt, ok := jobSuccessTime(events, "job-1")
+	if !ok || !processedAt.Equal(time.UnixMilli(1800)) {
+		t.Errorf("jobSuccessTime() = %v, %v, want the earliest success at 1800ms", processedAt, ok)
+	}
+	if _, ok := jobSuccessTime(events[:1], "job-1"); ok {
+		t.Error("jobSuccessTime() found a success without a success entry")
+	}
+
+	sentAt := time.UnixMilli(1000)
+	if got := endToEndLatency(sentAt, processedAt); got != 800*time.Millisecond {
+		t.Errorf("endToEndLatency() = %v, want 800ms", got)
+	}
+	// Clock skew can put the success entry before the send
+	if got := endToEndLatency(sentAt, time.UnixMilli(900)); got != 0 {
+		t.Errorf("endToEndLatency() with skew = %v, want 0", got)
+	}
+}
+
+func TestCheckMaxLatency(t *testing.T) {
+	for _, tt := range []struct {
+		latency, max time.Duration
+		ok           bool
+	}{
+		{5 * time.Second, 0, true},
+		{5 * time.Second, 10 * time.Second, true},
+		{10 * time.Second, 10 * time.Second, true},
+		{11 * time.Second, 10 * time.Second, false},
+	} {
+		if err := checkMaxLatency(tt.latency, tt.max); (err == nil) != tt.ok {
+			t.Errorf("checkMaxLatency(%v, %v) = %v, want ok %v", tt.latency, tt.max, err, tt.ok)
+		}
+	}
+}
+
+// fakeQueue fails PurgeQueue with purgeErr if set, and reports the queued
+// message counts from GetQueueAttributes, the last one repeatedly
+type fakeQueue struct {
+	purgeErr error
+	purges   int
+	counts   []string
+	gets     int
+}
+
+func (f *fakeQueue) PurgeQueue(ctx context.Context, params *sqs.PurgeQueueInput, optFns ...func(*sqs.Options)) (*sqs.PurgeQueueOutput, error) {
+	f.purges++
+	if f.purgeErr != nil {
+		return nil, f.purgeErr
+	}
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+func (f *fakeQueue) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	count := f.counts[min(f.gets, len(f.counts)-1)]
+	f.gets++
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{
+		string(types.QueueAttributeNameApproximateNumberOfMessages): count,
+	}}, nil
+}
+
+func TestPurgeQueueCooldown(t *testing.T) {
+	client := &fakeQueue{purgeErr: &types.PurgeQueueInProgress{Message: aws.String("only one purge per 60 seconds")}}
+
+	err := purgeQueue(context.Background(), client, "https://sqs.example/queue", time.Minute)
+	var inProgress *types.PurgeQueueInProgress
+	if !errors.As(err, &inProgress) || !strings.Contains(err.Error(), "cooldown") {
+		t.Fatalf("purgeQueue() error = %v, want a cooldown error wrapping PurgeQueueInProgress", err)
+	}
+	if client.purges != 1 || client.gets != 0 {
+		t.Errorf("purged %d times and checked the queue %d times, want 1 and 0", client.purges, client.gets)
+	}
+
+	// Other purge errors are returned as they are
+	client = &fakeQueue{purgeErr: &types.QueueDoesNotExist{Message: aws.String("no such queue")}}
+	if err := purgeQueue(context.Background(), client, "https://sqs.example/queue", time.Minute); err == nil || strings.Contains(err.Error(), "cooldown") {
+		t.Errorf("purgeQueue() error = %v, want the error without the cooldown hint", err)
+	}
+}
+
+func TestPurgeQueueWaitsUntilEmpty(t *testing.T) {
+	client := &fakeQueue{counts: []string{"0"}}
+	if err := purgeQueue(context.Background(), client, "https://sqs.example/queue", time.Minute); err != nil {
+		t.Fatalf("purgeQueue() error = %v", err)
+	}
+	if client.purges != 1 || client.gets != 1 {
+		t.Errorf("purged %d times and checked the queue %d times, want 1 each", client.purges, client.gets)
+	}
+
+	// A queue that never empties fails once the timeout is up
+	client = &fakeQueue{counts: []string{"3"}}
+	if err := waitForQueueDrained(context.Background(), client, "https://sqs.example/queue", 0); err == nil || !strings.Contains(err.Error(), "3 messages") {
+		t.Errorf("waitForQueueDrained() error = %v, want the remaining message count", err)
+	}
+}
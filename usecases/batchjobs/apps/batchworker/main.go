@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/example/hello-fargate-app/inputschema"
+	"github.com/example/hello-fargate-app/jsonlog"
 )
 
 // JobInput represents the input JSON structure
 type JobInput struct {
-	Message string                 `json:"message"`
-	Items   []string               `json:"items,omitempty"`
-	Data    map[string]interface{} `json:"data,omitempty"`
+	Message  string                 `json:"message"`
+	Items    []string               `json:"items,omitempty"`
+	S3Bucket string                 `json:"s3Bucket,omitempty"` // When set, Items are keys of objects in this bucket
+	Data     map[string]interface{} `json:"data,omitempty"`
 }
 
 // JobOutput represents the output JSON structure
@@ -20,61 +31,155 @@ type JobOutput struct {
 	Message    string                 `json:"message"`
 	ArrayIndex string                 `json:"arrayIndex,omitempty"`
 	JobID      string                 `json:"jobId,omitempty"`
+	Item       string                 `json:"item,omitempty"`
+	Bytes      int64                  `json:"bytes,omitempty"`
+	Checksum   string                 `json:"checksum,omitempty"` // SHA-256 of the processed item, with COMPUTE_CHECKSUM=true
 	Input      map[string]interface{} `json:"input,omitempty"`
 }
 
-func main() {
-	log.Println("AWS Batch job started.")
+// ManifestEntry records what one index produced, so that a downstream step
+// can check that every index completed with the expected hashes
+type ManifestEntry struct {
+	ArrayIndex     string `json:"arrayIndex,omitempty"`
+	Status         string `json:"status"`
+	Item           string `json:"item,omitempty"`
+	Checksum       string `json:"checksum"`       // SHA-256 of the processed item
+	ResultChecksum string `json:"resultChecksum"` // SHA-256 of the result JSON
+}
 
+func main() {
 	// Get array job index (auto-set by AWS Batch for array jobs)
 	// Empty string for non-array jobs
 	arrayIndex := os.Getenv("AWS_BATCH_JOB_ARRAY_INDEX")
 	jobID := os.Getenv("AWS_BATCH_JOB_ID")
 
+	logJSON("info", jobID, "AWS Batch job started", nil)
+
 	if arrayIndex != "" {
-		log.Printf("Running as array job index: %s\n", arrayIndex)
+		logJSON("info", jobID, "Running as array job", logFields{"array_index": arrayIndex})
 	} else {
-		log.Println("Running as single job (not an array job)")
+		logJSON("info", jobID, "Running as single job (not an array job)", nil)
 	}
 
 	// Get job input from environment variable
 	inputJSONString := os.Getenv("JOB_INPUT")
 	if inputJSONString == "" {
 		inputJSONString = "{}"
-		log.Println("No JOB_INPUT provided, using empty object.")
+		logJSON("info", jobID, "No JOB_INPUT provided, using empty object", nil)
+	}
+
+	// Optionally reject input that does not match a JSON Schema
+	if schemaPath := os.Getenv("INPUT_SCHEMA_PATH"); schemaPath != "" {
+		if err := inputschema.Validate(schemaPath, inputJSONString); err != nil {
+			logFatal(jobID, "JOB_INPUT does not match schema", logFields{"schema": schemaPath, "error": err.Error()})
+		}
+		logJSON("info", jobID, "JOB_INPUT matches schema", logFields{"schema": schemaPath})
 	}
 
 	// Parse the input JSON
 	var jobInput JobInput
 	if err := json.Unmarshal([]byte(inputJSONString), &jobInput); err != nil {
-		log.Printf("Warning: Failed to parse JOB_INPUT as structured input: %v\n", err)
+		logJSON("warn", jobID, "Failed to parse JOB_INPUT as structured input", logFields{"error": err.Error()})
 		// Try to parse as generic map
 		var genericInput map[string]interface{}
 		if err := json.Unmarshal([]byte(inputJSONString), &genericInput); err != nil {
-			log.Fatalf("Error: Failed to parse JOB_INPUT: %v\n", err)
+			logFatal(jobID, "Failed to parse JOB_INPUT", logFields{"error": err.Error()})
 		}
 		jobInput.Data = genericInput
 	}
 
-	log.Printf("Received input: %+v\n", jobInput)
+	logJSON("info", jobID, "Received input", logFields{"input": jobInput})
+
+	ctx := context.Background()
+
+	// Items are downloaded from S3 only when the input names a bucket
+	var s3Client S3API
+	if jobInput.S3Bucket != "" {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logFatal(jobID, "Failed to load AWS SDK config", logFields{"error": err.Error()})
+		}
+		s3Client = s3.NewFromConfig(cfg)
+	}
+
+	computeChecksum := os.Getenv("COMPUTE_CHECKSUM") == "true"
 
 	// Process the input based on array index
-	output := processJob(arrayIndex, jobID, jobInput)
+	output, err := processJob(ctx, s3Client, arrayIndex, jobID, jobInput, computeChecksum)
+
+	// Output the result as a single JSON line
+	logJSON("info", jobID, "Job output", logFields{"status": output.Status, "output": output})
+
+	result, marshalErr := json.MarshalIndent(output, "", "  ")
+	if marshalErr != nil {
+		logFatal(jobID, "Failed to marshal result", logFields{"error": marshalErr.Error()})
+	}
+
+	// Optionally persist the result of this index for downstream consumers
+	outputDir := os.Getenv("OUTPUT_DIR")
+	if outputDir != "" {
+		path, writeErr := writeOutputFile(outputDir, "result", arrayIndex, result)
+		if writeErr != nil {
+			logFatal(jobID, "Failed to write result", logFields{"path": path, "error": writeErr.Error()})
+		}
+		logJSON("info", jobID, "Wrote result", logFields{"path": path})
+	}
+
+	if computeChecksum {
+		entry := newManifestEntry(output, result)
+		logJSON("info", jobID, "Manifest entry", logFields{"manifest": entry})
+
+		if outputDir != "" {
+			data, marshalErr := json.MarshalIndent(entry, "", "  ")
+			if marshalErr != nil {
+				logFatal(jobID, "Failed to marshal manifest entry", logFields{"error": marshalErr.Error()})
+			}
+			path, writeErr := writeOutputFile(outputDir, "manifest", arrayIndex, data)
+			if writeErr != nil {
+				logFatal(jobID, "Failed to write manifest entry", logFields{"path": path, "error": writeErr.Error()})
+			}
+			logJSON("info", jobID, "Wrote manifest entry", logFields{"path": path})
+		}
+	}
 
-	// Output the result as JSON
-	outputBytes, err := json.MarshalIndent(output, "", "  ")
+	// A failed index exits nonzero so that Batch marks the child FAILED
 	if err != nil {
-		log.Fatalf("Error: Failed to marshal output: %v\n", err)
+		logFatal(jobID, "AWS Batch job failed", logFields{"error": err.Error()})
 	}
 
-	fmt.Println("--- Job Output ---")
-	fmt.Println(string(outputBytes))
-	fmt.Println("------------------")
+	logJSON("info", jobID, "AWS Batch job completed successfully", nil)
+}
+
+// logFields holds the extra fields of a structured log entry
+type logFields = jsonlog.Fields
+
+// jsonLogger writes one JSON object per line, also from concurrent goroutines
+var jsonLogger = jsonlog.New(os.Stderr)
+
+// logJSON writes a log entry with the level, message, job ID (omitted when
+// empty), timestamp and any extra fields
+func logJSON(level, jobID, msg string, fields logFields) {
+	jsonLogger.Log(level, jobID, msg, fields)
+}
+
+// logFatal logs an error entry and exits
+func logFatal(jobID, msg string, fields logFields) {
+	logJSON("error", jobID, msg, fields)
+	os.Exit(1)
+}
 
-	log.Println("AWS Batch job completed successfully.")
+// S3API is the subset of the S3 client used to download items, so that tests
+// can substitute a fake
+type S3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 }
 
-func processJob(arrayIndex, jobID string, input JobInput) JobOutput {
+// processJob processes the item selected by the array index, or echoes the
+// message when there are no items. The returned output has status "error"
+// when err is not nil. With computeChecksum, the output carries the SHA-256 of
+// the processed item: the downloaded object for S3 items, the item itself
+// otherwise, and the message for jobs without items.
+func processJob(ctx context.Context, s3Client S3API, arrayIndex, jobID string, input JobInput, computeChecksum bool) (JobOutput, error) {
 	output := JobOutput{
 		Status:     "success",
 		ArrayIndex: arrayIndex,
@@ -84,20 +189,110 @@ func processJob(arrayIndex, jobID string, input JobInput) JobOutput {
 
 	// For array jobs, process the item at the given index
 	if arrayIndex != "" && len(input.Items) > 0 {
-		// Parse array index
-		var idx int
-		fmt.Sscanf(arrayIndex, "%d", &idx)
+		idx, err := itemIndex(arrayIndex)
+		if err != nil {
+			output.Status = "error"
+			output.Message = err.Error()
+			return output, err
+		}
 
-		if idx < len(input.Items) {
-			output.Message = fmt.Sprintf("Processed item[%d]: %s", idx, input.Items[idx])
-		} else {
+		if idx >= len(input.Items) {
 			output.Message = fmt.Sprintf("Array index %d out of range (items: %d)", idx, len(input.Items))
+			return output, nil
+		}
+
+		output.Item = input.Items[idx]
+		if input.S3Bucket == "" {
+			output.Message = fmt.Sprintf("Processed item[%d]: %s", idx, output.Item)
+			if computeChecksum {
+				output.Checksum = checksum([]byte(output.Item))
+			}
+			return output, nil
+		}
+
+		n, sum, err := downloadItem(ctx, s3Client, input.S3Bucket, output.Item)
+		if err != nil {
+			output.Status = "error"
+			output.Message = fmt.Sprintf("Failed to process item[%d] s3://%s/%s: %v", idx, input.S3Bucket, output.Item, err)
+			return output, err
+		}
+		output.Bytes = n
+		output.Message = fmt.Sprintf("Processed item[%d]: s3://%s/%s (%d bytes)", idx, input.S3Bucket, output.Item, n)
+		if computeChecksum {
+			output.Checksum = sum
 		}
-	} else if input.Message != "" {
+		return output, nil
+	}
+
+	if input.Message != "" {
 		output.Message = fmt.Sprintf("Processed: %s (index: %s)", input.Message, arrayIndex)
 	} else {
 		output.Message = fmt.Sprintf("Processed successfully (array index: %s)", arrayIndex)
 	}
+	if computeChecksum {
+		output.Checksum = checksum([]byte(input.Message))
+	}
+
+	return output, nil
+}
+
+// checksum returns the hex-encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newManifestEntry describes output, whose JSON encoding is result
+func newManifestEntry(output JobOutput, result []byte) ManifestEntry {
+	return ManifestEntry{
+		ArrayIndex:     output.ArrayIndex,
+		Status:         output.Status,
+		Item:           output.Item,
+		Checksum:       output.Checksum,
+		ResultChecksum: checksum(result),
+	}
+}
+
+// itemIndex parses the AWS_BATCH_JOB_ARRAY_INDEX value
+func itemIndex(arrayIndex string) (int, error) {
+	idx, err := strconv.Atoi(arrayIndex)
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("invalid array index %q", arrayIndex)
+	}
+	return idx, nil
+}
 
-	return output
+// downloadItem reads the object at bucket/key and returns its size in bytes
+// and hex-encoded SHA-256
+func downloadItem(ctx context.Context, client S3API, bucket, key string) (int64, string, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	defer out.Body.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, out.Body)
+	if err != nil {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeOutputFile writes data to <prefix>-<index>.json in dir, or to
+// <prefix>.json for a job that is not an array job, and returns the path
+func writeOutputFile(dir, prefix, arrayIndex string, data []byte) (string, error) {
+	name := prefix + ".json"
+	if arrayIndex != "" {
+		name = fmt.Sprintf("%s-%s.json", prefix, arrayIndex)
+	}
+	path := filepath.Join(dir, name)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return path, err
+	}
+	return path, os.WriteFile(path, data, 0o644)
 }
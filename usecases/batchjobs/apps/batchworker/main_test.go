@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/example/hello-fargate-app/inputschema"
+)
+
+func TestShippedInputSchema(t *testing.T) {
+	if err := inputschema.Validate("input.schema.json", `{"message":"hello","items":["a","b"]}`); err != nil {
+		t.Errorf("valid JOB_INPUT rejected: %v", err)
+	}
+	for _, input := range []string{`{}`, `{"message":42}`, `{"message":"hello","items":[1]}`} {
+		if err := inputschema.Validate("input.schema.json", input); err == nil {
+			t.Errorf("JOB_INPUT %s accepted, want a schema violation", input)
+		}
+	}
+}
+
+// fakeS3 serves objects from a map of key to content; missing keys fail
+type fakeS3 struct {
+	objects map[string]string
+	gets    []string
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key)
+	f.gets = append(f.gets, key)
+	content, ok := f.objects[key]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(content))}, nil
+}
+
+func TestProcessJobSelectsItemByIndex(t *testing.T) {
+	input := JobInput{Message: "hello", Items: []string{"a", "b", "c"}}
+	for _, tt := range []struct {
+		arrayIndex  string
+		wantItem    string
+		wantMessage string
+	}{
+		{"0", "a", "Processed item[0]: a"},
+		{"2", "c", "Processed item[2]: c"},
+		// Indices past the items are not an error: there is nothing to do
+		{"5", "", "Array index 5 out of range (items: 3)"},
+		// A single job, or a job without items, echoes the message
+		{"", "", "Processed: hello (index: )"},
+	} {
+		output, err := processJob(context.Background(), nil, tt.arrayIndex, "job-1", input, false)
+		if err != nil || output.Status != "success" || output.Item != tt.wantItem || output.Message != tt.wantMessage {
+			t.Errorf("index %q: processJob() = %+v, %v, want item %q and message %q", tt.arrayIndex, output, err, tt.wantItem, tt.wantMessage)
+		}
+	}
+
+	output, err := processJob(context.Background(), nil, "1", "job-1", JobInput{Message: "hello"}, false)
+	if err != nil || output.Message != "Processed: hello (index: 1)" {
+		t.Errorf("no items: processJob() = %+v, %v, want the message echoed", output, err)
+	}
+
+	for _, index := range []string{"x", "-1"} {
+		output, err := processJob(context.Background(), nil, index, "job-1", input, false)
+		if err == nil || output.Status != "error" {
+			t.Errorf("index %q: processJob() = %+v, %v, want an error", index, output, err)
+		}
+	}
+}
+
+func TestProcessJobDownloadsS3Items(t *testing.T) {
+	client := &fakeS3{objects: map[string]string{"data/in/1.csv": "a,b,c\n"}}
+	input := JobInput{Items: []string{"in/0.csv", "in/1.csv", "in/missing.csv"}, S3Bucket: "data"}
+
+	output, err := processJob(context.Background(), client, "1", "job-1", input, false)
+	if err != nil || output.Status != "success" || output.Item != "in/1.csv" || output.Bytes != 6 {
+		t.Errorf("processJob() = %+v, %v, want in/1.csv processed with 6 bytes", output, err)
+	}
+	// Only the object of this index is downloaded
+	if len(client.gets) != 1 || client.gets[0] != "data/in/1.csv" {
+		t.Errorf("downloaded %v, want data/in/1.csv only", client.gets)
+	}
+
+	output, err = processJob(context.Background(), client, "2", "job-1", input, false)
+	if err == nil || output.Status != "error" || !strings.Contains(output.Message, "s3://data/in/missing.csv") {
+		t.Errorf("processJob() of a missing object = %+v, %v, want an error naming it", output, err)
+	}
+}
+
+func TestWriteOutputFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "results")
+	for _, tt := range []struct {
+		arrayIndex, wantName string
+	}{{"3", "result-3.json"}, {"", "result.json"}} {
+		path, err := writeOutputFile(dir, "result", tt.arrayIndex, []byte(`{"status":"success"}`))
+		if err != nil || path != filepath.Join(dir, tt.wantName) {
+			t.Errorf("writeOutputFile(index %q) = %q, %v, want %s", tt.arrayIndex, path, err, tt.wantName)
+			continue
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != `{"status":"success"}` {
+			t.Errorf("%s = %q, %v", tt.wantName, data, err)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// sha256sum of the empty string and of "abc"
+	for data, want := range map[string]string{
+		"":    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"abc": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	} {
+		if got := checksum([]byte(data)); got != want {
+			t.Errorf("checksum(%q) = %s, want %s", data, got, want)
+		}
+	}
+}
+
+func TestProcessJobChecksumOnlyWhenEnabled(t *testing.T) {
+	client := &fakeS3{objects: map[string]string{"data/in.csv": "abc"}}
+	for _, tt := range []struct {
+		name       string
+		arrayIndex string
+		input      JobInput
+		want       string
+	}{
+		{"item", "0", JobInput{Items: []string{"abc"}}, checksum([]byte("abc"))},
+		{"s3 item", "0", JobInput{Items: []string{"in.csv"}, S3Bucket: "data"}, checksum([]byte("abc"))},
+		{"message", "", JobInput{Message: "hello"}, checksum([]byte("hello"))},
+	} {
+		output, err := processJob(context.Background(), client, tt.arrayIndex, "job-1", tt.input, false)
+		if err != nil || output.Checksum != "" {
+			t.Errorf("%s without COMPUTE_CHECKSUM: checksum = %q, %v, want none", tt.name, output.Checksum, err)
+		}
+		data, _ := json.Marshal(output)
+		if strings.Contains(string(data), `"checksum"`) {
+			t.Errorf("%s without COMPUTE_CHECKSUM: output %s has a checksum field", tt.name, data)
+		}
+
+		output, err = processJob(context.Background(), client, tt.arrayIndex, "job-1", tt.input, true)
+		if err != nil || output.Checksum != tt.want {
+			t.Errorf("%s with COMPUTE_CHECKSUM: checksum = %q, %v, want %s", tt.name, output.Checksum, err, tt.want)
+		}
+	}
+}
+
+func TestNewManifestEntry(t *testing.T) {
+	output := JobOutput{Status: "success", ArrayIndex: "2", Item: "abc", Checksum: checksum([]byte("abc"))}
+	result := []byte(`{"status":"success"}`)
+
+	entry := newManifestEntry(output, result)
+	want := ManifestEntry{ArrayIndex: "2", Status: "success", Item: "abc", Checksum: output.Checksum, ResultChecksum: checksum(result)}
+	if entry != want {
+		t.Errorf("newManifestEntry() = %+v, want %+v", entry, want)
+	}
+}
@@ -93,7 +93,7 @@ resource "aws_batch_job_definition" "worker" {
     ]
 
     executionRoleArn = aws_iam_role.batch_execution_role.arn
-    # jobRoleArn = aws_iam_role.batch_job_role.arn  # Uncomment if app needs AWS API access
+    jobRoleArn       = aws_iam_role.batch_job_role.arn
 
     networkConfiguration = {
       assignPublicIp = "ENABLED"
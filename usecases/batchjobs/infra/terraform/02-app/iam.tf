@@ -28,46 +28,38 @@ resource "aws_iam_role_policy_attachment" "batch_execution_policy" {
   policy_arn = "arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy"
 }
 
-# Job Role (for app to access AWS APIs - optional)
-# Uncomment if your batch job needs to access S3, DynamoDB, etc.
-#
-# resource "aws_iam_role" "batch_job_role" {
-#   name = "hello-fargate-batchjobs-job-role"
-#
-#   assume_role_policy = jsonencode({
-#     Version = "2012-10-17"
-#     Statement = [{
-#       Action = "sts:AssumeRole"
-#       Effect = "Allow"
-#       Principal = {
-#         Service = "ecs-tasks.amazonaws.com"
-#       }
-#     }]
-#   })
-#
-#   tags = {
-#     Project = "hello-fargate-batchjobs"
-#   }
-# }
-#
-# # Example: S3 access policy for job role
-# resource "aws_iam_role_policy" "batch_job_s3_policy" {
-#   name = "hello-fargate-batchjobs-s3-policy"
-#   role = aws_iam_role.batch_job_role.id
-#
-#   policy = jsonencode({
-#     Version = "2012-10-17"
-#     Statement = [{
-#       Effect = "Allow"
-#       Action = [
-#         "s3:GetObject",
-#         "s3:PutObject",
-#         "s3:ListBucket"
-#       ]
-#       Resource = [
-#         "arn:aws:s3:::your-bucket-name",
-#         "arn:aws:s3:::your-bucket-name/*"
-#       ]
-#     }]
-#   })
-# }
+# Job Role (for app to access AWS APIs)
+resource "aws_iam_role" "batch_job_role" {
+  name = "hello-fargate-batchjobs-job-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "ecs-tasks.amazonaws.com"
+      }
+    }]
+  })
+
+  tags = {
+    Project = "hello-fargate-batchjobs"
+  }
+}
+
+# Lets the worker download the items of jobs whose input sets s3Bucket
+resource "aws_iam_role_policy" "batch_job_items" {
+  count = var.items_bucket_arn == "" ? 0 : 1
+  name  = "hello-fargate-batchjobs-items-read"
+  role  = aws_iam_role.batch_job_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+      Action   = ["s3:GetObject"]
+      Resource = ["${var.items_bucket_arn}/*"]
+    }]
+  })
+}
@@ -44,6 +44,12 @@ variable "job_memory" {
   default     = 512
 }
 
+variable "items_bucket_arn" {
+  description = "ARN of an S3 bucket the worker may download array items from (empty disables)"
+  type        = string
+  default     = ""
+}
+
 variable "security_group_ids" {
   description = "List of additional security group IDs"
   type        = list(string)
@@ -21,9 +21,10 @@ ECR_REPOSITORY_URI="$AWS_ACCOUNT_ID.dkr.ecr.$AWS_REGION.amazonaws.com/$IMAGE_NAM
 SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
 cd "$SCRIPT_DIR/../apps/batchworker"
 
-# Build the Docker image
+# Build the Docker image from the repository root, which holds the shared
+# lib/app module
 echo "Building Docker image..."
-docker build -t $IMAGE_NAME:$IMAGE_TAG .
+docker build -t $IMAGE_NAME:$IMAGE_TAG -f Dockerfile ../../../..
 
 # Authenticate Docker to ECR
 echo "Logging into ECR..."
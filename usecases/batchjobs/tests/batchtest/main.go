@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/batch"
 	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
-	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+
+	"github.com/example/hello-fargate-harness"
 )
 
 func main() {
@@ -25,6 +29,14 @@ func main() {
 	arraySize := flag.Int("array-size", 2, "Array job size (number of parallel jobs)")
 	logGroupName := flag.String("log-group", "/aws/batch/hello-fargate-batchjobs", "CloudWatch log group name")
 	timeout := flag.Duration("timeout", 5*time.Minute, "Timeout for job completion")
+	expectSucceeded := flag.Int("expect-succeeded", 0, "Number of array children that must reach SUCCEEDED (0 means all of -array-size)")
+	exitOnChildrenTerminal := flag.Bool("exit-on-children-terminal", false, "Stop waiting as soon as the status summary shows every array child SUCCEEDED or FAILED, even if the parent status lags behind")
+	childPollConcurrency := flag.Int("child-poll-concurrency", 0, "Describe array children with up to this many concurrent DescribeJobs calls on every poll to report per-child status (0 disables)")
+	prettyLogs := flag.Bool("pretty-logs", true, "Pretty-print JSON log messages; set to false to print them as-is")
+	maxRetries := flag.Int("max-retries", 2, "Maximum retries per AWS API call (throttling and transient errors)")
+	dependsOn := flag.String("depends-on", "", "ID of a job that must succeed before the array job starts")
+	sequential := flag.Bool("sequential", false, "Run the array children one after another (SEQUENTIAL dependency) instead of in parallel")
+	opTimeout := flag.Duration("op-timeout", 30*time.Second, "Timeout for each AWS API call, retries included (0 disables it)")
 	flag.Parse()
 
 	if *jobQueue == "" || *jobDefinition == "" {
@@ -32,11 +44,14 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *expectSucceeded <= 0 || *expectSucceeded > *arraySize {
+		*expectSucceeded = *arraySize
+	}
 
 	ctx := context.Background()
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := harness.LoadAWSConfig(ctx, *maxRetries, *opTimeout)
 	if err != nil {
 		log.Fatalf("Failed to load AWS SDK config: %v", err)
 	}
@@ -51,24 +66,23 @@ func main() {
 	fmt.Printf("  Job Definition: %s\n", *jobDefinition)
 	fmt.Printf("  Array Size: %d\n", *arraySize)
 	fmt.Printf("  Input: %s\n", *inputJSON)
-
-	submitJobInput := &batch.SubmitJobInput{
-		JobName:       &jobName,
-		JobQueue:      jobQueue,
-		JobDefinition: jobDefinition,
-		ArrayProperties: &batchtypes.ArrayProperties{
-			Size: aws.Int32(int32(*arraySize)),
-		},
-		ContainerOverrides: &batchtypes.ContainerOverrides{
-			Environment: []batchtypes.KeyValuePair{
-				{
-					Name:  aws.String("JOB_INPUT"),
-					Value: inputJSON,
-				},
-			},
-		},
+	if *dependsOn != "" {
+		fmt.Printf("  Depends On: %s\n", *dependsOn)
+	}
+	if *sequential {
+		fmt.Println("  Sequential: children run in index order")
 	}
 
+	submitJobInput := buildSubmitJobInput(submitOptions{
+		JobName:       jobName,
+		JobQueue:      *jobQueue,
+		JobDefinition: *jobDefinition,
+		ArraySize:     *arraySize,
+		InputJSON:     *inputJSON,
+		DependsOn:     *dependsOn,
+		Sequential:    *sequential,
+	})
+
 	submitOutput, err := batchClient.SubmitJob(ctx, submitJobInput)
 	if err != nil {
 		log.Fatalf("Failed to submit job: %v", err)
@@ -77,18 +91,24 @@ func main() {
 	jobID := *submitOutput.JobId
 	fmt.Printf("Job submitted: %s (ID: %s)\n", jobName, jobID)
 
+	// Stop the job when the test is aborted, so it does not keep consuming vCPUs
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go stopJobOnSignal(sigCh, &jobStopper{client: batchClient, jobID: jobID}, os.Exit)
+
 	// Wait for job to complete
 	fmt.Println("Waiting for array job to complete...")
 	startTime := time.Now()
 
 	var finalStatus batchtypes.JobStatus
 	var statusReason string
+	var statusSummary map[string]int32
 
 	for {
 		if time.Since(startTime) > *timeout {
 			fmt.Println("\n=== TIMEOUT DIAGNOSTICS ===")
 			printDiagnostics(ctx, batchClient, jobID, *jobQueue)
-			fmt.Println("===========================\n")
+			fmt.Println("===========================")
 			log.Fatalf("Timeout waiting for job to complete (waited %v)", *timeout)
 		}
 
@@ -112,6 +132,7 @@ func main() {
 		// Print array job progress
 		if job.ArrayProperties != nil {
 			summary := job.ArrayProperties.StatusSummary
+			statusSummary = summary
 			fmt.Printf("Job status: %s (PENDING:%d, RUNNABLE:%d, RUNNING:%d, SUCCEEDED:%d, FAILED:%d)\n",
 				finalStatus,
 				getStatusCount(summary, "PENDING"),
@@ -123,10 +144,23 @@ func main() {
 		} else {
 			fmt.Printf("Job status: %s\n", finalStatus)
 		}
+		printDependencyBlocked(os.Stdout, job, statusSummary, *sequential)
+
+		if *childPollConcurrency > 0 {
+			children, err := describeChildren(ctx, batchClient, jobID, *arraySize, *childPollConcurrency)
+			if err != nil {
+				fmt.Printf("Warning: Could not describe array children: %v\n", err)
+			} else {
+				printChildProgress(children)
+			}
+		}
 
 		// Check if job is in terminal state
-		if finalStatus == batchtypes.JobStatusSucceeded ||
-			finalStatus == batchtypes.JobStatusFailed {
+		if status, done := terminalStatus(finalStatus, statusSummary, *arraySize, *exitOnChildrenTerminal); done {
+			if status != finalStatus {
+				fmt.Printf("All %d array children are terminal; not waiting for the parent status to flip\n", *arraySize)
+				finalStatus = status
+			}
 			break
 		}
 
@@ -140,17 +174,316 @@ func main() {
 
 	// Fetch CloudWatch logs for all array job children
 	fmt.Println("\n--- CloudWatch Logs ---")
-	fetchLogs(ctx, cfg, *logGroupName, jobID, *arraySize)
+	fetchLogs(ctx, batchClient, cloudwatchlogs.NewFromConfig(cfg), *logGroupName, jobID, *prettyLogs)
 	fmt.Println("-----------------------")
 
+	// Summarize how each child ended, so failures can be told apart without
+	// digging through the logs
+	fmt.Println("\n--- Array Children ---")
+	var failedIndices []int
+	children, err := describeChildren(ctx, batchClient, jobID, *arraySize, max(*childPollConcurrency, 1))
+	if err != nil {
+		fmt.Printf("Warning: Could not describe array children: %v\n", err)
+	} else {
+		failedIndices = printChildSummary(os.Stdout, children)
+	}
+	fmt.Println("----------------------")
+
 	if finalStatus != batchtypes.JobStatusSucceeded {
 		fmt.Printf("Job failed with status: %s\n", finalStatus)
+		if len(failedIndices) > 0 {
+			fmt.Printf("Failed array indices: %v\n", failedIndices)
+		}
+		os.Exit(1)
+	}
+
+	// The parent job status alone does not guarantee every child succeeded,
+	// so check the children against the status summary as well
+	if err := verifyArrayChildren(statusSummary, *expectSucceeded, failedIndices); err != nil {
+		fmt.Printf("Array job children check failed: %v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("All array jobs completed successfully!")
 }
 
+// jobStopAPI is the subset of the Batch client used to stop a job
+type jobStopAPI interface {
+	DescribeJobs(ctx context.Context, params *batch.DescribeJobsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobsOutput, error)
+	CancelJob(ctx context.Context, params *batch.CancelJobInput, optFns ...func(*batch.Options)) (*batch.CancelJobOutput, error)
+	TerminateJob(ctx context.Context, params *batch.TerminateJobInput, optFns ...func(*batch.Options)) (*batch.TerminateJobOutput, error)
+}
+
+// jobStopper stops the submitted job at most once
+type jobStopper struct {
+	client jobStopAPI
+	jobID  string
+	once   sync.Once
+}
+
+// stop terminates the job and its array children, and also cancels it while
+// it has not started yet. Jobs that already finished are left alone, and
+// calls after the first do nothing.
+func (s *jobStopper) stop(ctx context.Context, reason string) {
+	s.once.Do(func() {
+		out, err := s.client.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: []string{s.jobID}})
+		if err != nil {
+			log.Printf("Failed to describe job %s before stopping it: %v", s.jobID, err)
+		} else if len(out.Jobs) > 0 {
+			switch status := out.Jobs[0].Status; status {
+			case batchtypes.JobStatusSucceeded, batchtypes.JobStatusFailed:
+				log.Printf("Job %s already %s; nothing to stop", s.jobID, status)
+				return
+			case batchtypes.JobStatusSubmitted, batchtypes.JobStatusPending, batchtypes.JobStatusRunnable:
+				if _, err := s.client.CancelJob(ctx, &batch.CancelJobInput{JobId: &s.jobID, Reason: &reason}); err != nil {
+					log.Printf("Failed to cancel job %s: %v", s.jobID, err)
+				} else {
+					log.Printf("Cancelled job %s (was %s)", s.jobID, status)
+				}
+			}
+		}
+
+		if _, err := s.client.TerminateJob(ctx, &batch.TerminateJobInput{JobId: &s.jobID, Reason: &reason}); err != nil {
+			log.Printf("Failed to terminate job %s: %v", s.jobID, err)
+			return
+		}
+		log.Printf("Terminated job %s", s.jobID)
+	})
+}
+
+// stopJobOnSignal waits for a signal on sigCh, stops the job and exits
+func stopJobOnSignal(sigCh <-chan os.Signal, stopper *jobStopper, exit func(int)) {
+	sig := <-sigCh
+	log.Printf("Received %v; stopping job %s", sig, stopper.jobID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	stopper.stop(ctx, fmt.Sprintf("batchtest aborted by %v", sig))
+	cancel()
+	exit(1)
+}
+
+// submitOptions describes the array job submitted by the test runner
+type submitOptions struct {
+	JobName       string
+	JobQueue      string
+	JobDefinition string
+	ArraySize     int
+	InputJSON     string
+	DependsOn     string // Job that must succeed first; empty for none
+	Sequential    bool   // Children run one after another in index order
+}
+
+// buildSubmitJobInput builds the SubmitJob request for opts
+func buildSubmitJobInput(opts submitOptions) *batch.SubmitJobInput {
+	input := &batch.SubmitJobInput{
+		JobName:       aws.String(opts.JobName),
+		JobQueue:      aws.String(opts.JobQueue),
+		JobDefinition: aws.String(opts.JobDefinition),
+		ArrayProperties: &batchtypes.ArrayProperties{
+			Size: aws.Int32(int32(opts.ArraySize)),
+		},
+		ContainerOverrides: &batchtypes.ContainerOverrides{
+			Environment: []batchtypes.KeyValuePair{
+				{
+					Name:  aws.String("JOB_INPUT"),
+					Value: aws.String(opts.InputJSON),
+				},
+			},
+		},
+	}
+
+	if opts.DependsOn != "" {
+		input.DependsOn = append(input.DependsOn, batchtypes.JobDependency{
+			JobId: aws.String(opts.DependsOn),
+		})
+	}
+	// A SEQUENTIAL dependency without a job ID makes each child of the array
+	// job depend on the previous index
+	if opts.Sequential {
+		input.DependsOn = append(input.DependsOn, batchtypes.JobDependency{
+			Type: batchtypes.ArrayJobDependencySequential,
+		})
+	}
+	return input
+}
+
+// printDependencyBlocked reports work that is PENDING because it waits on a
+// dependency: the parent job waiting on the jobs it depends on, and with
+// sequential children, the children waiting on their predecessors
+func printDependencyBlocked(w io.Writer, job batchtypes.JobDetail, summary map[string]int32, sequential bool) {
+	if job.Status == batchtypes.JobStatusPending {
+		var ids []string
+		for _, dep := range job.DependsOn {
+			if dep.JobId != nil {
+				ids = append(ids, *dep.JobId)
+			}
+		}
+		if len(ids) > 0 {
+			fmt.Fprintf(w, "  Blocked: waiting on jobs %v\n", ids)
+		}
+	}
+
+	if sequential {
+		if pending := getStatusCount(summary, "PENDING"); pending > 0 {
+			fmt.Fprintf(w, "  Blocked: %d children waiting on the previous index (SEQUENTIAL)\n", pending)
+		}
+	}
+}
+
+// verifyArrayChildren checks that at least expectSucceeded array children
+// reached SUCCEEDED, naming the failed indices when too few did
+func verifyArrayChildren(summary map[string]int32, expectSucceeded int, failedIndices []int) error {
+	succeeded := getStatusCount(summary, "SUCCEEDED")
+	failed := getStatusCount(summary, "FAILED")
+	fmt.Printf("Array children: %d SUCCEEDED, %d FAILED (expected at least %d SUCCEEDED)\n", succeeded, failed, expectSucceeded)
+
+	if int(succeeded) < expectSucceeded {
+		if len(failedIndices) > 0 {
+			return fmt.Errorf("only %d of the expected %d array children SUCCEEDED; failed indices: %v", succeeded, expectSucceeded, failedIndices)
+		}
+		return fmt.Errorf("only %d of the expected %d array children SUCCEEDED", succeeded, expectSucceeded)
+	}
+	return nil
+}
+
+// describeChildrenBatchSize is the maximum number of jobs per DescribeJobs call
+const describeChildrenBatchSize = 100
+
+// describeChildren returns the details of each child of an array job by array
+// index. Children are described in batches of up to 100, with at most
+// concurrency DescribeJobs calls in flight.
+func describeChildren(ctx context.Context, batchClient describeJobsAPI, jobID string, arraySize, concurrency int) (map[int]batchtypes.JobDetail, error) {
+	children := make(map[int]batchtypes.JobDetail, arraySize)
+	var mu sync.Mutex
+	var firstErr error
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for start := 0; start < arraySize; start += describeChildrenBatchSize {
+		end := start + describeChildrenBatchSize
+		if end > arraySize {
+			end = arraySize
+		}
+
+		ids := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			ids = append(ids, fmt.Sprintf("%s:%d", jobID, i))
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			out, err := batchClient.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: ids})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, child := range out.Jobs {
+				if child.ArrayProperties != nil && child.ArrayProperties.Index != nil {
+					children[int(*child.ArrayProperties.Index)] = child
+				}
+			}
+		}(ids)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return children, nil
+}
+
+// printChildProgress prints the array indices of the children in each status
+func printChildProgress(children map[int]batchtypes.JobDetail) {
+	byStatus := make(map[batchtypes.JobStatus][]int)
+	for index, child := range children {
+		byStatus[child.Status] = append(byStatus[child.Status], index)
+	}
+
+	var statuses []string
+	for status := range byStatus {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+
+	for _, status := range statuses {
+		indices := byStatus[batchtypes.JobStatus(status)]
+		sort.Ints(indices)
+		fmt.Printf("  %s: %v\n", status, indices)
+	}
+}
+
+// printChildSummary writes a table of each child's array index, status, exit
+// code and reason, and returns the indices of the FAILED children
+func printChildSummary(w io.Writer, children map[int]batchtypes.JobDetail) []int {
+	indices := make([]int, 0, len(children))
+	for index := range children {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tSTATUS\tEXIT CODE\tREASON")
+	var failed []int
+	for _, index := range indices {
+		child := children[index]
+		if child.Status == batchtypes.JobStatusFailed {
+			failed = append(failed, index)
+		}
+
+		exitCode := "-"
+		reason := aws.ToString(child.StatusReason)
+		if c := child.Container; c != nil {
+			if c.ExitCode != nil {
+				exitCode = fmt.Sprintf("%d", *c.ExitCode)
+			}
+			if r := aws.ToString(c.Reason); r != "" {
+				if reason != "" {
+					reason += ": "
+				}
+				reason += r
+			}
+		}
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", index, child.Status, exitCode, reason)
+	}
+	tw.Flush()
+	return failed
+}
+
+// terminalStatus reports whether the job is done and with which status. The
+// parent status can lag behind its children, so with exitOnChildrenTerminal
+// an array job is also done once the status summary shows every child
+// SUCCEEDED or FAILED; it then failed if any child did.
+func terminalStatus(status batchtypes.JobStatus, summary map[string]int32, arraySize int, exitOnChildrenTerminal bool) (batchtypes.JobStatus, bool) {
+	if status == batchtypes.JobStatusSucceeded || status == batchtypes.JobStatusFailed {
+		return status, true
+	}
+	if exitOnChildrenTerminal && arraySize > 0 && allChildrenTerminal(summary, arraySize) {
+		if getStatusCount(summary, "FAILED") > 0 {
+			return batchtypes.JobStatusFailed, true
+		}
+		return batchtypes.JobStatusSucceeded, true
+	}
+	return status, false
+}
+
+// allChildrenTerminal reports whether the status summary accounts for all
+// arraySize children as SUCCEEDED or FAILED
+func allChildrenTerminal(summary map[string]int32, arraySize int) bool {
+	return int(getStatusCount(summary, "SUCCEEDED")+getStatusCount(summary, "FAILED")) == arraySize
+}
+
 func getStatusCount(summary map[string]int32, status string) int32 {
 	if summary == nil {
 		return 0
@@ -158,54 +491,125 @@ func getStatusCount(summary map[string]int32, status string) int32 {
 	return summary[status]
 }
 
-func fetchLogs(ctx context.Context, cfg aws.Config, logGroupName, jobID string, arraySize int) {
-	logsClient := cloudwatchlogs.NewFromConfig(cfg)
+// describeJobsAPI is the subset of the Batch client used to describe jobs
+type describeJobsAPI interface {
+	DescribeJobs(ctx context.Context, params *batch.DescribeJobsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobsOutput, error)
+}
 
-	// For array jobs, logs are organized by array index
-	// Log stream pattern: batch/<job-definition-name>/default/<job-id>:<array-index>
-	// or: batch/<container-name>/<job-id>
+// batchJobsAPI is the subset of the Batch client used to find the children of an array job
+type batchJobsAPI interface {
+	batch.ListJobsAPIClient
+	describeJobsAPI
+}
 
-	// List all log streams that might contain our job's logs
-	listStreamsOutput, err := logsClient.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
-		LogGroupName: &logGroupName,
-		OrderBy:      "LastEventTime",
-		Descending:   aws.Bool(true),
-		Limit:        aws.Int32(50),
-	})
-	if err != nil {
-		fmt.Printf("Warning: Could not list log streams: %v\n", err)
-		return
+// logEventsAPI is the subset of the CloudWatch Logs client used to read log streams
+type logEventsAPI interface {
+	GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+// childLogStream is the log stream of one array child
+type childLogStream struct {
+	Index  int32
+	JobID  string
+	Stream string // Empty if the child has no log stream (e.g. it never started)
+}
+
+// listChildLogStreams finds the log stream of each terminal child of an array
+// job, ordered by array index. The children are listed with ListJobs and then
+// described to read the log stream name Batch assigned to their container.
+func listChildLogStreams(ctx context.Context, client batchJobsAPI, jobID string) ([]childLogStream, error) {
+	var children []childLogStream
+	for _, status := range []batchtypes.JobStatus{batchtypes.JobStatusSucceeded, batchtypes.JobStatusFailed} {
+		paginator := batch.NewListJobsPaginator(client, &batch.ListJobsInput{
+			ArrayJobId: &jobID,
+			JobStatus:  status,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s children: %w", status, err)
+			}
+			for _, jobSummary := range page.JobSummaryList {
+				if jobSummary.ArrayProperties == nil || jobSummary.ArrayProperties.Index == nil {
+					continue
+				}
+				children = append(children, childLogStream{
+					Index: *jobSummary.ArrayProperties.Index,
+					JobID: aws.ToString(jobSummary.JobId),
+				})
+			}
+		}
 	}
 
-	if len(listStreamsOutput.LogStreams) == 0 {
-		fmt.Println("No log streams found")
-		return
+	byJobID := make(map[string]int, len(children))
+	for i, child := range children {
+		byJobID[child.JobID] = i
 	}
+	for start := 0; start < len(children); start += describeChildrenBatchSize {
+		end := start + describeChildrenBatchSize
+		if end > len(children) {
+			end = len(children)
+		}
+
+		ids := make([]string, 0, end-start)
+		for _, child := range children[start:end] {
+			ids = append(ids, child.JobID)
+		}
 
-	// Find log streams related to our job
-	var relevantStreams []string
-	for _, stream := range listStreamsOutput.LogStreams {
-		streamName := *stream.LogStreamName
-		// Check if this stream is related to our job (contains job ID or array index pattern)
-		if strings.Contains(streamName, jobID) || isRecentStream(stream) {
-			relevantStreams = append(relevantStreams, streamName)
+		out, err := client.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: ids})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe children: %w", err)
+		}
+		for _, job := range out.Jobs {
+			i, ok := byJobID[aws.ToString(job.JobId)]
+			if !ok {
+				continue
+			}
+			children[i].Stream = jobLogStream(job)
 		}
 	}
 
-	// If no streams match job ID, take the most recent ones (likely our job's logs)
-	if len(relevantStreams) == 0 && len(listStreamsOutput.LogStreams) > 0 {
-		for i := 0; i < min(arraySize, len(listStreamsOutput.LogStreams)); i++ {
-			relevantStreams = append(relevantStreams, *listStreamsOutput.LogStreams[i].LogStreamName)
+	sort.Slice(children, func(i, j int) bool { return children[i].Index < children[j].Index })
+	return children, nil
+}
+
+// jobLogStream returns the log stream of a job's container, falling back to
+// that of its last attempt
+func jobLogStream(job batchtypes.JobDetail) string {
+	if job.Container != nil && aws.ToString(job.Container.LogStreamName) != "" {
+		return aws.ToString(job.Container.LogStreamName)
+	}
+	for i := len(job.Attempts) - 1; i >= 0; i-- {
+		if c := job.Attempts[i].Container; c != nil && aws.ToString(c.LogStreamName) != "" {
+			return aws.ToString(c.LogStreamName)
 		}
 	}
+	return ""
+}
+
+// fetchLogs prints the logs of each array child, labeled by its array index
+func fetchLogs(ctx context.Context, batchClient batchJobsAPI, logsClient logEventsAPI, logGroupName, jobID string, pretty bool) {
+	children, err := listChildLogStreams(ctx, batchClient, jobID)
+	if err != nil {
+		fmt.Printf("Warning: Could not find the log streams of the array children: %v\n", err)
+		return
+	}
+
+	if len(children) == 0 {
+		fmt.Println("No finished array children found")
+		return
+	}
 
-	// Fetch logs from each relevant stream
-	for _, streamName := range relevantStreams {
-		fmt.Printf("\n[Log Stream: %s]\n", streamName)
+	for _, child := range children {
+		if child.Stream == "" {
+			fmt.Printf("\n[Array index %d: %s] no log stream\n", child.Index, child.JobID)
+			continue
+		}
+		fmt.Printf("\n[Array index %d: %s]\n", child.Index, child.Stream)
 
 		getLogsOutput, err := logsClient.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
 			LogGroupName:  &logGroupName,
-			LogStreamName: &streamName,
+			LogStreamName: &child.Stream,
 			StartFromHead: aws.Bool(true),
 			Limit:         aws.Int32(100),
 		})
@@ -215,34 +619,11 @@ func fetchLogs(ctx context.Context, cfg aws.Config, logGroupName, jobID string,
 		}
 
 		for _, event := range getLogsOutput.Events {
-			// Try to pretty print JSON output
-			var prettyJSON map[string]interface{}
-			if err := json.Unmarshal([]byte(*event.Message), &prettyJSON); err == nil {
-				formattedJSON, _ := json.MarshalIndent(prettyJSON, "", "  ")
-				fmt.Println(string(formattedJSON))
-			} else {
-				fmt.Println(*event.Message)
-			}
+			harness.PrintLogEvent(aws.ToString(event.Message), pretty)
 		}
 	}
 }
 
-func isRecentStream(stream cwltypes.LogStream) bool {
-	if stream.LastEventTimestamp == nil {
-		return false
-	}
-	// Consider streams from the last 5 minutes as recent
-	lastEvent := time.UnixMilli(*stream.LastEventTimestamp)
-	return time.Since(lastEvent) < 5*time.Minute
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // printDiagnostics fetches and prints status/statusReason for job, job queue, and compute environment
 // to help debug issues like jobs stuck in RUNNABLE state
 func printDiagnostics(ctx context.Context, batchClient *batch.Client, jobID, jobQueueARN string) {
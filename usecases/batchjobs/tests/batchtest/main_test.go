@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+func TestVerifyArrayChildren(t *testing.T) {
+	// Depending on the job definition, the parent can report SUCCEEDED even
+	// though a child failed
+	summary := map[string]int32{"SUCCEEDED": 2, "FAILED": 1}
+
+	err := verifyArrayChildren(summary, 3, []int{1})
+	if err == nil {
+		t.Fatal("verifyArrayChildren() succeeded with a FAILED child")
+	}
+	for _, want := range []string{"only 2 of the expected 3", "failed indices: [1]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("verifyArrayChildren() error = %q, want it to contain %q", err, want)
+		}
+	}
+
+	if err := verifyArrayChildren(summary, 2, []int{1}); err != nil {
+		t.Errorf("verifyArrayChildren() with -expect-succeeded 2 error = %v", err)
+	}
+	if err := verifyArrayChildren(map[string]int32{"SUCCEEDED": 3}, 3, nil); err != nil {
+		t.Errorf("verifyArrayChildren() with all children SUCCEEDED error = %v", err)
+	}
+}
+
+// fakeDescribeJobs describes any child ID as a running array child and
+// records how many DescribeJobs calls overlap
+type fakeDescribeJobs struct {
+	mu          sync.Mutex
+	calls       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeDescribeJobs) DescribeJobs(ctx context.Context, params *batch.DescribeJobsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobsOutput, error) {
+	f.mu.Lock()
+	f.calls++
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	out := &batch.DescribeJobsOutput{}
+	for _, id := range params.Jobs {
+		index, err := strconv.Atoi(id[strings.LastIndex(id, ":")+1:])
+		if err != nil {
+			return nil, err
+		}
+		out.Jobs = append(out.Jobs, batchtypes.JobDetail{
+			JobId:           aws.String(id),
+			Status:          batchtypes.JobStatusRunning,
+			ArrayProperties: &batchtypes.ArrayPropertiesDetail{Index: aws.Int32(int32(index))},
+		})
+	}
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+	return out, nil
+}
+
+func TestDescribeChildrenConcurrently(t *testing.T) {
+	client := &fakeDescribeJobs{}
+
+	children, err := describeChildren(context.Background(), client, "job-1", 350, 2)
+	if err != nil {
+		t.Fatalf("describeChildren() error = %v", err)
+	}
+	if len(children) != 350 {
+		t.Fatalf("described %d children, want 350", len(children))
+	}
+	if child := children[349]; aws.ToString(child.JobId) != "job-1:349" || child.Status != batchtypes.JobStatusRunning {
+		t.Errorf("child 349 = %s %s, want job-1:349 RUNNING", aws.ToString(child.JobId), child.Status)
+	}
+	// 350 children take 4 calls of up to 100, at most 2 of them at once
+	if client.calls != 4 {
+		t.Errorf("DescribeJobs called %d times, want 4", client.calls)
+	}
+	if client.maxInFlight != 2 {
+		t.Errorf("max concurrent DescribeJobs calls = %d, want 2", client.maxInFlight)
+	}
+}
+
+func TestTerminalStatusChildrenBeforeParent(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     batchtypes.JobStatus
+		summary    map[string]int32
+		exitEarly  bool
+		wantStatus batchtypes.JobStatus
+		wantDone   bool
+	}{
+		// The children are done while the parent still reports RUNNING
+		{"children succeeded", batchtypes.JobStatusRunning, map[string]int32{"SUCCEEDED": 3}, true, batchtypes.JobStatusSucceeded, true},
+		{"a child failed", batchtypes.JobStatusRunning, map[string]int32{"SUCCEEDED": 2, "FAILED": 1}, true, batchtypes.JobStatusFailed, true},
+		{"without the option", batchtypes.JobStatusRunning, map[string]int32{"SUCCEEDED": 3}, false, batchtypes.JobStatusRunning, false},
+		{"children still running", batchtypes.JobStatusRunning, map[string]int32{"SUCCEEDED": 2, "RUNNING": 1}, true, batchtypes.JobStatusRunning, false},
+		{"parent done", batchtypes.JobStatusSucceeded, map[string]int32{"SUCCEEDED": 2, "RUNNING": 1}, false, batchtypes.JobStatusSucceeded, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, done := terminalStatus(tt.status, tt.summary, 3, tt.exitEarly)
+			if status != tt.wantStatus || done != tt.wantDone {
+				t.Errorf("terminalStatus() = %s, %v, want %s, %v", status, done, tt.wantStatus, tt.wantDone)
+			}
+		})
+	}
+}
+
+// fakeArrayJob serves the terminal children of one array job: ListJobs by
+// status, a page per child, and DescribeJobs with their log streams
+type fakeArrayJob struct {
+	children  []batchtypes.JobDetail
+	describes int
+}
+
+func (f *fakeArrayJob) child(index int32, status batchtypes.JobStatus, stream string) *batchtypes.JobDetail {
+	job := batchtypes.JobDetail{
+		JobId:           aws.String(fmt.Sprintf("job-1:%d", index)),
+		Status:          status,
+		ArrayProperties: &batchtypes.ArrayPropertiesDetail{Index: aws.Int32(index)},
+	}
+	if stream != "" {
+		job.Container = &batchtypes.ContainerDetail{LogStreamName: aws.String(stream)}
+	}
+	f.children = append(f.children, job)
+	return &f.children[len(f.children)-1]
+}
+
+func (f *fakeArrayJob) ListJobs(ctx context.Context, params *batch.ListJobsInput, optFns ...func(*batch.Options)) (*batch.ListJobsOutput, error) {
+	if aws.ToString(params.ArrayJobId) != "job-1" {
+		return nil, fmt.Errorf("unexpected array job %q", aws.ToString(params.ArrayJobId))
+	}
+	var matching []batchtypes.JobDetail
+	for _, job := range f.children {
+		if job.Status == params.JobStatus {
+			matching = append(matching, job)
+		}
+	}
+	next := 0
+	if params.NextToken != nil {
+		next, _ = strconv.Atoi(*params.NextToken)
+	}
+	out := &batch.ListJobsOutput{}
+	if next < len(matching) {
+		job := matching[next]
+		out.JobSummaryList = []batchtypes.JobSummary{{
+			JobId:           job.JobId,
+			Status:          job.Status,
+			ArrayProperties: &batchtypes.ArrayPropertiesSummary{Index: job.ArrayProperties.Index},
+		}}
+	}
+	if next+1 < len(matching) {
+		out.NextToken = aws.String(strconv.Itoa(next + 1))
+	}
+	return out, nil
+}
+
+func (f *fakeArrayJob) DescribeJobs(ctx context.Context, params *batch.DescribeJobsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobsOutput, error) {
+	f.describes++
+	out := &batch.DescribeJobsOutput{}
+	for _, id := range params.Jobs {
+		for _, job := range f.children {
+			if aws.ToString(job.JobId) == id {
+				out.Jobs = append(out.Jobs, job)
+			}
+		}
+	}
+	return out, nil
+}
+
+// fakeLogEvents returns one event per stream naming the stream, recording
+// which streams were read
+type fakeLogEvents struct {
+	streams []string
+}
+
+func (f *fakeLogEvents) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	stream := aws.ToString(params.LogStreamName)
+	f.streams = append(f.streams, stream)
+	return &cloudwatchlogs.GetLogEventsOutput{Events: []cwtypes.OutputLogEvent{{Message: aws.String("from " + stream)}}}, nil
+}
+
+func TestListChildLogStreams(t *testing.T) {
+	job := &fakeArrayJob{}
+	job.child(2, batchtypes.JobStatusSucceeded, "batchworker/default/stream-2")
+	job.child(0, batchtypes.JobStatusFailed, "batchworker/default/stream-0")
+	job.child(1, batchtypes.JobStatusSucceeded, "batchworker/default/stream-1")
+	// A retried child without a current container falls back to its last attempt
+	retried := job.child(3, batchtypes.JobStatusFailed, "")
+	retried.Attempts = []batchtypes.AttemptDetail{
+		{Container: &batchtypes.AttemptContainerDetail{LogStreamName: aws.String("batchworker/default/attempt-1")}},
+		{Container: &batchtypes.AttemptContainerDetail{LogStreamName: aws.String("batchworker/default/attempt-2")}},
+	}
+	// A child that never started has no stream
+	job.child(4, batchtypes.JobStatusFailed, "")
+
+	children, err := listChildLogStreams(context.Background(), job, "job-1")
+	if err != nil {
+		t.Fatalf("listChildLogStreams() error = %v", err)
+	}
+	want := []childLogStream{
+		{0, "job-1:0", "batchworker/default/stream-0"},
+		{1, "job-1:1", "batchworker/default/stream-1"},
+		{2, "job-1:2", "batchworker/default/stream-2"},
+		{3, "job-1:3", "batchworker/default/attempt-2"},
+		{4, "job-1:4", ""},
+	}
+	if !reflect.DeepEqual(children, want) {
+		t.Errorf("listChildLogStreams() = %+v, want %+v", children, want)
+	}
+	if job.describes != 1 {
+		t.Errorf("DescribeJobs called %d times for 5 children, want 1", job.describes)
+	}
+}
+
+func TestFetchLogsReadsOnlyChildStreams(t *testing.T) {
+	job := &fakeArrayJob{}
+	job.child(1, batchtypes.JobStatusSucceeded, "batchworker/default/stream-1")
+	job.child(0, batchtypes.JobStatusSucceeded, "batchworker/default/stream-0")
+	job.child(2, batchtypes.JobStatusFailed, "")
+	logs := &fakeLogEvents{}
+
+	fetchLogs(context.Background(), job, logs, "/aws/batch/job", "job-1", false)
+
+	want := []string{"batchworker/default/stream-0", "batchworker/default/stream-1"}
+	if !reflect.DeepEqual(logs.streams, want) {
+		t.Errorf("read log streams %q, want %q", logs.streams, want)
+	}
+}
+
+func TestBuildSubmitJobInput(t *testing.T) {
+	opts := submitOptions{JobName: "test", JobQueue: "queue", JobDefinition: "def", ArraySize: 3, InputJSON: `{"message":"hi"}`}
+
+	input := buildSubmitJobInput(opts)
+	if aws.ToInt32(input.ArrayProperties.Size) != 3 || len(input.DependsOn) != 0 {
+		t.Errorf("array size %d with dependencies %+v, want 3 and none", aws.ToInt32(input.ArrayProperties.Size), input.DependsOn)
+	}
+	if env := input.ContainerOverrides.Environment; len(env) != 1 || aws.ToString(env[0].Name) != "JOB_INPUT" || aws.ToString(env[0].Value) != opts.InputJSON {
+		t.Errorf("environment = %+v, want JOB_INPUT only", env)
+	}
+
+	opts.DependsOn = "job-0"
+	input = buildSubmitJobInput(opts)
+	if len(input.DependsOn) != 1 || aws.ToString(input.DependsOn[0].JobId) != "job-0" || input.DependsOn[0].Type != "" {
+		t.Errorf("-depends-on dependencies = %+v, want job-0 only", input.DependsOn)
+	}
+
+	opts.DependsOn, opts.Sequential = "", true
+	input = buildSubmitJobInput(opts)
+	if len(input.DependsOn) != 1 || input.DependsOn[0].JobId != nil || input.DependsOn[0].Type != batchtypes.ArrayJobDependencySequential {
+		t.Errorf("-sequential dependencies = %+v, want a SEQUENTIAL dependency without a job ID", input.DependsOn)
+	}
+
+	opts.DependsOn = "job-0"
+	input = buildSubmitJobInput(opts)
+	if len(input.DependsOn) != 2 || aws.ToString(input.DependsOn[0].JobId) != "job-0" || input.DependsOn[1].Type != batchtypes.ArrayJobDependencySequential {
+		t.Errorf("combined dependencies = %+v, want job-0 and SEQUENTIAL", input.DependsOn)
+	}
+}
+
+func TestPrintDependencyBlocked(t *testing.T) {
+	pending := batchtypes.JobDetail{
+		Status:    batchtypes.JobStatusPending,
+		DependsOn: []batchtypes.JobDependency{{JobId: aws.String("job-0")}},
+	}
+	var out strings.Builder
+	printDependencyBlocked(&out, pending, nil, false)
+	if !strings.Contains(out.String(), "waiting on jobs [job-0]") {
+		t.Errorf("pending parent printed %q, want the job it waits on", out.String())
+	}
+
+	running := batchtypes.JobDetail{Status: batchtypes.JobStatusRunning}
+	out.Reset()
+	printDependencyBlocked(&out, running, map[string]int32{"RUNNING": 1, "PENDING": 4}, true)
+	if !strings.Contains(out.String(), "4 children waiting on the previous index") {
+		t.Errorf("sequential children printed %q, want 4 blocked children", out.String())
+	}
+
+	out.Reset()
+	printDependencyBlocked(&out, running, map[string]int32{"PENDING": 4}, false)
+	if out.Len() != 0 {
+		t.Errorf("parallel children printed %q, want nothing", out.String())
+	}
+}
+
+func TestPrintChildSummary(t *testing.T) {
+	children := map[int]batchtypes.JobDetail{
+		2: {Status: batchtypes.JobStatusFailed, StatusReason: aws.String("Essential container in task exited"),
+			Container: &batchtypes.ContainerDetail{ExitCode: aws.Int32(3), Reason: aws.String("simulated failure")}},
+		0: {Status: batchtypes.JobStatusSucceeded, Container: &batchtypes.ContainerDetail{ExitCode: aws.Int32(0)}},
+		1: {Status: batchtypes.JobStatusFailed, StatusReason: aws.String("Host EC2 terminated")},
+	}
+
+	var out strings.Builder
+	failed := printChildSummary(&out, children)
+	if !reflect.DeepEqual(failed, []int{1, 2}) {
+		t.Errorf("failed indices = %v, want [1 2]", failed)
+	}
+
+	want := []string{
+		"INDEX  STATUS     EXIT CODE  REASON",
+		"0      SUCCEEDED  0          -",
+		"1      FAILED     -          Host EC2 terminated",
+		"2      FAILED     3          Essential container in task exited: simulated failure",
+	}
+	if got := strings.Split(strings.TrimRight(out.String(), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("summary =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// fakeJobStopper reports a job in status and records the stop calls made
+type fakeJobStopper struct {
+	status     batchtypes.JobStatus
+	cancels    []string
+	terminates []string
+	reasons    []string
+	err        error // Returned by TerminateJob
+}
+
+func (f *fakeJobStopper) DescribeJobs(ctx context.Context, params *batch.DescribeJobsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobsOutput, error) {
+	return &batch.DescribeJobsOutput{Jobs: []batchtypes.JobDetail{{JobId: aws.String(params.Jobs[0]), Status: f.status}}}, nil
+}
+
+func (f *fakeJobStopper) CancelJob(ctx context.Context, params *batch.CancelJobInput, optFns ...func(*batch.Options)) (*batch.CancelJobOutput, error) {
+	f.cancels = append(f.cancels, aws.ToString(params.JobId))
+	return &batch.CancelJobOutput{}, nil
+}
+
+func (f *fakeJobStopper) TerminateJob(ctx context.Context, params *batch.TerminateJobInput, optFns ...func(*batch.Options)) (*batch.TerminateJobOutput, error) {
+	f.terminates = append(f.terminates, aws.ToString(params.JobId))
+	f.reasons = append(f.reasons, aws.ToString(params.Reason))
+	return &batch.TerminateJobOutput{}, f.err
+}
+
+func TestStopJobOnSignalTerminates(t *testing.T) {
+	client := &fakeJobStopper{status: batchtypes.JobStatusRunning}
+	stopper := &jobStopper{client: client, jobID: "job-1"}
+
+	sigCh := make(chan os.Signal, 1)
+	sigCh <- syscall.SIGINT
+	exitCode := -1
+	stopJobOnSignal(sigCh, stopper, func(code int) { exitCode = code })
+
+	if exitCode != 1 {
+		t.Errorf("exit code = %d, want 1", exitCode)
+	}
+	if !reflect.DeepEqual(client.terminates, []string{"job-1"}) || len(client.cancels) != 0 {
+		t.Errorf("terminated %v and cancelled %v, want job-1 terminated only", client.terminates, client.cancels)
+	}
+	if len(client.reasons) != 1 || !strings.Contains(client.reasons[0], "interrupt") {
+		t.Errorf("terminate reasons = %q, want the signal named", client.reasons)
+	}
+
+	// Stopping again, e.g. on a second signal, does nothing
+	stopper.stop(context.Background(), "again")
+	if len(client.terminates) != 1 {
+		t.Errorf("TerminateJob called %d times, want 1", len(client.terminates))
+	}
+}
+
+func TestJobStopperByStatus(t *testing.T) {
+	for _, tt := range []struct {
+		status                batchtypes.JobStatus
+		cancels, terminations int
+	}{
+		{batchtypes.JobStatusSubmitted, 1, 1},
+		{batchtypes.JobStatusPending, 1, 1},
+		{batchtypes.JobStatusRunnable, 1, 1},
+		{batchtypes.JobStatusStarting, 0, 1},
+		{batchtypes.JobStatusRunning, 0, 1},
+		{batchtypes.JobStatusSucceeded, 0, 0},
+		{batchtypes.JobStatusFailed, 0, 0},
+	} {
+		client := &fakeJobStopper{status: tt.status}
+		(&jobStopper{client: client, jobID: "job-1"}).stop(context.Background(), "test")
+		if len(client.cancels) != tt.cancels || len(client.terminates) != tt.terminations {
+			t.Errorf("%s: %d cancels and %d terminations, want %d and %d",
+				tt.status, len(client.cancels), len(client.terminates), tt.cancels, tt.terminations)
+		}
+	}
+}
+
+func TestJobStopperTerminateError(t *testing.T) {
+	client := &fakeJobStopper{status: batchtypes.JobStatusRunning, err: errors.New("throttled")}
+	stopper := &jobStopper{client: client, jobID: "job-1"}
+	stopper.stop(context.Background(), "test")
+	stopper.stop(context.Background(), "test")
+	// A failed attempt is logged, not retried
+	if len(client.terminates) != 1 {
+		t.Errorf("TerminateJob called %d times, want 1", len(client.terminates))
+	}
+}
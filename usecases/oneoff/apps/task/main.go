@@ -1,10 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/example/hello-fargate-app/inputschema"
 )
 
 // TaskInput represents the input JSON structure
@@ -30,6 +41,14 @@ func main() {
 		log.Println("No TASK_INPUT provided, using empty object.")
 	}
 
+	// Optionally reject input that does not match a JSON Schema
+	if schemaPath := os.Getenv("INPUT_SCHEMA_PATH"); schemaPath != "" {
+		if err := inputschema.Validate(schemaPath, inputJsonString); err != nil {
+			log.Fatalf("Error: TASK_INPUT does not match schema %s: %v\n", schemaPath, err)
+		}
+		log.Printf("TASK_INPUT matches schema %s\n", schemaPath)
+	}
+
 	// Parse the input JSON
 	var taskInput TaskInput
 	if err := json.Unmarshal([]byte(inputJsonString), &taskInput); err != nil {
@@ -44,6 +63,20 @@ func main() {
 
 	log.Printf("Received input: %+v\n", taskInput)
 
+	// Optionally simulate slow and failing work for testing the test runner
+	sim, err := simulationFromEnv()
+	if err != nil {
+		log.Fatalf("Error: Invalid work simulation config: %v\n", err)
+	}
+	if sim.sleep > 0 {
+		log.Printf("Simulating work for %v (TASK_SLEEP_SECONDS)\n", sim.sleep)
+		time.Sleep(sim.sleep)
+	}
+	fail := sim.shouldFail()
+	if sim.failRate > 0 {
+		log.Printf("Failure injection (rate %.2f) decided to fail: %t\n", sim.failRate, fail)
+	}
+
 	// Process the input (simple example - just echo back with status)
 	output := TaskOutput{
 		Status:  "success",
@@ -54,6 +87,10 @@ func main() {
 	if taskInput.Message == "" {
 		output.Message = "Processed successfully (no message provided)"
 	}
+	if fail {
+		output.Status = "error"
+		output.Message = fmt.Sprintf("Injected failure (exit code %d)", sim.exitCode)
+	}
 
 	// Output the result as JSON
 	outputBytes, err := json.MarshalIndent(output, "", "  ")
@@ -65,5 +102,147 @@ func main() {
 	fmt.Println(string(outputBytes))
 	fmt.Println("-------------------")
 
+	// Optionally persist the same output for downstream consumers
+	if outputPath := os.Getenv("OUTPUT_PATH"); outputPath != "" {
+		if err := writeOutputFile(outputPath, outputBytes); err != nil {
+			log.Fatalf("Error: Failed to write output to %s: %v\n", outputPath, err)
+		}
+		log.Printf("Wrote output to %s\n", outputPath)
+	}
+	if outputURI := os.Getenv("OUTPUT_S3_URI"); outputURI != "" {
+		ctx := context.Background()
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			log.Fatalf("Error: Failed to load AWS SDK config: %v\n", err)
+		}
+		if err := uploadOutput(ctx, s3.NewFromConfig(cfg), outputURI, outputBytes); err != nil {
+			log.Fatalf("Error: Failed to upload output to %s: %v\n", outputURI, err)
+		}
+		log.Printf("Uploaded output to %s\n", outputURI)
+	}
+
+	if fail {
+		log.Printf("One-off Fargate task failed by injection, exiting with code %d.\n", sim.exitCode)
+		os.Exit(sim.exitCode)
+	}
 	log.Println("One-off Fargate task completed successfully.")
 }
+
+// simulation describes the simulated work configured through the environment
+type simulation struct {
+	sleep    time.Duration // How long the task pretends to work
+	failRate float64       // Probability of failing (0.0-1.0)
+	exitCode int           // Exit code used when failing
+	rng      *rand.Rand
+}
+
+// newSimulation returns a simulation failing with probability failRate. The
+// same seed always yields the same decision.
+func newSimulation(sleep time.Duration, failRate float64, exitCode int, seed int64) (simulation, error) {
+	if failRate < 0 || failRate > 1 {
+		return simulation{}, fmt.Errorf("failure rate must be between 0.0 and 1.0, got %v", failRate)
+	}
+	if exitCode < 1 || exitCode > 255 {
+		return simulation{}, fmt.Errorf("exit code must be between 1 and 255, got %d", exitCode)
+	}
+	return simulation{
+		sleep:    sleep,
+		failRate: failRate,
+		exitCode: exitCode,
+		rng:      rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// simulationFromEnv reads TASK_SLEEP_SECONDS, TASK_FAIL_RATE, TASK_FAIL_SEED
+// and TASK_EXIT_CODE. Setting TASK_EXIT_CODE without TASK_FAIL_RATE always
+// fails with that code; TASK_FAIL_RATE alone fails with exit code 1.
+func simulationFromEnv() (simulation, error) {
+	var sleep time.Duration
+	if v := os.Getenv("TASK_SLEEP_SECONDS"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs < 0 {
+			return simulation{}, fmt.Errorf("invalid TASK_SLEEP_SECONDS %q: must be a non-negative number", v)
+		}
+		sleep = time.Duration(secs * float64(time.Second))
+	}
+
+	exitCode := 1
+	v := os.Getenv("TASK_EXIT_CODE")
+	if v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return simulation{}, fmt.Errorf("invalid TASK_EXIT_CODE %q: %w", v, err)
+		}
+		exitCode = n
+	}
+
+	var failRate float64
+	if r := os.Getenv("TASK_FAIL_RATE"); r != "" {
+		rate, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return simulation{}, fmt.Errorf("invalid TASK_FAIL_RATE %q: %w", r, err)
+		}
+		failRate = rate
+	} else if v != "" {
+		failRate = 1
+	}
+
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("TASK_FAIL_SEED"); s != "" {
+		var err error
+		seed, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return simulation{}, fmt.Errorf("invalid TASK_FAIL_SEED %q: %w", s, err)
+		}
+	}
+	return newSimulation(sleep, failRate, exitCode, seed)
+}
+
+// shouldFail reports whether the task should fail
+func (s simulation) shouldFail() bool {
+	return s.failRate > 0 && s.rng.Float64() < s.failRate
+}
+
+// S3API is the subset of the S3 client used to upload the output, so that
+// tests can substitute a fake
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// writeOutputFile writes data to path, creating its parent directory if needed
+func writeOutputFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// uploadOutput uploads data as JSON to the object at an s3://bucket/key URI
+func uploadOutput(ctx context.Context, client S3API, uri string, data []byte) error {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+	})
+	return err
+}
+
+// parseS3URI splits an s3://bucket/key URI into its bucket and key. It is
+// split by hand rather than with url.Parse, since object keys may contain
+// characters such as '?', '#' and '%' that are not URL syntax here.
+func parseS3URI(uri string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if ok {
+		bucket, key, ok = strings.Cut(rest, "/")
+	}
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URI %q: expected s3://bucket/key", uri)
+	}
+	return bucket, key, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/example/hello-fargate-app/inputschema"
+)
+
+func TestParseS3URI(t *testing.T) {
+	for _, tt := range []struct {
+		uri, bucket, key string
+	}{
+		{"s3://bucket/output.json", "bucket", "output.json"},
+		{"s3://bucket/runs/2026/output.json", "bucket", "runs/2026/output.json"},
+		// Keys are taken verbatim, not as URL paths
+		{"s3://bucket/a?b#c%20d.json", "bucket", "a?b#c%20d.json"},
+		{"s3://bucket//leading-slash", "bucket", "/leading-slash"},
+	} {
+		bucket, key, err := parseS3URI(tt.uri)
+		if err != nil || bucket != tt.bucket || key != tt.key {
+			t.Errorf("parseS3URI(%q) = %q, %q, %v, want %q and %q", tt.uri, bucket, key, err, tt.bucket, tt.key)
+		}
+	}
+
+	for _, uri := range []string{"", "bucket/key", "https://bucket/key", "s3://bucket", "s3://bucket/", "s3:///key"} {
+		if _, _, err := parseS3URI(uri); err == nil {
+			t.Errorf("parseS3URI(%q) succeeded, want an error", uri)
+		}
+	}
+}
+
+func TestWriteOutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "dir", "output.json")
+	data := []byte(`{"status":"success"}`)
+
+	if err := writeOutputFile(path, data); err != nil {
+		t.Fatalf("writeOutputFile() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != string(data) {
+		t.Errorf("output file = %q, %v, want %q", got, err, data)
+	}
+}
+
+// fakeS3 records the objects put to it, failing with err if set
+type fakeS3 struct {
+	puts []*s3.PutObjectInput
+	body []byte
+	err  error
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, params)
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.body = body
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestUploadOutput(t *testing.T) {
+	client := &fakeS3{}
+	data := []byte(`{"status":"success"}`)
+
+	if err := uploadOutput(context.Background(), client, "s3://results/runs/output.json", data); err != nil {
+		t.Fatalf("uploadOutput() error = %v", err)
+	}
+	if len(client.puts) != 1 {
+		t.Fatalf("PutObject called %d times, want 1", len(client.puts))
+	}
+	put := client.puts[0]
+	if aws.ToString(put.Bucket) != "results" || aws.ToString(put.Key) != "runs/output.json" || aws.ToString(put.ContentType) != "application/json" {
+		t.Errorf("PutObject(bucket %q, key %q, content type %q), want results, runs/output.json and application/json",
+			aws.ToString(put.Bucket), aws.ToString(put.Key), aws.ToString(put.ContentType))
+	}
+	if string(client.body) != string(data) {
+		t.Errorf("uploaded %q, want %q", client.body, data)
+	}
+}
+
+func TestUploadOutputErrors(t *testing.T) {
+	client := &fakeS3{err: errors.New("access denied")}
+	if err := uploadOutput(context.Background(), client, "s3://results/output.json", nil); err == nil {
+		t.Error("uploadOutput() succeeded although PutObject failed")
+	}
+
+	client = &fakeS3{}
+	if err := uploadOutput(context.Background(), client, "s3://results", nil); err == nil || len(client.puts) != 0 {
+		t.Errorf("uploadOutput() with an invalid URI = %v after %d puts, want an error and no put", err, len(client.puts))
+	}
+}
+
+func TestSimulationSeededDecisions(t *testing.T) {
+	decisions := func(seed int64) []bool {
+		sim, err := newSimulation(0, 0.5, 3, seed)
+		if err != nil {
+			t.Fatalf("newSimulation() error = %v", err)
+		}
+		var got []bool
+		for i := 0; i < 20; i++ {
+			got = append(got, sim.shouldFail())
+		}
+		return got
+	}
+
+	first, again := decisions(42), decisions(42)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("seed 42 decided %v, then %v, want the same decisions", first, again)
+	}
+	failures := 0
+	for _, fail := range first {
+		if fail {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(first) {
+		t.Errorf("rate 0.5 failed %d of %d times, want a mix", failures, len(first))
+	}
+}
+
+func TestSimulationRateBounds(t *testing.T) {
+	never, _ := newSimulation(0, 0, 1, 1)
+	always, _ := newSimulation(0, 1, 1, 1)
+	for i := 0; i < 100; i++ {
+		if never.shouldFail() {
+			t.Fatal("rate 0 failed")
+		}
+		if !always.shouldFail() {
+			t.Fatal("rate 1 succeeded")
+		}
+	}
+
+	for _, tt := range []struct {
+		rate     float64
+		exitCode int
+	}{{-0.1, 1}, {1.5, 1}, {0.5, 0}, {0.5, 256}} {
+		if _, err := newSimulation(0, tt.rate, tt.exitCode, 1); err == nil {
+			t.Errorf("newSimulation(rate %v, exit code %d) succeeded, want an error", tt.rate, tt.exitCode)
+		}
+	}
+}
+
+func TestSimulationFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		sleep, rate, exitCode, seed string
+		wantSleep                   time.Duration
+		wantRate                    float64
+		wantExitCode                int
+	}{
+		{"", "", "", "", 0, 0, 1},
+		{"1.5", "", "", "", 1500 * time.Millisecond, 0, 1},
+		{"", "", "3", "", 0, 1, 3}, // an exit code alone always fails
+		{"", "0.25", "", "7", 0, 0.25, 1},
+		{"", "0.25", "4", "7", 0, 0.25, 4},
+	} {
+		t.Setenv("TASK_SLEEP_SECONDS", tt.sleep)
+		t.Setenv("TASK_FAIL_RATE", tt.rate)
+		t.Setenv("TASK_EXIT_CODE", tt.exitCode)
+		t.Setenv("TASK_FAIL_SEED", tt.seed)
+		sim, err := simulationFromEnv()
+		if err != nil || sim.sleep != tt.wantSleep || sim.failRate != tt.wantRate || sim.exitCode != tt.wantExitCode {
+			t.Errorf("%+v: simulationFromEnv() = sleep %v, rate %v, exit code %d, %v, want %v, %v, %d",
+				tt, sim.sleep, sim.failRate, sim.exitCode, err, tt.wantSleep, tt.wantRate, tt.wantExitCode)
+		}
+	}
+
+	for name, value := range map[string]string{"TASK_SLEEP_SECONDS": "-1", "TASK_FAIL_RATE": "often", "TASK_EXIT_CODE": "x", "TASK_FAIL_SEED": "1.5"} {
+		t.Run(name, func(t *testing.T) {
+			for _, n := range []string{"TASK_SLEEP_SECONDS", "TASK_FAIL_RATE", "TASK_EXIT_CODE", "TASK_FAIL_SEED"} {
+				t.Setenv(n, "")
+			}
+			t.Setenv(name, value)
+			if _, err := simulationFromEnv(); err == nil {
+				t.Errorf("%s=%q: simulationFromEnv() succeeded, want an error", name, value)
+			}
+		})
+	}
+}
+
+func TestShippedInputSchema(t *testing.T) {
+	if err := inputschema.Validate("input.schema.json", `{"message":"hello","data":{"n":1}}`); err != nil {
+		t.Errorf("valid TASK_INPUT rejected: %v", err)
+	}
+	for _, input := range []string{`{}`, `{"message":42}`, `{"message":"hello","data":[]}`} {
+		if err := inputschema.Validate("input.schema.json", input); err == nil {
+			t.Errorf("TASK_INPUT %s accepted, want a schema violation", input)
+		}
+	}
+}
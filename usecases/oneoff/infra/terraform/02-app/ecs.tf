@@ -68,6 +68,23 @@ resource "aws_iam_role" "ecs_task_role" {
   }
 }
 
+# Allow uploading the task output when an output bucket is configured
+data "aws_iam_policy_document" "output_upload" {
+  count = var.output_bucket_arn == "" ? 0 : 1
+
+  statement {
+    actions   = ["s3:PutObject"]
+    resources = ["${var.output_bucket_arn}/*"]
+  }
+}
+
+resource "aws_iam_role_policy" "output_upload" {
+  count  = var.output_bucket_arn == "" ? 0 : 1
+  name   = "hello-fargate-oneoff-output-upload"
+  role   = aws_iam_role.ecs_task_role.id
+  policy = data.aws_iam_policy_document.output_upload[0].json
+}
+
 # --- ECS Task Definition ---
 resource "aws_ecs_task_definition" "app_task" {
   family                   = "hello-fargate-oneoff-app-task"
@@ -83,12 +100,12 @@ resource "aws_ecs_task_definition" "app_task" {
       name      = "hello-fargate-oneoff-app-container"
       image     = var.image_uri
       essential = true
-      environment = [
+      environment = concat([
         {
           name  = "TASK_INPUT",
           value = "{}"
         }
-      ]
+      ], [for name, value in var.task_environment : { name = name, value = value }])
       logConfiguration = {
         logDriver = "awslogs"
         options = {
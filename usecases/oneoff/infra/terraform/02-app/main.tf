@@ -41,6 +41,18 @@ variable "vpc_id" {
   type        = string
 }
 
+variable "output_bucket_arn" {
+  description = "ARN of an S3 bucket the task may upload its output to via OUTPUT_S3_URI (empty disables)"
+  type        = string
+  default     = ""
+}
+
+variable "task_environment" {
+  description = "Extra container environment variables, e.g. { TASK_EXIT_CODE = \"3\" } to simulate a failing task"
+  type        = map(string)
+  default     = {}
+}
+
 variable "security_group_ids" {
   description = "List of additional security group IDs"
   type        = list(string)
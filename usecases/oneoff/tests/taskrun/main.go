@@ -5,16 +5,19 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	"github.com/example/hello-fargate-harness"
 )
 
 func main() {
@@ -24,6 +27,17 @@ func main() {
 	securityGroupID := flag.String("security-group-id", "", "The security group ID")
 	containerName := flag.String("container-name", "", "The name of the container")
 	inputJSON := flag.String("input", "{}", "JSON input to pass to the task")
+	successExitCodes := flag.String("success-exit-codes", "0", "Comma-separated container exit codes that count as success (e.g. 0,2)")
+	logGroup := flag.String("log-group", "/ecs/hello-fargate-oneoff-task", "CloudWatch log group, used when it cannot be discovered from the task definition")
+	logStreamPrefix := flag.String("log-stream-prefix", "ecs", "awslogs stream prefix, used when it cannot be discovered from the task definition")
+	assertSuccess := flag.Bool("assert-success", false, "Fail unless the task's --- Task Output --- block in the logs reports status \"success\"")
+	prettyLogs := flag.Bool("pretty-logs", true, "Pretty-print JSON log messages; set to false to print them as-is")
+	maxRetries := flag.Int("max-retries", 2, "Maximum retries per AWS API call (throttling and transient errors)")
+	opTimeout := flag.Duration("op-timeout", 30*time.Second, "Timeout for each AWS API call, retries included (0 disables it)")
+	timeout := flag.Duration("timeout", 15*time.Minute, "Overall deadline for starting the task and waiting for it to stop")
+	cpu := flag.Int("cpu", 0, "Task CPU units override, e.g. 512 (0 keeps the task definition's)")
+	memory := flag.Int("memory", 0, "Task memory override in MiB, e.g. 1024 (0 keeps the task definition's)")
+	command := flag.String("command", "", "Comma-separated container command override, e.g. ./go-app,--verbose (empty keeps the image's)")
 	flag.Parse()
 
 	if *clusterArn == "" || *taskDefinitionArn == "" || *subnetIDs == "" || *securityGroupID == "" {
@@ -32,16 +46,30 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
+	successCodes, err := parseExitCodes(*successExitCodes)
+	if err != nil {
+		fmt.Printf("Error: Invalid --success-exit-codes: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := harness.LoadAWSConfig(ctx, *maxRetries, *opTimeout)
 	if err != nil {
 		log.Fatalf("Failed to load AWS SDK config: %v", err)
 	}
 
 	ecsClient := ecs.NewFromConfig(cfg)
 
+	// Catch illegal Fargate sizes before RunTask, which reports them vaguely
+	if *cpu != 0 || *memory != 0 {
+		if err := validateSizeOverride(ctx, ecsClient, *taskDefinitionArn, *cpu, *memory); err != nil {
+			log.Fatalf("Invalid --cpu/--memory: %v", err)
+		}
+	}
+
 	// Parse subnet IDs
 	subnets := strings.Split(*subnetIDs, ",")
 	for i := range subnets {
@@ -56,6 +84,32 @@ func main() {
 	fmt.Printf("  Security Group: %s\n", *securityGroupID)
 	fmt.Printf("  Input: %s\n", *inputJSON)
 
+	overrides := &types.TaskOverride{
+		ContainerOverrides: []types.ContainerOverride{
+			{
+				Name: containerName,
+				Environment: []types.KeyValuePair{
+					{
+						Name:  aws.String("TASK_INPUT"),
+						Value: inputJSON,
+					},
+				},
+			},
+		},
+	}
+	if *cpu != 0 {
+		overrides.Cpu = aws.String(strconv.Itoa(*cpu))
+		fmt.Printf("  CPU: %d\n", *cpu)
+	}
+	if *memory != 0 {
+		overrides.Memory = aws.String(strconv.Itoa(*memory))
+		fmt.Printf("  Memory: %d MiB\n", *memory)
+	}
+	if *command != "" {
+		overrides.ContainerOverrides[0].Command = strings.Split(*command, ",")
+		fmt.Printf("  Command: %q\n", overrides.ContainerOverrides[0].Command)
+	}
+
 	runTaskInput := &ecs.RunTaskInput{
 		Cluster:        clusterArn,
 		TaskDefinition: taskDefinitionArn,
@@ -67,19 +121,7 @@ func main() {
 				AssignPublicIp: types.AssignPublicIpEnabled,
 			},
 		},
-		Overrides: &types.TaskOverride{
-			ContainerOverrides: []types.ContainerOverride{
-				{
-					Name: containerName,
-					Environment: []types.KeyValuePair{
-						{
-							Name:  aws.String("TASK_INPUT"),
-							Value: inputJSON,
-						},
-					},
-				},
-			},
-		},
+		Overrides: overrides,
 	}
 
 	runTaskOutput, err := ecsClient.RunTask(ctx, runTaskInput)
@@ -101,106 +143,510 @@ func main() {
 	taskArn := *runTaskOutput.Tasks[0].TaskArn
 	fmt.Printf("Task started: %s\n", taskArn)
 
+	logCfg := logConfig{Group: *logGroup, StreamPrefix: *logStreamPrefix, Container: *containerName}
+	if logCfg.Container == "" {
+		logCfg.Container = "hello-fargate-oneoff-app-container"
+	}
+	logCfg = resolveLogConfig(ctx, ecsClient, *taskDefinitionArn, *containerName, logCfg)
+	tailer := newLogTailer(cloudwatchlogs.NewFromConfig(cfg), taskArn, logCfg, *prettyLogs)
+
+	// Tail the logs from when the task is RUNNING until it stops
+	tailCtx, stopTail := context.WithCancel(ctx)
+	defer stopTail()
+	tailDone := make(chan struct{})
+	tailing := false
+
 	// Wait for task to complete
 	fmt.Println("Waiting for task to complete...")
-	var lastStatus string
-	var stoppedReason string
+	var transitions []statusTransition
+	lastTask, err := waitForStop(ctx, ecsClient, *clusterArn, taskArn, 5*time.Second, func(task types.Task) {
+		status := aws.ToString(task.LastStatus)
+		fmt.Printf("Task status: %s\n", status)
+		transitions = recordTransition(transitions, status, time.Now())
+
+		if status == "RUNNING" && !tailing {
+			tailing = true
+			fmt.Printf("Tailing logs (group: %s, stream: %s)\n", tailer.group, tailer.streamPrefix)
+			go func() {
+				defer close(tailDone)
+				tailer.run(tailCtx, 5*time.Second)
+			}()
+		}
+	})
+	if ctx.Err() != nil {
+		failOnDeadline(ecsClient, *clusterArn, taskArn, *timeout, lastTask)
+	}
+	if err != nil {
+		log.Fatalf("Failed to wait for the task: %v", err)
+	}
+
+	stoppedTask := *lastTask
+	lastStatus := aws.ToString(stoppedTask.LastStatus)
+	stoppedReason := aws.ToString(stoppedTask.StoppedReason)
+	// Get exit code from container
 	var exitCode int32
+	exitCodeReported := false
+	for _, container := range stoppedTask.Containers {
+		if container.ExitCode != nil {
+			exitCode = *container.ExitCode
+			exitCodeReported = true
+		}
+	}
+
+	if tailing {
+		stopTail()
+		<-tailDone
+	}
+
+	fmt.Printf("\nTask completed with status: %s\n", lastStatus)
+	if stoppedReason != "" {
+		fmt.Printf("Stopped reason: %s\n", stoppedReason)
+	}
+	fmt.Printf("Exit code: %d\n", exitCode)
+
+	printTimeline(os.Stdout, transitions, stoppedTask)
+
+	// A task that never started (e.g. the image could not be pulled or no
+	// ENI could be attached) has no container exit code
+	if !exitCodeReported {
+		fmt.Printf("\nTask failed to start (stop code: %s): %s\n", stoppedTask.StopCode, stoppedReason)
+		printContainerReasons(stoppedTask)
+		os.Exit(1)
+	}
 
+	// Print the log events not seen while tailing
+	fmt.Println("\n--- CloudWatch Logs ---")
+	if tailing {
+		fmt.Printf("(%d events already shown while the task was running)\n", len(tailer.lines))
+	} else {
+		fmt.Printf("Log group: %s, stream: %s\n", tailer.group, tailer.streamPrefix)
+	}
+	if err := tailer.fetch(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if tailer.streamName == "" {
+		fmt.Println("No log streams found")
+	}
+	logLines := tailer.lines
+	fmt.Println("-----------------------")
+
+	if code := runnerExitCode(exitCode, successCodes); code != 0 {
+		fmt.Printf("Exit code %d is not a success exit code (%s)\n", exitCode, *successExitCodes)
+		os.Exit(code)
+	}
+	if exitCode != 0 {
+		fmt.Printf("Exit code %d treated as success\n", exitCode)
+	}
+
+	if *assertSuccess {
+		output, err := parseTaskOutput(logLines)
+		if err != nil {
+			fmt.Printf("Task output assertion failed: %v\n", err)
+			os.Exit(1)
+		}
+		if output.Status != "success" {
+			fmt.Printf("Task output assertion failed: status is %q, expected \"success\" (message: %s)\n", output.Status, output.Message)
+			os.Exit(1)
+		}
+		fmt.Println("Task output assertion passed: status is \"success\"")
+	}
+}
+
+// describeTasksAPI is the subset of the ECS client used to poll a task, so
+// that tests can substitute a fake
+type describeTasksAPI interface {
+	DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+}
+
+// waitForStop describes the task every interval until it is STOPPED and
+// returns it, calling onStatus with every description. Once ctx is done, it
+// returns the last description, nil if there was none, with the error of ctx.
+func waitForStop(ctx context.Context, client describeTasksAPI, cluster, taskArn string, interval time.Duration, onStatus func(types.Task)) (*types.Task, error) {
+	var lastTask *types.Task
 	for {
-		describeTasksOutput, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{
-			Cluster: clusterArn,
+		output, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: &cluster,
 			Tasks:   []string{taskArn},
 		})
 		if err != nil {
-			log.Fatalf("Failed to describe task: %v", err)
+			if ctx.Err() != nil {
+				return lastTask, ctx.Err()
+			}
+			return lastTask, fmt.Errorf("failed to describe task: %w", err)
+		}
+		if len(output.Tasks) == 0 {
+			return lastTask, fmt.Errorf("task not found")
+		}
+
+		task := output.Tasks[0]
+		lastTask = &task
+		onStatus(task)
+		if aws.ToString(task.LastStatus) == "STOPPED" {
+			return lastTask, nil
 		}
 
-		if len(describeTasksOutput.Tasks) == 0 {
-			log.Fatalf("Task not found")
+		select {
+		case <-ctx.Done():
+			return lastTask, ctx.Err()
+		case <-time.After(interval):
 		}
+	}
+}
 
-		task := describeTasksOutput.Tasks[0]
-		lastStatus = *task.LastStatus
-		fmt.Printf("Task status: %s\n", lastStatus)
+// failOnDeadline prints what is known about a task that did not stop before
+// the --timeout deadline, stops it and exits nonzero. lastTask is nil if the
+// task was never described.
+func failOnDeadline(client *ecs.Client, cluster, taskArn string, timeout time.Duration, lastTask *types.Task) {
+	fmt.Printf("\nTimed out after %v waiting for the task to stop\n", timeout)
+	if lastTask != nil {
+		fmt.Printf("  Last status: %s (desired: %s)\n", aws.ToString(lastTask.LastStatus), aws.ToString(lastTask.DesiredStatus))
+		if lastTask.StoppedReason != nil {
+			fmt.Printf("  Stopped reason: %s\n", *lastTask.StoppedReason)
+		}
+		printContainerReasons(*lastTask)
+	}
 
-		if lastStatus == "STOPPED" {
-			if task.StoppedReason != nil {
-				stoppedReason = *task.StoppedReason
-			}
-			// Get exit code from container
-			for _, container := range task.Containers {
-				if container.ExitCode != nil {
-					exitCode = *container.ExitCode
-				}
-			}
+	// The run's context has expired, so stop the task with a fresh one
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := client.StopTask(ctx, &ecs.StopTaskInput{
+		Cluster: &cluster,
+		Task:    &taskArn,
+		Reason:  aws.String("test runner timed out"),
+	}); err != nil {
+		fmt.Printf("Warning: Failed to stop task: %v\n", err)
+	} else {
+		fmt.Println("Stopped the task")
+	}
+	os.Exit(1)
+}
+
+// printContainerReasons prints the status and, when ECS reports one, the
+// reason of each container in the task
+func printContainerReasons(task types.Task) {
+	for _, c := range task.Containers {
+		fmt.Printf("  Container %s: %s", aws.ToString(c.Name), aws.ToString(c.LastStatus))
+		if c.Reason != nil {
+			fmt.Printf(" (%s)", *c.Reason)
+		}
+		fmt.Println()
+	}
+}
+
+// taskOutput mirrors the JSON the task prints between its output markers
+type taskOutput struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// parseTaskOutput extracts the JSON printed between "--- Task Output ---" and
+// the closing dashes. Each line of the block is a separate log event.
+func parseTaskOutput(lines []string) (*taskOutput, error) {
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "--- Task Output ---" {
+			start = i + 1
 			break
 		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no \"--- Task Output ---\" block found in logs")
+	}
 
-		time.Sleep(5 * time.Second)
+	var block []string
+	for _, line := range lines[start:] {
+		if strings.TrimSpace(line) == "-------------------" {
+			break
+		}
+		block = append(block, line)
 	}
 
-	fmt.Printf("\nTask completed with status: %s\n", lastStatus)
-	if stoppedReason != "" {
-		fmt.Printf("Stopped reason: %s\n", stoppedReason)
+	var output taskOutput
+	if err := json.Unmarshal([]byte(strings.Join(block, "\n")), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse task output: %w", err)
 	}
-	fmt.Printf("Exit code: %d\n", exitCode)
+	return &output, nil
+}
 
-	// Fetch CloudWatch logs
-	fmt.Println("\n--- CloudWatch Logs ---")
-	fetchLogs(ctx, cfg, taskArn)
-	fmt.Println("-----------------------")
+// statusTransition records when a task status was first observed while polling
+type statusTransition struct {
+	Status     string
+	ObservedAt time.Time
+}
 
-	if exitCode != 0 {
-		os.Exit(int(exitCode))
+// recordTransition appends status to transitions when it differs from the
+// last recorded status, so each status keeps the time it was first observed
+func recordTransition(transitions []statusTransition, status string, at time.Time) []statusTransition {
+	if len(transitions) == 0 || transitions[len(transitions)-1].Status != status {
+		transitions = append(transitions, statusTransition{Status: status, ObservedAt: at})
 	}
+	return transitions
 }
 
-func fetchLogs(ctx context.Context, cfg aws.Config, taskArn string) {
-	logsClient := cloudwatchlogs.NewFromConfig(cfg)
+// printTimeline reports the observed status transitions and the per-phase
+// durations computed from the timestamps ECS records on the task. The ECS
+// timestamps are exact, while observed transitions are only as precise as
+// the polling interval.
+func printTimeline(w io.Writer, transitions []statusTransition, task types.Task) {
+	fmt.Fprintln(w, "\n--- Task Lifecycle Timeline ---")
+	for i, t := range transitions {
+		if i+1 < len(transitions) {
+			fmt.Fprintf(w, "  %-15s at %s (for ~%v)\n", t.Status, t.ObservedAt.Format("15:04:05"), transitions[i+1].ObservedAt.Sub(t.ObservedAt).Round(time.Second))
+		} else {
+			fmt.Fprintf(w, "  %-15s at %s\n", t.Status, t.ObservedAt.Format("15:04:05"))
+		}
+	}
 
-	// Extract task ID from ARN
-	parts := strings.Split(taskArn, "/")
-	taskID := parts[len(parts)-1]
+	fmt.Fprintln(w, "\nPhase durations (from ECS timestamps):")
+	fmt.Fprintf(w, "  Provisioning (created -> ENI connectivity): %s\n", phaseDuration(task.CreatedAt, task.ConnectivityAt))
+	fmt.Fprintf(w, "  Image pull (pull started -> pull stopped):   %s\n", phaseDuration(task.PullStartedAt, task.PullStoppedAt))
+	fmt.Fprintf(w, "  Startup (created -> started):                %s\n", phaseDuration(task.CreatedAt, task.StartedAt))
+	fmt.Fprintf(w, "  Running (started -> execution stopped):      %s\n", phaseDuration(task.StartedAt, task.ExecutionStoppedAt))
+	fmt.Fprintf(w, "  Stopping (stopping -> stopped):              %s\n", phaseDuration(task.StoppingAt, task.StoppedAt))
+	fmt.Fprintf(w, "  Total (created -> stopped):                  %s\n", phaseDuration(task.CreatedAt, task.StoppedAt))
+	fmt.Fprintln(w, "-------------------------------")
+}
 
-	logGroupName := "/ecs/hello-fargate-oneoff-task"
-	logStreamPrefix := fmt.Sprintf("ecs/hello-fargate-oneoff-app-container/%s", taskID)
+// phaseDuration formats the duration between two optional timestamps
+func phaseDuration(from, to *time.Time) string {
+	if from == nil || to == nil {
+		return "n/a"
+	}
+	return to.Sub(*from).Round(time.Millisecond).String()
+}
 
-	// List log streams
-	listStreamsOutput, err := logsClient.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
-		LogGroupName:        &logGroupName,
-		LogStreamNamePrefix: &logStreamPrefix,
-	})
-	if err != nil {
-		fmt.Printf("Warning: Could not list log streams: %v\n", err)
-		return
+// parseExitCodes parses a comma-separated list of exit codes into a set
+func parseExitCodes(s string) (map[int32]bool, error) {
+	codes := make(map[int32]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, err := strconv.ParseInt(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q: %w", part, err)
+		}
+		codes[int32(code)] = true
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no exit codes given")
 	}
+	return codes, nil
+}
 
-	if len(listStreamsOutput.LogStreams) == 0 {
-		fmt.Println("No log streams found")
-		return
+// runnerExitCode returns the exit code of the test runner for the container's
+// exit code: 0 when it is one of successCodes, and otherwise the container's
+// own exit code, or 1 when that is 0 but 0 is not a success exit code
+func runnerExitCode(exitCode int32, successCodes map[int32]bool) int {
+	switch {
+	case successCodes[exitCode]:
+		return 0
+	case exitCode == 0:
+		return 1
+	default:
+		return int(exitCode)
+	}
+}
+
+// fargateSizes lists the memory sizes in MiB that Fargate accepts for each
+// CPU value: every step from minMemory up to maxMemory
+var fargateSizes = []struct {
+	cpu, minMemory, maxMemory, step int
+}{
+	{256, 512, 512, 512},
+	{256, 1024, 2048, 1024},
+	{512, 1024, 4096, 1024},
+	{1024, 2048, 8192, 1024},
+	{2048, 4096, 16384, 1024},
+	{4096, 8192, 30720, 1024},
+	{8192, 16384, 61440, 4096},
+	{16384, 32768, 122880, 8192},
+}
+
+// validateFargateSize checks that cpu and memory form a Fargate-legal task size
+func validateFargateSize(cpu, memory int) error {
+	var allowed []string
+	for _, size := range fargateSizes {
+		if size.cpu != cpu {
+			continue
+		}
+		if memory >= size.minMemory && memory <= size.maxMemory && (memory-size.minMemory)%size.step == 0 {
+			return nil
+		}
+		allowed = append(allowed, fmt.Sprintf("%d-%d in steps of %d", size.minMemory, size.maxMemory, size.step))
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("unsupported CPU value %d (expected 256, 512, 1024, 2048, 4096, 8192 or 16384)", cpu)
+	}
+	return fmt.Errorf("memory %d MiB is not valid with %d CPU units (allowed MiB: %s)", memory, cpu, strings.Join(allowed, ", "))
+}
+
+// validateSizeOverride validates the task size resulting from the overrides,
+// taking the value that is not overridden (0) from the task definition
+func validateSizeOverride(ctx context.Context, client *ecs.Client, taskDefinitionArn string, cpu, memory int) error {
+	if cpu == 0 || memory == 0 {
+		out, err := client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: &taskDefinitionArn,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe task definition: %w", err)
+		}
+		if cpu == 0 {
+			cpu, _ = strconv.Atoi(aws.ToString(out.TaskDefinition.Cpu))
+		}
+		if memory == 0 {
+			memory, _ = strconv.Atoi(aws.ToString(out.TaskDefinition.Memory))
+		}
+	}
+	return validateFargateSize(cpu, memory)
+}
+
+// logConfig locates a container's awslogs output
+type logConfig struct {
+	Group        string
+	StreamPrefix string
+	Container    string
+}
+
+// taskDefinitionAPI is the part of the ECS API used to read a task definition
+type taskDefinitionAPI interface {
+	DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+}
+
+// resolveLogConfig returns the log configuration discovered from the task
+// definition, or fallback when it cannot be discovered
+func resolveLogConfig(ctx context.Context, client taskDefinitionAPI, taskDefinitionArn, containerName string, fallback logConfig) logConfig {
+	discovered, err := discoverLogConfig(ctx, client, taskDefinitionArn, containerName)
+	if err != nil {
+		fmt.Printf("Warning: Could not discover log configuration, using flags: %v\n", err)
+		return fallback
 	}
+	return discovered
+}
 
-	// Get log events
-	logStreamName := *listStreamsOutput.LogStreams[0].LogStreamName
-	getLogsOutput, err := logsClient.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
-		LogGroupName:  &logGroupName,
-		LogStreamName: &logStreamName,
-		StartFromHead: aws.Bool(true),
+// discoverLogConfig reads the awslogs group and stream prefix of a container
+// from the task definition. An empty containerName selects the first
+// container using the awslogs driver.
+func discoverLogConfig(ctx context.Context, client taskDefinitionAPI, taskDefinitionArn, containerName string) (logConfig, error) {
+	out, err := client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: &taskDefinitionArn,
 	})
 	if err != nil {
-		fmt.Printf("Warning: Could not get log events: %v\n", err)
-		return
+		return logConfig{}, fmt.Errorf("failed to describe task definition: %w", err)
 	}
 
-	for _, event := range getLogsOutput.Events {
-		// Try to pretty print JSON output
-		var prettyJSON map[string]interface{}
-		if err := json.Unmarshal([]byte(*event.Message), &prettyJSON); err == nil {
-			formattedJSON, _ := json.MarshalIndent(prettyJSON, "", "  ")
-			fmt.Println(string(formattedJSON))
-		} else {
-			fmt.Println(*event.Message)
+	for _, c := range out.TaskDefinition.ContainerDefinitions {
+		if containerName != "" && aws.ToString(c.Name) != containerName {
+			continue
+		}
+		lc := c.LogConfiguration
+		if lc == nil || lc.LogDriver != types.LogDriverAwslogs {
+			continue
+		}
+		group := lc.Options["awslogs-group"]
+		if group == "" {
+			continue
+		}
+		return logConfig{
+			Group:        group,
+			StreamPrefix: lc.Options["awslogs-stream-prefix"],
+			Container:    aws.ToString(c.Name),
+		}, nil
+	}
+	return logConfig{}, fmt.Errorf("no container with an awslogs configuration found")
+}
+
+// logsAPI is the subset of the CloudWatch Logs client used to tail a log
+// stream, so that tests can substitute a fake
+type logsAPI interface {
+	DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+	GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+// logTailer incrementally reads the awslogs stream of a task's container
+type logTailer struct {
+	client       logsAPI
+	group        string
+	streamPrefix string
+	pretty       bool
+
+	streamName string   // Resolved once the stream exists
+	nextToken  *string  // Forward token of the next unread events
+	lines      []string // Every event message read so far
+}
+
+// newLogTailer returns a tailer for the stream of the task's container
+func newLogTailer(client logsAPI, taskArn string, logCfg logConfig, pretty bool) *logTailer {
+	// Extract task ID from ARN
+	parts := strings.Split(taskArn, "/")
+	taskID := parts[len(parts)-1]
+
+	// awslogs names streams <prefix>/<container>/<task ID>
+	return &logTailer{
+		client:       client,
+		group:        logCfg.Group,
+		streamPrefix: fmt.Sprintf("%s/%s/%s", logCfg.StreamPrefix, logCfg.Container, taskID),
+		pretty:       pretty,
+	}
+}
+
+// run fetches and prints new events every interval until ctx is cancelled
+func (t *logTailer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.fetch(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch prints the events added since the previous call. It does nothing
+// until the log stream has been created.
+func (t *logTailer) fetch(ctx context.Context) error {
+	if t.streamName == "" {
+		listStreamsOutput, err := t.client.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+			LogGroupName:        &t.group,
+			LogStreamNamePrefix: &t.streamPrefix,
+		})
+		if err != nil {
+			return fmt.Errorf("could not list log streams: %w", err)
+		}
+		if len(listStreamsOutput.LogStreams) == 0 {
+			return nil
+		}
+		t.streamName = aws.ToString(listStreamsOutput.LogStreams[0].LogStreamName)
+	}
+
+	// GetLogEvents returns the token it was given once no newer events exist
+	for {
+		getLogsOutput, err := t.client.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  &t.group,
+			LogStreamName: &t.streamName,
+			StartFromHead: aws.Bool(true),
+			NextToken:     t.nextToken,
+		})
+		if err != nil {
+			return fmt.Errorf("could not get log events: %w", err)
+		}
+
+		for _, event := range getLogsOutput.Events {
+			t.lines = append(t.lines, aws.ToString(event.Message))
+			harness.PrintLogEvent(aws.ToString(event.Message), t.pretty)
+		}
+
+		next := getLogsOutput.NextForwardToken
+		done := next == nil || (t.nextToken != nil && *next == *t.nextToken)
+		t.nextToken = next
+		if done {
+			return nil
 		}
 	}
 }
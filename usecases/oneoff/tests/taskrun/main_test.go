@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestRunnerExitCode(t *testing.T) {
+	tests := []struct {
+		successExitCodes string
+		exitCode         int32
+		want             int
+	}{
+		{"0", 0, 0},
+		{"0", 2, 2},
+		{"0,2", 2, 0}, // exit code 2 configured as success
+		{"0, 2", 3, 3},
+		{"2", 0, 1},
+	}
+	for _, tt := range tests {
+		codes, err := parseExitCodes(tt.successExitCodes)
+		if err != nil {
+			t.Fatalf("parseExitCodes(%q) error = %v", tt.successExitCodes, err)
+		}
+		if got := runnerExitCode(tt.exitCode, codes); got != tt.want {
+			t.Errorf("runnerExitCode(%d) with -success-exit-codes %q = %d, want %d", tt.exitCode, tt.successExitCodes, got, tt.want)
+		}
+	}
+}
+
+func TestParseExitCodesInvalid(t *testing.T) {
+	for _, s := range []string{"", " , ", "zero", "0,x"} {
+		if _, err := parseExitCodes(s); err == nil {
+			t.Errorf("parseExitCodes(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestTimelinePhaseDurations(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ts := base.Add(d)
+		return &ts
+	}
+
+	// Statuses as seen by successive polls, 10s apart
+	var transitions []statusTransition
+	for i, status := range []string{"PROVISIONING", "PROVISIONING", "PENDING", "RUNNING", "RUNNING", "RUNNING", "STOPPED"} {
+		transitions = recordTransition(transitions, status, *at(time.Duration(i) * 10 * time.Second))
+	}
+	if len(transitions) != 4 {
+		t.Fatalf("recorded %d transitions, want 4: %+v", len(transitions), transitions)
+	}
+
+	task := types.Task{
+		CreatedAt:          at(0),
+		ConnectivityAt:     at(4500 * time.Millisecond),
+		PullStartedAt:      at(5 * time.Second),
+		PullStoppedAt:      at(17 * time.Second),
+		StartedAt:          at(20 * time.Second),
+		ExecutionStoppedAt: at(55 * time.Second),
+		StoppingAt:         at(56 * time.Second),
+		StoppedAt:          at(1 * time.Minute),
+	}
+	var buf bytes.Buffer
+	printTimeline(&buf, transitions, task)
+	out := buf.String()
+
+	for _, want := range []string{
+		"PROVISIONING    at 12:00:00 (for ~20s)",
+		"PENDING         at 12:00:20 (for ~10s)",
+		"RUNNING         at 12:00:30 (for ~30s)",
+		"STOPPED         at 12:01:00\n",
+		"Provisioning (created -> ENI connectivity): 4.5s",
+		"Image pull (pull started -> pull stopped):   12s",
+		"Startup (created -> started):                20s",
+		"Running (started -> execution stopped):      35s",
+		"Stopping (stopping -> stopped):              4s",
+		"Total (created -> stopped):                  1m0s",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("timeline missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPhaseDurationMissingTimestamp(t *testing.T) {
+	now := time.Now()
+	if got := phaseDuration(&now, nil); got != "n/a" {
+		t.Errorf("phaseDuration(now, nil) = %q, want n/a", got)
+	}
+}
+
+// fakeTaskDefinition answers DescribeTaskDefinition with the given
+// containers, or err
+type fakeTaskDefinition struct {
+	containers []types.ContainerDefinition
+	err        error
+}
+
+func (f fakeTaskDefinition) DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &types.TaskDefinition{ContainerDefinitions: f.containers}}, nil
+}
+
+func TestResolveLogConfigDiscoversAwslogs(t *testing.T) {
+	client := fakeTaskDefinition{containers: []types.ContainerDefinition{
+		{Name: aws.String("sidecar")},
+		{
+			Name: aws.String("app"),
+			LogConfiguration: &types.LogConfiguration{
+				LogDriver: types.LogDriverAwslogs,
+				Options: map[string]string{
+					"awslogs-group":         "/ecs/discovered",
+					"awslogs-stream-prefix": "svc",
+				},
+			},
+		},
+	}}
+	fallback := logConfig{Group: "/ecs/flag", StreamPrefix: "ecs", Container: "hello-fargate-oneoff-app-container"}
+
+	got := resolveLogConfig(context.Background(), client, "arn:td", "", fallback)
+	want := logConfig{Group: "/ecs/discovered", StreamPrefix: "svc", Container: "app"}
+	if got != want {
+		t.Fatalf("resolveLogConfig() = %+v, want %+v", got, want)
+	}
+
+	// The tailer reads the discovered group and the stream of the task
+	tailer := newLogTailer(nil, "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc123", got, false)
+	if tailer.group != "/ecs/discovered" || tailer.streamPrefix != "svc/app/abc123" {
+		t.Errorf("tailer group = %q, stream prefix = %q, want /ecs/discovered and svc/app/abc123", tailer.group, tailer.streamPrefix)
+	}
+}
+
+func TestResolveLogConfigFallsBack(t *testing.T) {
+	fallback := logConfig{Group: "/ecs/flag", StreamPrefix: "ecs", Container: "app"}
+	awslogs := &types.LogConfiguration{LogDriver: types.LogDriverAwslogs, Options: map[string]string{"awslogs-group": "/ecs/other"}}
+
+	for name, client := range map[string]fakeTaskDefinition{
+		"describe error":     {err: errors.New("AccessDeniedException")},
+		"no awslogs":         {containers: []types.ContainerDefinition{{Name: aws.String("app")}}},
+		"other container":    {containers: []types.ContainerDefinition{{Name: aws.String("sidecar"), LogConfiguration: awslogs}}},
+		"fluentd log driver": {containers: []types.ContainerDefinition{{Name: aws.String("app"), LogConfiguration: &types.LogConfiguration{LogDriver: types.LogDriverFluentd}}}},
+	} {
+		if got := resolveLogConfig(context.Background(), client, "arn:td", "app", fallback); got != fallback {
+			t.Errorf("%s: resolveLogConfig() = %+v, want the fallback %+v", name, got, fallback)
+		}
+	}
+}
+
+func TestParseTaskOutput(t *testing.T) {
+	lines := []string{
+		"Starting task...",
+		"--- Task Output ---",
+		"{",
+		`  "status": "success",`,
+		`  "message": "Task completed",`,
+		`  "timestamp": "2024-01-01T12:00:00Z"`,
+		"}",
+		"-------------------",
+		"Wrote output to /tmp/output.json",
+	}
+	output, err := parseTaskOutput(lines)
+	if err != nil {
+		t.Fatalf("parseTaskOutput() error = %v", err)
+	}
+	if output.Status != "success" || output.Message != "Task completed" {
+		t.Errorf("parseTaskOutput() = %+v, want status success and message Task completed", output)
+	}
+
+	lines[3] = `  "status": "error",`
+	if output, err := parseTaskOutput(lines); err != nil || output.Status != "error" {
+		t.Errorf("parseTaskOutput() = %+v, %v, want status error", output, err)
+	}
+}
+
+func TestParseTaskOutputInvalid(t *testing.T) {
+	for name, lines := range map[string][]string{
+		"no block":     {"Starting task...", "done"},
+		"invalid JSON": {"--- Task Output ---", "{not json", "-------------------"},
+	} {
+		if _, err := parseTaskOutput(lines); err == nil {
+			t.Errorf("%s: parseTaskOutput() succeeded, want an error", name)
+		}
+	}
+}
+
+// fakeLogStream serves one log stream whose events grow between fetches. A
+// forward token "t<n>" points at the n-th event, and pages hold at most two
+// events, as GetLogEvents pages are limited too.
+type fakeLogStream struct {
+	exists bool
+	events []string
+	tokens []*string // NextToken of each GetLogEvents call
+}
+
+func (f *fakeLogStream) DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	if f.exists {
+		out.LogStreams = []cwtypes.LogStream{{LogStreamName: aws.String(aws.ToString(params.LogStreamNamePrefix) + "-stream")}}
+	}
+	return out, nil
+}
+
+func (f *fakeLogStream) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	f.tokens = append(f.tokens, params.NextToken)
+	start := 0
+	if params.NextToken != nil {
+		start, _ = strconv.Atoi(strings.TrimPrefix(*params.NextToken, "t"))
+	}
+	end := min(start+2, len(f.events))
+	out := &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String(fmt.Sprintf("t%d", end))}
+	for _, msg := range f.events[start:end] {
+		out.Events = append(out.Events, cwtypes.OutputLogEvent{Message: aws.String(msg)})
+	}
+	return out, nil
+}
+
+func TestLogTailerFetchesIncrementally(t *testing.T) {
+	stream := &fakeLogStream{}
+	tailer := newLogTailer(stream, "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc123", logConfig{Group: "/ecs/app", StreamPrefix: "ecs", Container: "app"}, false)
+
+	// Nothing to read until awslogs creates the stream
+	if err := tailer.fetch(context.Background()); err != nil || tailer.streamName != "" || len(stream.tokens) != 0 {
+		t.Fatalf("fetch() before the stream exists = %v, stream %q, %d reads, want nothing read", err, tailer.streamName, len(stream.tokens))
+	}
+
+	stream.exists = true
+	stream.events = []string{"one", "two", "three"}
+	if err := tailer.fetch(context.Background()); err != nil {
+		t.Fatalf("fetch() error = %v", err)
+	}
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(tailer.lines, want) {
+		t.Errorf("lines = %v, want %v", tailer.lines, want)
+	}
+
+	// A later fetch resumes from the forward token and only adds new events
+	stream.events = append(stream.events, "four")
+	stream.tokens = nil
+	if err := tailer.fetch(context.Background()); err != nil {
+		t.Fatalf("fetch() error = %v", err)
+	}
+	if want := []string{"one", "two", "three", "four"}; !reflect.DeepEqual(tailer.lines, want) {
+		t.Errorf("lines = %v, want %v", tailer.lines, want)
+	}
+	if aws.ToString(stream.tokens[0]) != "t3" {
+		t.Errorf("resumed from token %q, want t3", aws.ToString(stream.tokens[0]))
+	}
+
+	// Without new events, the stream is read once and nothing is added
+	stream.tokens = nil
+	if err := tailer.fetch(context.Background()); err != nil || len(tailer.lines) != 4 || len(stream.tokens) != 1 {
+		t.Errorf("fetch() without new events = %v, %d lines after %d reads, want 4 lines after 1 read", err, len(tailer.lines), len(stream.tokens))
+	}
+}
+
+func TestLogTailerRunStopsOnCancel(t *testing.T) {
+	stream := &fakeLogStream{exists: true, events: []string{"one"}}
+	tailer := newLogTailer(stream, "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc123", logConfig{Group: "/ecs/app", StreamPrefix: "ecs", Container: "app"}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tailer.run(ctx, time.Hour)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not return after ctx was cancelled")
+	}
+	if !reflect.DeepEqual(tailer.lines, []string{"one"}) {
+		t.Errorf("lines = %v, want the events read before the cancel", tailer.lines)
+	}
+}
+
+// fakeDescribeTasks returns the given task descriptions in turn, repeating
+// the last one
+type fakeDescribeTasks struct {
+	tasks []types.Task
+	calls int
+}
+
+func (f *fakeDescribeTasks) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	task := f.tasks[min(f.calls, len(f.tasks)-1)]
+	f.calls++
+	return &ecs.DescribeTasksOutput{Tasks: []types.Task{task}}, nil
+}
+
+func TestWaitForStopDeadline(t *testing.T) {
+	client := &fakeDescribeTasks{tasks: []types.Task{
+		{LastStatus: aws.String("PROVISIONING")},
+		{LastStatus: aws.String("PENDING")},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var statuses []string
+	task, err := waitForStop(ctx, client, "cluster", "arn:task", time.Millisecond, func(task types.Task) {
+		statuses = append(statuses, aws.ToString(task.LastStatus))
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitForStop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The diagnostics report the last status seen before the deadline
+	if task == nil || aws.ToString(task.LastStatus) != "PENDING" {
+		t.Errorf("last task = %+v, want the PENDING one", task)
+	}
+	if len(statuses) < 2 || statuses[0] != "PROVISIONING" {
+		t.Errorf("statuses = %v, want PROVISIONING then PENDING", statuses)
+	}
+}
+
+func TestWaitForStopReturnsStoppedTask(t *testing.T) {
+	client := &fakeDescribeTasks{tasks: []types.Task{
+		{LastStatus: aws.String("PROVISIONING")},
+		{
+			LastStatus:    aws.String("STOPPED"),
+			StopCode:      types.TaskStopCodeTaskFailedToStart,
+			StoppedReason: aws.String("CannotPullContainerError: pull access denied"),
+		},
+	}}
+
+	task, err := waitForStop(context.Background(), client, "cluster", "arn:task", time.Millisecond, func(types.Task) {})
+	if err != nil {
+		t.Fatalf("waitForStop() error = %v", err)
+	}
+	if task.StopCode != types.TaskStopCodeTaskFailedToStart || client.calls != 2 {
+		t.Errorf("task stop code = %q after %d calls, want %q after 2", task.StopCode, client.calls, types.TaskStopCodeTaskFailedToStart)
+	}
+}
+
+func TestValidateFargateSize(t *testing.T) {
+	for _, tt := range []struct {
+		cpu, memory int
+		valid       bool
+	}{
+		{256, 512, true},
+		{256, 1024, true},
+		{256, 2048, true},
+		{256, 1536, false},
+		{256, 3072, false},
+		{512, 1024, true},
+		{512, 4096, true},
+		{512, 512, false},
+		{1024, 2048, true},
+		{1024, 8192, true},
+		{1024, 9216, false},
+		{2048, 16384, true},
+		{2048, 2048, false},
+		{4096, 30720, true},
+		{4096, 8704, false},
+		{8192, 16384, true},
+		{8192, 20480, true},
+		{8192, 17408, false}, // 8 vCPU goes in 4 GiB steps
+		{16384, 122880, true},
+		{16384, 36864, false}, // 16 vCPU goes in 8 GiB steps
+		{128, 512, false},
+		{3072, 8192, false},
+	} {
+		err := validateFargateSize(tt.cpu, tt.memory)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateFargateSize(%d, %d) = %v, want valid %v", tt.cpu, tt.memory, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateFargateSizeMessages(t *testing.T) {
+	if err := validateFargateSize(3072, 8192); err == nil || !strings.Contains(err.Error(), "unsupported CPU value 3072") {
+		t.Errorf("validateFargateSize(3072, 8192) = %v, want an unsupported CPU error", err)
+	}
+	// Both 256 CPU ranges are listed
+	if err := validateFargateSize(256, 4096); err == nil || !strings.Contains(err.Error(), "512-512") || !strings.Contains(err.Error(), "1024-2048") {
+		t.Errorf("validateFargateSize(256, 4096) = %v, want the allowed memory ranges", err)
+	}
+}
@@ -1,29 +1,200 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
 )
 
 // Input from Step Functions (original execution input or map item)
 // We expect the raw JSON string in TASK_INPUT env var
 type TaskInput map[string]interface{} // Use a generic map for flexibility
 
-// Output structure for the Initial Step
+// Output structure for the Initial Step. The JSON name of ParallelItems must
+// match the ItemsPath of the ParallelSteps map state
+// ($.initialTaskOutput.parallelItems).
 type InitialStepOutput struct {
-	Message       string        `json:"message"`
-	ParallelItems []interface{} `json:"parallelItems"`
+	Message       string         `json:"message"`
+	ParallelItems []ParallelItem `json:"parallelItems"`
 }
 
-// Output structure for Parallel Tasks
+// ParallelItem is one item of the ParallelSteps map state. Each item is
+// passed as-is as the TASK_INPUT of a parallel task.
+type ParallelItem struct {
+	TaskInput string   `json:"task_input"`      // Work for the parallel task; its presence marks a parallel task
+	Index     int      `json:"index"`           // Position of the item in parallelItems
+	Items     []string `json:"items,omitempty"` // Units of work processed concurrently; defaults to TaskInput alone
+}
+
+// Validate checks that the item conforms to the schema expected by the
+// parallel tasks
+func (item ParallelItem) Validate() error {
+	if item.TaskInput == "" {
+		return errors.New("task_input must not be empty")
+	}
+	if item.Index < 0 {
+		return fmt.Errorf("index must not be negative: %d", item.Index)
+	}
+	for i, unit := range item.Items {
+		if unit == "" {
+			return fmt.Errorf("items[%d] must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// WorkItems returns the units of work of the parallel task
+func (item ParallelItem) WorkItems() []string {
+	if len(item.Items) > 0 {
+		return item.Items
+	}
+	return []string{item.TaskInput}
+}
+
+// defaultUnits are the units of work of an execution whose input has none
+var defaultUnits = []string{"item_A", "item_B", "item_C"}
+
+// InitialStepInput is the part of the execution input read by the initial
+// step
+type InitialStepInput struct {
+	Items        []string `json:"items,omitempty"`        // Units of work; defaults to defaultUnits
+	ItemsPerTask int      `json:"itemsPerTask,omitempty"` // Units per parallel task; defaults to 1
+}
+
+// planParallelItems spreads the units of input over parallel items of
+// ItemsPerTask units each. An item of a single unit has it as its task input;
+// an item of several lists them in Items, with their names joined by commas
+// as its task input.
+func planParallelItems(input InitialStepInput) ([]ParallelItem, error) {
+	units := input.Items
+	if len(units) == 0 {
+		units = defaultUnits
+	}
+	perTask := input.ItemsPerTask
+	if perTask < 0 {
+		return nil, fmt.Errorf("itemsPerTask must not be negative: %d", perTask)
+	}
+	if perTask == 0 {
+		perTask = 1
+	}
+
+	items := make([]ParallelItem, 0, (len(units)+perTask-1)/perTask)
+	for start := 0; start < len(units); start += perTask {
+		chunk := units[start:min(start+perTask, len(units))]
+		item := ParallelItem{TaskInput: strings.Join(chunk, ","), Index: len(items)}
+		if len(chunk) > 1 {
+			item.Items = chunk
+		}
+		if err := item.Validate(); err != nil {
+			return nil, fmt.Errorf("parallel item %d: %w", item.Index, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseParallelItem decodes a parallel task input, rejecting unknown fields
+// and items that fail validation
+func parseParallelItem(input string) (ParallelItem, error) {
+	var item ParallelItem
+	dec := json.NewDecoder(bytes.NewReader([]byte(input)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&item); err != nil {
+		return ParallelItem{}, err
+	}
+	return item, item.Validate()
+}
+
+// Output structure for Parallel Tasks. Partial failures still succeed the
+// task, so the state machine can inspect PartialFailure and Results to decide
+// how to proceed.
 type ParallelTaskOutput struct {
-	ResultMessage string `json:"resultMessage"`
+	ResultMessage  string       `json:"resultMessage"`
+	Results        []ItemResult `json:"results"`
+	Succeeded      int          `json:"succeeded"`
+	Failed         int          `json:"failed"`
+	PartialFailure bool         `json:"partialFailure"`
+}
+
+// ItemResult is the outcome of processing one unit of work
+type ItemResult struct {
+	Item   string `json:"item"`
+	Status string `json:"status"` // "success" or "error"
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errAllItemsFailed is returned by aggregateResults when no item succeeded
+var errAllItemsFailed = errors.New("all items failed")
+
+// processItem does the work for one unit. With a non-empty failPrefix, units
+// starting with it fail, so that partial failures can be exercised end to end.
+func processItem(index int, unit, failPrefix string) (string, error) {
+	if failPrefix != "" && strings.HasPrefix(unit, failPrefix) {
+		return "", fmt.Errorf("simulated failure processing %q", unit)
+	}
+	return fmt.Sprintf("Successfully processed parallel item %d: %s", index, unit), nil
+}
+
+// processItems processes the units of item concurrently and returns their
+// results in order. Units starting with a non-empty failPrefix fail.
+func processItems(item ParallelItem, failPrefix string) []ItemResult {
+	units := item.WorkItems()
+	results := make([]ItemResult, len(units))
+	var wg sync.WaitGroup
+	for i, unit := range units {
+		wg.Add(1)
+		go func(i int, unit string) {
+			defer wg.Done()
+			result := ItemResult{Item: unit, Status: "success"}
+			msg, err := processItem(item.Index, unit, failPrefix)
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			} else {
+				result.Result = msg
+			}
+			results[i] = result
+		}(i, unit)
+	}
+	wg.Wait()
+	return results
+}
+
+// aggregateResults summarizes the item results of parallel item index. It
+// returns errAllItemsFailed, along with the output, when no item succeeded.
+func aggregateResults(index int, results []ItemResult) (ParallelTaskOutput, error) {
+	output := ParallelTaskOutput{Results: results}
+	for _, result := range results {
+		if result.Status == "success" {
+			output.Succeeded++
+		} else {
+			output.Failed++
+		}
+	}
+	output.PartialFailure = output.Succeeded > 0 && output.Failed > 0
+	output.ResultMessage = fmt.Sprintf("Processed parallel item %d: %d of %d items succeeded", index, output.Succeeded, len(results))
+
+	if output.Succeeded == 0 {
+		return output, errAllItemsFailed
+	}
+	return output, nil
 }
 
 func main() {
@@ -36,52 +207,88 @@ func main() {
 		log.Fatal("Error: AWS_STEP_FUNCTIONS_TASK_TOKEN environment variable not set.")
 	}
 
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load AWS SDK config: %v", err)
+	}
+	// The Runner retries sending the result itself, telling apart errors that
+	// retrying cannot fix, so the SDK makes a single attempt per call
+	runner := NewRunner(sfn.NewFromConfig(cfg, func(o *sfn.Options) { o.RetryMaxAttempts = 1 }), taskToken)
+
+	// Keep the task token alive while the task runs, so that a state with
+	// HeartbeatSeconds does not time the task out
+	heartbeatInterval, err := heartbeatIntervalFromEnv()
+	if err != nil {
+		runner.sendFailure(ctx, "InvalidConfig", err.Error())
+		log.Fatalf("Error: %v", err)
+	}
+	if heartbeatInterval > 0 {
+		runner.startHeartbeat(ctx, heartbeatInterval)
+	}
+
 	inputJsonString := os.Getenv("TASK_INPUT")
 	if inputJsonString == "" {
-		sendFailure(ctx, taskToken, "MissingInput", "TASK_INPUT environment variable not set.")
+		runner.sendFailure(ctx, "MissingInput", "TASK_INPUT environment variable not set.")
 		log.Fatal("Error: TASK_INPUT environment variable not set.")
 	}
 
 	var taskInput TaskInput
-	err := json.Unmarshal([]byte(inputJsonString), &taskInput)
+	err = json.Unmarshal([]byte(inputJsonString), &taskInput)
 	if err != nil {
-		sendFailure(ctx, taskToken, "InvalidInputJSON", fmt.Sprintf("Failed to unmarshal TASK_INPUT: %v", err))
+		runner.sendFailure(ctx, "InvalidInputJSON", fmt.Sprintf("Failed to unmarshal TASK_INPUT: %v", err))
 		log.Fatalf("Error unmarshalling TASK_INPUT: %v\n", err)
 	}
 	log.Printf("Received input: %+v\n", taskInput)
 
 	// --- Task Logic ---
 	// Determine if this is the initial step or a parallel step based on input
-	// A simple heuristic: if input contains a specific key added by the parallel step item, it's parallel.
-	// In our TF definition, the parallel item is the whole object {"task_input": "..."}, so check for "task_input"
+	// Parallel items emitted by the initial step always carry "task_input";
+	// in our TF definition the item is passed as the whole TASK_INPUT
 	var outputJsonBytes []byte
 	if _, isParallelTask := taskInput["task_input"]; isParallelTask {
 		// Logic for Parallel Task
 		log.Println("Running as a parallel task.")
-		// Process the item (taskInput contains the item)
-		resultMsg := fmt.Sprintf("Successfully processed parallel item: %v", taskInput)
-		output := ParallelTaskOutput{ResultMessage: resultMsg}
+		item, err := parseParallelItem(inputJsonString)
+		if err != nil {
+			runner.sendFailure(ctx, "InvalidParallelItem", fmt.Sprintf("TASK_INPUT is not a valid parallel item: %v", err))
+			log.Fatalf("Error: TASK_INPUT is not a valid parallel item: %v\n", err)
+		}
+		// Process the units of work concurrently; only a task in which every
+		// unit failed is reported as failed
+		output, err := aggregateResults(item.Index, processItems(item, os.Getenv("ITEM_FAIL_PREFIX")))
+		if err != nil {
+			runner.sendFailure(ctx, "AllItemsFailed", fmt.Sprintf("%s: %+v", output.ResultMessage, output.Results))
+			log.Fatalf("Error: parallel item %d: %v\n", item.Index, err)
+		}
+		if output.PartialFailure {
+			log.Printf("Warning: %d of %d items failed\n", output.Failed, len(output.Results))
+		}
 		outputJsonBytes, err = json.Marshal(output)
 		if err != nil {
-			sendFailure(ctx, taskToken, "OutputMarshalError", fmt.Sprintf("Failed to marshal parallel task output: %v", err))
+			runner.sendFailure(ctx, "OutputMarshalError", fmt.Sprintf("Failed to marshal parallel task output: %v", err))
 			log.Fatalf("Error marshalling parallel task output: %v\n", err)
 		}
 	} else {
 		// Logic for Initial Step
 		log.Println("Running as the initial task.")
-		// Generate dummy items for the map state
-		dummyItems := []interface{}{
-			map[string]string{"task_input": "item_A"},
-			map[string]string{"task_input": "item_B"},
-			map[string]string{"task_input": "item_C"},
+		// Spread the units of work of the execution input over the map state
+		var initialInput InitialStepInput
+		if err := json.Unmarshal([]byte(inputJsonString), &initialInput); err != nil {
+			runner.sendFailure(ctx, "InvalidInputJSON", fmt.Sprintf("TASK_INPUT is not a valid initial step input: %v", err))
+			log.Fatalf("Error: TASK_INPUT is not a valid initial step input: %v\n", err)
+		}
+		items, err := planParallelItems(initialInput)
+		if err != nil {
+			runner.sendFailure(ctx, "InvalidParallelItem", err.Error())
+			log.Fatalf("Error generating parallel items: %v\n", err)
 		}
 		output := InitialStepOutput{
 			Message:       "Output from Initial Step",
-			ParallelItems: dummyItems,
+			ParallelItems: items,
 		}
 		outputJsonBytes, err = json.Marshal(output)
 		if err != nil {
-			sendFailure(ctx, taskToken, "OutputMarshalError", fmt.Sprintf("Failed to marshal initial task output: %v", err))
+			runner.sendFailure(ctx, "OutputMarshalError", fmt.Sprintf("Failed to marshal initial task output: %v", err))
 			log.Fatalf("Error marshalling initial task output: %v\n", err)
 		}
 	}
@@ -90,44 +297,225 @@ func main() {
 	log.Println("Sending success to Step Functions...")
 	// Log the output being sent
 	log.Printf("Output being sent to SFN: %s\n", string(outputJsonBytes))
-	sendSuccess(ctx, taskToken, string(outputJsonBytes))
+	if err := runner.sendSuccess(ctx, string(outputJsonBytes)); err != nil {
+		// If sending success fails, we can't really send failure anymore.
+		log.Fatalf("Failed to send task success to Step Functions: %v", err)
+	}
 	log.Println("Fargate task finished successfully.")
 }
 
-// Helper function to send success
-func sendSuccess(ctx context.Context, token, output string) {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatalf("Failed to load AWS SDK config: %v", err)
+// SFNAPI is the subset of the Step Functions client used to report the task
+// result, so that tests can substitute a fake
+type SFNAPI interface {
+	SendTaskSuccess(ctx context.Context, params *sfn.SendTaskSuccessInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskSuccessOutput, error)
+	SendTaskFailure(ctx context.Context, params *sfn.SendTaskFailureInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskFailureOutput, error)
+	SendTaskHeartbeat(ctx context.Context, params *sfn.SendTaskHeartbeatInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskHeartbeatOutput, error)
+}
+
+// Runner reports the outcome of the task identified by taskToken to Step
+// Functions
+type Runner struct {
+	client        SFNAPI
+	taskToken     string
+	backoff       time.Duration // Delay before the first retry of a result
+	stopHeartbeat func()        // Set while heartbeats are being sent
+}
+
+// resultAttempts is how many times the task result is sent before giving up
+const resultAttempts = 5
+
+// defaultHeartbeatInterval is how often heartbeats are sent unless
+// SFN_HEARTBEAT_SECONDS says otherwise
+const defaultHeartbeatInterval = 60 * time.Second
+
+// heartbeatIntervalFromEnv reads SFN_HEARTBEAT_SECONDS; 0 disables heartbeats
+func heartbeatIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("SFN_HEARTBEAT_SECONDS")
+	if value == "" {
+		return defaultHeartbeatInterval, nil
 	}
-	sfnClient := sfn.NewFromConfig(cfg)
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid SFN_HEARTBEAT_SECONDS %q: must be a non-negative integer", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
 
-	_, err = sfnClient.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
-		TaskToken: &token,
-		Output:    &output,
-	})
-	if err != nil {
-		// If sending success fails, we can't really send failure anymore.
-		log.Fatalf("Failed to send task success to Step Functions: %v", err)
+// NewRunner returns a Runner that reports through client
+func NewRunner(client SFNAPI, taskToken string) *Runner {
+	return &Runner{client: client, taskToken: taskToken, backoff: time.Second}
+}
+
+// startHeartbeat sends a heartbeat every interval in the background until
+// the task result is sent. A failed heartbeat is logged and retried on the
+// next tick.
+func (r *Runner) startHeartbeat(ctx context.Context, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_, err := r.client.SendTaskHeartbeat(ctx, &sfn.SendTaskHeartbeatInput{TaskToken: &r.taskToken})
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Warning: Failed to send task heartbeat: %v", err)
+				}
+			}
+		}
+	}()
+
+	r.stopHeartbeat = func() {
+		cancel()
+		<-done
 	}
-	log.Println("Successfully sent task success.")
+	log.Printf("Sending task heartbeats every %v.", interval)
 }
 
-// Helper function to send failure
-func sendFailure(ctx context.Context, token, errorCause, errorMessage string) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// endHeartbeat stops the heartbeats, if any, and waits for an in-flight one
+// to finish so that no heartbeat follows the task result
+func (r *Runner) endHeartbeat() {
+	if r.stopHeartbeat != nil {
+		r.stopHeartbeat()
+		r.stopHeartbeat = nil
+	}
+}
+
+// sendSuccess reports the task as succeeded with the given JSON output,
+// retrying transient errors
+func (r *Runner) sendSuccess(ctx context.Context, output string) error {
+	r.endHeartbeat()
+	err := r.sendWithRetry(ctx, "SendTaskSuccess", func() error {
+		_, err := r.client.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
+			TaskToken: &r.taskToken,
+			Output:    &output,
+		})
+		return err
+	})
 	if err != nil {
-		log.Printf("Warning: Failed to load AWS SDK config for sending failure: %v", err)
-		return // Don't fatal error if we can't report the failure
+		return err
 	}
-	sfnClient := sfn.NewFromConfig(cfg)
+	log.Println("Successfully sent task success.")
+	return nil
+}
 
-	_, err = sfnClient.SendTaskFailure(ctx, &sfn.SendTaskFailureInput{
-		TaskToken: &token,
-		Error:     &errorCause,   // Short error identifier
-		Cause:     &errorMessage, // Longer description
+// sendFailure reports the task as failed, retrying transient errors. Errors
+// are only logged, since the caller is about to exit anyway.
+func (r *Runner) sendFailure(ctx context.Context, errorCause, errorMessage string) {
+	r.endHeartbeat()
+	cause := truncateCause(errorMessage)
+	err := r.sendWithRetry(ctx, "SendTaskFailure", func() error {
+		_, err := r.client.SendTaskFailure(ctx, &sfn.SendTaskFailureInput{
+			TaskToken: &r.taskToken,
+			Error:     &errorCause, // Short error identifier
+			Cause:     &cause,      // Longer description
+		})
+		return err
 	})
 	if err != nil {
 		log.Printf("Warning: Failed to send task failure to Step Functions: %v", err)
 	}
 }
+
+// maxCauseLength is the longest cause SendTaskFailure accepts
+const maxCauseLength = 32768
+
+// truncateCause shortens cause to fit in maxCauseLength bytes, cutting at a
+// UTF-8 character boundary and marking the cut
+func truncateCause(cause string) string {
+	if len(cause) <= maxCauseLength {
+		return cause
+	}
+	const marker = "... (truncated)"
+	cut := maxCauseLength - len(marker)
+	for cut > 0 && !utf8.RuneStart(cause[cut]) {
+		cut--
+	}
+	return cause[:cut] + marker
+}
+
+// sendWithRetry calls send up to resultAttempts times, backing off
+// exponentially between attempts as long as the error is transient. An
+// attempt that timed out may still have reached Step Functions, in which
+// case the task is closed by the time of the retry; a retry failing because
+// the task timed out or no longer exists then counts as delivered.
+func (r *Runner) sendWithRetry(ctx context.Context, op string, send func() error) error {
+	backoff := r.backoff
+	maybeDelivered := 0 // Last attempt that timed out, if any
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if maybeDelivered > 0 && isTaskClosedError(err) {
+			log.Printf("%s failed (attempt %d/%d) as the task is closed; attempt %d, which timed out, was likely delivered: %v",
+				op, attempt, resultAttempts, maybeDelivered, err)
+			return nil
+		}
+		if !isRetryableSendError(err) {
+			log.Printf("%s failed (attempt %d/%d), not retrying: %v", op, attempt, resultAttempts, err)
+			return err
+		}
+		if isTimeoutError(err) {
+			maybeDelivered = attempt
+		}
+		if attempt >= resultAttempts {
+			log.Printf("%s failed (attempt %d/%d), giving up: %v", op, attempt, resultAttempts, err)
+			return err
+		}
+
+		log.Printf("%s failed (attempt %d/%d), retrying in %v: %v", op, attempt, resultAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryableSendError reports whether sending the task result again may
+// succeed: throttling, 5xx and timeout errors are retried, whereas a task
+// that timed out, no longer exists or has an invalid token or output is not
+func isRetryableSendError(err error) bool {
+	var (
+		invalidToken  *sfntypes.InvalidToken
+		invalidOutput *sfntypes.InvalidOutput
+	)
+	if isTaskClosedError(err) || errors.As(err, &invalidToken) || errors.As(err, &invalidOutput) {
+		return false
+	}
+
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool() {
+		return true
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500 {
+		return true
+	}
+
+	return isTimeoutError(err)
+}
+
+// isTaskClosedError reports whether err says the task timed out or no longer
+// exists, which is also what Step Functions returns for a task whose result
+// was already sent
+func isTaskClosedError(err error) bool {
+	var (
+		timedOut     *sfntypes.TaskTimedOut
+		doesNotExist *sfntypes.TaskDoesNotExist
+	)
+	return errors.As(err, &timedOut) || errors.As(err, &doesNotExist)
+}
+
+// isTimeoutError reports whether err is a network timeout, after which the
+// request may or may not have been processed
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestInitialStepItemsConformToSchema(t *testing.T) {
+	items, err := planParallelItems(InitialStepInput{})
+	if err != nil {
+		t.Fatalf("planParallelItems() error = %v", err)
+	}
+	data, err := json.Marshal(InitialStepOutput{Message: "Output from Initial Step", ParallelItems: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The map state reads $.initialTaskOutput.parallelItems and passes each
+	// item as the TASK_INPUT of a parallel task
+	var output struct {
+		ParallelItems []json.RawMessage `json:"parallelItems"`
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.ParallelItems) != 3 {
+		t.Fatalf("emitted %d parallel items, want 3: %s", len(output.ParallelItems), data)
+	}
+	for i, raw := range output.ParallelItems {
+		item, err := parseParallelItem(string(raw))
+		if err != nil {
+			t.Errorf("parallel item %s does not parse: %v", raw, err)
+			continue
+		}
+		if want := (ParallelItem{TaskInput: items[i].TaskInput, Index: i}); !reflect.DeepEqual(item, want) {
+			t.Errorf("parallel item %d = %+v, want %+v", i, item, want)
+		}
+	}
+}
+
+func TestPlanParallelItemsSpreadsUnits(t *testing.T) {
+	items, err := planParallelItems(InitialStepInput{Items: []string{"a", "b", "c", "d", "e"}, ItemsPerTask: 2})
+	if err != nil {
+		t.Fatalf("planParallelItems() error = %v", err)
+	}
+	want := []ParallelItem{
+		{TaskInput: "a,b", Index: 0, Items: []string{"a", "b"}},
+		{TaskInput: "c,d", Index: 1, Items: []string{"c", "d"}},
+		{TaskInput: "e", Index: 2},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("planParallelItems() = %+v, want %+v", items, want)
+	}
+
+	// The planned items round-trip through the map state as parallel items
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := parseParallelItem(string(data))
+		if err != nil || !reflect.DeepEqual(parsed.WorkItems(), item.WorkItems()) {
+			t.Errorf("parallel item %s parsed as %+v, %v, want work items %v", data, parsed, err, item.WorkItems())
+		}
+	}
+}
+
+func TestPlanParallelItemsRejectsInvalidInput(t *testing.T) {
+	for _, input := range []InitialStepInput{
+		{Items: []string{"item_A", ""}},
+		{Items: []string{"item_A", ""}, ItemsPerTask: 2},
+		{ItemsPerTask: -1},
+	} {
+		if items, err := planParallelItems(input); err == nil {
+			t.Errorf("planParallelItems(%+v) = %+v, want an error", input, items)
+		}
+	}
+}
+
+func TestProcessItemsFailPrefix(t *testing.T) {
+	item := ParallelItem{TaskInput: "a", Index: 1, Items: []string{"ok_1", "fail_2", "ok_3"}}
+
+	// Without a prefix configured, nothing fails on purpose
+	for _, result := range processItems(item, "") {
+		if result.Status != "success" {
+			t.Errorf("without ITEM_FAIL_PREFIX, %s = %+v, want success", result.Item, result)
+		}
+	}
+
+	results := processItems(item, "fail")
+	var statuses []string
+	for i, result := range results {
+		if result.Item != item.Items[i] {
+			t.Errorf("result %d is for %s, want %s", i, result.Item, item.Items[i])
+		}
+		statuses = append(statuses, result.Status)
+	}
+	if want := []string{"success", "error", "success"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if results[1].Error == "" || results[1].Result != "" || results[0].Result == "" {
+		t.Errorf("results = %+v, want an error for fail_2 and results for the others", results)
+	}
+}
+
+func TestAggregateResults(t *testing.T) {
+	ok := ItemResult{Item: "a", Status: "success", Result: "done"}
+	failed := ItemResult{Item: "b", Status: "error", Error: "boom"}
+	for _, tt := range []struct {
+		name              string
+		results           []ItemResult
+		succeeded, failed int
+		partial           bool
+		wantErr           error
+	}{
+		{"all succeeded", []ItemResult{ok, ok}, 2, 0, false, nil},
+		{"partial failure", []ItemResult{ok, failed, ok}, 2, 1, true, nil},
+		{"all failed", []ItemResult{failed, failed}, 0, 2, false, errAllItemsFailed},
+	} {
+		output, err := aggregateResults(4, tt.results)
+		if err != tt.wantErr {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if output.Succeeded != tt.succeeded || output.Failed != tt.failed || output.PartialFailure != tt.partial {
+			t.Errorf("%s: %d succeeded, %d failed, partial %v, want %d, %d, %v",
+				tt.name, output.Succeeded, output.Failed, output.PartialFailure, tt.succeeded, tt.failed, tt.partial)
+		}
+		if !reflect.DeepEqual(output.Results, tt.results) {
+			t.Errorf("%s: results = %+v, want them passed through", tt.name, output.Results)
+		}
+		want := fmt.Sprintf("Processed parallel item 4: %d of %d items succeeded", tt.succeeded, len(tt.results))
+		if output.ResultMessage != want {
+			t.Errorf("%s: message = %q, want %q", tt.name, output.ResultMessage, want)
+		}
+	}
+}
+
+func TestTruncateCause(t *testing.T) {
+	if short := "item_B failed"; truncateCause(short) != short {
+		t.Errorf("truncateCause(%q) = %q, want it unchanged", short, truncateCause(short))
+	}
+	exact := strings.Repeat("x", maxCauseLength)
+	if truncateCause(exact) != exact {
+		t.Error("truncateCause() shortened a cause of exactly maxCauseLength")
+	}
+
+	// A multi-byte character is not split at the cut
+	long := strings.Repeat("é", maxCauseLength)
+	got := truncateCause(long)
+	if len(got) > maxCauseLength || !utf8.ValidString(got) || !strings.HasSuffix(got, "... (truncated)") {
+		t.Errorf("truncateCause() of %d bytes = %d bytes, valid UTF-8 %v, want at most %d valid bytes marked as truncated",
+			len(long), len(got), utf8.ValidString(got), maxCauseLength)
+	}
+}
+
+func TestRunnerSendFailureTruncatesCause(t *testing.T) {
+	client := &fakeSFN{}
+	NewRunner(client, "token-1").sendFailure(context.Background(), "AllItemsFailed", strings.Repeat("x", 100000))
+	if len(client.failures) != 1 || len(aws.ToString(client.failures[0].Cause)) > maxCauseLength {
+		t.Errorf("sent %d failures, want 1 with a cause of at most %d bytes", len(client.failures), maxCauseLength)
+	}
+}
+
+func TestParseParallelItemRejectsInvalidItems(t *testing.T) {
+	for _, input := range []string{
+		`{"index":0}`,
+		`{"task_input":"item_A","index":-1}`,
+		`{"task_input":"item_A","index":0,"items":["a",""]}`,
+		`{"task_input":"item_A","index":0,"extra":true}`,
+		`not json`,
+	} {
+		if item, err := parseParallelItem(input); err == nil {
+			t.Errorf("parseParallelItem(%s) = %+v, want an error", input, item)
+		}
+	}
+}
+
+// fakeSFN records the calls the Runner makes. The task result calls fail
+// with the errors in errs, one per call, before succeeding.
+type fakeSFN struct {
+	mu              sync.Mutex
+	successes       []*sfn.SendTaskSuccessInput
+	failures        []*sfn.SendTaskFailureInput
+	heartbeats      int
+	heartbeatTokens []string
+	errs            []error
+}
+
+// nextErr returns the error for the next task result call; f.mu must be held
+func (f *fakeSFN) nextErr() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *fakeSFN) SendTaskSuccess(ctx context.Context, params *sfn.SendTaskSuccessInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskSuccessOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.successes = append(f.successes, params)
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sfn.SendTaskSuccessOutput{}, nil
+}
+
+func (f *fakeSFN) SendTaskFailure(ctx context.Context, params *sfn.SendTaskFailureInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskFailureOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, params)
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sfn.SendTaskFailureOutput{}, nil
+}
+
+func (f *fakeSFN) SendTaskHeartbeat(ctx context.Context, params *sfn.SendTaskHeartbeatInput, optFns ...func(*sfn.Options)) (*sfn.SendTaskHeartbeatOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heartbeats++
+	f.heartbeatTokens = append(f.heartbeatTokens, aws.ToString(params.TaskToken))
+	return &sfn.SendTaskHeartbeatOutput{}, nil
+}
+
+func TestRunnerSendSuccess(t *testing.T) {
+	client := &fakeSFN{}
+	r := NewRunner(client, "token-1")
+
+	if err := r.sendSuccess(context.Background(), `{"ok":true}`); err != nil {
+		t.Fatalf("sendSuccess() error = %v", err)
+	}
+	if len(client.successes) != 1 || len(client.failures) != 0 {
+		t.Fatalf("sent %d successes and %d failures, want 1 and 0", len(client.successes), len(client.failures))
+	}
+	got := client.successes[0]
+	if aws.ToString(got.TaskToken) != "token-1" || aws.ToString(got.Output) != `{"ok":true}` {
+		t.Errorf("SendTaskSuccess(token %q, output %q), want token-1 and the output", aws.ToString(got.TaskToken), aws.ToString(got.Output))
+	}
+}
+
+func TestRunnerSendFailure(t *testing.T) {
+	client := &fakeSFN{}
+	r := NewRunner(client, "token-1")
+
+	r.sendFailure(context.Background(), "ProcessingError", "item_B failed")
+	if len(client.failures) != 1 || len(client.successes) != 0 {
+		t.Fatalf("sent %d failures and %d successes, want 1 and 0", len(client.failures), len(client.successes))
+	}
+	got := client.failures[0]
+	if aws.ToString(got.TaskToken) != "token-1" || aws.ToString(got.Error) != "ProcessingError" || aws.ToString(got.Cause) != "item_B failed" {
+		t.Errorf("SendTaskFailure(token %q, error %q, cause %q), want token-1, ProcessingError and item_B failed",
+			aws.ToString(got.TaskToken), aws.ToString(got.Error), aws.ToString(got.Cause))
+	}
+}
+
+func TestRunnerStopsHeartbeatBeforeResult(t *testing.T) {
+	client := &fakeSFN{}
+	r := NewRunner(client, "token-1")
+	r.startHeartbeat(context.Background(), 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	if err := r.sendSuccess(context.Background(), "{}"); err != nil {
+		t.Fatalf("sendSuccess() error = %v", err)
+	}
+	client.mu.Lock()
+	beats := client.heartbeats
+	client.mu.Unlock()
+	if beats == 0 {
+		t.Error("no heartbeat was sent before the result")
+	}
+	time.Sleep(20 * time.Millisecond)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.heartbeats != beats {
+		t.Errorf("heartbeats went from %d to %d after the result, want none", beats, client.heartbeats)
+	}
+}
+
+func TestHeartbeatIntervalFromEnv(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": defaultHeartbeatInterval, "0": 0, "30": 30 * time.Second} {
+		t.Setenv("SFN_HEARTBEAT_SECONDS", value)
+		if got, err := heartbeatIntervalFromEnv(); err != nil || got != want {
+			t.Errorf("SFN_HEARTBEAT_SECONDS=%q: heartbeatIntervalFromEnv() = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"-1", "1.5", "soon"} {
+		t.Setenv("SFN_HEARTBEAT_SECONDS", value)
+		if _, err := heartbeatIntervalFromEnv(); err == nil {
+			t.Errorf("SFN_HEARTBEAT_SECONDS=%q: heartbeatIntervalFromEnv() succeeded, want an error", value)
+		}
+	}
+}
+
+func TestRunnerHeartbeatsOnSchedule(t *testing.T) {
+	client := &fakeSFN{}
+	r := NewRunner(client, "token-1")
+	r.startHeartbeat(context.Background(), 20*time.Millisecond)
+
+	// No heartbeat before the first interval has passed
+	time.Sleep(5 * time.Millisecond)
+	client.mu.Lock()
+	early := client.heartbeats
+	client.mu.Unlock()
+	if early != 0 {
+		t.Errorf("%d heartbeats after 5ms with a 20ms interval, want 0", early)
+	}
+
+	time.Sleep(105 * time.Millisecond)
+	r.endHeartbeat()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	// About 5 ticks in 110ms; allow for a slow scheduler
+	if client.heartbeats < 3 || client.heartbeats > 6 {
+		t.Errorf("%d heartbeats in 110ms with a 20ms interval, want about 5", client.heartbeats)
+	}
+	for _, token := range client.heartbeatTokens {
+		if token != "token-1" {
+			t.Errorf("heartbeat sent with token %q, want token-1", token)
+		}
+	}
+}
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var throttled = &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+
+func TestRunnerSendSuccessRetriesTransientErrors(t *testing.T) {
+	client := &fakeSFN{errs: []error{throttled, throttled}}
+	r := NewRunner(client, "token-1")
+	r.backoff = time.Millisecond
+
+	if err := r.sendSuccess(context.Background(), "{}"); err != nil {
+		t.Fatalf("sendSuccess() error = %v", err)
+	}
+	if len(client.successes) != 3 {
+		t.Errorf("SendTaskSuccess called %d times, want 3 (two failures, then success)", len(client.successes))
+	}
+}
+
+func TestRunnerSendFailureRetriesTransientErrors(t *testing.T) {
+	client := &fakeSFN{errs: []error{&smithy.OperationError{ServiceID: "SFN", OperationName: "SendTaskFailure", Err: timeoutError{}}, throttled}}
+	r := NewRunner(client, "token-1")
+	r.backoff = time.Millisecond
+
+	r.sendFailure(context.Background(), "AllItemsFailed", "every unit failed")
+	if len(client.failures) != 3 {
+		t.Errorf("SendTaskFailure called %d times, want 3", len(client.failures))
+	}
+}
+
+func TestRunnerSendSuccessGivesUp(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		errs      []error
+		wantCalls int
+	}{
+		{"terminal error", []error{&sfntypes.InvalidToken{Message: aws.String("bad token")}}, 1},
+		{"task timed out", []error{&sfntypes.TaskTimedOut{Message: aws.String("timed out")}}, 1},
+		{"still throttled", []error{throttled, throttled, throttled, throttled, throttled, throttled}, resultAttempts},
+	} {
+		client := &fakeSFN{errs: tt.errs}
+		r := NewRunner(client, "token-1")
+		r.backoff = time.Millisecond
+
+		if err := r.sendSuccess(context.Background(), "{}"); err == nil {
+			t.Errorf("%s: sendSuccess() succeeded, want an error", tt.name)
+		}
+		if len(client.successes) != tt.wantCalls {
+			t.Errorf("%s: SendTaskSuccess called %d times, want %d", tt.name, len(client.successes), tt.wantCalls)
+		}
+	}
+}
+
+func TestRunnerSendSuccessAfterTimedOutDelivery(t *testing.T) {
+	// The first attempt reached Step Functions but its response timed out, so
+	// the retry finds the task closed
+	client := &fakeSFN{errs: []error{timeoutError{}, &sfntypes.TaskTimedOut{Message: aws.String("Task Timed Out")}}}
+	r := NewRunner(client, "token-1")
+	r.backoff = time.Millisecond
+
+	if err := r.sendSuccess(context.Background(), "{}"); err != nil {
+		t.Errorf("sendSuccess() = %v, want the timed-out attempt counted as delivered", err)
+	}
+	if len(client.successes) != 2 {
+		t.Errorf("SendTaskSuccess called %d times, want 2", len(client.successes))
+	}
+
+	// Without a timed-out attempt, a closed task is an error
+	client = &fakeSFN{errs: []error{throttled, &sfntypes.TaskDoesNotExist{Message: aws.String("gone")}}}
+	r = NewRunner(client, "token-1")
+	r.backoff = time.Millisecond
+	if err := r.sendSuccess(context.Background(), "{}"); err == nil {
+		t.Error("sendSuccess() succeeded after a throttled attempt and a closed task, want an error")
+	}
+}
+
+func TestRunnerSendWithRetryCancelled(t *testing.T) {
+	client := &fakeSFN{errs: []error{throttled, throttled}}
+	r := NewRunner(client, "token-1")
+	r.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.sendSuccess(ctx, "{}"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sendSuccess() = %v, want the context error while backing off", err)
+	}
+}
+
+func TestRunnerUsesInjectedClient(t *testing.T) {
+	// One client serves every call of the process: heartbeats and the result
+	client := &fakeSFN{}
+	r := NewRunner(client, "token-1")
+	r.startHeartbeat(context.Background(), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	r.sendFailure(context.Background(), "InvalidConfig", "bad config")
+
+	other := NewRunner(client, "token-2")
+	if err := other.sendSuccess(context.Background(), "{}"); err != nil {
+		t.Fatalf("sendSuccess() error = %v", err)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.heartbeats == 0 || len(client.failures) != 1 || len(client.successes) != 1 {
+		t.Fatalf("injected client saw %d heartbeats, %d failures and %d successes, want some, 1 and 1",
+			client.heartbeats, len(client.failures), len(client.successes))
+	}
+	if got := aws.ToString(client.failures[0].TaskToken); got != "token-1" {
+		t.Errorf("failure sent with token %q, want token-1", got)
+	}
+	if got := aws.ToString(client.successes[0].TaskToken); got != "token-2" {
+		t.Errorf("success sent with token %q, want token-2", got)
+	}
+}
@@ -19,6 +19,12 @@ variable "task_memory" {
   type        = number
   default     = 512
 }
+
+variable "item_fail_prefix" {
+  description = "Units of work starting with this prefix fail on purpose, to exercise partial failures; empty disables it (Optional, set via TF_ITEM_FAIL_PREFIX env var)"
+  type        = string
+  default     = ""
+}
 variable "subnet_ids" {
   description = "List of subnet IDs for Fargate task networking (Set via TF_SUBNET_IDS env var, comma-separated)"
   type        = list(string)
@@ -144,6 +150,10 @@ resource "aws_ecs_task_definition" "app_task" {
         {
           name  = "AWS_STEP_FUNCTIONS_TASK_TOKEN",
           value = "dummy" // Placeholder, will be overridden by SFN 
+        },
+        {
+          name  = "ITEM_FAIL_PREFIX",
+          value = var.item_fail_prefix
         }
       ]
       logConfiguration = {
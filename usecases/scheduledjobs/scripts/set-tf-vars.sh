@@ -101,4 +101,9 @@ fi
 # 8. TF_VAR_task_memory
 if [[ -n "$TF_TASK_MEMORY" ]]; then
     echo "export TF_VAR_task_memory=${TF_TASK_MEMORY}"
+fi
+
+# 9. TF_VAR_item_fail_prefix
+if [[ -n "$TF_ITEM_FAIL_PREFIX" ]]; then
+    echo "export TF_VAR_item_fail_prefix=\"${TF_ITEM_FAIL_PREFIX}\""
 fi 
\ No newline at end of file
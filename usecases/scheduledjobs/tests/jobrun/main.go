@@ -3,18 +3,27 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	eventtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
 	"github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"github.com/example/hello-fargate-harness"
 )
 
 func main() {
@@ -23,6 +32,18 @@ func main() {
 	testMode := flag.String("mode", "direct", "Test mode: 'direct' for direct Step Functions execution, 'eventbridge' for EventBridge trigger, 'scheduled' for scheduled EventBridge trigger")
 	eventBusName := flag.String("event-bus", "default", "EventBridge event bus name (for eventbridge mode)")
 	scheduledDelayMinutes := flag.Int("scheduled-delay", 1, "Minutes to wait before scheduled execution (for scheduled mode)")
+	scheduleExpression := flag.String("schedule-expression", "", "Raw EventBridge schedule expression such as 'rate(1 minute)' or 'cron(0/5 * * * ? *)', used instead of the one-time cron computed from -scheduled-delay (for scheduled mode)")
+	roleArn := flag.String("role-arn", "", "IAM role EventBridge assumes to start the state machine (for scheduled mode); discovered from the existing schedule rule when empty")
+	scheduleWait := flag.Duration("schedule-wait", 10*time.Minute, "Maximum time to wait for the first execution triggered by -schedule-expression (for scheduled mode)")
+	inputS3Bucket := flag.String("input-s3-bucket", "", "S3 bucket to upload inputs larger than 256KB to; the execution then receives {\"inputS3Uri\": \"s3://...\"} instead")
+	rawOutput := flag.Bool("raw-output", false, "Print the execution output as-is instead of pretty-printing it")
+	outputFile := flag.String("output-file", "", "Also write the execution output to this file")
+	maxRetries := flag.Int("max-retries", 2, "Maximum retries per AWS API call (throttling and transient errors)")
+	opTimeout := flag.Duration("op-timeout", 30*time.Second, "Timeout for each AWS API call, retries included (0 disables it)")
+	maxHistoryEvents := flag.Int("max-history-events", 1000, "Maximum execution history events to scan for the failure cause of a failed execution (0 disables the scan)")
+	failureEvents := flag.Int("failure-events", 5, "Number of most recent failed task events (with their state names) to print for a failed execution")
+	express := flag.Bool("express", false, "Run an EXPRESS state machine synchronously with StartSyncExecution and print its result without polling (direct mode only)")
+	count := flag.Int("count", 1, "Number of events to send in eventbridge mode; each must start its own execution, matched by correlation ID")
 	flag.Parse()
 
 	if *stateMachineArn == "" {
@@ -31,23 +52,78 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *count < 1 {
+		log.Fatalf("Invalid -count %d: must be at least 1", *count)
+	}
+	if *count > 1 && *testMode != "eventbridge" {
+		log.Fatalf("-count is only supported in eventbridge mode")
+	}
+	if *count > 1 && *outputFile != "" {
+		log.Fatalf("-output-file cannot be combined with -count > 1")
+	}
+	if *scheduleExpression != "" {
+		if err := validateScheduleExpression(*scheduleExpression); err != nil {
+			log.Fatalf("Invalid -schedule-expression: %v", err)
+		}
+	}
+	if *express && *testMode != "direct" {
+		log.Fatalf("-express is only supported in direct mode")
+	}
+	// A synchronous execution can run for up to 5 minutes within a single call
+	if *express && *opTimeout > 0 && *opTimeout < expressMaxDuration {
+		fmt.Printf("Raising -op-timeout to %v for the synchronous execution\n", expressMaxDuration)
+		*opTimeout = expressMaxDuration
+	}
+
 	ctx := context.Background()
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := harness.LoadAWSConfig(ctx, *maxRetries, *opTimeout)
 	if err != nil {
 		log.Fatalf("unable to load SDK config, %v", err)
 	}
 
+	// Fail fast with a clear message instead of a cryptic error from StartExecution or PutTargets
+	smType, err := validateStateMachine(ctx, sfn.NewFromConfig(cfg), *stateMachineArn)
+	if err != nil {
+		log.Fatalf("State machine preflight check failed: %v", err)
+	}
+	if *testMode == "direct" {
+		if err := checkStateMachineType(smType, *express); err != nil {
+			log.Fatalf("State machine preflight check failed: %v", err)
+		}
+	}
+
+	// StartExecution and PutEvents reject inputs above 256KB
+	input, err := prepareInput(ctx, s3.NewFromConfig(cfg), *inputJson, *inputS3Bucket)
+	if err != nil {
+		log.Fatalf("Invalid input: %v", err)
+	}
+
+	if *express {
+		out, err := executeSync(ctx, cfg, *stateMachineArn, input)
+		if err != nil {
+			log.Fatalf("Failed to run synchronous execution: %v", err)
+		}
+		if err := reportSyncResult(out, outputOptions{Raw: *rawOutput, File: *outputFile}); err != nil {
+			log.Fatalf("Synchronous execution failed: %v", err)
+		}
+		return
+	}
+
 	var executionArn string
+	var executionArns []string
 
 	switch *testMode {
 	case "direct":
-		executionArn, err = executeDirectly(ctx, cfg, *stateMachineArn, *inputJson)
+		executionArn, err = executeDirectly(ctx, cfg, *stateMachineArn, input)
 	case "eventbridge":
-		executionArn, err = executeViaEventBridge(ctx, cfg, *stateMachineArn, *inputJson, *eventBusName)
+		executionArns, err = executeViaEventBridge(ctx, eventbridge.NewFromConfig(cfg), sfn.NewFromConfig(cfg), *stateMachineArn, input, *eventBusName, *count)
+		if err == nil && len(executionArns) == 1 {
+			executionArn = executionArns[0]
+		}
 	case "scheduled":
-		executionArn, err = executeViaScheduledTrigger(ctx, cfg, *stateMachineArn, *inputJson, *scheduledDelayMinutes)
+		executionArn, err = executeViaScheduledTrigger(ctx, eventbridge.NewFromConfig(cfg), sfn.NewFromConfig(cfg), *stateMachineArn, input, *scheduledDelayMinutes, *scheduleExpression, *scheduleWait, *roleArn)
 	default:
 		log.Fatalf("Invalid mode: %s. Use 'direct', 'eventbridge', or 'scheduled'", *testMode)
 	}
@@ -56,12 +132,100 @@ func main() {
 		log.Fatalf("Failed to start execution: %v", err)
 	}
 
+	if len(executionArns) > 1 {
+		if err := monitorExecutions(ctx, cfg, executionArns, *maxHistoryEvents, *failureEvents, outputOptions{Raw: *rawOutput}); err != nil {
+			log.Fatalf("Failed to monitor executions: %v", err)
+		}
+		return
+	}
+
 	// Monitor execution
-	if err := monitorExecution(ctx, cfg, executionArn); err != nil {
+	if err := monitorExecution(ctx, cfg, executionArn, *maxHistoryEvents, *failureEvents, outputOptions{Raw: *rawOutput, File: *outputFile}); err != nil {
 		log.Fatalf("Failed to monitor execution: %v", err)
 	}
 }
 
+// stateMachineAPI is the subset of the Step Functions client used by the
+// preflight check
+type stateMachineAPI interface {
+	DescribeStateMachine(ctx context.Context, params *sfn.DescribeStateMachineInput, optFns ...func(*sfn.Options)) (*sfn.DescribeStateMachineOutput, error)
+}
+
+// validateStateMachine checks that the state machine exists and is ACTIVE,
+// and returns its type
+func validateStateMachine(ctx context.Context, sfnClient stateMachineAPI, stateMachineArn string) (types.StateMachineType, error) {
+	descOutput, err := sfnClient.DescribeStateMachine(ctx, &sfn.DescribeStateMachineInput{
+		StateMachineArn: &stateMachineArn,
+	})
+	if err != nil {
+		var notFound *types.StateMachineDoesNotExist
+		if errors.As(err, &notFound) {
+			return "", fmt.Errorf("state machine %s does not exist", stateMachineArn)
+		}
+		return "", fmt.Errorf("failed to describe state machine: %w", err)
+	}
+
+	if descOutput.Status != types.StateMachineStatusActive {
+		return "", fmt.Errorf("state machine %s is %s, expected %s", stateMachineArn, descOutput.Status, types.StateMachineStatusActive)
+	}
+
+	fmt.Printf("State machine %s is %s (%s)\n", aws.ToString(descOutput.Name), descOutput.Status, descOutput.Type)
+	return descOutput.Type, nil
+}
+
+// checkStateMachineType checks that -express is used exactly for EXPRESS
+// state machines: StartSyncExecution only runs those, and the executions of
+// an EXPRESS state machine started asynchronously cannot be polled
+func checkStateMachineType(smType types.StateMachineType, express bool) error {
+	if express && smType != types.StateMachineTypeExpress {
+		return fmt.Errorf("-express requires an %s state machine, but this one is %s", types.StateMachineTypeExpress, smType)
+	}
+	if !express && smType == types.StateMachineTypeExpress {
+		return fmt.Errorf("state machine is %s; pass -express to run it synchronously", smType)
+	}
+	return nil
+}
+
+// maxInputBytes is the Step Functions limit on execution input size
+const maxInputBytes = 256 * 1024
+
+// putObjectAPI is the part of the S3 API used to upload oversized inputs
+type putObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// prepareInput returns input unchanged when it fits the Step Functions limit.
+// Larger inputs are uploaded to bucket and replaced by an S3 pointer, or
+// rejected when no bucket is given.
+func prepareInput(ctx context.Context, s3Client putObjectAPI, input, bucket string) (string, error) {
+	if len(input) <= maxInputBytes {
+		return input, nil
+	}
+	if bucket == "" {
+		return "", fmt.Errorf("input is %d bytes, exceeding the %d byte Step Functions limit; pass -input-s3-bucket to upload it to S3", len(input), maxInputBytes)
+	}
+
+	key := fmt.Sprintf("jobrun-inputs/%d.json", time.Now().UnixNano())
+	fmt.Printf("Input is %d bytes, uploading to s3://%s/%s\n", len(input), bucket, key)
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        strings.NewReader(input),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload input to S3: %w", err)
+	}
+
+	pointer, err := json.Marshal(map[string]string{
+		"inputS3Uri": fmt.Sprintf("s3://%s/%s", bucket, key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal S3 pointer: %w", err)
+	}
+	return string(pointer), nil
+}
+
 func executeDirectly(ctx context.Context, cfg aws.Config, stateMachineArn, inputJson string) (string, error) {
 	sfnClient := sfn.NewFromConfig(cfg)
 
@@ -79,10 +243,49 @@ func executeDirectly(ctx context.Context, cfg aws.Config, stateMachineArn, input
 	return executionArn, nil
 }
 
-func executeViaEventBridge(ctx context.Context, cfg aws.Config, stateMachineArn, inputJson, eventBusName string) (string, error) {
-	ebClient := eventbridge.NewFromConfig(cfg)
+// expressMaxDuration is the longest an EXPRESS execution can run
+const expressMaxDuration = 5 * time.Minute
+
+// executeSync runs an EXPRESS state machine with StartSyncExecution, which
+// returns once the execution has finished
+func executeSync(ctx context.Context, cfg aws.Config, stateMachineArn, inputJson string) (*sfn.StartSyncExecutionOutput, error) {
 	sfnClient := sfn.NewFromConfig(cfg)
 
+	fmt.Printf("Starting synchronous execution for state machine: %s\n", stateMachineArn)
+	out, err := sfnClient.StartSyncExecution(ctx, &sfn.StartSyncExecutionInput{
+		StateMachineArn: &stateMachineArn,
+		Input:           &inputJson,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start synchronous execution: %w", err)
+	}
+	return out, nil
+}
+
+// reportSyncResult prints the result of a synchronous execution: its output
+// when it succeeded, or its error and cause otherwise
+func reportSyncResult(out *sfn.StartSyncExecutionOutput, opts outputOptions) error {
+	fmt.Printf("Execution %s finished with status: %s\n", aws.ToString(out.ExecutionArn), out.Status)
+	if out.StartDate != nil && out.StopDate != nil {
+		fmt.Printf("Duration: %v\n", out.StopDate.Sub(*out.StartDate))
+	}
+
+	if out.Status != types.SyncExecutionStatusSucceeded {
+		fmt.Printf("  Error: %s\n  Cause: %s\n", aws.ToString(out.Error), aws.ToString(out.Cause))
+		return fmt.Errorf("execution failed with status: %s (%s)", out.Status, aws.ToString(out.Error))
+	}
+	return printExecutionOutput(aws.ToString(out.Output), opts)
+}
+
+// putEventsAPI is the subset of the EventBridge client used to send the test
+// events
+type putEventsAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// executeViaEventBridge sends count test events, each with its own correlation
+// ID, and returns the ARNs of the executions they started, in sending order
+func executeViaEventBridge(ctx context.Context, ebClient putEventsAPI, sfnClient executionLookupAPI, stateMachineArn, inputJson, eventBusName string, count int) ([]string, error) {
 	// Parse input JSON to include in event detail
 	var inputData map[string]interface{}
 	if err := json.Unmarshal([]byte(inputJson), &inputData); err != nil {
@@ -90,81 +293,193 @@ func executeViaEventBridge(ctx context.Context, cfg aws.Config, stateMachineArn,
 		inputData = map[string]interface{}{"rawInput": inputJson}
 	}
 
-	// Create event detail
-	eventDetail := map[string]interface{}{
-		"stateMachineArn": stateMachineArn,
-		"timestamp":       time.Now().Format(time.RFC3339),
-		"testInput":       inputData,
+	// Create one event per correlation ID; the test rule passes the detail to
+	// the execution input as "eventDetail"
+	sentAt := time.Now()
+	correlationIDs := make([]string, count)
+	entries := make([]eventtypes.PutEventsRequestEntry, count)
+	for i := range entries {
+		correlationIDs[i] = fmt.Sprintf("jobrun-%d-%d", sentAt.UnixNano(), i)
+		detailBytes, err := json.Marshal(map[string]interface{}{
+			"stateMachineArn": stateMachineArn,
+			"timestamp":       sentAt.Format(time.RFC3339),
+			"correlationId":   correlationIDs[i],
+			"testInput":       inputData,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event detail: %w", err)
+		}
+		entries[i] = eventtypes.PutEventsRequestEntry{
+			Source:       aws.String("fargate.workflow.test"),
+			DetailType:   aws.String("Test Trigger"),
+			Detail:       aws.String(string(detailBytes)),
+			EventBusName: aws.String(eventBusName),
+		}
 	}
 
-	detailBytes, err := json.Marshal(eventDetail)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal event detail: %w", err)
+	// Send test events to EventBridge, at most 10 per PutEvents call
+	fmt.Printf("Sending %d test event(s) to EventBridge (bus: %s)...\n", count, eventBusName)
+	for start := 0; start < len(entries); start += 10 {
+		end := min(start+10, len(entries))
+		putEventsOutput, err := ebClient.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries[start:end]})
+		if err != nil {
+			return nil, fmt.Errorf("failed to put events: %w", err)
+		}
+		if putEventsOutput.FailedEntryCount > 0 {
+			for _, entry := range putEventsOutput.Entries {
+				if entry.ErrorMessage != nil {
+					return nil, fmt.Errorf("failed to send %d event(s): %s", putEventsOutput.FailedEntryCount, *entry.ErrorMessage)
+				}
+			}
+			return nil, fmt.Errorf("failed to send %d event(s)", putEventsOutput.FailedEntryCount)
+		}
 	}
 
-	// Send test event to EventBridge
-	fmt.Printf("Sending test event to EventBridge (bus: %s)...\n", eventBusName)
-	putEventsInput := &eventbridge.PutEventsInput{
-		Entries: []eventtypes.PutEventsRequestEntry{
-			{
-				Source:       aws.String("fargate.workflow.test"),
-				DetailType:   aws.String("Test Trigger"),
-				Detail:       aws.String(string(detailBytes)),
-				EventBusName: aws.String(eventBusName),
-			},
-		},
-	}
+	fmt.Println("Events sent successfully. Waiting for Step Functions executions to start...")
 
-	putEventsOutput, err := ebClient.PutEvents(ctx, putEventsInput)
-	if err != nil {
-		return "", fmt.Errorf("failed to put event: %w", err)
-	}
+	// Allow for clock skew between this machine and Step Functions
+	return findExecutionByCorrelationID(ctx, sfnClient, stateMachineArn, sentAt.Add(-30*time.Second), correlationIDs, 10, correlationPollInterval)
+}
 
-	if putEventsOutput.FailedEntryCount > 0 && len(putEventsOutput.Entries) > 0 {
-		return "", fmt.Errorf("failed to send event: %s", *putEventsOutput.Entries[0].ErrorMessage)
-	}
+// executionLookupAPI is the subset of the Step Functions client used to match
+// executions to correlation IDs
+type executionLookupAPI interface {
+	sfn.ListExecutionsAPIClient
+	DescribeExecution(ctx context.Context, params *sfn.DescribeExecutionInput, optFns ...func(*sfn.Options)) (*sfn.DescribeExecutionOutput, error)
+}
 
-	fmt.Println("Event sent successfully. Waiting for Step Functions execution to start...")
+// correlationPollInterval is how long findExecutionByCorrelationID waits between
+// scans while executions are still missing
+const correlationPollInterval = 3 * time.Second
 
-	// Poll for the execution to start
-	// We need to list executions and find the one that was just triggered
-	time.Sleep(2 * time.Second) // Give EventBridge time to process
+// findExecutionByCorrelationID polls the executions started since `since`
+// every interval until one has been found for every correlation ID, and
+// returns their ARNs in the order of correlationIDs. Executions are matched by the
+// correlation ID in their input, not by start time; `since` only bounds the
+// scan. It gives up after maxAttempts scans or once ctx is done.
+func findExecutionByCorrelationID(ctx context.Context, client executionLookupAPI, stateMachineArn string, since time.Time, correlationIDs []string, maxAttempts int, interval time.Duration) ([]string, error) {
+	pending := make(map[string]int, len(correlationIDs)) // correlation ID -> index
+	for i, id := range correlationIDs {
+		pending[id] = i
+	}
+	arns := make([]string, len(correlationIDs))
+	checked := make(map[string]bool) // executions whose input was already read
 
-	var executionArn string
-	maxAttempts := 10
-	for i := 0; i < maxAttempts; i++ {
-		listOutput, err := sfnClient.ListExecutions(ctx, &sfn.ListExecutionsInput{
+	for attempt := 1; ; attempt++ {
+		// Executions are listed newest first
+		paginator := sfn.NewListExecutionsPaginator(client, &sfn.ListExecutionsInput{
 			StateMachineArn: &stateMachineArn,
-			StatusFilter:    types.ExecutionStatusRunning,
-			MaxResults:      int32(10),
 		})
-		if err != nil {
-			return "", fmt.Errorf("failed to list executions: %w", err)
-		}
+	scan:
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list executions: %w", err)
+			}
+			for _, exec := range page.Executions {
+				if exec.StartDate.Before(since) {
+					break scan
+				}
+				arn := aws.ToString(exec.ExecutionArn)
+				if checked[arn] {
+					continue
+				}
+
+				desc, err := client.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: exec.ExecutionArn})
+				if err != nil {
+					return nil, fmt.Errorf("failed to describe execution %s: %w", arn, err)
+				}
+				checked[arn] = true
 
-		// Find the most recent execution
-		for _, exec := range listOutput.Executions {
-			// Check if this execution started recently (within last 30 seconds)
-			if time.Since(*exec.StartDate) < 30*time.Second {
-				executionArn = *exec.ExecutionArn
-				fmt.Printf("Found execution triggered by EventBridge: %s\n", executionArn)
-				return executionArn, nil
+				id := executionCorrelationID(aws.ToString(desc.Input))
+				if i, ok := pending[id]; ok {
+					arns[i] = arn
+					delete(pending, id)
+					fmt.Printf("Found execution triggered by EventBridge (correlation ID %s): %s\n", id, arn)
+				}
 			}
 		}
 
-		if i < maxAttempts-1 {
-			fmt.Printf("Waiting for execution to start... (attempt %d/%d)\n", i+1, maxAttempts)
-			time.Sleep(3 * time.Second)
+		if len(pending) == 0 {
+			return arns, nil
+		}
+		if attempt >= maxAttempts {
+			return nil, fmt.Errorf("found %d of %d executions after %d attempts", len(correlationIDs)-len(pending), len(correlationIDs), maxAttempts)
+		}
+		fmt.Printf("Waiting for executions to start... (%d/%d found, attempt %d/%d)\n", len(correlationIDs)-len(pending), len(correlationIDs), attempt, maxAttempts)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
 		}
 	}
+}
 
-	return "", fmt.Errorf("execution not found after %d attempts", maxAttempts)
+// executionCorrelationID returns the correlation ID in the input of an
+// execution started by the test rule, or "" if there is none
+func executionCorrelationID(input string) string {
+	var parsed struct {
+		EventDetail struct {
+			CorrelationID string `json:"correlationId"`
+		} `json:"eventDetail"`
+	}
+	if err := json.Unmarshal([]byte(input), &parsed); err != nil {
+		return ""
+	}
+	return parsed.EventDetail.CorrelationID
 }
 
-func executeViaScheduledTrigger(ctx context.Context, cfg aws.Config, stateMachineArn, inputJson string, delayMinutes int) (string, error) {
-	ebClient := eventbridge.NewFromConfig(cfg)
-	sfnClient := sfn.NewFromConfig(cfg)
+var (
+	rateExpressionPattern = regexp.MustCompile(`^rate\((\d+) (minutes?|hours?|days?)\)$`)
+	cronExpressionPattern = regexp.MustCompile(`^cron\((.*)\)$`)
+	cronFieldPattern      = regexp.MustCompile(`^[0-9A-Za-z*?/,#-]+$`)
+)
 
+// validateScheduleExpression checks that expr is a well-formed EventBridge
+// rate(...) or cron(...) expression, so that typos fail before PutRule
+func validateScheduleExpression(expr string) error {
+	if m := rateExpressionPattern.FindStringSubmatch(expr); m != nil {
+		value, err := strconv.Atoi(m[1])
+		if err != nil || value < 1 {
+			return fmt.Errorf("rate value in %q must be a positive integer", expr)
+		}
+		// EventBridge requires the singular unit for 1 and the plural otherwise
+		if plural := strings.HasSuffix(m[2], "s"); plural != (value != 1) {
+			return fmt.Errorf("rate unit in %q must be singular for 1 and plural otherwise", expr)
+		}
+		return nil
+	}
+
+	if m := cronExpressionPattern.FindStringSubmatch(expr); m != nil {
+		fields := strings.Fields(m[1])
+		if len(fields) != 6 {
+			return fmt.Errorf("cron expression %q must have 6 fields (minutes hours day-of-month month day-of-week year), got %d", expr, len(fields))
+		}
+		for _, field := range fields {
+			if !cronFieldPattern.MatchString(field) {
+				return fmt.Errorf("cron expression %q has invalid field %q", expr, field)
+			}
+		}
+		// Day-of-month and day-of-week cannot both be specified
+		if (fields[2] == "?") == (fields[4] == "?") {
+			return fmt.Errorf("cron expression %q must use '?' in exactly one of day-of-month and day-of-week", expr)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%q is neither rate(<value> <unit>) nor cron(<6 fields>)", expr)
+}
+
+// scheduledRuleAPI is the subset of the EventBridge client used to create,
+// target and delete the temporary rule
+type scheduledRuleAPI interface {
+	roleDiscoveryAPI
+	targetAPI
+	ruleAPI
+	PutRule(ctx context.Context, params *eventbridge.PutRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutRuleOutput, error)
+}
+
+func executeViaScheduledTrigger(ctx context.Context, ebClient scheduledRuleAPI, sfnClient executionLookupAPI, stateMachineArn, inputJson string, delayMinutes int, customExpression string, scheduleWait time.Duration, roleArnFlag string) (string, error) {
 	// Generate a unique rule name for this test
 	timestamp := time.Now().Unix()
 	ruleName := fmt.Sprintf("test-scheduled-trigger-%d", timestamp)
@@ -172,21 +487,21 @@ func executeViaScheduledTrigger(ctx context.Context, cfg aws.Config, stateMachin
 	// Calculate the schedule time (current time + delay)
 	scheduleTime := time.Now().Add(time.Duration(delayMinutes) * time.Minute)
 	
-	// Create a cron expression for the specific time
-	// EventBridge cron format: cron(Minutes Hours Day-of-month Month Day-of-week Year)
-	cronExpression := fmt.Sprintf("cron(%d %d %d %d ? %d)", 
-		scheduleTime.Minute(),
-		scheduleTime.Hour(),
-		scheduleTime.Day(),
-		int(scheduleTime.Month()),
-		scheduleTime.Year())
+	cronExpression := ruleScheduleExpression(scheduleTime, customExpression)
 
-	fmt.Printf("Creating scheduled rule '%s' to trigger at %s...\n", ruleName, scheduleTime.Format("15:04:05"))
+	description := fmt.Sprintf("Temporary test rule to trigger Step Functions at %s", scheduleTime.Format(time.RFC3339))
+	if customExpression != "" {
+		description = fmt.Sprintf("Temporary test rule to trigger Step Functions on %s", customExpression)
+		fmt.Printf("Creating scheduled rule '%s' with schedule expression %s...\n", ruleName, cronExpression)
+	} else {
+		fmt.Printf("Creating scheduled rule '%s' to trigger at %s...\n", ruleName, scheduleTime.Format("15:04:05"))
+	}
 	
 	// Create the scheduled rule
+	ruleCreatedAt := time.Now()
 	putRuleInput := &eventbridge.PutRuleInput{
 		Name:               &ruleName,
-		Description:        aws.String(fmt.Sprintf("Temporary test rule to trigger Step Functions at %s", scheduleTime.Format(time.RFC3339))),
+		Description:        &description,
 		ScheduleExpression: &cronExpression,
 		State:              eventtypes.RuleStateEnabled,
 	}
@@ -198,95 +513,54 @@ func executeViaScheduledTrigger(ctx context.Context, cfg aws.Config, stateMachin
 
 	fmt.Printf("Created rule with ARN: %s\n", *putRuleOutput.RuleArn)
 
-	// Get the IAM role ARN from the existing scheduled rule
-	// We'll reuse the same role that was created for the main scheduled rule
-
-	// List existing targets to find the IAM role
-	existingRules, err := ebClient.ListRules(ctx, &eventbridge.ListRulesInput{
-		NamePrefix: aws.String("fargate-workflow-schedule-rule"),
-	})
-	if err != nil || len(existingRules.Rules) == 0 {
-		// Clean up the rule we just created
-		ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName})
-		return "", fmt.Errorf("failed to find existing scheduled rule to get IAM role")
-	}
+	// Ensure cleanup happens on every return, including early errors and
+	// panics, and when the test runner is interrupted while waiting
+	cleanup := &ruleCleanup{client: ebClient, ruleName: ruleName, targetID: "1"}
+	defer cleanup.run()
+	stopSignals := cleanupOnSignal(cleanup)
+	defer stopSignals()
 
-	// Get targets from the existing rule to find the IAM role
-	existingTargets, err := ebClient.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
-		Rule: existingRules.Rules[0].Name,
-	})
-	if err != nil || len(existingTargets.Targets) == 0 {
-		// Clean up the rule we just created
-		ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName})
-		return "", fmt.Errorf("failed to get IAM role from existing rule")
+	roleArn, err := resolveRuleRoleArn(ctx, ebClient, roleArnFlag)
+	if err != nil {
+		return "", err
 	}
 
-	roleArn := existingTargets.Targets[0].RoleArn
-
 	// Add the Step Functions state machine as a target
-	putTargetsInput := &eventbridge.PutTargetsInput{
-		Rule: &ruleName,
-		Targets: []eventtypes.Target{
-			{
-				Id:      aws.String("1"),
-				Arn:     &stateMachineArn,
-				RoleArn: roleArn,
-				Input:   &inputJson,
-			},
-		},
-	}
-
-	putTargetsOutput, err := ebClient.PutTargets(ctx, putTargetsInput)
+	err = addRuleTarget(ctx, ebClient, ruleName, eventtypes.Target{
+		Id:      &cleanup.targetID,
+		Arn:     &stateMachineArn,
+		RoleArn: &roleArn,
+		Input:   &inputJson,
+	})
 	if err != nil {
-		// Clean up the rule we just created
-		ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName})
-		return "", fmt.Errorf("failed to add target to scheduled rule: %w", err)
+		return "", err
 	}
 
-	if putTargetsOutput.FailedEntryCount > 0 && len(putTargetsOutput.FailedEntries) > 0 {
-		// Clean up the rule we just created
-		ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName})
-		return "", fmt.Errorf("failed to add target: %s", *putTargetsOutput.FailedEntries[0].ErrorMessage)
+	if customExpression != "" {
+		fmt.Printf("Scheduled rule created successfully. Waiting up to %v for the first execution...\n", scheduleWait)
+		return waitForFirstExecution(ctx, sfnClient, stateMachineArn, inputJson, ruleCreatedAt, scheduleWait)
 	}
 
 	fmt.Printf("Scheduled rule created successfully. Waiting %d minute(s) for execution...\n", delayMinutes)
 
-	// Ensure cleanup happens
-	defer func() {
-		fmt.Printf("Cleaning up temporary rule '%s'...\n", ruleName)
-		// Remove targets first
-		ebClient.RemoveTargets(ctx, &eventbridge.RemoveTargetsInput{
-			Rule: &ruleName,
-			Ids:  []string{"1"},
-		})
-		// Then delete the rule
-		if _, err := ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName}); err != nil {
-			fmt.Printf("Warning: Failed to delete temporary rule: %v\n", err)
-		} else {
-			fmt.Println("Temporary rule cleaned up successfully.")
-		}
-	}()
-
 	// Wait for the scheduled time plus a buffer
 	waitTime := time.Until(scheduleTime) + 30*time.Second
 	fmt.Printf("Waiting %v for scheduled execution to trigger...\n", waitTime.Round(time.Second))
-	
-	// Show countdown
+
+	// Show a countdown, but return as soon as the context is done so that the
+	// rule is cleaned up right away
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
-	
-	done := make(chan bool)
-	go func() {
-		time.Sleep(waitTime)
-		done <- true
-	}()
-	
-	countdownLoop:
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+countdown:
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timer.C:
 			fmt.Println("Wait time complete, checking for execution...")
-			break countdownLoop
+			break countdown
 		case <-ticker.C:
 			remaining := time.Until(scheduleTime.Add(30 * time.Second))
 			if remaining > 0 {
@@ -295,48 +569,292 @@ func executeViaScheduledTrigger(ctx context.Context, cfg aws.Config, stateMachin
 		}
 	}
 
-	// Now poll for the execution
-	var executionArn string
-	maxAttempts := 20 // More attempts since we're looking for a scheduled execution
-	for i := 0; i < maxAttempts; i++ {
-		listOutput, err := sfnClient.ListExecutions(ctx, &sfn.ListExecutionsInput{
+	// Match the execution by the target's input rather than by its start
+	// time, so that other executions around the scheduled time are skipped
+	return waitForFirstExecution(ctx, sfnClient, stateMachineArn, inputJson, ruleCreatedAt, 2*time.Minute)
+}
+
+// scheduleRulePrefix is the name prefix of the workflow's own schedule rule,
+// whose target role is reused for the temporary rule
+const scheduleRulePrefix = "fargate-workflow-schedule-rule"
+
+// roleDiscoveryAPI is the subset of the EventBridge client used to discover
+// the role of the workflow's schedule rule
+type roleDiscoveryAPI interface {
+	ListRules(ctx context.Context, params *eventbridge.ListRulesInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListRulesOutput, error)
+	ListTargetsByRule(ctx context.Context, params *eventbridge.ListTargetsByRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error)
+}
+
+// resolveRuleRoleArn returns roleArn when it is set. Otherwise it reuses the
+// role of the first target of the first rule named with scheduleRulePrefix.
+func resolveRuleRoleArn(ctx context.Context, client roleDiscoveryAPI, roleArn string) (string, error) {
+	if roleArn != "" {
+		fmt.Printf("Using IAM role %s\n", roleArn)
+		return roleArn, nil
+	}
+
+	rules, err := client.ListRules(ctx, &eventbridge.ListRulesInput{
+		NamePrefix: aws.String(scheduleRulePrefix),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list rules with prefix %q to discover the IAM role (pass -role-arn to skip discovery): %w", scheduleRulePrefix, err)
+	}
+	if len(rules.Rules) == 0 {
+		return "", fmt.Errorf("no rule with prefix %q found to discover the IAM role from; pass -role-arn", scheduleRulePrefix)
+	}
+
+	ruleName := aws.ToString(rules.Rules[0].Name)
+	targets, err := client.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+		Rule: &ruleName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list targets of rule %s to discover the IAM role (pass -role-arn to skip discovery): %w", ruleName, err)
+	}
+	for _, target := range targets.Targets {
+		if target.RoleArn != nil {
+			fmt.Printf("Discovered IAM role %s from rule %s\n", *target.RoleArn, ruleName)
+			return *target.RoleArn, nil
+		}
+	}
+	return "", fmt.Errorf("rule %s (prefix %q) has no target with an IAM role; pass -role-arn", ruleName, scheduleRulePrefix)
+}
+
+// ruleScheduleExpression returns the user-provided cron(...)/rate(...)
+// expression verbatim when it is set, and otherwise a one-time cron
+// expression firing at scheduleTime
+func ruleScheduleExpression(scheduleTime time.Time, customExpression string) string {
+	if customExpression != "" {
+		return customExpression
+	}
+	// EventBridge cron format: cron(Minutes Hours Day-of-month Month Day-of-week Year)
+	return fmt.Sprintf("cron(%d %d %d %d ? %d)",
+		scheduleTime.Minute(),
+		scheduleTime.Hour(),
+		scheduleTime.Day(),
+		int(scheduleTime.Month()),
+		scheduleTime.Year())
+}
+
+// waitForFirstExecution polls for the first execution of the state machine
+// started after the given time with the input of the temporary rule's target,
+// for schedules whose trigger time is not known in advance. Executions started
+// by anything else, such as the workflow's own schedule, are skipped unless
+// their input is identical.
+func waitForFirstExecution(ctx context.Context, client executionLookupAPI, stateMachineArn, input string, after time.Time, maxWait time.Duration) (string, error) {
+	deadline := time.Now().Add(maxWait)
+	checked := make(map[string]bool) // executions whose input was already read
+	for {
+		// Executions are listed newest first, so the last match is the first triggered one
+		var executionArn string
+		paginator := sfn.NewListExecutionsPaginator(client, &sfn.ListExecutionsInput{
 			StateMachineArn: &stateMachineArn,
-			MaxResults:      int32(10),
 		})
-		if err != nil {
-			return "", fmt.Errorf("failed to list executions: %w", err)
-		}
+	scan:
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return "", fmt.Errorf("failed to list executions: %w", err)
+			}
+			for _, exec := range page.Executions {
+				if !exec.StartDate.After(after) {
+					break scan
+				}
+				arn := aws.ToString(exec.ExecutionArn)
+				if checked[arn] {
+					continue
+				}
 
-		// Find the most recent execution that started after our schedule time
-		for _, exec := range listOutput.Executions {
-			// Check if this execution started after our scheduled time (with some buffer)
-			if exec.StartDate.After(scheduleTime.Add(-30*time.Second)) && 
-			   exec.StartDate.Before(scheduleTime.Add(2*time.Minute)) {
-				executionArn = *exec.ExecutionArn
-				fmt.Printf("Found execution triggered by scheduled rule: %s\n", executionArn)
-				return executionArn, nil
+				desc, err := client.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: exec.ExecutionArn})
+				if err != nil {
+					return "", fmt.Errorf("failed to describe execution %s: %w", arn, err)
+				}
+				checked[arn] = true
+
+				// EventBridge passes the target's constant input through verbatim
+				if aws.ToString(desc.Input) == input {
+					executionArn = arn
+				}
 			}
 		}
+		if executionArn != "" {
+			fmt.Printf("Found execution triggered by scheduled rule: %s\n", executionArn)
+			return executionArn, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return "", fmt.Errorf("no execution triggered by the schedule within %v", maxWait)
+		}
+
+		fmt.Printf("Still waiting for the first scheduled execution... %v remaining\n", remaining.Round(time.Second))
+		timer := time.NewTimer(min(10*time.Second, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// targetAPI is the subset of the EventBridge client used to add the state
+// machine target to the temporary rule
+type targetAPI interface {
+	PutTargets(ctx context.Context, params *eventbridge.PutTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutTargetsOutput, error)
+	ListTargetsByRule(ctx context.Context, params *eventbridge.ListTargetsByRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error)
+}
+
+// addRuleTarget adds target to the rule and checks that it was actually
+// registered before the caller waits for it to fire
+func addRuleTarget(ctx context.Context, ebClient targetAPI, ruleName string, target eventtypes.Target) error {
+	putTargetsOutput, err := ebClient.PutTargets(ctx, &eventbridge.PutTargetsInput{
+		Rule:    &ruleName,
+		Targets: []eventtypes.Target{target},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add target to scheduled rule: %w", err)
+	}
 
-		if i < maxAttempts-1 {
-			fmt.Printf("Checking for scheduled execution... (attempt %d/%d)\n", i+1, maxAttempts)
-			time.Sleep(5 * time.Second)
+	if putTargetsOutput.FailedEntryCount > 0 {
+		// PutTargets can partially fail, so report every failed entry
+		for _, entry := range putTargetsOutput.FailedEntries {
+			fmt.Printf("Failed to add target '%s': %s (%s)\n",
+				aws.ToString(entry.TargetId), aws.ToString(entry.ErrorMessage), aws.ToString(entry.ErrorCode))
 		}
+		return fmt.Errorf("failed to add %d target(s) to scheduled rule", putTargetsOutput.FailedEntryCount)
 	}
 
-	return "", fmt.Errorf("scheduled execution not found after %d attempts", maxAttempts)
+	return verifyTargetRegistered(ctx, ebClient, ruleName, aws.ToString(target.Id))
 }
 
-func monitorExecution(ctx context.Context, cfg aws.Config, executionArn string) error {
+// verifyTargetRegistered checks that the rule has a target with the given ID
+func verifyTargetRegistered(ctx context.Context, ebClient targetAPI, ruleName, targetID string) error {
+	targetsOutput, err := ebClient.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+		Rule: &ruleName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list targets of scheduled rule: %w", err)
+	}
+
+	for _, target := range targetsOutput.Targets {
+		if aws.ToString(target.Id) == targetID {
+			fmt.Printf("Verified target '%s' is registered on rule '%s'\n", targetID, ruleName)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("target '%s' not registered on scheduled rule '%s'", targetID, ruleName)
+}
+
+// ruleAPI is the subset of the EventBridge client used to delete the
+// temporary rule
+type ruleAPI interface {
+	ListTargetsByRule(ctx context.Context, params *eventbridge.ListTargetsByRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error)
+	RemoveTargets(ctx context.Context, params *eventbridge.RemoveTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.RemoveTargetsOutput, error)
+	DeleteRule(ctx context.Context, params *eventbridge.DeleteRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DeleteRuleOutput, error)
+	DescribeRule(ctx context.Context, params *eventbridge.DescribeRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DescribeRuleOutput, error)
+}
+
+// ruleCleanup deletes the temporary rule once, on whichever of a return, a
+// panic or an interrupt comes first
+type ruleCleanup struct {
+	client   ruleAPI
+	ruleName string
+	targetID string // Target added to the rule, removed even if listing the targets fails
+	once     sync.Once
+}
+
+// run deletes the rule with its own timeout, so that it still works when the
+// caller's context was cancelled
+func (c *ruleCleanup) run() {
+	c.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cleanupScheduledRule(ctx, c.client, c.ruleName, c.targetID)
+	})
+}
+
+// cleanupOnSignal runs cleanup and exits when SIGINT or SIGTERM is received,
+// until the returned function is called
+func cleanupOnSignal(cleanup *ruleCleanup) (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigCh:
+			fmt.Printf("\nReceived %v, cleaning up before exiting...\n", sig)
+			cleanup.run()
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// cleanupScheduledRule removes all targets from the temporary rule, deletes it
+// and checks that it is gone. Targets must be removed first, as EventBridge
+// refuses to delete a rule that has targets; if they cannot be listed, the
+// known target ID is removed instead.
+func cleanupScheduledRule(ctx context.Context, ebClient ruleAPI, ruleName, targetID string) {
+	fmt.Printf("Cleaning up temporary rule '%s'...\n", ruleName)
+
+	ids := []string{targetID}
+	targetsOutput, err := ebClient.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+		Rule: &ruleName,
+	})
+	if err != nil {
+		fmt.Printf("Warning: Failed to list targets of temporary rule, removing target '%s': %v\n", targetID, err)
+	} else {
+		ids = nil
+		for _, target := range targetsOutput.Targets {
+			ids = append(ids, aws.ToString(target.Id))
+		}
+	}
+	if len(ids) > 0 {
+		if _, err := ebClient.RemoveTargets(ctx, &eventbridge.RemoveTargetsInput{
+			Rule: &ruleName,
+			Ids:  ids,
+		}); err != nil {
+			fmt.Printf("Warning: Failed to remove targets from temporary rule: %v\n", err)
+		}
+	}
+
+	if _, err := ebClient.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &ruleName}); err != nil {
+		fmt.Printf("Warning: Failed to delete temporary rule: %v\n", err)
+		return
+	}
+
+	// DescribeRule fails with ResourceNotFoundException once the rule is gone
+	_, err = ebClient.DescribeRule(ctx, &eventbridge.DescribeRuleInput{Name: &ruleName})
+	var notFound *eventtypes.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		fmt.Println("Temporary rule cleaned up successfully.")
+	case err != nil:
+		fmt.Printf("Warning: Could not verify that temporary rule '%s' was deleted: %v\n", ruleName, err)
+	default:
+		fmt.Printf("Warning: Temporary rule '%s' still exists after deletion; delete it manually\n", ruleName)
+	}
+}
+
+// outputOptions controls how a successful execution's output is reported
+type outputOptions struct {
+	Raw  bool   // print the output string without reformatting
+	File string // optional path the output is also written to
+}
+
+func monitorExecution(ctx context.Context, cfg aws.Config, executionArn string, maxHistoryEvents, failureEvents int, opts outputOptions) error {
 	sfnClient := sfn.NewFromConfig(cfg)
 
 	fmt.Println("Waiting for execution to complete...")
 
 	var lastStatus types.ExecutionStatus
 	for {
-		descOutput, err := sfnClient.DescribeExecution(ctx, &sfn.DescribeExecutionInput{
-			ExecutionArn: &executionArn,
-		})
+		descOutput, err := describeExecution(ctx, sfnClient, executionArn)
 		if err != nil {
 			return fmt.Errorf("failed to describe execution: %w", err)
 		}
@@ -358,28 +876,268 @@ func monitorExecution(ctx context.Context, cfg aws.Config, executionArn string)
 
 	// Get final output if succeeded
 	if lastStatus == types.ExecutionStatusSucceeded {
-		descOutput, err := sfnClient.DescribeExecution(ctx, &sfn.DescribeExecutionInput{
-			ExecutionArn: &executionArn,
-		})
+		descOutput, err := describeExecution(ctx, sfnClient, executionArn)
 		if err != nil {
 			return fmt.Errorf("failed to describe execution for output: %w", err)
 		}
 
-		fmt.Println("\n--- Execution Output --- ")
-		var prettyJSON map[string]interface{}
-		err = json.Unmarshal([]byte(*descOutput.Output), &prettyJSON)
+		return printExecutionOutput(aws.ToString(descOutput.Output), opts)
+	} else {
+		if maxHistoryEvents > 0 {
+			report, err := findFailureCause(ctx, sfnClient, executionArn, maxHistoryEvents, failureEvents)
+			if err != nil {
+				fmt.Printf("Warning: Could not read execution history: %v\n", err)
+			} else {
+				printFailureReport(report)
+				if report.Terminal != nil {
+					return fmt.Errorf("execution failed with status: %s (%s)", lastStatus, report.Terminal.Error)
+				}
+			}
+		}
+		fmt.Println("Execution did not succeed. Check the AWS Step Functions console for details.")
+		return fmt.Errorf("execution failed with status: %s", lastStatus)
+	}
+}
+
+// monitorExecutions waits for each execution in turn and prints how many of
+// them succeeded. It fails if any execution did not succeed.
+func monitorExecutions(ctx context.Context, cfg aws.Config, executionArns []string, maxHistoryEvents, failureEvents int, opts outputOptions) error {
+	var failed []string
+	for i, executionArn := range executionArns {
+		fmt.Printf("\n=== Execution %d/%d: %s ===\n", i+1, len(executionArns), executionArn)
+		if err := monitorExecution(ctx, cfg, executionArn, maxHistoryEvents, failureEvents, opts); err != nil {
+			fmt.Printf("Execution %d/%d failed: %v\n", i+1, len(executionArns), err)
+			failed = append(failed, executionArn)
+		}
+	}
+
+	fmt.Printf("\n%d/%d executions succeeded\n", len(executionArns)-len(failed), len(executionArns))
+	for _, arn := range failed {
+		fmt.Printf("  FAILED: %s\n", arn)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d executions did not succeed", len(failed), len(executionArns))
+	}
+	return nil
+}
+
+// failureEvent is a failure event of an execution history
+type failureEvent struct {
+	Type  types.HistoryEventType
+	State string // Name of the state the event belongs to, if known
+	Error string
+	Cause string
+}
+
+// failureReport summarizes why an execution failed
+type failureReport struct {
+	Terminal *failureEvent  // ExecutionFailed, ExecutionTimedOut or ExecutionAborted; nil if not found
+	Failures []failureEvent // Most recent failed task events, newest first
+}
+
+// findFailureCause reads the execution history backwards, a page at a time,
+// until it has found the terminal failure event and the failureEvents most
+// recent failed task events with their state names, or has read back to the
+// start of the execution, or after maxEvents events, whichever comes first
+func findFailureCause(ctx context.Context, client sfn.GetExecutionHistoryAPIClient, executionArn string, maxEvents, failureEvents int) (failureReport, error) {
+	paginator := sfn.NewGetExecutionHistoryPaginator(client, &sfn.GetExecutionHistoryInput{
+		ExecutionArn: &executionArn,
+		ReverseOrder: true,
+		MaxResults:   int32(min(maxEvents, 1000)),
+	})
+
+	scan := newFailureScan(failureEvents)
+	for paginator.HasMorePages() && scan.read < maxEvents && !scan.complete() {
+		page, err := paginator.NextPage(ctx)
 		if err != nil {
+			return failureReport{}, err
+		}
+		scan.add(page.Events[:min(len(page.Events), maxEvents-scan.read)])
+	}
+
+	if scan.report.Terminal == nil {
+		fmt.Printf("No failure event found in the last %d history events\n", scan.read)
+	}
+	return scan.report, nil
+}
+
+// failureScan builds the failure report of an execution from its history,
+// read newest first a page at a time. Each event is processed once, so the
+// work grows linearly with the events read.
+type failureScan struct {
+	lastN   int                          // Failed task events to report
+	byID    map[int64]types.HistoryEvent // Events read so far
+	report  failureReport
+	read    int            // Number of events read
+	started bool           // The ExecutionStarted event, the oldest one, has been read
+	pending []pendingState // Failures whose state is not known yet
+}
+
+// pendingState is a failure in the report whose state lookup stopped at
+// event next, which has not been read yet
+type pendingState struct {
+	index int
+	next  int64
+}
+
+func newFailureScan(lastN int) *failureScan {
+	return &failureScan{lastN: lastN, byID: make(map[int64]types.HistoryEvent)}
+}
+
+// add records the next page of events, given newest first. The terminal
+// failure event and up to lastN failed task events are collected; the state
+// of a task event is found by following its PreviousEventId chain back to the
+// event that entered the state, which may be on a later page.
+func (s *failureScan) add(events []types.HistoryEvent) {
+	s.read += len(events)
+	for _, event := range events {
+		s.byID[event.Id] = event
+		if event.Type == types.HistoryEventTypeExecutionStarted {
+			s.started = true
+		}
+
+		errName, cause, terminal, ok := eventFailure(event)
+		if !ok {
+			continue
+		}
+		failure := failureEvent{Type: event.Type, Error: aws.ToString(errName), Cause: aws.ToString(cause)}
+		if terminal {
+			if s.report.Terminal == nil {
+				s.report.Terminal = &failure
+			}
+			continue
+		}
+		if len(s.report.Failures) < s.lastN {
+			s.pending = append(s.pending, pendingState{index: len(s.report.Failures), next: event.PreviousEventId})
+			s.report.Failures = append(s.report.Failures, failure)
+		}
+	}
+
+	// Resume the state lookups that stopped at an event not read yet
+	unresolved := s.pending[:0]
+	for _, p := range s.pending {
+		name, missing := stateName(p.next, s.byID)
+		if name != "" {
+			s.report.Failures[p.index].State = name
+		} else if missing != 0 {
+			unresolved = append(unresolved, pendingState{index: p.index, next: missing})
+		}
+	}
+	s.pending = unresolved
+}
+
+// complete reports whether reading older events cannot change the report:
+// the whole history has been read, or the terminal event, lastN failed task
+// events and all their states have been found
+func (s *failureScan) complete() bool {
+	if s.started {
+		return true
+	}
+	return s.report.Terminal != nil && len(s.report.Failures) >= s.lastN && len(s.pending) == 0
+}
+
+// eventFailure returns the error and cause of a failure event, and whether
+// it ended the execution. ok is false for other events.
+func eventFailure(event types.HistoryEvent) (errName, cause *string, terminal, ok bool) {
+	switch {
+	case event.ExecutionFailedEventDetails != nil:
+		return event.ExecutionFailedEventDetails.Error, event.ExecutionFailedEventDetails.Cause, true, true
+	case event.ExecutionTimedOutEventDetails != nil:
+		return event.ExecutionTimedOutEventDetails.Error, event.ExecutionTimedOutEventDetails.Cause, true, true
+	case event.ExecutionAbortedEventDetails != nil:
+		return event.ExecutionAbortedEventDetails.Error, event.ExecutionAbortedEventDetails.Cause, true, true
+	case event.TaskFailedEventDetails != nil:
+		return event.TaskFailedEventDetails.Error, event.TaskFailedEventDetails.Cause, false, true
+	case event.TaskTimedOutEventDetails != nil:
+		return event.TaskTimedOutEventDetails.Error, event.TaskTimedOutEventDetails.Cause, false, true
+	case event.TaskStartFailedEventDetails != nil:
+		return event.TaskStartFailedEventDetails.Error, event.TaskStartFailedEventDetails.Cause, false, true
+	case event.TaskSubmitFailedEventDetails != nil:
+		return event.TaskSubmitFailedEventDetails.Error, event.TaskSubmitFailedEventDetails.Cause, false, true
+	}
+	return nil, nil, false, false
+}
+
+// stateName follows the PreviousEventId chain from event id back to the
+// event that entered the state, and returns the state name. If the chain
+// leaves the events read so far, it returns the ID of the first missing event
+// instead; if the chain ends without entering a state, both are empty.
+func stateName(id int64, byID map[int64]types.HistoryEvent) (name string, missing int64) {
+	for id != 0 {
+		prev, ok := byID[id]
+		if !ok {
+			return "", id
+		}
+		if prev.StateEnteredEventDetails != nil {
+			return aws.ToString(prev.StateEnteredEventDetails.Name), 0
+		}
+		id = prev.PreviousEventId
+	}
+	return "", 0
+}
+
+// printFailureReport prints the terminal failure event and the failed task
+// events leading to it
+func printFailureReport(report failureReport) {
+	if report.Terminal != nil {
+		fmt.Printf("Failure event: %s\n  Error: %s\n  Cause: %s\n", report.Terminal.Type, report.Terminal.Error, report.Terminal.Cause)
+	}
+	if len(report.Failures) == 0 {
+		return
+	}
+	fmt.Printf("Last %d failed task event(s), newest first:\n", len(report.Failures))
+	for _, failure := range report.Failures {
+		state := failure.State
+		if state == "" {
+			state = "(unknown state)"
+		}
+		fmt.Printf("  %s in %s\n    Error: %s\n    Cause: %s\n", failure.Type, state, failure.Error, failure.Cause)
+	}
+}
+
+// printExecutionOutput prints the execution output, pretty-printing JSON
+// unless raw output is requested, and writes the printed text to opts.File
+func printExecutionOutput(output string, opts outputOptions) error {
+	text := output
+	if !opts.Raw {
+		var prettyJSON map[string]interface{}
+		if err := json.Unmarshal([]byte(output), &prettyJSON); err != nil {
 			fmt.Println("Output is not valid JSON, printing as string:")
-			fmt.Println(*descOutput.Output)
 		} else {
 			formattedJSON, _ := json.MarshalIndent(prettyJSON, "", "  ")
-			fmt.Println(string(formattedJSON))
+			text = string(formattedJSON)
 		}
-		fmt.Println("------------------------")
-		return nil
-	} else {
-		// Optionally retrieve failure details if needed
-		fmt.Println("Execution did not succeed. Check the AWS Step Functions console for details.")
-		return fmt.Errorf("execution failed with status: %s", lastStatus)
 	}
-}
\ No newline at end of file
+
+	fmt.Println("\n--- Execution Output --- ")
+	fmt.Println(text)
+	fmt.Println("------------------------")
+
+	if opts.File != "" {
+		if err := os.WriteFile(opts.File, []byte(text+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		fmt.Printf("Execution output written to %s\n", opts.File)
+	}
+	return nil
+}
+
+// describeExecutionAPI is the part of the Step Functions API used to poll an
+// execution
+type describeExecutionAPI interface {
+	DescribeExecution(ctx context.Context, params *sfn.DescribeExecutionInput, optFns ...func(*sfn.Options)) (*sfn.DescribeExecutionOutput, error)
+}
+
+// describeExecution calls DescribeExecution. Throttling and 5xx errors are
+// already retried by the SDK retryer (see -max-retries); a missing execution
+// is reported as such, as no amount of retrying would help.
+func describeExecution(ctx context.Context, client describeExecutionAPI, executionArn string) (*sfn.DescribeExecutionOutput, error) {
+	out, err := client.DescribeExecution(ctx, &sfn.DescribeExecutionInput{
+		ExecutionArn: &executionArn,
+	})
+	var notFound *types.ExecutionDoesNotExist
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("execution %s does not exist: %w", executionArn, err)
+	}
+	return out, err
+}
@@ -0,0 +1,905 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeEventBridge records the calls made against a single temporary rule
+type fakeEventBridge struct {
+	putTargets     *eventbridge.PutTargetsOutput
+	rules          []eventtypes.Rule // Returned by ListRules
+	listRulesErr   error
+	createdRule    string
+	targets        []eventtypes.Target
+	listTargets    int
+	removedTargets []string
+	deletedRule    string
+	calls          []string // Names of the rule-changing and describing calls, in order
+}
+
+func (f *fakeEventBridge) PutRule(ctx context.Context, params *eventbridge.PutRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutRuleOutput, error) {
+	f.calls = append(f.calls, "PutRule")
+	f.createdRule = aws.ToString(params.Name)
+	return &eventbridge.PutRuleOutput{RuleArn: aws.String("arn:rule/" + f.createdRule)}, nil
+}
+
+func (f *fakeEventBridge) ListRules(ctx context.Context, params *eventbridge.ListRulesInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListRulesOutput, error) {
+	f.calls = append(f.calls, "ListRules")
+	if f.listRulesErr != nil {
+		return nil, f.listRulesErr
+	}
+	return &eventbridge.ListRulesOutput{Rules: f.rules}, nil
+}
+
+func (f *fakeEventBridge) PutTargets(ctx context.Context, params *eventbridge.PutTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutTargetsOutput, error) {
+	failed := map[string]bool{}
+	for _, entry := range f.putTargets.FailedEntries {
+		failed[aws.ToString(entry.TargetId)] = true
+	}
+	for _, target := range params.Targets {
+		if !failed[aws.ToString(target.Id)] {
+			f.targets = append(f.targets, target)
+		}
+	}
+	f.calls = append(f.calls, "PutTargets")
+	return f.putTargets, nil
+}
+
+func (f *fakeEventBridge) ListTargetsByRule(ctx context.Context, params *eventbridge.ListTargetsByRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error) {
+	f.listTargets++
+	return &eventbridge.ListTargetsByRuleOutput{Targets: f.targets}, nil
+}
+
+func (f *fakeEventBridge) RemoveTargets(ctx context.Context, params *eventbridge.RemoveTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.RemoveTargetsOutput, error) {
+	f.calls = append(f.calls, "RemoveTargets")
+	f.removedTargets = append(f.removedTargets, params.Ids...)
+	return &eventbridge.RemoveTargetsOutput{}, nil
+}
+
+func (f *fakeEventBridge) DeleteRule(ctx context.Context, params *eventbridge.DeleteRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DeleteRuleOutput, error) {
+	f.calls = append(f.calls, "DeleteRule")
+	f.deletedRule = aws.ToString(params.Name)
+	return &eventbridge.DeleteRuleOutput{}, nil
+}
+
+func (f *fakeEventBridge) DescribeRule(ctx context.Context, params *eventbridge.DescribeRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DescribeRuleOutput, error) {
+	f.calls = append(f.calls, "DescribeRule")
+	return nil, &eventtypes.ResourceNotFoundException{Message: aws.String("rule does not exist")}
+}
+
+func TestAddRuleTargetPartialFailure(t *testing.T) {
+	eb := &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{
+		FailedEntryCount: 1,
+		FailedEntries: []eventtypes.PutTargetsResultEntry{{
+			TargetId:     aws.String("1"),
+			ErrorCode:    aws.String("ConcurrentModificationException"),
+			ErrorMessage: aws.String("rule is being modified"),
+		}},
+	}}
+
+	err := addRuleTarget(context.Background(), eb, "test-rule", eventtypes.Target{Id: aws.String("1"), Arn: aws.String("arn:sm")})
+	if err == nil || !strings.Contains(err.Error(), "failed to add 1 target(s)") {
+		t.Fatalf("addRuleTarget() error = %v, want the failed target count", err)
+	}
+	if eb.listTargets != 0 {
+		t.Errorf("ListTargetsByRule called %d times after PutTargets failed", eb.listTargets)
+	}
+
+	// The rule is still deleted; it has no targets left to remove
+	(&ruleCleanup{client: eb, ruleName: "test-rule", targetID: "1"}).run()
+	if eb.deletedRule != "test-rule" || len(eb.removedTargets) != 0 {
+		t.Errorf("deleted rule %q after removing %v, want test-rule with no targets", eb.deletedRule, eb.removedTargets)
+	}
+}
+
+func TestAddRuleTargetVerifiesRegistration(t *testing.T) {
+	eb := &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{}}
+	if err := addRuleTarget(context.Background(), eb, "test-rule", eventtypes.Target{Id: aws.String("1")}); err != nil {
+		t.Fatalf("addRuleTarget() error = %v", err)
+	}
+	if eb.listTargets != 1 {
+		t.Errorf("ListTargetsByRule called %d times, want 1", eb.listTargets)
+	}
+
+	// PutTargets reported success but the target never showed up
+	eb = &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{}}
+	if err := verifyTargetRegistered(context.Background(), eb, "test-rule", "1"); err == nil {
+		t.Error("verifyTargetRegistered() succeeded without a registered target")
+	}
+}
+
+func TestScheduledTriggerCleansUpAfterEarlyFailure(t *testing.T) {
+	// Role discovery fails right after the rule was created
+	eb := &fakeEventBridge{listRulesErr: errors.New("access denied")}
+	_, err := executeViaScheduledTrigger(context.Background(), eb, &fakeExecutions{}, "arn:sm", `{}`, 1, "", time.Minute, "")
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("executeViaScheduledTrigger() error = %v, want the discovery error", err)
+	}
+	if eb.createdRule == "" || eb.deletedRule != eb.createdRule {
+		t.Errorf("deleted rule %q, want the created rule %q", eb.deletedRule, eb.createdRule)
+	}
+	if want := []string{"PutRule", "ListRules", "DeleteRule", "DescribeRule"}; !reflect.DeepEqual(eb.calls, want) {
+		t.Errorf("calls = %v, want %v", eb.calls, want)
+	}
+}
+
+func TestScheduledTriggerCleansUpAfterCancellation(t *testing.T) {
+	// The target was added when the wait for the execution is cancelled
+	eb := &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := executeViaScheduledTrigger(ctx, eb, &fakeExecutions{}, "arn:sm", `{}`, 0, "rate(1 minute)", time.Hour, "arn:role")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("executeViaScheduledTrigger() error = %v, want context.Canceled", err)
+	}
+	if want := []string{"PutRule", "PutTargets", "RemoveTargets", "DeleteRule", "DescribeRule"}; !reflect.DeepEqual(eb.calls, want) {
+		t.Errorf("calls = %v, want %v", eb.calls, want)
+	}
+	if !reflect.DeepEqual(eb.removedTargets, []string{"1"}) || eb.deletedRule != eb.createdRule {
+		t.Errorf("removed targets %v of rule %q, want [1] of %q", eb.removedTargets, eb.deletedRule, eb.createdRule)
+	}
+}
+
+func TestScheduledTriggerCancelledDuringCountdown(t *testing.T) {
+	// The one-time schedule fires a minute from now; cancellation must not
+	// wait for it before cleaning up
+	eb := &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := executeViaScheduledTrigger(ctx, eb, &fakeExecutions{}, "arn:sm", `{}`, 1, "", time.Hour, "arn:role")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("executeViaScheduledTrigger() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("executeViaScheduledTrigger() returned after %v, want well under the countdown", elapsed)
+	}
+	if want := []string{"PutRule", "PutTargets", "RemoveTargets", "DeleteRule", "DescribeRule"}; !reflect.DeepEqual(eb.calls, want) {
+		t.Errorf("calls = %v, want %v", eb.calls, want)
+	}
+}
+
+func TestScheduledTriggerCleansUpAfterPanic(t *testing.T) {
+	eb := &fakeEventBridge{putTargets: &eventbridge.PutTargetsOutput{}}
+	sfnClient := &fakeExecutions{onList: func(int) { panic("list failed") }}
+	func() {
+		defer func() {
+			if r := recover(); r != "list failed" {
+				t.Errorf("recovered %v, want the panic to propagate", r)
+			}
+		}()
+		executeViaScheduledTrigger(context.Background(), eb, sfnClient, "arn:sm", `{}`, 0, "rate(1 minute)", time.Hour, "arn:role")
+	}()
+	if eb.createdRule == "" || eb.deletedRule != eb.createdRule || len(eb.removedTargets) != 1 {
+		t.Errorf("removed targets %v of rule %q, want the target of %q", eb.removedTargets, eb.deletedRule, eb.createdRule)
+	}
+}
+
+func TestResolveRuleRoleArnFlagTakesPrecedence(t *testing.T) {
+	eb := &fakeEventBridge{
+		rules:   []eventtypes.Rule{{Name: aws.String(scheduleRulePrefix + "-daily")}},
+		targets: []eventtypes.Target{{Id: aws.String("1"), RoleArn: aws.String("arn:discovered")}},
+	}
+	got, err := resolveRuleRoleArn(context.Background(), eb, "arn:flag")
+	if err != nil || got != "arn:flag" {
+		t.Errorf("resolveRuleRoleArn() = %q, %v, want arn:flag", got, err)
+	}
+	if len(eb.calls) != 0 || eb.listTargets != 0 {
+		t.Errorf("discovery made calls %v and %d target listings despite -role-arn", eb.calls, eb.listTargets)
+	}
+
+	// Without the flag, the role is discovered
+	got, err = resolveRuleRoleArn(context.Background(), eb, "")
+	if err != nil || got != "arn:discovered" {
+		t.Errorf("resolveRuleRoleArn() without -role-arn = %q, %v, want arn:discovered", got, err)
+	}
+}
+
+func TestResolveRuleRoleArnNamesPrefix(t *testing.T) {
+	for name, eb := range map[string]*fakeEventBridge{
+		"no rule":    {},
+		"no role":    {rules: []eventtypes.Rule{{Name: aws.String(scheduleRulePrefix)}}, targets: []eventtypes.Target{{Id: aws.String("1")}}},
+		"list fails": {listRulesErr: errors.New("access denied")},
+	} {
+		_, err := resolveRuleRoleArn(context.Background(), eb, "")
+		if err == nil || !strings.Contains(err.Error(), `"`+scheduleRulePrefix+`"`) || !strings.Contains(err.Error(), "-role-arn") {
+			t.Errorf("%s: resolveRuleRoleArn() error = %v, want it to name the prefix and -role-arn", name, err)
+		}
+	}
+}
+
+func TestRuleScheduleExpression(t *testing.T) {
+	at := time.Date(2026, time.March, 7, 14, 5, 0, 0, time.UTC)
+	if got, want := ruleScheduleExpression(at, ""), "cron(5 14 7 3 ? 2026)"; got != want {
+		t.Errorf("ruleScheduleExpression() = %q, want %q", got, want)
+	}
+	for _, expr := range []string{"rate(1 minute)", "cron(0/5 * * * ? *)"} {
+		if got := ruleScheduleExpression(at, expr); got != expr {
+			t.Errorf("ruleScheduleExpression(%q) = %q, want it verbatim", expr, got)
+		}
+	}
+}
+
+func TestValidateScheduleExpression(t *testing.T) {
+	for _, expr := range []string{
+		"rate(1 minute)",
+		"rate(5 minutes)",
+		"rate(1 hour)",
+		"rate(12 hours)",
+		"rate(7 days)",
+		"cron(0/5 * * * ? *)",
+		"cron(15 10 ? * MON-FRI *)",
+		"cron(0 8 1 * ? 2026)",
+		"cron(0 18 ? * 6L *)",
+		"cron(0 10 ? * 2#1 *)",
+	} {
+		if err := validateScheduleExpression(expr); err != nil {
+			t.Errorf("validateScheduleExpression(%q) = %v, want nil", expr, err)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"rate(0 minutes)",
+		"rate(1 minutes)",
+		"rate(5 minute)",
+		"rate(-1 hours)",
+		"rate(5 weeks)",
+		"rate(5minutes)",
+		"rate(five minutes)",
+		"cron(0 12 * * *)",         // 5 fields
+		"cron(0 12 * * ? * *)",     // 7 fields
+		"cron(0 12 * * * *)",       // neither day field is '?'
+		"cron(0 12 ? * ? *)",       // both day fields are '?'
+		"cron(0 12 ? * MON;TUE *)", // invalid character
+		"0 12 * * ? *",             // missing cron(...)
+		"at(2026-03-07T14:05:00)",  // one-time schedules are EventBridge Scheduler only
+	} {
+		if err := validateScheduleExpression(expr); err == nil {
+			t.Errorf("validateScheduleExpression(%q) = nil, want an error", expr)
+		}
+	}
+}
+
+// fakeExecutions serves ListExecutions (newest first) and DescribeExecution
+// from a fixed set of executions
+type fakeExecutions struct {
+	executions []sfntypes.ExecutionListItem
+	inputs     map[string]string // execution ARN -> input
+	describes  int
+	lists      int
+	pageSize   int             // Executions per ListExecutions page; 0 for a single page
+	onList     func(calls int) // Called on each ListExecutions call, e.g. to start executions
+}
+
+func (f *fakeExecutions) ListExecutions(ctx context.Context, params *sfn.ListExecutionsInput, optFns ...func(*sfn.Options)) (*sfn.ListExecutionsOutput, error) {
+	f.lists++
+	if f.onList != nil {
+		f.onList(f.lists)
+	}
+	if f.pageSize == 0 {
+		return &sfn.ListExecutionsOutput{Executions: f.executions}, nil
+	}
+	start := 0
+	if params.NextToken != nil {
+		fmt.Sscan(*params.NextToken, &start)
+	}
+	end := min(start+f.pageSize, len(f.executions))
+	out := &sfn.ListExecutionsOutput{Executions: f.executions[start:end]}
+	if end < len(f.executions) {
+		out.NextToken = aws.String(fmt.Sprint(end))
+	}
+	return out, nil
+}
+
+func (f *fakeExecutions) DescribeExecution(ctx context.Context, params *sfn.DescribeExecutionInput, optFns ...func(*sfn.Options)) (*sfn.DescribeExecutionOutput, error) {
+	f.describes++
+	arn := aws.ToString(params.ExecutionArn)
+	return &sfn.DescribeExecutionOutput{ExecutionArn: params.ExecutionArn, Input: aws.String(f.inputs[arn])}, nil
+}
+
+// add records an execution started at startedAt; executions must be added
+// oldest first
+func (f *fakeExecutions) add(arn, input string, startedAt time.Time) {
+	f.executions = append([]sfntypes.ExecutionListItem{{ExecutionArn: aws.String(arn), StartDate: aws.Time(startedAt)}}, f.executions...)
+	if f.inputs == nil {
+		f.inputs = map[string]string{}
+	}
+	f.inputs[arn] = input
+}
+
+func TestWaitForFirstExecutionMatchesInput(t *testing.T) {
+	created := time.Now().Add(-5 * time.Minute)
+	client := &fakeExecutions{}
+	client.add("before-rule", `{"job":"test"}`, created.Add(-time.Minute))
+	client.add("workflow-schedule", `{"source":"schedule"}`, created.Add(time.Minute))
+	client.add("first", `{"job":"test"}`, created.Add(2*time.Minute))
+	client.add("second", `{"job":"test"}`, created.Add(3*time.Minute))
+
+	arn, err := waitForFirstExecution(context.Background(), client, "arn:sm", `{"job":"test"}`, created, time.Minute)
+	if err != nil {
+		t.Fatalf("waitForFirstExecution() error = %v", err)
+	}
+	if arn != "first" {
+		t.Errorf("waitForFirstExecution() = %q, want first", arn)
+	}
+	if client.describes != 3 {
+		t.Errorf("described %d executions, want the 3 started after the rule", client.describes)
+	}
+}
+
+func TestWaitForFirstExecutionCancelled(t *testing.T) {
+	client := &fakeExecutions{}
+	client.add("other", `{"source":"schedule"}`, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := waitForFirstExecution(ctx, client, "arn:sm", `{"job":"test"}`, time.Now().Add(-time.Minute), time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("waitForFirstExecution() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForFirstExecution() returned after %v, want well under the 10s poll interval", elapsed)
+	}
+	if client.describes != 1 {
+		t.Errorf("described %d executions, want 1", client.describes)
+	}
+}
+
+// fakeStateMachine answers DescribeStateMachine with out, or err
+type fakeStateMachine struct {
+	out *sfn.DescribeStateMachineOutput
+	err error
+}
+
+func (f fakeStateMachine) DescribeStateMachine(ctx context.Context, params *sfn.DescribeStateMachineInput, optFns ...func(*sfn.Options)) (*sfn.DescribeStateMachineOutput, error) {
+	return f.out, f.err
+}
+
+func TestValidateStateMachine(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  fakeStateMachine
+		wantErr string
+	}{
+		{
+			name:   "active",
+			client: fakeStateMachine{out: &sfn.DescribeStateMachineOutput{Name: aws.String("sm"), Status: sfntypes.StateMachineStatusActive, Type: sfntypes.StateMachineTypeStandard}},
+		},
+		{
+			name:    "deleting",
+			client:  fakeStateMachine{out: &sfn.DescribeStateMachineOutput{Name: aws.String("sm"), Status: sfntypes.StateMachineStatusDeleting}},
+			wantErr: "is DELETING, expected ACTIVE",
+		},
+		{
+			name:    "missing",
+			client:  fakeStateMachine{err: &sfntypes.StateMachineDoesNotExist{Message: aws.String("not found")}},
+			wantErr: "does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smType, err := validateStateMachine(context.Background(), tt.client, "arn:aws:states:us-east-1:123456789012:stateMachine:sm")
+			if tt.wantErr == "" {
+				if err != nil || smType != sfntypes.StateMachineTypeStandard {
+					t.Errorf("validateStateMachine() = %q, %v, want STANDARD", smType, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateStateMachine() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// flakyDescriber answers DescribeExecution with errs in turn, then with status
+type flakyDescriber struct {
+	errs   []error
+	status sfntypes.ExecutionStatus
+	calls  int
+}
+
+func (f *flakyDescriber) DescribeExecution(ctx context.Context, params *sfn.DescribeExecutionInput, optFns ...func(*sfn.Options)) (*sfn.DescribeExecutionOutput, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+	return &sfn.DescribeExecutionOutput{ExecutionArn: params.ExecutionArn, Status: f.status}, nil
+}
+
+func TestDescribeExecutionLeavesRetriesToSDK(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	client := &flakyDescriber{errs: []error{throttled}, status: sfntypes.ExecutionStatusRunning}
+
+	// The SDK retryer has already retried the call by the time it fails;
+	// retrying again here would multiply the attempts
+	_, err := describeExecution(context.Background(), client, "arn:exec")
+	if !errors.Is(err, throttled) {
+		t.Fatalf("describeExecution() error = %v, want %v", err, throttled)
+	}
+	if client.calls != 1 {
+		t.Errorf("DescribeExecution called %d times, want 1", client.calls)
+	}
+}
+
+func TestDescribeExecutionMissingFailsFast(t *testing.T) {
+	client := &flakyDescriber{errs: []error{&sfntypes.ExecutionDoesNotExist{Message: aws.String("no such execution")}}}
+	_, err := describeExecution(context.Background(), client, "arn:exec")
+	var notFound *sfntypes.ExecutionDoesNotExist
+	if !errors.As(err, &notFound) {
+		t.Fatalf("describeExecution() error = %v, want ExecutionDoesNotExist", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("DescribeExecution called %d times, want 1", client.calls)
+	}
+}
+
+func TestPrintExecutionOutput(t *testing.T) {
+	const output = `{"result":{"items":3},"status":"ok"}`
+	tests := []struct {
+		name string
+		raw  bool
+		in   string
+		want string
+	}{
+		{"pretty", false, output, "{\n  \"result\": {\n    \"items\": 3\n  },\n  \"status\": \"ok\"\n}\n"},
+		{"raw", true, output, output + "\n"},
+		{"not JSON", false, "plain text", "plain text\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "output.json")
+			if err := printExecutionOutput(tt.in, outputOptions{Raw: tt.raw, File: file}); err != nil {
+				t.Fatalf("printExecutionOutput() error = %v", err)
+			}
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output file = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintExecutionOutputUnwritableFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "missing", "output.json")
+	if err := printExecutionOutput(`{}`, outputOptions{File: file}); err == nil {
+		t.Error("printExecutionOutput() succeeded writing into a missing directory")
+	}
+}
+
+// fakeS3 records the objects uploaded with PutObject
+type fakeS3 struct {
+	objects map[string]string // "bucket/key" -> body
+	err     error
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if f.objects == nil {
+		f.objects = map[string]string{}
+	}
+	f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestPrepareInputUploadsOversizedInput(t *testing.T) {
+	input := `{"data":"` + strings.Repeat("x", maxInputBytes) + `"}`
+	client := &fakeS3{}
+
+	got, err := prepareInput(context.Background(), client, input, "inputs-bucket")
+	if err != nil {
+		t.Fatalf("prepareInput() error = %v", err)
+	}
+	var pointer struct {
+		InputS3URI string `json:"inputS3Uri"`
+	}
+	if err := json.Unmarshal([]byte(got), &pointer); err != nil {
+		t.Fatalf("prepareInput() = %q, want an S3 pointer: %v", got, err)
+	}
+	key, ok := strings.CutPrefix(pointer.InputS3URI, "s3://")
+	if !ok || client.objects[key] != input {
+		t.Errorf("pointer %q does not reference the uploaded input (uploaded %d objects)", pointer.InputS3URI, len(client.objects))
+	}
+}
+
+func TestPrepareInputSmallOrUnconfigured(t *testing.T) {
+	client := &fakeS3{}
+	if got, err := prepareInput(context.Background(), client, `{"job":"test"}`, "inputs-bucket"); err != nil || got != `{"job":"test"}` {
+		t.Errorf("prepareInput(small) = %q, %v, want the input unchanged", got, err)
+	}
+
+	large := strings.Repeat("x", maxInputBytes+1)
+	if _, err := prepareInput(context.Background(), client, large, ""); err == nil || !strings.Contains(err.Error(), "-input-s3-bucket") {
+		t.Errorf("prepareInput(large, no bucket) error = %v, want a hint to pass -input-s3-bucket", err)
+	}
+
+	client.err = errors.New("AccessDenied")
+	if _, err := prepareInput(context.Background(), client, large, "inputs-bucket"); err == nil {
+		t.Error("prepareInput() succeeded although the upload failed")
+	}
+	if len(client.objects) != 0 {
+		t.Errorf("uploaded %d objects for inputs that fit or failed, want 0", len(client.objects))
+	}
+}
+
+// fakeEventBus accepts PutEvents and starts an execution in executions for
+// every event, as the test rule would, with the event detail as eventDetail
+type fakeEventBus struct {
+	executions *fakeExecutions
+	calls      int
+	sent       []eventtypes.PutEventsRequestEntry
+}
+
+func (f *fakeEventBus) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.calls++
+	for _, entry := range params.Entries {
+		f.sent = append(f.sent, entry)
+		arn := fmt.Sprintf("exec-%d", len(f.sent))
+		f.executions.add(arn, `{"eventDetail":`+aws.ToString(entry.Detail)+`}`, time.Now())
+	}
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func TestExecuteViaEventBridgeFindsEveryExecution(t *testing.T) {
+	executions := &fakeExecutions{}
+	executions.add("unrelated", `{"source":"schedule"}`, time.Now())
+	bus := &fakeEventBus{executions: executions}
+
+	arns, err := executeViaEventBridge(context.Background(), bus, executions, "arn:sm", `{"job":"test"}`, "default", 12)
+	if err != nil {
+		t.Fatalf("executeViaEventBridge() error = %v", err)
+	}
+
+	if len(bus.sent) != 12 || bus.calls != 2 {
+		t.Errorf("sent %d events in %d PutEvents calls, want 12 in 2", len(bus.sent), bus.calls)
+	}
+	if len(arns) != 12 {
+		t.Fatalf("found %d executions, want 12", len(arns))
+	}
+	// Each event maps to the execution it started, in sending order
+	for i, arn := range arns {
+		if want := fmt.Sprintf("exec-%d", i+1); arn != want {
+			t.Errorf("execution %d = %q, want %q", i, arn, want)
+		}
+	}
+}
+
+func TestExecuteViaEventBridgeIgnoresNewerExecutions(t *testing.T) {
+	executions := &fakeExecutions{}
+	bus := &fakeEventBus{executions: executions}
+	// Executions started right after the event, which a start-time window
+	// would match
+	executions.onList = func(calls int) {
+		if calls == 1 {
+			executions.add("schedule", `{"source":"schedule"}`, time.Now())
+			executions.add("other-run", `{"eventDetail":{"correlationId":"jobrun-other-0"}}`, time.Now())
+		}
+	}
+
+	arns, err := executeViaEventBridge(context.Background(), bus, executions, "arn:sm", `{"job":"test"}`, "default", 1)
+	if err != nil {
+		t.Fatalf("executeViaEventBridge() error = %v", err)
+	}
+	if len(arns) != 1 || arns[0] != "exec-1" {
+		t.Errorf("executeViaEventBridge() = %q, want [exec-1], the execution the event started", arns)
+	}
+}
+
+func TestFindExecutionByCorrelationIDGivesUp(t *testing.T) {
+	executions := &fakeExecutions{}
+	executions.add("found", `{"eventDetail":{"correlationId":"a"}}`, time.Now())
+
+	_, err := findExecutionByCorrelationID(context.Background(), executions, "arn:sm", time.Now().Add(-time.Minute), []string{"a", "b"}, 3, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "found 1 of 2 executions after 3 attempts") {
+		t.Errorf("findExecutionByCorrelationID() error = %v, want 1 of 2 found after 3 attempts", err)
+	}
+}
+
+func TestFindExecutionByCorrelationIDCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := findExecutionByCorrelationID(ctx, &fakeExecutions{}, "arn:sm", time.Now().Add(-time.Minute), []string{"a"}, 10, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("findExecutionByCorrelationID() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("findExecutionByCorrelationID() returned after %v, want well under the poll interval", elapsed)
+	}
+}
+
+// fakeHistory serves GetExecutionHistory newest first, pageSize events a
+// page, from events given oldest first with Id i+1
+type fakeHistory struct {
+	events   []sfntypes.HistoryEvent
+	pageSize int
+	calls    int
+}
+
+func (f *fakeHistory) GetExecutionHistory(ctx context.Context, params *sfn.GetExecutionHistoryInput, optFns ...func(*sfn.Options)) (*sfn.GetExecutionHistoryOutput, error) {
+	f.calls++
+	end := len(f.events)
+	if params.NextToken != nil {
+		fmt.Sscan(*params.NextToken, &end)
+	}
+	start := max(end-f.pageSize, 0)
+	out := &sfn.GetExecutionHistoryOutput{}
+	for i := end - 1; i >= start; i-- {
+		out.Events = append(out.Events, f.events[i])
+	}
+	if start > 0 {
+		out.NextToken = aws.String(fmt.Sprint(start))
+	}
+	return out, nil
+}
+
+// add appends an event following the previous one and returns its ID
+func (f *fakeHistory) add(event sfntypes.HistoryEvent) int64 {
+	event.Id = int64(len(f.events) + 1)
+	if event.PreviousEventId == 0 && len(f.events) > 0 {
+		event.PreviousEventId = event.Id - 1
+	}
+	f.events = append(f.events, event)
+	return event.Id
+}
+
+// filler adds n events that are neither failures nor state entries
+func (f *fakeHistory) filler(n int) {
+	for i := 0; i < n; i++ {
+		f.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypePassStateExited})
+	}
+}
+
+func TestFindFailureCauseStopsAtFailureEvent(t *testing.T) {
+	history := &fakeHistory{pageSize: 3}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionStarted})
+	history.filler(40)
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskStateEntered,
+		StateEnteredEventDetails: &sfntypes.StateEnteredEventDetails{Name: aws.String("ProcessItems")}})
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskScheduled})
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskFailed,
+		TaskFailedEventDetails: &sfntypes.TaskFailedEventDetails{Error: aws.String("States.TaskFailed"), Cause: aws.String("exit code 1")}})
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionFailed,
+		ExecutionFailedEventDetails: &sfntypes.ExecutionFailedEventDetails{Error: aws.String("States.TaskFailed")}})
+
+	report, err := findFailureCause(context.Background(), history, "arn:execution", 1000, 1)
+	if err != nil {
+		t.Fatalf("findFailureCause() error = %v", err)
+	}
+	// The failure and the state it failed in are on the two newest pages
+	if history.calls != 2 {
+		t.Errorf("GetExecutionHistory called %d times, want 2", history.calls)
+	}
+	if report.Terminal == nil || report.Terminal.Type != sfntypes.HistoryEventTypeExecutionFailed {
+		t.Errorf("terminal event = %+v, want ExecutionFailed", report.Terminal)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].State != "ProcessItems" || report.Failures[0].Cause != "exit code 1" {
+		t.Errorf("failures = %+v, want the TaskFailed event of ProcessItems", report.Failures)
+	}
+}
+
+func TestFindFailureCauseStopsAtMaxEvents(t *testing.T) {
+	history := &fakeHistory{pageSize: 4}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionStarted})
+	history.filler(100)
+
+	report, err := findFailureCause(context.Background(), history, "arn:execution", 10, 1)
+	if err != nil {
+		t.Fatalf("findFailureCause() error = %v", err)
+	}
+	if history.calls != 3 {
+		t.Errorf("GetExecutionHistory called %d times for 10 events in pages of 4, want 3", history.calls)
+	}
+	if report.Terminal != nil || len(report.Failures) != 0 {
+		t.Errorf("report = %+v, want no failure", report)
+	}
+}
+
+func TestFindExecutionByCorrelationIDMatchesInput(t *testing.T) {
+	now := time.Now()
+	executions := &fakeExecutions{pageSize: 2}
+	// Started before the event was sent, with the same correlation ID
+	executions.add("too-old", `{"eventDetail":{"correlationId":"c-1"}}`, now.Add(-time.Hour))
+	executions.add("match", `{"eventDetail":{"correlationId":"c-1"}}`, now.Add(-3*time.Second))
+	// Newer executions that a start-time heuristic would pick
+	executions.add("schedule", `{"source":"schedule"}`, now.Add(-2*time.Second))
+	executions.add("other-event", `{"eventDetail":{"correlationId":"c-2"}}`, now.Add(-time.Second))
+	executions.add("not-json", `not json`, now)
+
+	arns, err := findExecutionByCorrelationID(context.Background(), executions, "arn:sm", now.Add(-time.Minute), []string{"c-1"}, 1, time.Millisecond)
+	if err != nil || len(arns) != 1 || arns[0] != "match" {
+		t.Fatalf("findExecutionByCorrelationID() = %q, %v, want [match]", arns, err)
+	}
+	// The scan pages through the listing and stops at executions older than since
+	if executions.lists != 3 {
+		t.Errorf("ListExecutions called %d times for 5 executions in pages of 2, want 3", executions.lists)
+	}
+	if executions.describes != 4 {
+		t.Errorf("DescribeExecution called %d times, want 4 (not the execution before since)", executions.describes)
+	}
+}
+
+func TestFindExecutionByCorrelationIDWaitsForExecution(t *testing.T) {
+	now := time.Now()
+	executions := &fakeExecutions{}
+	executions.add("unrelated", `{"source":"schedule"}`, now)
+	executions.onList = func(calls int) {
+		if calls == 3 {
+			executions.add("late", `{"eventDetail":{"correlationId":"c-1"}}`, now.Add(time.Second))
+		}
+	}
+
+	arns, err := findExecutionByCorrelationID(context.Background(), executions, "arn:sm", now.Add(-time.Minute), []string{"c-1"}, 5, time.Millisecond)
+	if err != nil || len(arns) != 1 || arns[0] != "late" {
+		t.Fatalf("findExecutionByCorrelationID() = %q, %v, want [late]", arns, err)
+	}
+	if executions.lists != 3 {
+		t.Errorf("ListExecutions called %d times, want 3", executions.lists)
+	}
+	// Inputs are read once, not on every scan
+	if executions.describes != 2 {
+		t.Errorf("DescribeExecution called %d times, want 2", executions.describes)
+	}
+
+	_, err = findExecutionByCorrelationID(context.Background(), executions, "arn:sm", now.Add(-time.Minute), []string{"missing"}, 2, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "found 0 of 1 executions after 2 attempts") {
+		t.Errorf("findExecutionByCorrelationID() of a missing ID = %v, want it to give up", err)
+	}
+}
+
+// taskFailed returns a TaskFailed event with the given cause
+func taskFailed(cause string) sfntypes.HistoryEvent {
+	return sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskFailed,
+		TaskFailedEventDetails: &sfntypes.TaskFailedEventDetails{Error: aws.String("States.TaskFailed"), Cause: aws.String(cause)}}
+}
+
+// stateEntered returns a TaskStateEntered event for state name
+func stateEntered(name string) sfntypes.HistoryEvent {
+	return sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskStateEntered,
+		StateEnteredEventDetails: &sfntypes.StateEnteredEventDetails{Name: aws.String(name)}}
+}
+
+func TestFindFailureCauseReadsBackToExecutionStart(t *testing.T) {
+	history := &fakeHistory{pageSize: 2}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionStarted})
+	// A failure in Fetch that was caught, then three failed attempts of Process
+	history.add(stateEntered("Fetch"))
+	history.add(taskFailed("fetch failed"))
+	history.add(stateEntered("Process"))
+	for _, cause := range []string{"attempt 1", "attempt 2", "attempt 3"} {
+		history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskScheduled})
+		history.add(taskFailed(cause))
+	}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionFailed,
+		ExecutionFailedEventDetails: &sfntypes.ExecutionFailedEventDetails{Error: aws.String("States.TaskFailed")}})
+
+	// Fewer failures than asked for: the scan ends at the execution start
+	report, err := findFailureCause(context.Background(), history, "arn:execution", 1000, 5)
+	if err != nil {
+		t.Fatalf("findFailureCause() error = %v", err)
+	}
+	var got []string
+	for _, failure := range report.Failures {
+		got = append(got, failure.State+": "+failure.Cause)
+	}
+	want := []string{"Process: attempt 3", "Process: attempt 2", "Process: attempt 1", "Fetch: fetch failed"}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("failures = %q, want %q", got, want)
+	}
+	if report.Terminal == nil || report.Terminal.Error != "States.TaskFailed" {
+		t.Errorf("terminal event = %+v, want ExecutionFailed with States.TaskFailed", report.Terminal)
+	}
+	if history.calls != 6 {
+		t.Errorf("GetExecutionHistory called %d times for 11 events in pages of 2, want 6", history.calls)
+	}
+}
+
+func TestFindFailureCauseStopsOnceStatesAreKnown(t *testing.T) {
+	history := &fakeHistory{pageSize: 2}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionStarted})
+	history.filler(40)
+	history.add(stateEntered("Process"))
+	for _, cause := range []string{"attempt 1", "attempt 2"} {
+		history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeTaskScheduled})
+		history.add(taskFailed(cause))
+	}
+	history.add(sfntypes.HistoryEvent{Type: sfntypes.HistoryEventTypeExecutionFailed,
+		ExecutionFailedEventDetails: &sfntypes.ExecutionFailedEventDetails{Error: aws.String("States.TaskFailed")}})
+
+	report, err := findFailureCause(context.Background(), history, "arn:execution", 1000, 2)
+	if err != nil {
+		t.Fatalf("findFailureCause() error = %v", err)
+	}
+	// The two failures are on the first two pages and their state on the third
+	if history.calls != 3 {
+		t.Errorf("GetExecutionHistory called %d times, want 3", history.calls)
+	}
+	if len(report.Failures) != 2 || report.Failures[0].State != "Process" || report.Failures[1].State != "Process" {
+		t.Errorf("failures = %+v, want two failures of Process", report.Failures)
+	}
+}
+
+func TestCheckStateMachineType(t *testing.T) {
+	for _, tt := range []struct {
+		smType  sfntypes.StateMachineType
+		express bool
+		wantErr string
+	}{
+		{sfntypes.StateMachineTypeExpress, true, ""},
+		{sfntypes.StateMachineTypeStandard, false, ""},
+		{sfntypes.StateMachineTypeStandard, true, "-express requires an EXPRESS state machine, but this one is STANDARD"},
+		{sfntypes.StateMachineTypeExpress, false, "pass -express"},
+	} {
+		err := checkStateMachineType(tt.smType, tt.express)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkStateMachineType(%s, express %v) = %v, want %q", tt.smType, tt.express, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReportSyncResult(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	succeeded := &sfn.StartSyncExecutionOutput{
+		ExecutionArn: aws.String("arn:express:exec"),
+		Status:       sfntypes.SyncExecutionStatusSucceeded,
+		Output:       aws.String(`{"status":"ok"}`),
+		StartDate:    aws.Time(start),
+		StopDate:     aws.Time(start.Add(1500 * time.Millisecond)),
+	}
+	file := filepath.Join(t.TempDir(), "output.json")
+	if err := reportSyncResult(succeeded, outputOptions{File: file}); err != nil {
+		t.Fatalf("reportSyncResult() error = %v", err)
+	}
+	if got, err := os.ReadFile(file); err != nil || string(got) != "{\n  \"status\": \"ok\"\n}\n" {
+		t.Errorf("output file = %q, %v, want the pretty-printed output", got, err)
+	}
+
+	for _, status := range []sfntypes.SyncExecutionStatus{sfntypes.SyncExecutionStatusFailed, sfntypes.SyncExecutionStatusTimedOut} {
+		failed := &sfn.StartSyncExecutionOutput{
+			ExecutionArn: aws.String("arn:express:exec"),
+			Status:       status,
+			Error:        aws.String("States.TaskFailed"),
+			Cause:        aws.String("boom"),
+		}
+		file := filepath.Join(t.TempDir(), "output.json")
+		err := reportSyncResult(failed, outputOptions{File: file})
+		if err == nil || !strings.Contains(err.Error(), string(status)) || !strings.Contains(err.Error(), "States.TaskFailed") {
+			t.Errorf("reportSyncResult(%s) = %v, want an error naming the status and error", status, err)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("reportSyncResult(%s) wrote an output file", status)
+		}
+	}
+}
@@ -1,20 +1,62 @@
 package main
 
 import (
-	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/example/hello-fargate-app/drain"
+	"github.com/example/hello-fargate-app/handlertimeout"
+	"github.com/example/hello-fargate-app/serverid"
+	"github.com/example/hello-fargate-app/tlsversion"
 )
 
+// EchoResponse represents the echo endpoint response (same shape as the
+// backend service's)
+type EchoResponse struct {
+	Message   string                 `json:"message"`
+	ServerID  string                 `json:"server_id"`
+	Timestamp string                 `json:"timestamp"`
+	Echo      map[string]interface{} `json:"echo,omitempty"`
+}
+
 var serverID string
 
+// writeTimeout is the server's WriteTimeout, which HANDLER_TIMEOUT_SECONDS
+// must stay below
+const writeTimeout = 10 * time.Second
+
+// Build metadata, set at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// requiredScope is the scope that callers of /api/echo must hold, checked in
+// addition to ALB's jwt-validation. Empty disables the check.
+var requiredScope = os.Getenv("REQUIRED_SCOPE")
+
+// maxEchoBodyBytes caps the JSON body that /api/echo reads on POST
+const maxEchoBodyBytes = 1 << 20
+
+// drainer serves /ready and drains the server on shutdown
+var drainer drain.Drainer
+
 func init() {
-	serverID, _ = os.Hostname()
+	// Use container hostname as unique server ID
+	serverID = serverid.Resolve()
 }
 
 func main() {
@@ -23,35 +65,63 @@ func main() {
 		port = "8080"
 	}
 
+	drainDelay, err := drain.DelayFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	drainer.ServerID = serverID
+	drainer.Delay = drainDelay
+
+	// Bound the processing time of every request
+	handlerTimeout, err := handlertimeout.FromEnv(writeTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
+	mux.Handle("/ready", &drainer)
+	mux.HandleFunc("/version", versionHandler)
 	mux.HandleFunc("/api/echo", echoHandler)
 	mux.HandleFunc("/api/whoami", whoamiHandler)
 
 	server := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      handlertimeout.Wrap(mux, handlerTimeout),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
 
-	// Graceful shutdown
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-
-		log.Println("Shutting down server...")
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+	// Terminate TLS directly when a certificate is provided
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	tlsEnabled := tlsCertFile != "" && tlsKeyFile != ""
+	if tlsEnabled {
+		minVersion, err := tlsversion.Parse(os.Getenv("TLS_MIN_VERSION"))
+		if err != nil {
+			log.Fatalf("Invalid TLS_MIN_VERSION: %v", err)
 		}
-	}()
+		server.TLSConfig = &tls.Config{MinVersion: minVersion}
+	}
+
+	// Graceful shutdown: fail /ready, drain, then shut down
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go drainer.ShutdownOnSignal(server, sigChan)
 
 	log.Printf("API server starting on port %s (server_id: %s)", port, serverID)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if requiredScope != "" {
+		log.Printf("Requiring scope %q on /api/echo", requiredScope)
+	}
+
+	drainer.SetReady()
+	if tlsEnabled {
+		log.Printf("TLS enabled (min version: %s)", tls.VersionName(server.TLSConfig.MinVersion))
+		err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 	log.Println("Server stopped")
@@ -66,16 +136,112 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// echoHandler returns a simple response (protected by ALB jwt-validation)
+// versionHandler reports the build metadata of the running image, which tells
+// old and new tasks apart during a rolling deployment
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"server_id":  serverID,
+	})
+}
+
+// echoHandler returns a simple response (protected by ALB jwt-validation),
+// reflecting the JSON body of POST requests. When REQUIRED_SCOPE is set, it
+// also rejects tokens without that scope.
 func echoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":   "Hello from protected API",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"server_id": serverID,
+	if requiredScope != "" {
+		if err := checkScope(r, requiredScope); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":     err.Error(),
+				"server_id": serverID,
+			})
+			return
+		}
+	}
+
+	// Reflect a posted JSON object back under "echo"; an empty body is fine
+	var input map[string]interface{}
+	if r.Method == http.MethodPost {
+		body := http.MaxBytesReader(w, r.Body, maxEchoBodyBytes)
+		if err := json.NewDecoder(body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+			status, msg := http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status, msg = http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", tooLarge.Limit)
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":     msg,
+				"server_id": serverID,
+			})
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(EchoResponse{
+		Message:   "Hello from protected API",
+		ServerID:  serverID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Echo:      input,
 	})
 }
 
+// checkScope returns an error unless the caller's access token carries the
+// given scope in its space-separated "scope" claim. The token signature is
+// not verified here; that is left to ALB's jwt-validation rule.
+func checkScope(r *http.Request, scope string) error {
+	token := accessToken(r)
+	if token == "" {
+		return fmt.Errorf("missing access token")
+	}
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		return fmt.Errorf("invalid access token: %w", err)
+	}
+	granted, _ := claims["scope"].(string)
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return nil
+		}
+	}
+	return fmt.Errorf("access token lacks required scope %q", scope)
+}
+
+// accessToken returns the bearer token of the Authorization header, which is
+// the token ALB's jwt-validation rule checked. Other headers such as
+// X-Amzn-Oidc-Accesstoken are only set by ALB's authenticate-oidc action, and
+// jwt-validation passes them through from the client unchanged, so they
+// cannot be trusted here.
+func accessToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return ""
+}
+
+// decodeJWTClaims decodes the payload segment of a JWT without verifying it
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected 3 segments, got %d", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("parse claims: %w", err)
+	}
+	return claims, nil
+}
+
 // whoamiHandler returns request headers (useful for debugging ALB-added headers)
 func whoamiHandler(w http.ResponseWriter, r *http.Request) {
 	// Convert headers to a simple map for cleaner JSON output
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testToken returns an unsigned JWT carrying the given scope claim
+func testToken(t *testing.T, scope string) string {
+	t.Helper()
+	payload, err := json.Marshal(map[string]string{"sub": "client-1", "scope": scope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func TestEchoHandlerRequiredScope(t *testing.T) {
+	orig := requiredScope
+	requiredScope = "api/read"
+	t.Cleanup(func() { requiredScope = orig })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"scope present", map[string]string{"Authorization": "Bearer " + testToken(t, "api/write api/read")}, http.StatusOK},
+		{"scope missing", map[string]string{"Authorization": "Bearer " + testToken(t, "api/write")}, http.StatusForbidden},
+		{"no token", nil, http.StatusForbidden},
+		{"malformed token", map[string]string{"Authorization": "Bearer not-a-jwt"}, http.StatusForbidden},
+		// ALB passes client headers through, so a forged one must not grant the scope
+		{"forged oidc header", map[string]string{
+			"Authorization":           "Bearer " + testToken(t, "api/write"),
+			"X-Amzn-Oidc-Accesstoken": testToken(t, "api/read"),
+		}, http.StatusForbidden},
+		{"forged oidc header only", map[string]string{"X-Amzn-Oidc-Accesstoken": testToken(t, "api/read")}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/echo", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			echoHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusForbidden {
+				var body map[string]string
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["error"] == "" {
+					t.Errorf("body = %v, %v, want a JSON error", body, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthHandlerIgnoresRequiredScope(t *testing.T) {
+	orig := requiredScope
+	requiredScope = "api/read"
+	t.Cleanup(func() { requiredScope = orig })
+
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestEchoHandlerBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		want     int
+		wantEcho map[string]interface{}
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, nil},
+		{"valid post", http.MethodPost, `{"hello":"world","n":1}`, http.StatusOK, map[string]interface{}{"hello": "world", "n": 1.0}},
+		{"empty post", http.MethodPost, "", http.StatusOK, nil},
+		{"malformed post", http.MethodPost, `{"hello":`, http.StatusBadRequest, nil},
+		{"oversized post", http.MethodPost, `{"pad":"` + strings.Repeat("x", maxEchoBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			echoHandler(rec, httptest.NewRequest(tt.method, "/api/echo", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			var resp struct {
+				EchoResponse
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if tt.want != http.StatusOK {
+				if resp.Error == "" {
+					t.Error("error response has no error message")
+				}
+				return
+			}
+			if !reflect.DeepEqual(resp.Echo, tt.wantEcho) {
+				t.Errorf("echo = %v, want %v", resp.Echo, tt.wantEcho)
+			}
+		})
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	prev := serverID
+	t.Cleanup(func() { serverID = prev })
+	serverID = "api-1"
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/version returned invalid JSON %q: %v", rec.Body, err)
+	}
+	// Without -ldflags the build metadata defaults to dev
+	want := map[string]string{"version": "dev", "commit": "dev", "build_time": "dev", "server_id": "api-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/version = %v, want %v", got, want)
+	}
+}
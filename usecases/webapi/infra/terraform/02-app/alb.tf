@@ -74,7 +74,7 @@ resource "aws_lb_target_group" "api" {
     healthy_threshold   = 2
     interval            = 30
     matcher             = "200"
-    path                = "/health"
+    path                = "/ready"
     port                = "traffic-port"
     protocol            = "HTTP"
     timeout             = 5
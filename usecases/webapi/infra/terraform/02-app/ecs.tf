@@ -105,9 +105,21 @@ resource "aws_ecs_task_definition" "api" {
         {
           name  = "PORT"
           value = "8080"
+        },
+        {
+          name  = "REQUIRED_SCOPE"
+          value = "${aws_cognito_resource_server.api.identifier}/read"
+        },
+        {
+          name  = "DRAIN_SECONDS"
+          value = tostring(var.drain_seconds)
         }
       ]
 
+      # How long ECS waits after SIGTERM before killing the container; the
+      # app fits its drain and shutdown within it (drain.StopTimeout in lib/app)
+      stopTimeout = 30
+
       logConfiguration = {
         logDriver = "awslogs"
         options = {
@@ -6,6 +6,8 @@ set -e
 
 SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
 PROJECT_ROOT=$(realpath "$SCRIPT_DIR/..")
+# Images are built from the repository root, which holds the shared lib/app module
+REPO_ROOT=$(realpath "$PROJECT_ROOT/../..")
 TF_ECR_DIR="$PROJECT_ROOT/infra/terraform/01-ecr"
 
 # Get AWS region
@@ -20,11 +22,18 @@ if [ -z "$REPO_URL" ]; then
     exit 1
 fi
 
+# Build metadata reported by /version
+BUILD_ARGS=(
+    --build-arg "VERSION=$(git -C "$PROJECT_ROOT" describe --tags --always --dirty 2>/dev/null || echo dev)"
+    --build-arg "COMMIT=$(git -C "$PROJECT_ROOT" rev-parse --short HEAD 2>/dev/null || echo dev)"
+    --build-arg "BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+)
+
 echo "Building Docker image..."
 
 # Build API image
 echo "Building API image..."
-docker build -t hello-fargate-webapi-app:latest "$PROJECT_ROOT/apps/api"
+docker build "${BUILD_ARGS[@]}" -t hello-fargate-webapi-app:latest -f "$PROJECT_ROOT/apps/api/Dockerfile" "$REPO_ROOT"
 
 # Login to ECR
 echo "Logging into ECR..."
@@ -3,17 +3,28 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/example/hello-fargate-harness"
 )
 
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
 // TokenResponse represents the OAuth2 token response from Cognito
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
@@ -28,11 +39,32 @@ func main() {
 	clientSecret := flag.String("client-secret", "", "Cognito app client secret")
 	scope := flag.String("scope", "", "OAuth scope to request")
 	timeout := flag.Duration("timeout", 5*time.Minute, "Test timeout")
+	headerTransport := harness.RegisterHeaderFlags(flag.CommandLine, version)
+	output := flag.String("output", "text", "Output mode: 'text' stops at the first failure, 'junit' runs every test and writes a JUnit XML report")
+	expiryTest := flag.Bool("expiry-test", false, "After the functional tests, wait for the access token to expire and check that ALB rejects it and accepts a fresh one (needs a -timeout beyond the token lifetime)")
+	expiryDryRun := flag.Bool("expiry-dry-run", false, "With -expiry-test, only log the wait and the expired-token check instead of doing them, so that the -timeout budget and the token refresh are checked right away")
+	loadRequests := flag.Int("load-requests", 0, "After the functional tests pass, send this many authenticated requests to /api/echo and report latency and throughput (0 disables)")
+	loadConcurrency := flag.Int("load-concurrency", 10, "Number of concurrent workers for -load-requests")
+	loadMaxErrorRate := flag.Float64("load-max-error-rate", 0.01, "Fail the load test if more than this fraction (0.0-1.0) of requests fail")
+	echoPayload := flag.String("echo-payload", "", "JSON object that Test 4 POSTs to /api/echo and expects echoed back (empty sends a GET)")
+	reportFile := flag.String("report-file", "results.xml", "Path of the JUnit XML report (with -output junit)")
 	flag.Parse()
 
 	if *albURL == "" || *tokenEndpoint == "" || *clientID == "" || *clientSecret == "" || *scope == "" {
 		log.Fatal("Required flags: -alb-url, -token-endpoint, -client-id, -client-secret, -scope")
 	}
+	if *output != "text" && *output != "junit" {
+		log.Fatalf("Invalid -output %q: must be 'text' or 'junit'", *output)
+	}
+	if *loadRequests > 0 && *loadConcurrency < 1 {
+		log.Fatalf("Invalid -load-concurrency %d: must be at least 1", *loadConcurrency)
+	}
+	if *echoPayload != "" {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(*echoPayload), &obj); err != nil {
+			log.Fatalf("Invalid -echo-payload: must be a JSON object: %v", err)
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
@@ -40,86 +72,346 @@ func main() {
 	// Create HTTP client that skips TLS verification (self-signed cert)
 	httpClient := &http.Client{
 		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
+		Transport: headerTransport.Wrap(&http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true, // Required for self-signed certificate
 			},
-		},
+		}),
+	}
+
+	// Client for the Cognito token endpoint, which has a valid certificate
+	tokenClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: headerTransport.Wrap(http.DefaultTransport),
 	}
 
 	// Wait for ALB health check to pass
 	log.Println("Waiting for ALB to be healthy...")
-	if err := waitForHealth(ctx, httpClient, *albURL+"/health"); err != nil {
+	if err := harness.WaitForHealth(ctx, httpClient, *albURL+"/health"); err != nil {
 		log.Fatalf("ALB not healthy: %v", err)
 	}
 	log.Println("ALB is healthy!")
 
-	// Test 1: Unauthenticated request to /health (should succeed - not protected)
-	log.Println("\n=== Test 1: Unauthenticated request to /health ===")
-	if err := testHealthEndpoint(ctx, httpClient, *albURL+"/health"); err != nil {
-		log.Fatalf("Test 1 FAILED: %v", err)
+	var token string
+	var tokenExpiresAt time.Time
+	tests := []testCase{
+		{
+			name: "Unauthenticated request to /health",
+			run: func() (string, error) {
+				return "Health endpoint accessible without authentication", testHealthEndpoint(ctx, httpClient, *albURL+"/health")
+			},
+		},
+		{
+			name: "Unauthenticated request to /api/echo",
+			run: func() (string, error) {
+				return "Protected endpoint correctly rejected unauthenticated request", testUnauthenticated(ctx, httpClient, *albURL+"/api/echo")
+			},
+		},
+		{
+			name: "Getting access token from Cognito",
+			run: func() (string, error) {
+				tokenResp, err := getAccessToken(ctx, tokenClient, *tokenEndpoint, *clientID, *clientSecret, *scope)
+				if err != nil {
+					return "", fmt.Errorf("failed to get access token: %w", err)
+				}
+				token = tokenResp.AccessToken
+				if tokenExpiresAt, err = tokenExpiry(tokenResp, time.Now()); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+				return fmt.Sprintf("Got access token (length: %d chars)", len(token)), nil
+			},
+		},
+		{
+			name: "Authenticated request to /api/echo",
+			run: func() (string, error) {
+				return "Protected endpoint accessible with valid JWT", testAuthenticated(ctx, httpClient, *albURL+"/api/echo", token, *echoPayload)
+			},
+		},
+		{
+			name: "Verify /api/whoami endpoint",
+			run: func() (string, error) {
+				return "Whoami endpoint returns server information", testWhoami(ctx, httpClient, *albURL+"/api/whoami", token)
+			},
+		},
 	}
-	log.Println("Test 1 PASSED: Health endpoint accessible without authentication")
-
-	// Test 2: Unauthenticated request to /api/echo (should fail with 401)
-	log.Println("\n=== Test 2: Unauthenticated request to /api/echo ===")
-	if err := testUnauthenticated(ctx, httpClient, *albURL+"/api/echo"); err != nil {
-		log.Fatalf("Test 2 FAILED: %v", err)
+	if *expiryTest {
+		tests = append(tests, testCase{
+			name: "Expired token rejected, fresh token accepted",
+			run: func() (string, error) {
+				refresh := func() (string, error) {
+					tokenResp, err := getAccessToken(ctx, tokenClient, *tokenEndpoint, *clientID, *clientSecret, *scope)
+					return tokenResp.AccessToken, err
+				}
+				return "ALB enforces token expiry", testTokenExpiry(ctx, httpClient, *albURL+"/api/echo", token, tokenExpiresAt, refresh, sleepContext, *expiryDryRun)
+			},
+		})
 	}
-	log.Println("Test 2 PASSED: Protected endpoint correctly rejected unauthenticated request")
 
-	// Test 3: Get access token from Cognito
-	log.Println("\n=== Test 3: Getting access token from Cognito ===")
-	token, err := getAccessToken(ctx, *tokenEndpoint, *clientID, *clientSecret, *scope)
-	if err != nil {
-		log.Fatalf("Test 3 FAILED: Failed to get access token: %v", err)
+	// In junit mode a failing test is recorded and the remaining tests still run
+	results := runTests(tests, *output == "junit")
+
+	if *output == "junit" {
+		if err := writeJUnitReport(*reportFile, "apitest", results); err != nil {
+			log.Fatalf("Failed to write JUnit report: %v", err)
+		}
+		log.Printf("JUnit report written to %s", *reportFile)
 	}
-	log.Printf("Test 3 PASSED: Got access token (length: %d chars)", len(token))
 
-	// Test 4: Authenticated request to /api/echo (should succeed)
-	log.Println("\n=== Test 4: Authenticated request to /api/echo ===")
-	if err := testAuthenticated(ctx, httpClient, *albURL+"/api/echo", token); err != nil {
-		log.Fatalf("Test 4 FAILED: %v", err)
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
 	}
-	log.Println("Test 4 PASSED: Protected endpoint accessible with valid JWT")
+	if failed > 0 {
+		log.Fatalf("%d of %d tests FAILED", failed, len(tests))
+	}
+
+	if *loadRequests > 0 {
+		log.Printf("\n=== Load test: %d requests to /api/echo (concurrency: %d) ===", *loadRequests, *loadConcurrency)
+		result := runLoad(ctx, httpClient, *albURL+"/api/echo", token, *loadRequests, *loadConcurrency)
+		printLoadSummary(result)
 
-	// Test 5: Verify /api/whoami returns expected data
-	log.Println("\n=== Test 5: Verify /api/whoami endpoint ===")
-	if err := testWhoami(ctx, httpClient, *albURL+"/api/whoami", token); err != nil {
-		log.Fatalf("Test 5 FAILED: %v", err)
+		errorRate := float64(result.Errors) / float64(*loadRequests)
+		if errorRate > *loadMaxErrorRate {
+			log.Fatalf("Load test FAILED: error rate %.2f%% exceeds the %.2f%% maximum", errorRate*100, *loadMaxErrorRate*100)
+		}
+		log.Println("Load test PASSED")
 	}
-	log.Println("Test 5 PASSED: Whoami endpoint returns server information")
 
 	fmt.Println("\n========================================")
 	fmt.Println("All JWT validation tests PASSED!")
 	fmt.Println("========================================")
 }
 
-func waitForHealth(ctx context.Context, client *http.Client, healthURL string) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+// testCase is one step of the test run. run returns the message logged when
+// the step passes.
+type testCase struct {
+	name string
+	run  func() (string, error)
+}
+
+// testResult records the outcome of a testCase
+type testResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// runTests runs the tests in order and returns their results. Unless
+// continueOnFailure is set, the first failure aborts the run.
+func runTests(tests []testCase, continueOnFailure bool) []testResult {
+	var results []testResult
+	for i, tc := range tests {
+		log.Printf("\n=== Test %d: %s ===", i+1, tc.name)
+
+		start := time.Now()
+		msg, err := tc.run()
+		results = append(results, testResult{Name: tc.name, Duration: time.Since(start), Err: err})
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
 		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+			if !continueOnFailure {
+				log.Fatalf("Test %d FAILED: %v", i+1, err)
+			}
+			log.Printf("Test %d FAILED: %v", i+1, err)
+			continue
 		}
+		log.Printf("Test %d PASSED: %s", i+1, msg)
+	}
+	return results
+}
+
+// loadResult holds the outcome of a load test
+type loadResult struct {
+	Latencies []time.Duration // Latencies of the successful requests
+	Errors    int             // Requests that failed or did not return 200
+	Elapsed   time.Duration   // Wall-clock time of the whole run
+}
 
-		resp, err := client.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			resp.Body.Close()
-			return nil
+// runLoad sends requests authenticated GETs to url with up to concurrency
+// workers sharing client and the bearer token
+func runLoad(ctx context.Context, client *http.Client, url, token string, requests, concurrency int) loadResult {
+	var result loadResult
+	var mu sync.Mutex
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				latency, err := sendAuthenticated(ctx, client, url, token)
+
+				mu.Lock()
+				if err != nil {
+					result.Errors++
+				} else {
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+// sendAuthenticated sends one authenticated GET and returns its latency
+func sendAuthenticated(ctx context.Context, client *http.Client, url, token string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// latencyStats summarizes a set of request latencies
+type latencyStats struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// summarizeLatencies computes the latency statistics, using the nearest-rank
+// method for percentiles. It returns zero stats for no latencies.
+func summarizeLatencies(latencies []time.Duration) latencyStats {
+	if len(latencies) == 0 {
+		return latencyStats{}
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	percentile := func(p int) time.Duration {
+		rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+		if rank < 1 {
+			rank = 1
 		}
-		if resp != nil {
-			resp.Body.Close()
+		return sorted[rank-1]
+	}
+
+	return latencyStats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(50),
+		P95:   percentile(95),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// printLoadSummary prints the load test results as a table
+func printLoadSummary(result loadResult) {
+	stats := summarizeLatencies(result.Latencies)
+	total := stats.Count + result.Errors
+
+	var throughput float64
+	if result.Elapsed > 0 {
+		throughput = float64(total) / result.Elapsed.Seconds()
+	}
+
+	fmt.Println("\n--- Load Test Results ---")
+	fmt.Printf("%-12s %d\n", "Requests", total)
+	fmt.Printf("%-12s %d\n", "Errors", result.Errors)
+	fmt.Printf("%-12s %v\n", "Duration", result.Elapsed.Round(time.Millisecond))
+	fmt.Printf("%-12s %.1f req/s\n", "Throughput", throughput)
+	fmt.Printf("%-12s %v\n", "Min", stats.Min.Round(time.Microsecond))
+	fmt.Printf("%-12s %v\n", "Mean", stats.Mean.Round(time.Microsecond))
+	fmt.Printf("%-12s %v\n", "p50", stats.P50.Round(time.Microsecond))
+	fmt.Printf("%-12s %v\n", "p95", stats.P95.Round(time.Microsecond))
+	fmt.Printf("%-12s %v\n", "p99", stats.P99.Round(time.Microsecond))
+	fmt.Printf("%-12s %v\n", "Max", stats.Max.Round(time.Microsecond))
+	fmt.Println("-------------------------")
+}
+
+// junitTestSuite is the root element of a JUnit XML report
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// marshalJUnit renders the results as a JUnit XML test suite
+func marshalJUnit(suiteName string, results []testResult) ([]byte, error) {
+	suite := junitTestSuite{Name: suiteName, Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      r.Name,
+			ClassName: suiteName,
+			Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
 		}
+		if r.Err != nil {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: r.Err.Error(), Text: r.Err.Error()}
+		}
+		total += r.Duration
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
 
-		log.Printf("Waiting for health check... (error: %v)", err)
-		time.Sleep(5 * time.Second)
+	out, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// writeJUnitReport writes the results as a JUnit XML file at path
+func writeJUnitReport(path, suiteName string, results []testResult) error {
+	data, err := marshalJUnit(suiteName, results)
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(path, data, 0644)
 }
 
 func testHealthEndpoint(ctx context.Context, client *http.Client, url string) error {
@@ -138,6 +430,7 @@ func testHealthEndpoint(ctx context.Context, client *http.Client, url string) er
 	log.Printf("Response status: %d, body: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 
 	if resp.StatusCode != http.StatusOK {
+		logFailedResponse(req, resp, body)
 		return fmt.Errorf("expected 200, got %d: %s", resp.StatusCode, body)
 	}
 	return nil
@@ -157,6 +450,9 @@ func testUnauthenticated(ctx context.Context, client *http.Client, url string) e
 
 	body, _ := io.ReadAll(resp.Body)
 	log.Printf("Response status: %d, body length: %d", resp.StatusCode, len(body))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logFailedResponse(req, resp, body)
+	}
 
 	if resp.StatusCode != http.StatusUnauthorized {
 		return fmt.Errorf("expected 401, got %d: %s", resp.StatusCode, body)
@@ -164,14 +460,14 @@ func testUnauthenticated(ctx context.Context, client *http.Client, url string) e
 	return nil
 }
 
-func getAccessToken(ctx context.Context, tokenURL, clientID, clientSecret, scope string) (string, error) {
+func getAccessToken(ctx context.Context, client *http.Client, tokenURL, clientID, clientSecret, scope string) (TokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "client_credentials")
 	data.Set("scope", scope)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return TokenResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.SetBasicAuth(clientID, clientSecret)
@@ -181,9 +477,9 @@ func getAccessToken(ctx context.Context, tokenURL, clientID, clientSecret, scope
 	log.Printf("Client ID: %s", clientID)
 	log.Printf("Scope: %s", scope)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("token request failed: %w", err)
+		return TokenResponse{}, fmt.Errorf("token request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -191,29 +487,39 @@ func getAccessToken(ctx context.Context, tokenURL, clientID, clientSecret, scope
 	log.Printf("Token response status: %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
+		return TokenResponse{}, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
 	}
 
 	var tokenResp TokenResponse
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return "", fmt.Errorf("failed to parse token response: %w", err)
+		return TokenResponse{}, fmt.Errorf("failed to parse token response: %w", err)
 	}
 
 	if tokenResp.AccessToken == "" {
-		return "", fmt.Errorf("empty access token in response")
+		return TokenResponse{}, fmt.Errorf("empty access token in response")
 	}
 
 	log.Printf("Token type: %s, expires in: %d seconds", tokenResp.TokenType, tokenResp.ExpiresIn)
-	return tokenResp.AccessToken, nil
+	return tokenResp, nil
 }
 
-func testAuthenticated(ctx context.Context, client *http.Client, url, token string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func testAuthenticated(ctx context.Context, client *http.Client, url, token, payload string) error {
+	method := http.MethodGet
+	var reqBody io.Reader
+	if payload != "" {
+		method = http.MethodPost
+		reqBody = strings.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
+	if payload != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -225,11 +531,140 @@ func testAuthenticated(ctx context.Context, client *http.Client, url, token stri
 	log.Printf("Response status: %d, body: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 
 	if resp.StatusCode != http.StatusOK {
+		logFailedResponse(req, resp, body)
 		return fmt.Errorf("expected 200, got %d: %s", resp.StatusCode, body)
 	}
+	if payload == "" {
+		return nil
+	}
+
+	// Verify the posted payload is echoed back unchanged
+	var want map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &want); err != nil {
+		return fmt.Errorf("failed to parse -echo-payload: %w", err)
+	}
+	var result struct {
+		Echo map[string]interface{} `json:"echo"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	// The echo key is omitted for an empty object, which decodes as nil
+	if len(want) == 0 && len(result.Echo) == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(result.Echo, want) {
+		return fmt.Errorf("expected echo %v, got %v", want, result.Echo)
+	}
+	return nil
+}
+
+// expiryMargin is how long after the token's expiry the expired token is
+// retried, to allow for clock skew between the runner and ALB
+const expiryMargin = 10 * time.Second
+
+// testTokenExpiry waits until just after expiresAt, checks that ALB rejects
+// the expired token with 401, then checks that a token from refresh is
+// accepted again. The wait is done by sleep so that it can be stubbed out,
+// and fails up front if it would outlast the ctx deadline. A dry run only
+// logs the wait and the expired-token check.
+func testTokenExpiry(ctx context.Context, client *http.Client, url, token string, expiresAt time.Time, refresh func() (string, error), sleep func(context.Context, time.Duration) error, dryRun bool) error {
+	if token == "" {
+		return fmt.Errorf("no access token to expire")
+	}
+	if expiresAt.IsZero() {
+		return fmt.Errorf("the expiry of the access token is unknown")
+	}
+
+	wait := time.Until(expiresAt.Add(expiryMargin))
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		return fmt.Errorf("token expires at %s, after the -timeout deadline %s", expiresAt.Format(time.RFC3339), deadline.Format(time.RFC3339))
+	}
+
+	if dryRun {
+		log.Printf("Dry run: would wait %v for the access token to expire and expect 401 for it", wait.Round(time.Second))
+	} else {
+		log.Printf("Waiting %v for the access token to expire...", wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			return fmt.Errorf("waiting for token expiry: %w", err)
+		}
+
+		if err := testExpiredToken(ctx, client, url, token); err != nil {
+			return err
+		}
+		log.Println("Expired token rejected, fetching a fresh token...")
+	}
+
+	fresh, err := refresh()
+	if err != nil {
+		return fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	return testAuthenticated(ctx, client, url, fresh, "")
+}
+
+// tokenExpiry returns when the access token expires: expires_in after
+// issuedAt or, if the response lacks expires_in, at the exp claim of the
+// token itself
+func tokenExpiry(tokenResp TokenResponse, issuedAt time.Time) (time.Time, error) {
+	if tokenResp.ExpiresIn > 0 {
+		return issuedAt.Add(time.Duration(tokenResp.ExpiresIn) * time.Second), nil
+	}
+
+	parts := strings.Split(tokenResp.AccessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("token response has no expires_in and the access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("token response has no expires_in and the JWT payload cannot be decoded: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("token response has no expires_in and the JWT has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// testExpiredToken checks that a request with an expired token gets 401
+func testExpiredToken(ctx context.Context, client *http.Client, url, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	log.Printf("Response status: %d, body length: %d", resp.StatusCode, len(body))
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		logFailedResponse(req, resp, body)
+		return fmt.Errorf("expected 401 for the expired token, got %d: %s", resp.StatusCode, body)
+	}
 	return nil
 }
 
+// sleepContext sleeps for d, returning early with the error of ctx if it
+// is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func testWhoami(ctx context.Context, client *http.Client, url, token string) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -248,6 +683,7 @@ func testWhoami(ctx context.Context, client *http.Client, url, token string) err
 	log.Printf("Response status: %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
+		logFailedResponse(req, resp, body)
 		return fmt.Errorf("expected 200, got %d: %s", resp.StatusCode, body)
 	}
 
@@ -267,3 +703,16 @@ func testWhoami(ctx context.Context, client *http.Client, url, token string) err
 	log.Printf("Server ID: %v", result["server_id"])
 	return nil
 }
+
+// logFailedResponse logs the request and response details needed to diagnose
+// why ALB rejected a request. On JWT validation failures, ALB explains the
+// rejection reason in the WWW-Authenticate header rather than in the body.
+func logFailedResponse(req *http.Request, resp *http.Response, body []byte) {
+	log.Printf("Request: %s %s (Authorization header present: %t)", req.Method, req.URL, req.Header.Get("Authorization") != "")
+	if wwwAuth := resp.Header.Get("WWW-Authenticate"); wwwAuth != "" {
+		log.Printf("WWW-Authenticate: %s", wwwAuth)
+	}
+	if len(body) > 0 {
+		log.Printf("Response body: %s", strings.TrimSpace(string(body)))
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLog redirects the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestUnauthenticatedLogsWWWAuthenticate(t *testing.T) {
+	const wwwAuth = `Bearer error="invalid_token", error_description="the token has expired"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", wwwAuth)
+		http.Error(w, "401 Authorization Required", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	logs := captureLog(t)
+	if err := testUnauthenticated(context.Background(), srv.Client(), srv.URL+"/api/echo"); err != nil {
+		t.Fatalf("testUnauthenticated() error = %v", err)
+	}
+
+	for _, want := range []string{"WWW-Authenticate: " + wwwAuth, "Response body: 401 Authorization Required"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log output missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestMarshalJUnit(t *testing.T) {
+	results := []testResult{
+		{Name: "Health endpoint", Duration: 120 * time.Millisecond},
+		{Name: "Authenticated request", Duration: 1500 * time.Millisecond, Err: errors.New(`expected 200, got 401 <"invalid_token">`)},
+	}
+
+	data, err := marshalJUnit("apitest", results)
+	if err != nil {
+		t.Fatalf("marshalJUnit() error = %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(xml.Header)) {
+		t.Errorf("report does not start with the XML header: %q", data)
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, data)
+	}
+	if suite.Name != "apitest" || suite.Tests != 2 || suite.Failures != 1 || suite.Time != "1.620" {
+		t.Errorf("suite = %q with %d tests, %d failures in %ss, want apitest with 2 tests, 1 failure in 1.620s", suite.Name, suite.Tests, suite.Failures, suite.Time)
+	}
+	if len(suite.TestCases) != 2 {
+		t.Fatalf("got %d test cases, want 2", len(suite.TestCases))
+	}
+	if tc := suite.TestCases[0]; tc.Name != "Health endpoint" || tc.ClassName != "apitest" || tc.Time != "0.120" || tc.Failure != nil {
+		t.Errorf("passing test case = %+v", tc)
+	}
+	// The failure message survives escaping
+	if f := suite.TestCases[1].Failure; f == nil || f.Message != `expected 200, got 401 <"invalid_token">` {
+		t.Errorf("failing test case failure = %+v", f)
+	}
+}
+
+func TestRunTestsContinuesOnFailure(t *testing.T) {
+	captureLog(t)
+	var ran []string
+	step := func(name string, err error) testCase {
+		return testCase{name: name, run: func() (string, error) {
+			ran = append(ran, name)
+			return "ok", err
+		}}
+	}
+
+	results := runTests([]testCase{step("first", nil), step("second", errors.New("boom")), step("third", nil)}, true)
+	if len(ran) != 3 || len(results) != 3 {
+		t.Fatalf("ran %v, want all 3 tests despite the failure", ran)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Errorf("results = %+v, want only the second to fail", results)
+	}
+}
+
+// expiryALB accepts only the tokens in valid with 200 and rejects others with 401
+func expiryALB(t *testing.T, valid ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, token := range valid {
+			if r.Header.Get("Authorization") == "Bearer "+token {
+				w.Write([]byte(`{"message":"ok"}`))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTokenExpiryFlow(t *testing.T) {
+	captureLog(t)
+	alb := expiryALB(t, "fresh")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+
+	var slept time.Duration
+	sleep := func(ctx context.Context, d time.Duration) error {
+		slept = d
+		return nil
+	}
+	refresh := func() (string, error) { return "fresh", nil }
+
+	err := testTokenExpiry(ctx, alb.Client(), alb.URL+"/api/echo", "old", time.Now().Add(time.Hour), refresh, sleep, false)
+	if err != nil {
+		t.Fatalf("testTokenExpiry() error = %v", err)
+	}
+	if want := time.Hour + expiryMargin; slept < want-time.Second || slept > want {
+		t.Errorf("slept %v, want about %v", slept, want)
+	}
+}
+
+func TestTokenExpiryFlowFailures(t *testing.T) {
+	captureLog(t)
+	noSleep := func(context.Context, time.Duration) error { return nil }
+	refresh := func() (string, error) { return "fresh", nil }
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	for _, tt := range []struct {
+		name      string
+		alb       *httptest.Server
+		expiresAt time.Time
+		want      string
+	}{
+		{"expired token accepted", expiryALB(t, "old", "fresh"), time.Now(), "expected 401"},
+		{"fresh token rejected", expiryALB(t), time.Now(), "expected 200"},
+		{"beyond the deadline", expiryALB(t, "fresh"), time.Now().Add(2 * time.Hour), "after the -timeout deadline"},
+		{"unknown expiry", expiryALB(t, "fresh"), time.Time{}, "expiry of the access token is unknown"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testTokenExpiry(ctx, tt.alb.Client(), tt.alb.URL+"/api/echo", "old", tt.expiresAt, refresh, noSleep, false)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("testTokenExpiry() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenExpiryDryRun(t *testing.T) {
+	logs := captureLog(t)
+	// The old token has not expired, which a dry run does not check
+	alb := expiryALB(t, "old", "fresh")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+	sleep := func(context.Context, time.Duration) error {
+		t.Error("dry run slept")
+		return nil
+	}
+	refreshed := false
+	refresh := func() (string, error) {
+		refreshed = true
+		return "fresh", nil
+	}
+
+	if err := testTokenExpiry(ctx, alb.Client(), alb.URL+"/api/echo", "old", time.Now().Add(time.Hour), refresh, sleep, true); err != nil {
+		t.Fatalf("testTokenExpiry() dry run error = %v", err)
+	}
+	if !refreshed {
+		t.Error("dry run did not refresh the token")
+	}
+	if !strings.Contains(logs.String(), "Dry run: would wait") {
+		t.Errorf("dry run did not log the skipped wait: %s", logs)
+	}
+
+	// The -timeout budget is still checked
+	short, cancelShort := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelShort()
+	if err := testTokenExpiry(short, alb.Client(), alb.URL+"/api/echo", "old", time.Now().Add(time.Hour), refresh, sleep, true); err == nil {
+		t.Error("dry run accepted a token expiring after the deadline")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	issuedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+
+	got, err := tokenExpiry(TokenResponse{AccessToken: jwt(`{"exp":1}`), ExpiresIn: 3600}, issuedAt)
+	if err != nil || !got.Equal(issuedAt.Add(time.Hour)) {
+		t.Errorf("tokenExpiry() with expires_in = %v, %v, want %v", got, err, issuedAt.Add(time.Hour))
+	}
+
+	// Without expires_in, the exp claim is used rather than expiring at once
+	got, err = tokenExpiry(TokenResponse{AccessToken: jwt(`{"exp":1704114000}`)}, issuedAt)
+	if want := time.Unix(1704114000, 0); err != nil || !got.Equal(want) {
+		t.Errorf("tokenExpiry() from exp = %v, %v, want %v", got, err, want)
+	}
+
+	for _, token := range []string{"opaque", jwt(`{"sub":"client"}`), "a.!!!.c"} {
+		if got, err := tokenExpiry(TokenResponse{AccessToken: token}, issuedAt); err == nil {
+			t.Errorf("tokenExpiry(%q) = %v, want an error", token, got)
+		}
+	}
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+	// 1ms to 100ms, shuffled
+	var latencies []time.Duration
+	for i := 0; i < 100; i++ {
+		latencies = append(latencies, time.Duration((i*37)%100+1)*time.Millisecond)
+	}
+	first := latencies[0]
+
+	stats := summarizeLatencies(latencies)
+	want := latencyStats{
+		Count: 100,
+		Min:   time.Millisecond,
+		Mean:  50500 * time.Microsecond,
+		P50:   50 * time.Millisecond,
+		P95:   95 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+	}
+	if stats != want {
+		t.Errorf("summarizeLatencies() = %+v, want %+v", stats, want)
+	}
+	if latencies[0] != first {
+		t.Error("summarizeLatencies() reordered its input")
+	}
+
+	if got := summarizeLatencies([]time.Duration{7 * time.Millisecond}); got.P50 != 7*time.Millisecond || got.P99 != 7*time.Millisecond {
+		t.Errorf("summarizeLatencies() of one sample = %+v", got)
+	}
+	if got := summarizeLatencies(nil); got != (latencyStats{}) {
+		t.Errorf("summarizeLatencies(nil) = %+v, want zero stats", got)
+	}
+}
+
+func TestRunLoadCountsErrors(t *testing.T) {
+	var mu sync.Mutex
+	calls, inFlight, maxInFlight := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		time.Sleep(5 * time.Millisecond)
+		if r.Header.Get("Authorization") != "Bearer token-1" || n%4 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	result := runLoad(context.Background(), srv.Client(), srv.URL, "token-1", 40, 4)
+	if result.Errors != 10 || len(result.Latencies) != 30 {
+		t.Errorf("errors = %d, successes = %d, want 10 and 30", result.Errors, len(result.Latencies))
+	}
+	if maxInFlight > 4 {
+		t.Errorf("max concurrent requests = %d, want at most 4", maxInFlight)
+	}
+}
+
+// echoALB stands in for the webapi behind ALB, echoing a posted object the
+// way the api app does, including omitting an empty one
+func echoALB(t *testing.T, mangle func(map[string]interface{})) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&input)
+		}
+		if mangle != nil {
+			mangle(input)
+		}
+		json.NewEncoder(w).Encode(struct {
+			Message string                 `json:"message"`
+			Echo    map[string]interface{} `json:"echo,omitempty"`
+		}{"Hello from protected API", input})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAuthenticatedEchoPayload(t *testing.T) {
+	captureLog(t)
+	alb := echoALB(t, nil)
+	for _, payload := range []string{"", `{}`, `{"hello":"world","nested":{"n":1}}`} {
+		if err := testAuthenticated(context.Background(), alb.Client(), alb.URL+"/api/echo", "token", payload); err != nil {
+			t.Errorf("testAuthenticated(%q) error = %v", payload, err)
+		}
+	}
+
+	changed := echoALB(t, func(input map[string]interface{}) { input["hello"] = "changed" })
+	if err := testAuthenticated(context.Background(), changed.Client(), changed.URL+"/api/echo", "token", `{"hello":"world"}`); err == nil {
+		t.Error("testAuthenticated() succeeded on a changed echo, want an error")
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/example/hello-fargate-webapp
 
 go 1.23
+
+require github.com/example/hello-fargate-app v0.0.0
+
+replace github.com/example/hello-fargate-app => ../../../../lib/app
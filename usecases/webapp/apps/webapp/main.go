@@ -1,22 +1,59 @@
 package main
 
 import (
-	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/example/hello-fargate-app/drain"
+	"github.com/example/hello-fargate-app/handlertimeout"
+	"github.com/example/hello-fargate-app/serverid"
 )
 
 var serverID string
 
+// writeTimeout is the server's WriteTimeout, which HANDLER_TIMEOUT_SECONDS
+// must stay below
+const writeTimeout = 10 * time.Second
+
+// Build metadata, set at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// verifier checks X-Amzn-Oidc-Data signatures; nil disables verification
+var verifier *oidcVerifier
+
+// tokenSkew is the clock-skew tolerance applied to exp and nbf claims
+var tokenSkew time.Duration
+
+// drainer serves /ready and drains the server on shutdown
+var drainer drain.Drainer
+
 func init() {
-	serverID, _ = os.Hostname()
+	// Use container hostname as unique server ID
+	serverID = serverid.Resolve()
 }
 
 func main() {
@@ -25,33 +62,61 @@ func main() {
 		port = "8080"
 	}
 
+	// Verify ALB-signed OIDC data unless explicitly disabled (e.g. local testing)
+	if os.Getenv("OIDC_VERIFY") != "false" {
+		region := os.Getenv("AWS_REGION")
+		albARN := os.Getenv("ALB_ARN")
+		if region == "" || albARN == "" {
+			log.Fatal("AWS_REGION and ALB_ARN are required to verify OIDC data (set OIDC_VERIFY=false to disable)")
+		}
+		verifier = newOIDCVerifier(region, albARN)
+		log.Printf("OIDC data verification enabled (region: %s, signer: %s)", region, albARN)
+	} else {
+		log.Println("WARNING: OIDC data verification disabled; claims are trusted without checking the signature")
+	}
+
+	if v := os.Getenv("TOKEN_SKEW_SECONDS"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 0 {
+			log.Fatalf("Invalid TOKEN_SKEW_SECONDS %q: must be a non-negative integer", v)
+		}
+		tokenSkew = time.Duration(secs) * time.Second
+	}
+
+	drainDelay, err := drain.DelayFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	drainer.ServerID = serverID
+	drainer.Delay = drainDelay
+
+	// Bound the processing time of every request
+	handlerTimeout, err := handlertimeout.FromEnv(writeTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
+	mux.Handle("/ready", &drainer)
+	mux.HandleFunc("/version", versionHandler)
 	mux.HandleFunc("/app/profile", profileHandler)
+	mux.HandleFunc("/app/logout", logoutHandler)
 
 	server := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      handlertimeout.Wrap(mux, handlerTimeout),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
 
-	// Graceful shutdown
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-
-		log.Println("Shutting down server...")
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
-		}
-	}()
+	// Graceful shutdown: fail /ready, drain, then shut down
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go drainer.ShutdownOnSignal(server, sigChan)
 
 	log.Printf("Webapp server starting on port %s (server_id: %s)", port, serverID)
+	drainer.SetReady()
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
@@ -67,6 +132,18 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// versionHandler reports the build metadata of the running image, which tells
+// old and new tasks apart during a rolling deployment
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"server_id":  serverID,
+	})
+}
+
 // profileHandler returns user profile from ALB OIDC headers
 // This endpoint is protected by ALB authenticate-cognito action
 func profileHandler(w http.ResponseWriter, r *http.Request) {
@@ -79,8 +156,42 @@ func profileHandler(w http.ResponseWriter, r *http.Request) {
 	oidcData := r.Header.Get("X-Amzn-Oidc-Data")
 	accessToken := r.Header.Get("X-Amzn-Oidc-Accesstoken")
 
-	// Decode user claims from OIDC data JWT
-	claims := decodeOIDCData(oidcData)
+	// Verify and decode user claims from OIDC data JWT
+	var claims map[string]interface{}
+	if verifier != nil {
+		var err error
+		claims, err = verifier.verify(oidcData, time.Now(), tokenSkew)
+		if err != nil {
+			log.Printf("OIDC data verification failed: %v", err)
+			message := "invalid OIDC data"
+			if errors.Is(err, errTokenExpired) {
+				message = "token expired"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": message,
+			})
+			return
+		}
+	} else {
+		claims = decodeOIDCData(oidcData)
+	}
+
+	// Reject stale or not-yet-valid tokens
+	if err := checkTokenTimes(claims, time.Now(), tokenSkew); err != nil {
+		log.Printf("OIDC token rejected: %v", err)
+		message := "token expired"
+		if errors.Is(err, errTokenNotYetValid) {
+			message = "token not yet valid"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": message,
+		})
+		return
+	}
 
 	// Build response
 	response := map[string]interface{}{
@@ -96,8 +207,112 @@ func profileHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// Errors returned for tokens used outside their validity period
+var (
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not yet valid")
+)
+
+// checkTokenTimes rejects claims whose exp is in the past or whose nbf is in
+// the future, allowing skew in both directions. Missing claims are not checked.
+func checkTokenTimes(claims map[string]interface{}, now time.Time, skew time.Duration) error {
+	if exp, ok := claims["exp"].(float64); ok {
+		if err := checkExpiry(int64(exp), now, skew); err != nil {
+			return err
+		}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		notBefore := time.Unix(int64(nbf), 0)
+		if now.Before(notBefore.Add(-skew)) {
+			return fmt.Errorf("%w: valid from %s", errTokenNotYetValid, notBefore.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// checkExpiry rejects an exp Unix timestamp that is more than skew in the past
+func checkExpiry(exp int64, now time.Time, skew time.Duration) error {
+	expiry := time.Unix(exp, 0)
+	if now.After(expiry.Add(skew)) {
+		return fmt.Errorf("%w at %s", errTokenExpired, expiry.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// sessionCookiePrefix is the ALB session cookie name; ALB shards large sessions
+// into AWSELBAuthSessionCookie-0, -1, ...
+const sessionCookiePrefix = "AWSELBAuthSessionCookie"
+
+// logoutHandler expires the ALB session cookies and redirects to the Cognito
+// logout endpoint, or returns a JSON confirmation for JSON clients
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Always expire the first two shards, plus any other shard sent by the client
+	names := map[string]bool{
+		sessionCookiePrefix + "-0": true,
+		sessionCookiePrefix + "-1": true,
+	}
+	for _, c := range r.Cookies() {
+		if strings.HasPrefix(c.Name, sessionCookiePrefix) {
+			names[c.Name] = true
+		}
+	}
+	for name := range names {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: true,
+		})
+	}
+
+	logoutURL, err := cognitoLogoutURL()
+	if err != nil {
+		log.Printf("Logout redirect unavailable: %v", err)
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message":    "Logged out",
+			"server_id":  serverID,
+			"logout_url": logoutURL,
+		})
+		return
+	}
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "logout is not configured",
+		})
+		return
+	}
+	http.Redirect(w, r, logoutURL, http.StatusFound)
+}
+
+// cognitoLogoutURL builds the Cognito hosted UI logout URL from
+// COGNITO_DOMAIN, AWS_REGION, COGNITO_CLIENT_ID and LOGOUT_REDIRECT_URI
+func cognitoLogoutURL() (string, error) {
+	domain := os.Getenv("COGNITO_DOMAIN")
+	region := os.Getenv("AWS_REGION")
+	clientID := os.Getenv("COGNITO_CLIENT_ID")
+	redirectURI := os.Getenv("LOGOUT_REDIRECT_URI")
+	if domain == "" || region == "" || clientID == "" || redirectURI == "" {
+		return "", errors.New("COGNITO_DOMAIN, AWS_REGION, COGNITO_CLIENT_ID and LOGOUT_REDIRECT_URI are required")
+	}
+
+	q := url.Values{}
+	q.Set("client_id", clientID)
+	q.Set("logout_uri", redirectURI)
+	return fmt.Sprintf("https://%s.auth.%s.amazoncognito.com/logout?%s", domain, region, q.Encode()), nil
+}
+
 // decodeOIDCData decodes the JWT payload from ALB's X-Amzn-Oidc-Data header
-// Note: In production, you should verify the JWT signature using ALB's public key
+// without verifying its signature. Only used when OIDC_VERIFY=false.
 func decodeOIDCData(data string) map[string]interface{} {
 	if data == "" {
 		return nil
@@ -132,3 +347,149 @@ func decodeOIDCData(data string) map[string]interface{} {
 
 	return claims
 }
+
+// kidPattern restricts key IDs so they cannot alter the public key URL
+var kidPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// oidcVerifier verifies the ES256 signature of ALB's X-Amzn-Oidc-Data JWT
+// using the ALB public keys of a region, cached by key ID. Only tokens signed
+// by the expected ALB are accepted, since every ALB in the region signs with
+// the same keys.
+type oidcVerifier struct {
+	keyURL string
+	signer string // ARN of the ALB expected in the JWT header
+	client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PublicKey
+}
+
+// newOIDCVerifier creates a verifier fetching keys for the given region and
+// accepting tokens signed by the ALB with ARN signer
+func newOIDCVerifier(region, signer string) *oidcVerifier {
+	return &oidcVerifier{
+		keyURL: fmt.Sprintf("https://public-keys.auth.elb.%s.amazonaws.com/", region),
+		signer: signer,
+		client: &http.Client{Timeout: 5 * time.Second},
+		keys:   make(map[string]*ecdsa.PublicKey),
+	}
+}
+
+// verify checks the token signature and the exp field ALB sets in the JWT
+// header, allowing skew, and returns the claims. The exp and nbf claims are
+// checked separately by checkTokenTimes.
+func (v *oidcVerifier) verify(token string, now time.Time, skew time.Duration) (map[string]interface{}, error) {
+	if token == "" {
+		return nil, errors.New("missing X-Amzn-Oidc-Data header")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT: expected 3 parts, got %d", len(parts))
+	}
+
+	var header struct {
+		Alg    string `json:"alg"`
+		Kid    string `json:"kid"`
+		Signer string `json:"signer"`
+		Exp    int64  `json:"exp"`
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT header: %w", err)
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT header: %w", err)
+	}
+	if header.Alg != "ES256" {
+		return nil, fmt.Errorf("unexpected JWT algorithm %q", header.Alg)
+	}
+	if !kidPattern.MatchString(header.Kid) {
+		return nil, fmt.Errorf("invalid key ID %q", header.Kid)
+	}
+	if header.Signer != v.signer {
+		return nil, fmt.Errorf("unexpected JWT signer %q", header.Signer)
+	}
+
+	key, err := v.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// ES256 signatures are the 32-byte r and s values concatenated
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT signature: %w", err)
+	}
+	if len(sig) != 64 {
+		return nil, fmt.Errorf("invalid ES256 signature length %d", len(sig))
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	sv := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(key, hash[:], r, sv) {
+		return nil, errors.New("JWT signature verification failed")
+	}
+	if header.Exp != 0 {
+		if err := checkExpiry(header.Exp, now, skew); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT claims: %w", err)
+	}
+	return claims, nil
+}
+
+// publicKey returns the ALB public key for kid, fetching it on first use
+func (v *oidcVerifier) publicKey(kid string) (*ecdsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	resp, err := v.client.Get(v.keyURL + kid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch public key %s: %w", kid, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch public key %s: status %d", kid, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %s: %w", kid, err)
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", kid)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", kid, err)
+	}
+	key, ok = parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ECDSA key", kid)
+	}
+
+	v.mu.Lock()
+	v.keys[kid] = key
+	v.mu.Unlock()
+	return key, nil
+}
+
+// decodeSegment decodes a base64url JWT segment. ALB pads its segments, so
+// trailing "=" characters are accepted.
+func decodeSegment(seg string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+}
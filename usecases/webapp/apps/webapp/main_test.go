@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testALB = "arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/webapp/0123456789abcdef"
+
+// testSigner signs JWTs the way ALB does and serves its public key like the
+// regional ALB key endpoint
+type testSigner struct {
+	key *ecdsa.PrivateKey
+	srv *httptest.Server
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test-kid" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(pemKey)
+	}))
+	t.Cleanup(srv.Close)
+	return &testSigner{key: key, srv: srv}
+}
+
+// verifier returns a verifier fetching keys from the signer and expecting
+// tokens from testALB
+func (s *testSigner) verifier() *oidcVerifier {
+	v := newOIDCVerifier("ap-northeast-1", testALB)
+	v.keyURL = s.srv.URL + "/"
+	return v
+}
+
+// sign returns a JWT with the given header fields and claims, signed with
+// ES256. alg and kid default to ES256 and test-kid.
+func (s *testSigner) sign(t *testing.T, header, claims map[string]interface{}) string {
+	t.Helper()
+	h := map[string]interface{}{"alg": "ES256", "kid": "test-kid"}
+	for k, v := range header {
+		h[k] = v
+	}
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signingInput := segment(h) + "." + segment(claims)
+
+	hash := sha256.Sum256([]byte(signingInput))
+	r, sv, err := ecdsa.Sign(rand.Reader, s.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyAcceptsExpectedSigner(t *testing.T) {
+	s := newTestSigner(t)
+	token := s.sign(t, map[string]interface{}{"signer": testALB}, map[string]interface{}{"sub": "user-1"})
+
+	claims, err := s.verifier().verify(token, time.Now(), 0)
+	if err != nil {
+		t.Fatalf("verify() error = %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Errorf("sub = %v, want user-1", claims["sub"])
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	s := newTestSigner(t)
+	other := newTestSigner(t)
+	otherALB := strings.Replace(testALB, "webapp", "attacker", 1)
+	claims := map[string]interface{}{"sub": "user-1"}
+
+	valid := s.sign(t, map[string]interface{}{"signer": testALB}, claims)
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"other ALB", s.sign(t, map[string]interface{}{"signer": otherALB}, claims), "unexpected JWT signer"},
+		{"no signer", s.sign(t, nil, claims), "unexpected JWT signer"},
+		{"other key", other.sign(t, map[string]interface{}{"signer": testALB}, claims), "signature verification failed"},
+		{"tampered claims", tampered, "signature verification failed"},
+		{"unknown kid", s.sign(t, map[string]interface{}{"signer": testALB, "kid": "other-kid"}, claims), "status 404"},
+		{"wrong alg", s.sign(t, map[string]interface{}{"signer": testALB, "alg": "HS256"}, claims), "unexpected JWT algorithm"},
+		{"missing", "", "missing X-Amzn-Oidc-Data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.verifier().verify(tt.token, time.Now(), 0)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfileHandlerRejectsOtherSigner(t *testing.T) {
+	s := newTestSigner(t)
+	prev := verifier
+	verifier = s.verifier()
+	t.Cleanup(func() { verifier = prev })
+
+	token := s.sign(t, map[string]interface{}{"signer": "arn:aws:elasticloadbalancing:other"}, map[string]interface{}{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/app/profile", nil)
+	req.Header.Set("X-Amzn-Oidc-Data", token)
+	rec := httptest.NewRecorder()
+	profileHandler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("body = %q, want a JSON error", rec.Body.String())
+	}
+}
+
+func setLogoutEnv(t *testing.T) {
+	t.Setenv("COGNITO_DOMAIN", "hello-webapp")
+	t.Setenv("AWS_REGION", "ap-northeast-1")
+	t.Setenv("COGNITO_CLIENT_ID", "client123")
+	t.Setenv("LOGOUT_REDIRECT_URI", "https://webapp.example.com/health")
+}
+
+func TestLogoutHandlerRedirects(t *testing.T) {
+	setLogoutEnv(t)
+	req := httptest.NewRequest(http.MethodGet, "/app/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "AWSELBAuthSessionCookie-2", Value: "shard"})
+	req.AddCookie(&http.Cookie{Name: "unrelated", Value: "keep"})
+	rec := httptest.NewRecorder()
+	logoutHandler(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want 302", rec.Code)
+	}
+	want := "https://hello-webapp.auth.ap-northeast-1.amazoncognito.com/logout?client_id=client123&logout_uri=https%3A%2F%2Fwebapp.example.com%2Fhealth"
+	if got := rec.Header().Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	expired := map[string]bool{}
+	for _, c := range rec.Result().Cookies() {
+		if c.Value != "" || c.MaxAge >= 0 || !c.Expires.Before(time.Now()) {
+			t.Errorf("cookie %s not expired: %q", c.Name, c.String())
+		}
+		expired[c.Name] = true
+	}
+	for _, name := range []string{"AWSELBAuthSessionCookie-0", "AWSELBAuthSessionCookie-1", "AWSELBAuthSessionCookie-2"} {
+		if !expired[name] {
+			t.Errorf("cookie %s was not expired", name)
+		}
+	}
+	if expired["unrelated"] {
+		t.Error("unrelated cookie was expired")
+	}
+}
+
+func TestLogoutHandlerJSON(t *testing.T) {
+	setLogoutEnv(t)
+	req := httptest.NewRequest(http.MethodGet, "/app/logout", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	logoutHandler(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Location") != "" {
+		t.Fatalf("status = %d, Location = %q, want 200 without a redirect", rec.Code, rec.Header().Get("Location"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["message"] != "Logged out" || !strings.HasPrefix(body["logout_url"], "https://hello-webapp.auth.") {
+		t.Errorf("body = %v, want a logout confirmation with the logout URL", body)
+	}
+	if len(rec.Result().Cookies()) < 2 {
+		t.Errorf("set %d cookies, want the session cookies expired", len(rec.Result().Cookies()))
+	}
+}
+
+func TestLogoutHandlerUnconfigured(t *testing.T) {
+	t.Setenv("COGNITO_DOMAIN", "")
+	rec := httptest.NewRecorder()
+	logoutHandler(rec, httptest.NewRequest(http.MethodGet, "/app/logout", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+}
+
+// getProfile calls profileHandler with token as X-Amzn-Oidc-Data
+func getProfile(t *testing.T, token string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/app/profile", nil)
+	req.Header.Set("X-Amzn-Oidc-Data", token)
+	rec := httptest.NewRecorder()
+	profileHandler(rec, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %q", rec.Body.String())
+	}
+	return rec.Code, body
+}
+
+func TestProfileHandlerTokenTimes(t *testing.T) {
+	s := newTestSigner(t)
+	prevVerifier, prevSkew := verifier, tokenSkew
+	verifier = s.verifier()
+	t.Cleanup(func() { verifier, tokenSkew = prevVerifier, prevSkew })
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute).Unix(), now.Add(time.Minute).Unix()
+	tests := []struct {
+		name      string
+		headerExp int64
+		claims    map[string]interface{}
+		skew      time.Duration
+		wantCode  int
+		wantError string
+	}{
+		{"valid", future, map[string]interface{}{"exp": future, "nbf": past}, 0, http.StatusOK, ""},
+		{"header expired", past, map[string]interface{}{"exp": future}, 0, http.StatusUnauthorized, "token expired"},
+		{"header expired within skew", past, map[string]interface{}{}, 2 * time.Minute, http.StatusOK, ""},
+		{"claims expired", future, map[string]interface{}{"exp": past}, 0, http.StatusUnauthorized, "token expired"},
+		{"not yet valid", future, map[string]interface{}{"nbf": future}, 0, http.StatusUnauthorized, "token not yet valid"},
+		{"not yet valid within skew", future, map[string]interface{}{"nbf": future}, 2 * time.Minute, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenSkew = tt.skew
+			claims := map[string]interface{}{"sub": "user-1"}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			token := s.sign(t, map[string]interface{}{"signer": testALB, "exp": tt.headerExp}, claims)
+
+			code, body := getProfile(t, token)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if tt.wantError != "" && body["error"] != tt.wantError {
+				t.Errorf("error = %v, want %q", body["error"], tt.wantError)
+			}
+			if tt.wantCode == http.StatusOK {
+				if got := body["claims"].(map[string]interface{})["sub"]; got != "user-1" {
+					t.Errorf("claims sub = %v, want user-1", got)
+				}
+			}
+		})
+	}
+}
+
+func TestProfileHandlerTokenTimesWithoutVerification(t *testing.T) {
+	prev := verifier
+	verifier = nil
+	t.Cleanup(func() { verifier = prev })
+
+	unsigned := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	past, future := time.Now().Add(-time.Minute).Unix(), time.Now().Add(time.Minute).Unix()
+
+	if code, body := getProfile(t, unsigned(`{"exp":`+strconv.FormatInt(past, 10)+`}`)); code != http.StatusUnauthorized || body["error"] != "token expired" {
+		t.Errorf("expired token: status = %d, body = %v, want 401 token expired", code, body)
+	}
+	if code, body := getProfile(t, unsigned(`{"nbf":`+strconv.FormatInt(future, 10)+`}`)); code != http.StatusUnauthorized || body["error"] != "token not yet valid" {
+		t.Errorf("future token: status = %d, body = %v, want 401 token not yet valid", code, body)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	prev := serverID
+	t.Cleanup(func() { serverID = prev })
+	serverID = "webapp-1"
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/version returned invalid JSON %q: %v", rec.Body, err)
+	}
+	// Without -ldflags the build metadata defaults to dev
+	want := map[string]string{"version": "dev", "commit": "dev", "build_time": "dev", "server_id": "webapp-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/version = %v, want %v", got, want)
+	}
+}
@@ -44,6 +44,9 @@ resource "aws_cognito_user_pool_client" "webapp" {
   # Callback URL for ALB
   callback_urls = ["https://${aws_lb.webapp.dns_name}/oauth2/idpresponse"]
 
+  # Where Cognito sends users after /app/logout
+  logout_urls = ["https://${aws_lb.webapp.dns_name}/health"]
+
   # Auth flows for testing
   explicit_auth_flows = [
     "ALLOW_ADMIN_USER_PASSWORD_AUTH",
@@ -105,9 +105,37 @@ resource "aws_ecs_task_definition" "webapp" {
         {
           name  = "PORT"
           value = "8080"
+        },
+        {
+          name  = "AWS_REGION"
+          value = data.aws_region.current.region
+        },
+        {
+          name  = "ALB_ARN"
+          value = aws_lb.webapp.arn
+        },
+        {
+          name  = "COGNITO_DOMAIN"
+          value = aws_cognito_user_pool_domain.webapp.domain
+        },
+        {
+          name  = "COGNITO_CLIENT_ID"
+          value = aws_cognito_user_pool_client.webapp.id
+        },
+        {
+          name  = "LOGOUT_REDIRECT_URI"
+          value = "https://${aws_lb.webapp.dns_name}/health"
+        },
+        {
+          name  = "DRAIN_SECONDS"
+          value = tostring(var.drain_seconds)
         }
       ]
 
+      # How long ECS waits after SIGTERM before killing the container; the
+      # app fits its drain and shutdown within it (drain.StopTimeout in lib/app)
+      stopTimeout = 30
+
       logConfiguration = {
         logDriver = "awslogs"
         options = {
@@ -11,11 +11,19 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
 	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/example/hello-fargate-harness"
 )
 
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	albURL := flag.String("alb-url", "", "ALB HTTPS URL")
 	cognitoDomain := flag.String("cognito-domain", "", "Cognito domain (without .auth.region.amazoncognito.com)")
@@ -24,10 +32,20 @@ func main() {
 	username := flag.String("username", "", "Test user email")
 	password := flag.String("password", "", "Test user password")
 	timeout := flag.Duration("timeout", 5*time.Minute, "Test timeout")
+	maxRedirects := flag.Int("max-redirects", 10, "Maximum number of redirects to follow from the Cognito login back to the ALB callback")
+	cookieFile := flag.String("cookie-file", "", "File to load/save the ALB session cookies from/to, to skip the Cognito login when the saved session is still valid")
+	continueOnFailure := flag.Bool("continue-on-failure", false, "Run all tests even if one fails, and print a summary at the end")
+	output := flag.String("output", "text", "Output format: text or json")
+	headerTransport := harness.RegisterHeaderFlags(flag.CommandLine, version)
 	flag.Parse()
 
 	if *albURL == "" || *cognitoDomain == "" || *region == "" || *clientID == "" || *username == "" || *password == "" {
-		log.Fatal("Required flags: -alb-url, -cognito-domain, -region, -client-id, -username, -password")
+		log.Print("Required flags: -alb-url, -cognito-domain, -region, -client-id, -username, -password")
+		os.Exit(exitError)
+	}
+	if *output != "text" && *output != "json" {
+		log.Printf("Invalid -output %q: must be text or json", *output)
+		os.Exit(exitError)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
@@ -36,29 +54,29 @@ func main() {
 	// Create HTTP client with cookie jar (to maintain session)
 	jar, err := cookiejar.New(nil)
 	if err != nil {
-		log.Fatalf("Failed to create cookie jar: %v", err)
+		log.Printf("Failed to create cookie jar: %v", err)
+		os.Exit(exitError)
 	}
 
+	// Sets the User-Agent and custom headers on every request
+	transport := headerTransport.Wrap(&http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true, // Required for self-signed certificate
+		},
+	})
+
 	// Client that follows redirects (for health check)
 	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Jar:     jar,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // Required for self-signed certificate
-			},
-		},
+		Timeout:   30 * time.Second,
+		Jar:       jar,
+		Transport: transport,
 	}
 
 	// Client that does NOT follow redirects (for testing redirect behavior)
 	noRedirectClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Jar:     jar,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+		Timeout:   30 * time.Second,
+		Jar:       jar,
+		Transport: transport,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -66,70 +84,200 @@ func main() {
 
 	// Wait for ALB health check to pass
 	log.Println("Waiting for ALB to be healthy...")
-	if err := waitForHealth(ctx, httpClient, *albURL+"/health"); err != nil {
-		log.Fatalf("ALB not healthy: %v", err)
+	if err := harness.WaitForHealth(ctx, httpClient, *albURL+"/health"); err != nil {
+		log.Printf("ALB not healthy: %v", err)
+		if *output == "json" {
+			writeJSONReport(nil, fmt.Errorf("ALB not healthy: %w", err))
+		}
+		os.Exit(exitError)
 	}
 	log.Println("ALB is healthy!")
 
-	// Test 1: Health endpoint (unauthenticated)
-	log.Println("\n=== Test 1: Unauthenticated request to /health ===")
-	if err := testHealthEndpoint(ctx, httpClient, *albURL+"/health"); err != nil {
-		log.Fatalf("Test 1 FAILED: %v", err)
+	cognitoBaseURL := fmt.Sprintf("https://%s.auth.%s.amazoncognito.com", *cognitoDomain, *region)
+
+	tests := []testCase{
+		{
+			// Test 1: Health endpoint (unauthenticated)
+			name:    "Unauthenticated request to /health",
+			passMsg: "Health endpoint accessible without authentication",
+			run: func() error {
+				return testHealthEndpoint(ctx, httpClient, *albURL+"/health")
+			},
+		},
+		{
+			// Test 2: Unauthenticated request to /app/profile should redirect to Cognito
+			name:    "Unauthenticated request to /app/profile",
+			passMsg: "Protected endpoint correctly redirects to Cognito login",
+			run: func() error {
+				return testUnauthenticatedRedirect(ctx, noRedirectClient, *albURL+"/app/profile")
+			},
+		},
+		{
+			// Test 3: Authenticate via HTTP-based Cognito login flow
+			name:    "Authenticate via Cognito login",
+			passMsg: "Successfully authenticated and obtained session cookie",
+			run: func() error {
+				// Reuse a session saved by a previous run, if it is still accepted by ALB
+				if *cookieFile != "" {
+					reused, err := reuseSavedSession(ctx, noRedirectClient, *albURL, *cookieFile)
+					if err != nil {
+						log.Printf("Could not reuse session from %s, falling back to login: %v", *cookieFile, err)
+					}
+					if reused {
+						return nil
+					}
+				}
+
+				if err := authenticateViaCognito(ctx, noRedirectClient, httpClient, *albURL, cognitoBaseURL, *clientID, *username, *password, *maxRedirects); err != nil {
+					return err
+				}
+
+				if *cookieFile != "" {
+					if err := saveSessionCookies(jar, *albURL, *cookieFile); err != nil {
+						return fmt.Errorf("failed to save session cookies: %w", err)
+					}
+					log.Printf("Saved session cookies to %s", *cookieFile)
+				}
+				return nil
+			},
+		},
+		{
+			// Test 4: Access protected endpoint with session cookie
+			name:    "Authenticated request to /app/profile",
+			passMsg: "Protected endpoint accessible with session cookie, user claims verified",
+			run: func() error {
+				return testAuthenticatedProfile(ctx, httpClient, *albURL+"/app/profile")
+			},
+		},
 	}
-	log.Println("Test 1 PASSED: Health endpoint accessible without authentication")
 
-	// Test 2: Unauthenticated request to /app/profile should redirect to Cognito
-	log.Println("\n=== Test 2: Unauthenticated request to /app/profile ===")
-	if err := testUnauthenticatedRedirect(ctx, noRedirectClient, *albURL+"/app/profile"); err != nil {
-		log.Fatalf("Test 2 FAILED: %v", err)
+	results := runTests(tests, *continueOnFailure)
+
+	if *output == "json" {
+		writeJSONReport(results, nil)
+	} else if *continueOnFailure {
+		printSummary(os.Stdout, results)
 	}
-	log.Println("Test 2 PASSED: Protected endpoint correctly redirects to Cognito login")
 
-	// Test 3: Authenticate via HTTP-based Cognito login flow
-	log.Println("\n=== Test 3: Authenticate via Cognito login ===")
-	cognitoBaseURL := fmt.Sprintf("https://%s.auth.%s.amazoncognito.com", *cognitoDomain, *region)
-	if err := authenticateViaCognito(ctx, noRedirectClient, httpClient, *albURL, cognitoBaseURL, *clientID, *username, *password); err != nil {
-		log.Fatalf("Test 3 FAILED: %v", err)
+	for _, r := range results {
+		if r.err != nil {
+			log.Print("Some webapp authentication tests FAILED")
+			os.Exit(exitFailed)
+		}
 	}
-	log.Println("Test 3 PASSED: Successfully authenticated and obtained session cookie")
 
-	// Test 4: Access protected endpoint with session cookie
-	log.Println("\n=== Test 4: Authenticated request to /app/profile ===")
-	if err := testAuthenticatedProfile(ctx, httpClient, *albURL+"/app/profile"); err != nil {
-		log.Fatalf("Test 4 FAILED: %v", err)
+	if *output == "text" {
+		fmt.Println("\n========================================")
+		fmt.Println("All webapp authentication tests PASSED!")
+		fmt.Println("========================================")
 	}
-	log.Println("Test 4 PASSED: Protected endpoint accessible with session cookie, user claims verified")
+}
+
+// Exit codes; 0 means all tests passed
+const (
+	exitFailed = 1 // one or more tests failed
+	exitError  = 2 // the tests could not be run (invalid flags, ALB not healthy)
+)
 
-	fmt.Println("\n========================================")
-	fmt.Println("All webapp authentication tests PASSED!")
-	fmt.Println("========================================")
+// testCase is a single step of the webapp authentication test
+type testCase struct {
+	name    string
+	passMsg string
+	run     func() error
 }
 
-func waitForHealth(ctx context.Context, client *http.Client, healthURL string) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+// testResult records the outcome of a testCase
+type testResult struct {
+	name     string
+	passMsg  string
+	err      error
+	duration time.Duration
+}
+
+// runTests runs the tests in order. Unless continueOnFailure is set, the
+// remaining tests are skipped after the first failure.
+func runTests(tests []testCase, continueOnFailure bool) []testResult {
+	var results []testResult
+	for i, tc := range tests {
+		log.Printf("\n=== Test %d: %s ===", i+1, tc.name)
+		start := time.Now()
+		err := tc.run()
+		results = append(results, testResult{name: tc.name, passMsg: tc.passMsg, err: err, duration: time.Since(start)})
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
 		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+			log.Printf("Test %d FAILED: %v", i+1, err)
+			if !continueOnFailure {
+				break
+			}
+		} else {
+			log.Printf("Test %d PASSED: %s", i+1, tc.passMsg)
 		}
+	}
+	return results
+}
 
-		resp, err := client.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			resp.Body.Close()
-			return nil
-		}
-		if resp != nil {
-			resp.Body.Close()
+// printSummary writes which tests passed and failed to w
+func printSummary(w io.Writer, results []testResult) {
+	passed := 0
+	fmt.Fprintln(w, "\n--- Webapp Test Summary ---")
+	for i, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(w, "  Test %d FAILED: %s (%v)\n", i+1, r.name, r.err)
+		} else {
+			passed++
+			fmt.Fprintf(w, "  Test %d PASSED: %s\n", i+1, r.name)
 		}
+	}
+	fmt.Fprintf(w, "Passed: %d, Failed: %d\n", passed, len(results)-passed)
+	fmt.Fprintln(w, "---------------------------")
+}
 
-		log.Printf("Waiting for health check... (error: %v)", err)
-		time.Sleep(5 * time.Second)
+// jsonTestResult is the JSON representation of a testResult
+type jsonTestResult struct {
+	Name            string  `json:"name"`
+	Passed          bool    `json:"passed"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Detail          string  `json:"detail"`
+}
+
+// jsonReport is the document written to stdout with -output json
+type jsonReport struct {
+	Passed bool             `json:"passed"`
+	Error  string           `json:"error,omitempty"`
+	Tests  []jsonTestResult `json:"tests"`
+}
+
+// writeJSONReport writes the test results to stdout as JSON. runErr is set
+// when the tests could not be run at all.
+func writeJSONReport(results []testResult, runErr error) {
+	out, _ := json.MarshalIndent(newJSONReport(results, runErr), "", "  ")
+	fmt.Println(string(out))
+}
+
+// newJSONReport builds the -output json document from the test results
+func newJSONReport(results []testResult, runErr error) jsonReport {
+	report := jsonReport{
+		Passed: runErr == nil,
+		Tests:  []jsonTestResult{},
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
 	}
+
+	for _, r := range results {
+		jr := jsonTestResult{
+			Name:            r.name,
+			Passed:          r.err == nil,
+			DurationSeconds: r.duration.Seconds(),
+			Detail:          r.passMsg,
+		}
+		if r.err != nil {
+			jr.Detail = r.err.Error()
+			report.Passed = false
+		}
+		report.Tests = append(report.Tests, jr)
+	}
+	return report
 }
 
 func testHealthEndpoint(ctx context.Context, client *http.Client, url string) error {
@@ -183,7 +331,7 @@ func testUnauthenticatedRedirect(ctx context.Context, client *http.Client, url s
 	return nil
 }
 
-func authenticateViaCognito(ctx context.Context, noRedirectClient, httpClient *http.Client, albURL, cognitoBaseURL, clientID, username, password string) error {
+func authenticateViaCognito(ctx context.Context, noRedirectClient, httpClient *http.Client, albURL, cognitoBaseURL, clientID, username, password string, maxRedirects int) error {
 	// Step 1: Request protected endpoint to get redirected to Cognito
 	log.Println("Step 1: Initiating OAuth flow by requesting protected endpoint...")
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, albURL+"/app/profile", nil)
@@ -242,14 +390,10 @@ func authenticateViaCognito(ctx context.Context, noRedirectClient, httpClient *h
 
 	// Step 3: Extract CSRF token from login form
 	log.Println("Step 3: Extracting CSRF token from login form...")
-	csrfToken := extractCSRFToken(string(body))
-	if csrfToken == "" {
-		// Try alternative extraction methods
-		csrfToken = extractCSRFTokenAlt(string(body))
-	}
-	if csrfToken == "" {
+	csrfToken, err := extractCSRFToken(string(body))
+	if err != nil {
 		log.Printf("Login page HTML (first 2000 chars): %s", truncateString(string(body), 2000))
-		return fmt.Errorf("failed to extract CSRF token from login page")
+		return fmt.Errorf("failed to extract CSRF token from login page: %w", err)
 	}
 	log.Printf("Step 3: Extracted CSRF token: %s", truncateString(csrfToken, 20))
 
@@ -290,30 +434,9 @@ func authenticateViaCognito(ctx context.Context, noRedirectClient, httpClient *h
 
 	// Step 5: Follow redirect chain to ALB callback
 	log.Println("Step 5: Following redirect chain to ALB callback...")
-	redirectCount := 0
-	maxRedirects := 10
-	currentURL := resp.Header.Get("Location")
-
-	for resp.StatusCode == http.StatusFound && redirectCount < maxRedirects {
-		log.Printf("Step 5: Following redirect to: %s", truncateString(currentURL, 100))
-
-		req, err = http.NewRequestWithContext(ctx, http.MethodGet, currentURL, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create redirect request: %w", err)
-		}
-
-		resp, err = noRedirectClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("redirect request failed: %w", err)
-		}
-		resp.Body.Close()
-
-		if resp.StatusCode == http.StatusFound {
-			currentURL = resp.Header.Get("Location")
-			redirectCount++
-		} else {
-			break
-		}
+	resp, redirectCount, err := followRedirectChain(ctx, noRedirectClient, resp, maxRedirects)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("Step 5: Final response status: %d after %d redirects", resp.StatusCode, redirectCount)
@@ -385,43 +508,281 @@ func testAuthenticatedProfile(ctx context.Context, client *http.Client, profileU
 	return nil
 }
 
-// extractCSRFToken extracts the CSRF token from the Cognito login page HTML
-func extractCSRFToken(html string) string {
-	// Look for: <input type="hidden" name="_csrf" value="...">
-	re := regexp.MustCompile(`name="_csrf"\s+value="([^"]+)"`)
-	matches := re.FindStringSubmatch(html)
-	if len(matches) > 1 {
-		return matches[1]
+// followRedirectChain follows 302 redirects starting from resp until a
+// non-redirect response is returned. It fails with the full chain of visited
+// URLs when the same Location is seen twice (e.g. a misconfigured callback URL
+// bouncing between Cognito and ALB) or when more than maxRedirects are needed.
+func followRedirectChain(ctx context.Context, client *http.Client, resp *http.Response, maxRedirects int) (*http.Response, int, error) {
+	var chain []string
+	visited := map[string]bool{}
+
+	for resp.StatusCode == http.StatusFound {
+		currentURL := resp.Header.Get("Location")
+
+		if visited[currentURL] {
+			chain = append(chain, currentURL)
+			logRedirectChain(chain)
+			return nil, len(chain), fmt.Errorf("redirect loop detected: %s was visited twice", truncateString(currentURL, 100))
+		}
+		if len(chain) >= maxRedirects {
+			chain = append(chain, currentURL)
+			logRedirectChain(chain)
+			return nil, len(chain), fmt.Errorf("exceeded maximum of %d redirects", maxRedirects)
+		}
+		visited[currentURL] = true
+		chain = append(chain, currentURL)
+
+		log.Printf("Step 5: Following redirect to: %s", truncateString(currentURL, 100))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, currentURL, nil)
+		if err != nil {
+			return nil, len(chain), fmt.Errorf("failed to create redirect request: %w", err)
+		}
+
+		resp, err = client.Do(req)
+		if err != nil {
+			return nil, len(chain), fmt.Errorf("redirect request failed: %w", err)
+		}
+		resp.Body.Close()
+	}
+
+	return resp, len(chain), nil
+}
+
+// logRedirectChain logs every URL visited while following a redirect chain
+func logRedirectChain(chain []string) {
+	log.Printf("Redirect chain (%d hops):", len(chain))
+	for i, u := range chain {
+		log.Printf("  [%d] %s", i+1, u)
+	}
+}
+
+// savedCookie is the on-disk representation of a session cookie
+type savedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// saveSessionCookies writes the cookies the jar holds for the ALB to path
+func saveSessionCookies(jar http.CookieJar, albURL, path string) error {
+	u, err := url.Parse(albURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse ALB URL: %w", err)
+	}
+
+	var saved []savedCookie
+	for _, c := range jar.Cookies(u) {
+		saved = append(saved, savedCookie{Name: c.Name, Value: c.Value})
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cookies: %w", err)
+	}
+
+	// The session cookie grants access as the test user, so keep it private
+	return os.WriteFile(path, data, 0600)
+}
+
+// reuseSavedSession loads the cookies saved at path into the client's jar and
+// checks whether ALB still accepts them. When the file is missing, the session
+// has expired or the check fails for any other reason (including network
+// errors), the loaded cookies are removed again so that the full login flow
+// starts from a clean state.
+func reuseSavedSession(ctx context.Context, noRedirectClient *http.Client, albURL, path string) (reused bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Cookie file %s not found, performing full login", path)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+
+	var saved []savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return false, fmt.Errorf("failed to parse cookie file: %w", err)
+	}
+
+	u, err := url.Parse(albURL)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse ALB URL: %w", err)
 	}
 
-	// Try alternative pattern: value="..." name="_csrf"
-	re = regexp.MustCompile(`value="([^"]+)"\s+name="_csrf"`)
-	matches = re.FindStringSubmatch(html)
-	if len(matches) > 1 {
-		return matches[1]
+	cookies := make([]*http.Cookie, 0, len(saved))
+	for _, c := range saved {
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value, Path: "/"})
 	}
+	noRedirectClient.Jar.SetCookies(u, cookies)
+
+	defer func() {
+		if reused {
+			return
+		}
+		expired := make([]*http.Cookie, 0, len(saved))
+		for _, c := range saved {
+			expired = append(expired, &http.Cookie{Name: c.Name, Path: "/", MaxAge: -1})
+		}
+		noRedirectClient.Jar.SetCookies(u, expired)
+	}()
+
+	// A valid session is served directly, an expired one is redirected to Cognito
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, albURL+"/app/profile", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := noRedirectClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
 
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("Reusing valid session from %s, skipping Cognito login", path)
+		return true, nil
+	}
+
+	log.Printf("Saved session is no longer valid (status: %d), performing full login", resp.StatusCode)
+	return false, nil
+}
+
+// csrfFieldNames are the names under which Cognito login pages carry the
+// CSRF token: _csrf in the classic Hosted UI, and camel- or snake-cased
+// variants in the managed login pages
+var csrfFieldNames = []string{"_csrf", "csrf", "csrfToken", "csrf_token"}
+
+// csrfStrategy is one way of finding the CSRF token in a login page
+type csrfStrategy struct {
+	name    string
+	extract func(doc *html.Node, page string) string
+}
+
+// csrfStrategies are tried in order; structured HTML parsing comes first and
+// the classic regexes are the last resort
+var csrfStrategies = []csrfStrategy{
+	{"form input", csrfFromInput},
+	{"meta tag", csrfFromMeta},
+	{"embedded JSON", csrfFromJSON},
+	{"legacy regex", csrfFromRegex},
+}
+
+// extractCSRFToken extracts the CSRF token from a Cognito login page, either
+// the classic Hosted UI or managed login. The error lists the strategies
+// that were tried.
+func extractCSRFToken(page string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		// html.Parse rarely fails; the regex strategies still apply
+		doc = &html.Node{Type: html.DocumentNode}
+	}
+
+	var tried []string
+	for _, strategy := range csrfStrategies {
+		if token := strategy.extract(doc, page); token != "" {
+			log.Printf("Found CSRF token via %s", strategy.name)
+			return token, nil
+		}
+		tried = append(tried, strategy.name)
+	}
+	return "", fmt.Errorf("no CSRF token found (tried: %s)", strings.Join(tried, ", "))
+}
+
+// csrfFromInput returns the value of an <input> named like a CSRF field
+func csrfFromInput(doc *html.Node, _ string) string {
+	var token string
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Data == "input" && isCSRFFieldName(htmlAttr(n, "name")) {
+			token = htmlAttr(n, "value")
+		}
+		return token != ""
+	})
+	return token
+}
+
+// csrfFromMeta returns the content of a <meta name="csrf-token"> tag
+func csrfFromMeta(doc *html.Node, _ string) string {
+	var token string
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Data == "meta" && strings.EqualFold(htmlAttr(n, "name"), "csrf-token") {
+			token = htmlAttr(n, "content")
+		}
+		return token != ""
+	})
+	return token
+}
+
+// csrfJSONPattern matches a CSRF field in a JSON blob, e.g. the page state
+// that managed login embeds in a <script> element
+var csrfJSONPattern = regexp.MustCompile(`"(?:_csrf|csrf|csrfToken|csrf_token)"\s*:\s*"([^"]+)"`)
+
+// csrfFromJSON returns a CSRF field from the JSON embedded in <script>
+// elements
+func csrfFromJSON(doc *html.Node, _ string) string {
+	var token string
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Data == "script" && n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+			if m := csrfJSONPattern.FindStringSubmatch(n.FirstChild.Data); m != nil {
+				token = m[1]
+			}
+		}
+		return token != ""
+	})
+	return token
+}
+
+// csrfRegexPatterns match the _csrf hidden input of the classic Hosted UI,
+// including markup that does not parse as HTML
+var csrfRegexPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`name="_csrf"\s+value="([^"]+)"`),
+	regexp.MustCompile(`value="([^"]+)"\s+name="_csrf"`),
+	regexp.MustCompile(`<input[^>]*name="_csrf"[^>]*value="([^"]+)"`),
+	regexp.MustCompile(`<input[^>]*value="([^"]+)"[^>]*name="_csrf"`),
+	regexp.MustCompile(`name=['"]_csrf['"][^>]*value=['"]([^'"]+)['"]`),
+	regexp.MustCompile(`value=['"]([^'"]+)['"][^>]*name=['"]_csrf['"]`),
+}
+
+// csrfFromRegex matches the raw page against the classic Hosted UI patterns
+func csrfFromRegex(_ *html.Node, page string) string {
+	for _, re := range csrfRegexPatterns {
+		if m := re.FindStringSubmatch(page); m != nil {
+			return m[1]
+		}
+	}
 	return ""
 }
 
-// extractCSRFTokenAlt tries alternative patterns to find the CSRF token
-func extractCSRFTokenAlt(html string) string {
-	// Pattern: name="_csrf" followed by value on same or next attribute
-	patterns := []string{
-		`<input[^>]*name="_csrf"[^>]*value="([^"]+)"`,
-		`<input[^>]*value="([^"]+)"[^>]*name="_csrf"`,
-		`name=['"]_csrf['"][^>]*value=['"]([^'"]+)['"]`,
-		`value=['"]([^'"]+)['"][^>]*name=['"]_csrf['"]`,
-	}
-
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(html)
-		if len(matches) > 1 {
-			return matches[1]
+// isCSRFFieldName reports whether name is one of csrfFieldNames
+func isCSRFFieldName(name string) bool {
+	for _, field := range csrfFieldNames {
+		if name == field {
+			return true
 		}
 	}
+	return false
+}
 
+// walkHTML visits the element nodes of the tree rooted at n in document
+// order until visit returns true
+func walkHTML(n *html.Node, visit func(*html.Node) bool) bool {
+	if n.Type == html.ElementNode && visit(n) {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if walkHTML(c, visit) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlAttr returns the value of the named attribute of n, or ""
+func htmlAttr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
 	return ""
 }
 
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mixedTests returns the four webtest steps where the second and third fail
+func mixedTests(ran *[]string) []testCase {
+	step := func(name string, err error) testCase {
+		return testCase{name: name, passMsg: name + " passed", run: func() error {
+			*ran = append(*ran, name)
+			return err
+		}}
+	}
+	return []testCase{
+		step("health", nil),
+		step("redirect", errors.New("expected 302 redirect, got 200")),
+		step("login", errors.New("session cookie not found after authentication")),
+		step("profile", nil),
+	}
+}
+
+func TestRunTestsContinueOnFailure(t *testing.T) {
+	var ran []string
+	results := runTests(mixedTests(&ran), true)
+
+	if len(ran) != 4 || len(results) != 4 {
+		t.Fatalf("ran %v (%d results), want all 4 tests", ran, len(results))
+	}
+
+	var summary bytes.Buffer
+	printSummary(&summary, results)
+	for _, want := range []string{
+		"Test 1 PASSED: health",
+		"Test 2 FAILED: redirect (expected 302 redirect, got 200)",
+		"Test 3 FAILED: login",
+		"Test 4 PASSED: profile",
+		"Passed: 2, Failed: 2",
+	} {
+		if !strings.Contains(summary.String(), want) {
+			t.Errorf("summary missing %q:\n%s", want, summary.String())
+		}
+	}
+}
+
+func TestRunTestsStopsOnFirstFailure(t *testing.T) {
+	var ran []string
+	results := runTests(mixedTests(&ran), false)
+
+	if got := fmt.Sprint(ran); got != "[health redirect]" {
+		t.Errorf("ran %s, want [health redirect]", got)
+	}
+	if len(results) != 2 || results[1].err == nil {
+		t.Errorf("results = %+v, want 2 with the second failed", results)
+	}
+}
+
+// redirectResponse is a 302 response to location, as returned by the login POST
+func redirectResponse(location string) *http.Response {
+	return &http.Response{StatusCode: http.StatusFound, Header: http.Header{"Location": {location}}}
+}
+
+func TestFollowRedirectChainDetectsLoop(t *testing.T) {
+	// A misconfigured callback sends the browser back to the same URL; like
+	// Cognito and ALB, the stub sends absolute Locations
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+"/oauth2/idpresponse", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	_, hops, err := followRedirectChain(context.Background(), client, redirectResponse(srv.URL+"/oauth2/idpresponse"), 10)
+	if err == nil || !strings.Contains(err.Error(), "redirect loop detected") {
+		t.Fatalf("followRedirectChain() error = %v, want redirect loop", err)
+	}
+	if hops != 2 {
+		t.Errorf("followRedirectChain() hops = %d, want 2", hops)
+	}
+}
+
+func TestFollowRedirectChainCap(t *testing.T) {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		http.Redirect(w, r, fmt.Sprintf("http://%s/hop/%d", r.Host, n), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	_, _, err := followRedirectChain(context.Background(), client, redirectResponse(srv.URL+"/hop/0"), 3)
+	if err == nil || !strings.Contains(err.Error(), "exceeded maximum of 3 redirects") {
+		t.Fatalf("followRedirectChain() error = %v, want redirect cap", err)
+	}
+}
+
+func TestFollowRedirectChainReachesCallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.Redirect(w, r, "http://"+r.Host+"/oauth2/idpresponse", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, hops, err := followRedirectChain(context.Background(), client, redirectResponse(srv.URL+"/login"), 10)
+	if err != nil {
+		t.Fatalf("followRedirectChain() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || hops != 2 {
+		t.Errorf("followRedirectChain() = %d after %d hops, want 200 after 2", resp.StatusCode, hops)
+	}
+}
+
+// newSessionClient returns a non-redirecting client with an empty cookie jar
+func newSessionClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sessionALB serves /app/profile to requests carrying the given session
+// cookie and redirects everything else to Cognito
+func sessionALB(session string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("AWSELBAuthSessionCookie-0"); err == nil && c.Value == session {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, "https://example.auth.us-east-1.amazoncognito.com/oauth2/authorize", http.StatusFound)
+	}))
+}
+
+func TestSavedSessionRoundTrip(t *testing.T) {
+	srv := sessionALB("valid")
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cookies.json")
+
+	// Save the cookies of a logged-in client
+	first := newSessionClient(t)
+	u, _ := url.Parse(srv.URL)
+	first.Jar.SetCookies(u, []*http.Cookie{{Name: "AWSELBAuthSessionCookie-0", Value: "valid", Path: "/"}})
+	if err := saveSessionCookies(first.Jar, srv.URL, path); err != nil {
+		t.Fatalf("saveSessionCookies() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cookie file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// A fresh client reuses them and skips the login
+	second := newSessionClient(t)
+	reused, err := reuseSavedSession(context.Background(), second, srv.URL, path)
+	if err != nil || !reused {
+		t.Fatalf("reuseSavedSession() = %v, %v, want reused", reused, err)
+	}
+	if len(second.Jar.Cookies(u)) != 1 {
+		t.Errorf("jar holds %v, want the reused session cookie", second.Jar.Cookies(u))
+	}
+}
+
+func TestSavedSessionExpiredResetsJar(t *testing.T) {
+	srv := sessionALB("valid")
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	os.WriteFile(path, []byte(`[{"name":"AWSELBAuthSessionCookie-0","value":"expired"}]`), 0600)
+
+	client := newSessionClient(t)
+	reused, err := reuseSavedSession(context.Background(), client, srv.URL, path)
+	if err != nil || reused {
+		t.Fatalf("reuseSavedSession() = %v, %v, want a fallback to login", reused, err)
+	}
+	u, _ := url.Parse(srv.URL)
+	if cookies := client.Jar.Cookies(u); len(cookies) != 0 {
+		t.Errorf("jar still holds %v after the expired session", cookies)
+	}
+}
+
+func TestSavedSessionNetworkErrorResetsJar(t *testing.T) {
+	srv := sessionALB("valid")
+	albURL := srv.URL
+	srv.Close() // the check request fails to connect
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	os.WriteFile(path, []byte(`[{"name":"AWSELBAuthSessionCookie-0","value":"valid"}]`), 0600)
+
+	client := newSessionClient(t)
+	reused, err := reuseSavedSession(context.Background(), client, albURL, path)
+	if err == nil || reused {
+		t.Fatalf("reuseSavedSession() = %v, %v, want a request error", reused, err)
+	}
+	u, _ := url.Parse(albURL)
+	if cookies := client.Jar.Cookies(u); len(cookies) != 0 {
+		t.Errorf("jar still holds %v after the failed check", cookies)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	results := []testResult{
+		{name: "Unauthenticated request to /health", passMsg: "Health endpoint accessible", duration: 1500 * time.Millisecond},
+		{name: "Unauthenticated request to /app/profile", passMsg: "Redirects to Cognito", duration: 250 * time.Millisecond},
+		{name: "Authenticate via Cognito login", passMsg: "Authenticated", err: errors.New("session cookie not found after authentication"), duration: 2 * time.Second},
+		{name: "Authenticated request to /app/profile", passMsg: "Claims verified", duration: 500 * time.Millisecond},
+	}
+
+	out, err := json.Marshal(newJSONReport(results, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"passed":false,"tests":[` +
+		`{"name":"Unauthenticated request to /health","passed":true,"duration_seconds":1.5,"detail":"Health endpoint accessible"},` +
+		`{"name":"Unauthenticated request to /app/profile","passed":true,"duration_seconds":0.25,"detail":"Redirects to Cognito"},` +
+		`{"name":"Authenticate via Cognito login","passed":false,"duration_seconds":2,"detail":"session cookie not found after authentication"},` +
+		`{"name":"Authenticated request to /app/profile","passed":true,"duration_seconds":0.5,"detail":"Claims verified"}]}`
+	if string(out) != want {
+		t.Errorf("JSON report =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestJSONReportRunError(t *testing.T) {
+	out, err := json.Marshal(newJSONReport(nil, errors.New("ALB not healthy: context deadline exceeded")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"passed":false,"error":"ALB not healthy: context deadline exceeded","tests":[]}`
+	if string(out) != want {
+		t.Errorf("JSON report = %s, want %s", out, want)
+	}
+}
+
+func TestExtractCSRFTokenFixtures(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		want    string
+	}{
+		{"classic_hosted_ui.html", "classic-3b6f1c2a-9d4e-4f7a-8c1b-2e5d6a7f8b9c"},
+		{"managed_login.html", "managed-7e2d9a41-5c3b-4e8f-a1d6-0b9c8e7f6a5d"},
+	} {
+		page, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := extractCSRFToken(string(page)); err != nil || got != tt.want {
+			t.Errorf("%s: extractCSRFToken() = %q, %v, want %q", tt.fixture, got, err, tt.want)
+		}
+	}
+}
+
+func TestExtractCSRFTokenStrategies(t *testing.T) {
+	for _, tt := range []struct {
+		name, page, want string
+	}{
+		{"snake-cased input", `<form><input type="hidden" name="csrf_token" value="input-token"></form>`, "input-token"},
+		{"meta tag", `<head><meta name="csrf-token" content="meta-token"></head>`, "meta-token"},
+		{"embedded JSON", `<script>window.__STATE__ = {"_csrf": "json-token"};</script>`, "json-token"},
+		// Markup hidden from the parser, e.g. in a comment, only matches the regexes
+		{"legacy regex", `<!-- <input name="_csrf" value="regex-token"> -->`, "regex-token"},
+	} {
+		if got, err := extractCSRFToken(tt.page); err != nil || got != tt.want {
+			t.Errorf("%s: extractCSRFToken() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestExtractCSRFTokenListsStrategies(t *testing.T) {
+	_, err := extractCSRFToken(`<html><body><form><input name="username"></form></body></html>`)
+	if err == nil {
+		t.Fatal("extractCSRFToken() succeeded on a page without a token")
+	}
+	for _, strategy := range csrfStrategies {
+		if !strings.Contains(err.Error(), strategy.name) {
+			t.Errorf("error %q does not mention strategy %q", err, strategy.name)
+		}
+	}
+}